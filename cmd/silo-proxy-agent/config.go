@@ -3,18 +3,28 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"log/slog"
 	"strings"
 
 	"github.com/EternisAI/silo-proxy/internal/api/http"
+	grpcclient "github.com/EternisAI/silo-proxy/internal/grpc/client"
 	"github.com/joho/godotenv"
 	"github.com/lwlee2608/adder"
 )
 
 type Config struct {
-	Log   LogConfig
-	Http  http.Config
-	Grpc  GrpcConfig
-	Local LocalConfig
+	Log      LogConfig
+	Http     http.Config
+	Grpc     GrpcConfig
+	Local    LocalConfig
+	Timeouts TimeoutsConfig `mapstructure:"timeouts"`
+}
+
+type TimeoutsConfig struct {
+	ResponseHeaderSeconds int `mapstructure:"response_header_seconds"`
+	BodyIdleSeconds       int `mapstructure:"body_idle_seconds"`
+	SendSeconds           int `mapstructure:"send_seconds"`
+	PingIntervalSeconds   int `mapstructure:"ping_interval_seconds"`
 }
 
 type GrpcConfig struct {
@@ -29,10 +39,33 @@ type TLSConfig struct {
 	KeyFile            string `mapstructure:"key_file"`
 	CAFile             string `mapstructure:"ca_file"`
 	ServerNameOverride string `mapstructure:"server_name_override"`
+	RenewBeforeDays    int    `mapstructure:"renew_before_days"`
 }
 
 type LocalConfig struct {
-	ServiceURL string `mapstructure:"service_url"`
+	ServiceURL string            `mapstructure:"service_url"`
+	Routes     []RouteConfig     `mapstructure:"routes"`
+	TCPTunnels []TCPTunnelConfig `mapstructure:"tcp_tunnels"`
+	Workers    WorkersConfig     `mapstructure:"workers"`
+}
+
+// WorkersConfig bounds concurrent calls to the local services. Zero workers
+// handles every request as soon as it arrives.
+type WorkersConfig struct {
+	Count     int `mapstructure:"count"`
+	QueueSize int `mapstructure:"queue_size"`
+}
+
+type RouteConfig struct {
+	Host        string `mapstructure:"host"`
+	PathPrefix  string `mapstructure:"path_prefix"`
+	Upstream    string `mapstructure:"upstream"`
+	StripPrefix bool   `mapstructure:"strip_prefix"`
+}
+
+type TCPTunnelConfig struct {
+	Name   string `mapstructure:"name"`
+	Target string `mapstructure:"target"`
 }
 
 var config Config
@@ -73,3 +106,40 @@ func InitConfig() {
 		}
 	}
 }
+
+// redactedConfig returns c without its secrets, for the "config" command.
+func redactedConfig(c Config) Config {
+	if c.Http.AdminAPIKey != "" {
+		c.Http.AdminAPIKey = "REDACTED"
+	}
+	if c.Log.Http.AdminAPIKey != "" {
+		c.Log.Http.AdminAPIKey = "REDACTED"
+	}
+	return c
+}
+
+// localRoutes converts the configured route table. Without any routes,
+// service_url serves every request.
+func localRoutes(local LocalConfig) []grpcclient.Route {
+	if len(local.Routes) == 0 {
+		if local.ServiceURL == "" {
+			return nil
+		}
+		return []grpcclient.Route{{Upstream: local.ServiceURL}}
+	}
+
+	if local.ServiceURL != "" {
+		slog.Warn("local.service_url is ignored when local.routes is set; add a route with path_prefix \"/\" for a default upstream")
+	}
+
+	routes := make([]grpcclient.Route, 0, len(local.Routes))
+	for _, r := range local.Routes {
+		routes = append(routes, grpcclient.Route{
+			Host:        r.Host,
+			PathPrefix:  r.PathPrefix,
+			Upstream:    r.Upstream,
+			StripPrefix: r.StripPrefix,
+		})
+	}
+	return routes
+}
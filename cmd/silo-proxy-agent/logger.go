@@ -1,9 +1,11 @@
 package main
 
 import (
+	"errors"
 	"log/slog"
 	"os"
 	"strings"
+	"syscall"
 
 	"github.com/EternisAI/silo-proxy/internal/api/http"
 )
@@ -43,3 +45,12 @@ func initLogger(logLevel string) {
 
 	slog.SetDefault(logger)
 }
+
+// flushLogs commits the log output to storage, for the "flush_logs" command.
+// Pipes and terminals need no flushing and cannot be synced.
+func flushLogs() error {
+	if err := os.Stdout.Sync(); err != nil && !errors.Is(err, syscall.EINVAL) && !errors.Is(err, syscall.ENOTSUP) {
+		return err
+	}
+	return nil
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"log/slog"
 	"net/http"
@@ -14,6 +15,7 @@ import (
 	"github.com/EternisAI/silo-proxy/internal/api/http/handler"
 	"github.com/EternisAI/silo-proxy/internal/api/http/middleware"
 	grpcclient "github.com/EternisAI/silo-proxy/internal/grpc/client"
+	"github.com/EternisAI/silo-proxy/internal/metrics"
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 )
@@ -39,9 +41,41 @@ func main() {
 		KeyFile:            config.Grpc.TLS.KeyFile,
 		CAFile:             config.Grpc.TLS.CAFile,
 		ServerNameOverride: config.Grpc.TLS.ServerNameOverride,
+		RenewBefore:        time.Duration(config.Grpc.TLS.RenewBeforeDays) * 24 * time.Hour,
 	}
 
-	grpcClient := grpcclient.NewClient(config.Grpc.ServerAddress, config.Grpc.AgentID, config.Local.ServiceURL, tlsConfig)
+	router, err := grpcclient.NewRouter(localRoutes(config.Local))
+	if err != nil {
+		slog.Error("Invalid local routes", "error", err)
+		os.Exit(1)
+	}
+
+	tcpTunnels := make([]grpcclient.TCPTunnel, 0, len(config.Local.TCPTunnels))
+	for _, t := range config.Local.TCPTunnels {
+		tcpTunnels = append(tcpTunnels, grpcclient.TCPTunnel{Name: t.Name, Target: t.Target})
+	}
+
+	grpcClient := grpcclient.NewClient(config.Grpc.ServerAddress, config.Grpc.AgentID, router, tcpTunnels, tlsConfig)
+	grpcClient.SetVersion(AppVersion)
+	grpcClient.SetTimeouts(grpcclient.Timeouts{
+		ResponseHeader: time.Duration(config.Timeouts.ResponseHeaderSeconds) * time.Second,
+		BodyIdle:       time.Duration(config.Timeouts.BodyIdleSeconds) * time.Second,
+		Send:           time.Duration(config.Timeouts.SendSeconds) * time.Second,
+		PingInterval:   time.Duration(config.Timeouts.PingIntervalSeconds) * time.Second,
+	})
+	if config.Local.Workers.Count > 0 {
+		grpcClient.SetWorkerPool(config.Local.Workers.Count, config.Local.Workers.QueueSize)
+	}
+	grpcClient.RegisterCommand("config", func(context.Context, json.RawMessage) (any, error) {
+		return redactedConfig(config), nil
+	})
+	grpcClient.RegisterCommand("flush_logs", func(context.Context, json.RawMessage) (any, error) {
+		if err := flushLogs(); err != nil {
+			return nil, err
+		}
+		return map[string]bool{"flushed": true}, nil
+	})
+	metrics.RegisterAgent(grpcClient.SendQueueDepth)
 	if err := grpcClient.Start(); err != nil {
 		slog.Error("Failed to start gRPC client", "error", err)
 		os.Exit(1)
@@ -60,6 +94,7 @@ func main() {
 	engine.Use(gin.Recovery())
 	engine.Use(middleware.RequestLogger())
 	engine.GET("/health", handler.NewHealthHandler().Check)
+	engine.GET("/metrics", metrics.Handler())
 
 	server := &http.Server{
 		Addr:    fmt.Sprintf(":%d", config.Http.Port),
@@ -12,13 +12,16 @@ import (
 	"path/filepath"
 
 	"github.com/EternisAI/silo-proxy/internal/api/http/dto"
+	"github.com/EternisAI/silo-proxy/internal/cert"
 )
 
 func runProvision(args []string) error {
 	fs := flag.NewFlagSet("provision", flag.ExitOnError)
 	server := fs.String("server", "", "Server URL (e.g., https://server:8080)")
 	key := fs.String("key", "", "Provision key")
+	agentID := fs.String("agent-id", "", "Agent ID the provision key was issued for")
 	certDir := fs.String("cert-dir", "./certs", "Directory to save certificates")
+	legacy := fs.Bool("legacy", false, "Let the server generate the private key (requires provision.allow_server_generated_keys on the server)")
 	insecure := fs.Bool("insecure", false, "Skip TLS certificate verification (for development only)")
 	if err := fs.Parse(args); err != nil {
 		return err
@@ -31,11 +34,32 @@ func runProvision(args []string) error {
 		return fmt.Errorf("--key is required")
 	}
 
+	if *agentID == "" && !*legacy {
+		return fmt.Errorf("--agent-id is required")
+	}
+
 	if *insecure {
 		fmt.Fprintln(os.Stderr, "WARNING: Using insecure TLS mode. This is unsafe for production.")
 	}
 
-	reqBody, err := json.Marshal(dto.ProvisionRequest{Key: *key})
+	provReq := dto.ProvisionRequest{Key: *key}
+	var keyPEM []byte
+	if !*legacy {
+		if err := cert.ValidateAgentID(*agentID); err != nil {
+			return err
+		}
+
+		agentKey, csrPEM, err := cert.GenerateAgentCSR(*agentID)
+		if err != nil {
+			return err
+		}
+		if keyPEM, err = cert.KeyToPEM(agentKey); err != nil {
+			return fmt.Errorf("failed to encode key: %w", err)
+		}
+		provReq.CSR = string(csrPEM)
+	}
+
+	reqBody, err := json.Marshal(provReq)
 	if err != nil {
 		return fmt.Errorf("failed to marshal request: %w", err)
 	}
@@ -69,6 +93,13 @@ func runProvision(args []string) error {
 		return fmt.Errorf("failed to parse response: %w", err)
 	}
 
+	if *legacy {
+		if provResp.KeyPEM == "" {
+			return fmt.Errorf("server did not return a private key")
+		}
+		keyPEM = []byte(provResp.KeyPEM)
+	}
+
 	agentCertDir := filepath.Join(*certDir, "agents", provResp.AgentID)
 	caCertDir := filepath.Join(*certDir, "ca")
 
@@ -86,7 +117,7 @@ func runProvision(args []string) error {
 	if err := os.WriteFile(certPath, []byte(provResp.CertPEM), 0644); err != nil {
 		return fmt.Errorf("failed to write cert: %w", err)
 	}
-	if err := os.WriteFile(keyPath, []byte(provResp.KeyPEM), 0600); err != nil {
+	if err := os.WriteFile(keyPath, keyPEM, 0600); err != nil {
 		return fmt.Errorf("failed to write key: %w", err)
 	}
 	if err := os.WriteFile(caPath, []byte(provResp.CACertPEM), 0644); err != nil {
@@ -4,10 +4,12 @@ import (
 	"encoding/json"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/EternisAI/silo-proxy/internal/api/http"
 	"github.com/EternisAI/silo-proxy/internal/auth"
 	"github.com/EternisAI/silo-proxy/internal/db"
+	grpcserver "github.com/EternisAI/silo-proxy/internal/grpc/server"
 	"github.com/joho/godotenv"
 	"github.com/lwlee2608/adder"
 )
@@ -19,17 +21,48 @@ type Config struct {
 	DB        db.Config        `mapstructure:"db"`
 	JWT       auth.Config      `mapstructure:"jwt"`
 	Provision ProvisionConfig  `mapstructure:"provision"`
+	TCP       TCPConfig        `mapstructure:"tcp"`
+	Timeouts  TimeoutsConfig   `mapstructure:"timeouts"`
+	Reconnect ReconnectConfig  `mapstructure:"reconnect"`
+}
+
+type ReconnectConfig struct {
+	GraceSeconds      int `mapstructure:"grace_seconds"`
+	MaxQueuedRequests int `mapstructure:"max_queued_requests"`
+}
+
+type TimeoutsConfig struct {
+	RequestSeconds         int                     `mapstructure:"request_seconds"`
+	MaxRequestSeconds      int                     `mapstructure:"max_request_seconds"`
+	SendSeconds            int                     `mapstructure:"send_seconds"`
+	StaleConnectionSeconds int                     `mapstructure:"stale_connection_seconds"`
+	Overrides              []TimeoutOverrideConfig `mapstructure:"overrides"`
+}
+
+type TimeoutOverrideConfig struct {
+	AgentID        string `mapstructure:"agent_id"`
+	PathPrefix     string `mapstructure:"path_prefix"`
+	RequestSeconds int    `mapstructure:"request_seconds"`
+}
+
+type TCPConfig struct {
+	Enabled   bool           `mapstructure:"enabled"`
+	PortRange http.PortRange `mapstructure:"port_range"`
 }
 
 type ProvisionConfig struct {
-	Enabled                bool `mapstructure:"enabled"`
-	KeyTTLHours            int  `mapstructure:"key_ttl_hours"`
-	CleanupIntervalMinutes int  `mapstructure:"cleanup_interval_minutes"`
+	Enabled                  bool `mapstructure:"enabled"`
+	KeyTTLHours              int  `mapstructure:"key_ttl_hours"`
+	CleanupIntervalMinutes   int  `mapstructure:"cleanup_interval_minutes"`
+	AllowServerGeneratedKeys bool `mapstructure:"allow_server_generated_keys"`
 }
 
 type GrpcConfig struct {
-	Port int       `mapstructure:"port"`
-	TLS  TLSConfig `mapstructure:"tls"`
+	Port                   int       `mapstructure:"port"`
+	TLS                    TLSConfig `mapstructure:"tls"`
+	AllowUnverifiedAgentID bool      `mapstructure:"allow_unverified_agent_id"`
+	MinProtocolVersion     int       `mapstructure:"min_protocol_version"`
+	MaxStreamsPerAgent     int       `mapstructure:"max_streams_per_agent"`
 }
 
 type TLSConfig struct {
@@ -82,3 +115,26 @@ func InitConfig() {
 		}
 	}
 }
+
+// serverTimeouts converts the configured timeouts; unset values keep the
+// server's defaults.
+func serverTimeouts(c TimeoutsConfig) grpcserver.Timeouts {
+	timeouts := grpcserver.Timeouts{
+		Request:         seconds(c.RequestSeconds),
+		MaxRequest:      seconds(c.MaxRequestSeconds),
+		Send:            seconds(c.SendSeconds),
+		StaleConnection: seconds(c.StaleConnectionSeconds),
+	}
+	for _, o := range c.Overrides {
+		timeouts.RequestOverrides = append(timeouts.RequestOverrides, grpcserver.RequestTimeoutOverride{
+			AgentID:    o.AgentID,
+			PathPrefix: o.PathPrefix,
+			Timeout:    seconds(o.RequestSeconds),
+		})
+	}
+	return timeouts
+}
+
+func seconds(n int) time.Duration {
+	return time.Duration(n) * time.Second
+}
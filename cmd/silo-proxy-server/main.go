@@ -11,13 +11,25 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/EternisAI/silo-proxy/internal/access"
+	"github.com/EternisAI/silo-proxy/internal/agents"
 	internalhttp "github.com/EternisAI/silo-proxy/internal/api/http"
 	"github.com/EternisAI/silo-proxy/internal/auth"
 	"github.com/EternisAI/silo-proxy/internal/cert"
+	"github.com/EternisAI/silo-proxy/internal/commands"
 	"github.com/EternisAI/silo-proxy/internal/db"
 	"github.com/EternisAI/silo-proxy/internal/db/sqlc"
+	"github.com/EternisAI/silo-proxy/internal/domains"
+	"github.com/EternisAI/silo-proxy/internal/forwarded"
 	grpcserver "github.com/EternisAI/silo-proxy/internal/grpc/server"
+	"github.com/EternisAI/silo-proxy/internal/metrics"
+	"github.com/EternisAI/silo-proxy/internal/pools"
 	"github.com/EternisAI/silo-proxy/internal/provision"
+	"github.com/EternisAI/silo-proxy/internal/ratelimit"
+	"github.com/EternisAI/silo-proxy/internal/renewal"
+	"github.com/EternisAI/silo-proxy/internal/reservations"
+	"github.com/EternisAI/silo-proxy/internal/revocation"
+	"github.com/EternisAI/silo-proxy/internal/tcpproxy"
 	"github.com/EternisAI/silo-proxy/internal/users"
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
@@ -74,7 +86,46 @@ func main() {
 		}
 	}
 
+	certVerified := config.Grpc.TLS.Enabled && config.Grpc.TLS.ClientAuth == "require"
+	if !certVerified && !config.Grpc.AllowUnverifiedAgentID {
+		slog.Error("Agent identity cannot be verified: enable TLS with client_auth \"require\" or set grpc.allow_unverified_agent_id")
+		os.Exit(1)
+	}
+	if !certVerified {
+		slog.Warn("Agents are identified by the agent_id they send; enable mutual TLS to verify them")
+	}
+
 	grpcSrv := grpcserver.NewServer(config.Grpc.Port, tlsConfig)
+	grpcSrv.SetAllowUnverifiedAgentID(config.Grpc.AllowUnverifiedAgentID)
+	grpcSrv.SetTimeouts(serverTimeouts(config.Timeouts))
+	grpcSrv.SetReconnectGrace(seconds(config.Reconnect.GraceSeconds), config.Reconnect.MaxQueuedRequests)
+	grpcSrv.SetVersion(AppVersion)
+	grpcSrv.SetMinProtocolVersion(config.Grpc.MinProtocolVersion)
+	grpcSrv.SetMaxStreamsPerAgent(config.Grpc.MaxStreamsPerAgent)
+	metrics.RegisterServer(grpcSrv.GetConnectionManager().SendQueueDepths)
+
+	registry := agents.NewService(queries)
+	if closed, err := registry.CloseOpenSessions(context.Background()); err != nil {
+		slog.Error("Failed to close agent sessions left open", "error", err)
+		os.Exit(1)
+	} else if closed > 0 {
+		slog.Info("Closed agent sessions left open by the previous run", "agents", closed)
+	}
+	grpcSrv.SetSessionRecorder(registry)
+
+	var revocationService *revocation.Service
+	var renewalService *renewal.Service
+	if certService != nil {
+		revocationService = revocation.NewService(queries, certService)
+		if err := revocationService.Load(context.Background()); err != nil {
+			slog.Error("Failed to load revoked certificates", "error", err)
+			os.Exit(1)
+		}
+		go db.Refresh(context.Background(), time.Minute, "revoked certificates", revocationService)
+		grpcSrv.SetRevocationChecker(revocationService)
+		renewalService = renewal.NewService(queries, certService, revocationService)
+		grpcSrv.SetCertRenewer(renewalService)
+	}
 
 	portManager, err := internalhttp.NewPortManager(
 		config.Http.AgentPortRange.Start,
@@ -84,8 +135,41 @@ func main() {
 		slog.Error("Failed to create port manager", "error", err)
 		os.Exit(1)
 	}
+	portManager.SetPoolName("agent_http")
+
+	reservationStore := reservations.NewStore(queries)
+	if err := portManager.SetReservationStore(reservationStore); err != nil {
+		slog.Error("Failed to load agent port reservations", "error", err)
+		os.Exit(1)
+	}
+	defer portManager.Close()
+
+	accessService := access.NewService(queries, config.JWT.Secret)
+	if err := accessService.Load(context.Background()); err != nil {
+		slog.Error("Failed to load agent access policies", "error", err)
+		os.Exit(1)
+	}
+	go db.Refresh(context.Background(), time.Minute, "agent access policies", accessService)
+
+	trustedProxies, err := forwarded.ParseTrustedProxies(config.Http.TrustedProxies)
+	if err != nil {
+		slog.Error("Invalid trusted proxies", "error", err)
+		os.Exit(1)
+	}
+	accessService.SetTrustedProxies(trustedProxies)
 
 	agentServerManager := internalhttp.NewAgentServerManager(portManager, grpcSrv)
+	agentServerManager.SetAccessPolicies(accessService)
+	agentServerManager.SetTrustedProxies(trustedProxies)
+
+	var rateLimits *ratelimit.AgentLimiter
+	if config.Http.RateLimit.Enabled() {
+		rateLimits = ratelimit.NewAgentLimiter(config.Http.RateLimit)
+		agentServerManager.SetRateLimits(rateLimits)
+		slog.Info("Agent rate limits enabled",
+			"per_agent", config.Http.RateLimit.PerAgent,
+			"per_client_ip", config.Http.RateLimit.PerClientIP)
+	}
 	grpcSrv.SetAgentServerManager(agentServerManager)
 
 	slog.Info("Agent port pool initialized",
@@ -93,6 +177,86 @@ func main() {
 		"range_end", config.Http.AgentPortRange.End,
 		"pool_size", config.Http.AgentPortRange.End-config.Http.AgentPortRange.Start+1)
 
+	if config.TCP.Enabled {
+		tcpPortManager, err := internalhttp.NewPortManager(
+			config.TCP.PortRange.Start,
+			config.TCP.PortRange.End,
+		)
+		if err != nil {
+			slog.Error("Failed to create TCP tunnel port manager", "error", err)
+			os.Exit(1)
+		}
+		tcpPortManager.SetPoolName("tcp_tunnel")
+		if err := tcpPortManager.SetReservationStore(reservationStore); err != nil {
+			slog.Error("Failed to load TCP tunnel port reservations", "error", err)
+			os.Exit(1)
+		}
+		defer tcpPortManager.Close()
+
+		grpcSrv.SetTCPTunnelManager(tcpproxy.NewTunnelManager(tcpPortManager, grpcSrv))
+		slog.Info("TCP tunnels enabled",
+			"range_start", config.TCP.PortRange.Start,
+			"range_end", config.TCP.PortRange.End)
+	}
+
+	var domainService *domains.Service
+	var virtualHosts *internalhttp.VirtualHostRouter
+	if config.Http.VirtualHosts.Enabled {
+		domainService = domains.NewService(queries)
+		domainService.SetBaseDomain(config.Http.VirtualHosts.BaseDomain)
+		domainService.SetAPIHosts(config.Http.APIHosts)
+		if err := domainService.Load(context.Background()); err != nil {
+			slog.Error("Failed to load agent domains", "error", err)
+			os.Exit(1)
+		}
+		go db.Refresh(context.Background(), time.Minute, "agent domains", domainService)
+
+		virtualHosts = internalhttp.NewVirtualHostRouter(config.Http.VirtualHosts.BaseDomain, domainService, grpcSrv)
+		virtualHosts.SetAPIHosts(config.Http.APIHosts)
+		virtualHosts.SetAccessPolicies(accessService)
+		virtualHosts.SetTrustedProxies(trustedProxies)
+		if rateLimits != nil {
+			virtualHosts.SetRateLimits(rateLimits)
+		}
+		slog.Info("Virtual host routing enabled",
+			"base_domain", config.Http.VirtualHosts.BaseDomain,
+			"port", config.Http.VirtualHosts.Port)
+		if config.Http.VirtualHosts.Port == 0 && len(config.Http.APIHosts) == 0 {
+			slog.Warn("Virtual hosts share the API port but http.api_hosts is not set; an agent or custom domain may shadow the API host")
+		}
+	}
+
+	var agentPools *pools.Set
+	var poolRouter *internalhttp.PoolRouter
+	if len(config.Http.Pools) > 0 {
+		agentPools, err = pools.NewSet(config.Http.Pools, grpcSrv)
+		if err != nil {
+			slog.Error("Invalid agent pools", "error", err)
+			os.Exit(1)
+		}
+		for _, host := range config.Http.APIHosts {
+			if pool, ok := agentPools.ByHost(domains.Normalize(host)); ok {
+				slog.Error("Agent pool host is an API host", "pool", pool.Name, "host", pool.Host)
+				os.Exit(1)
+			}
+		}
+
+		poolRouter = internalhttp.NewPoolRouter(agentPools, grpcSrv)
+		poolRouter.SetAccessPolicies(accessService)
+		poolRouter.SetTrustedProxies(trustedProxies)
+		if rateLimits != nil {
+			poolRouter.SetRateLimits(rateLimits)
+		}
+		for _, pool := range agentPools.All() {
+			slog.Info("Agent pool configured",
+				"pool", pool.Name,
+				"agents", pool.Members(),
+				"balance", pool.Balance(),
+				"port", pool.Port,
+				"host", pool.Host)
+		}
+	}
+
 	var keyStore *provision.KeyStore
 	if config.Provision.Enabled {
 		if certService == nil {
@@ -103,15 +267,31 @@ func main() {
 		keyStore = provision.NewKeyStore(ttl)
 		cleanupInterval := time.Duration(config.Provision.CleanupIntervalMinutes) * time.Minute
 		go keyStore.StartCleanup(context.Background(), cleanupInterval)
-		slog.Info("Provisioning enabled", "key_ttl_hours", config.Provision.KeyTTLHours)
+		slog.Info("Provisioning enabled",
+			"key_ttl_hours", config.Provision.KeyTTLHours,
+			"allow_server_generated_keys", config.Provision.AllowServerGeneratedKeys)
 	}
 
 	services := &internalhttp.Services{
 		GrpcServer:  grpcSrv,
 		CertService: certService,
+		Revocations: revocationService,
+		Renewals:    renewalService,
 		AuthService: authService,
 		UserService: userService,
 		KeyStore:    keyStore,
+		Registry:    registry,
+		AgentPorts:  portManager,
+		Domains:     domainService,
+		Access:      accessService,
+		RateLimits:  rateLimits,
+
+		TrustedProxies: trustedProxies,
+		Commands:       commands.NewPolicy(config.Http.Commands),
+		Pools:          agentPools,
+
+		AllowServerGeneratedKeys: config.Provision.AllowServerGeneratedKeys,
+		ProxyRoles:               config.Http.ProxyRoles,
 	}
 
 	gin.SetMode(gin.ReleaseMode)
@@ -127,12 +307,44 @@ func main() {
 	engine.Use(gin.Recovery())
 	internalhttp.SetupRoute(engine, services, config.Http.AdminAPIKey, config.JWT.Secret)
 
+	var rootHandler http.Handler = engine
+	var ingressServer *http.Server
+	if virtualHosts != nil {
+		if config.Http.VirtualHosts.Port == 0 {
+			rootHandler = virtualHosts.Handler(engine)
+		} else {
+			ingressServer = &http.Server{
+				Addr:    fmt.Sprintf(":%d", config.Http.VirtualHosts.Port),
+				Handler: virtualHosts,
+			}
+		}
+	}
+
+	var poolServers []*http.Server
+	if poolRouter != nil {
+		rootHandler = poolRouter.Handler(rootHandler)
+		if ingressServer != nil {
+			ingressServer.Handler = poolRouter.Handler(ingressServer.Handler)
+		}
+		poolServers = poolRouter.Servers()
+	}
+
 	httpServer := &http.Server{
 		Addr:    fmt.Sprintf(":%d", config.Http.Port),
-		Handler: engine,
+		Handler: rootHandler,
 	}
 
-	errChan := make(chan error, 2)
+	var metricsServer *http.Server
+	if config.Http.MetricsPort != 0 {
+		metricsEngine := gin.New()
+		metricsEngine.GET("/metrics", metrics.Handler())
+		metricsServer = &http.Server{
+			Addr:    fmt.Sprintf(":%d", config.Http.MetricsPort),
+			Handler: metricsEngine,
+		}
+	}
+
+	errChan := make(chan error, 4+len(poolServers))
 	go func() {
 		slog.Info("Starting HTTP server", "address", httpServer.Addr)
 		if err := httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
@@ -140,6 +352,33 @@ func main() {
 		}
 	}()
 
+	if ingressServer != nil {
+		go func() {
+			slog.Info("Starting virtual host ingress server", "address", ingressServer.Addr)
+			if err := ingressServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				errChan <- fmt.Errorf("virtual host ingress server error: %w", err)
+			}
+		}()
+	}
+
+	if metricsServer != nil {
+		go func() {
+			slog.Info("Starting metrics server", "address", metricsServer.Addr)
+			if err := metricsServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				errChan <- fmt.Errorf("metrics server error: %w", err)
+			}
+		}()
+	}
+
+	for _, poolServer := range poolServers {
+		go func() {
+			slog.Info("Starting agent pool server", "address", poolServer.Addr)
+			if err := poolServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				errChan <- fmt.Errorf("agent pool server error: %w", err)
+			}
+		}()
+	}
+
 	go func() {
 		if err := grpcSrv.Start(); err != nil {
 			errChan <- fmt.Errorf("gRPC server error: %w", err)
@@ -173,6 +412,44 @@ func main() {
 		}
 	}()
 
+	if ingressServer != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+			defer cancel()
+			if err := ingressServer.Shutdown(ctx); err != nil {
+				slog.Error("Virtual host ingress server shutdown error", "error", err)
+			} else {
+				slog.Info("Virtual host ingress server stopped")
+			}
+		}()
+	}
+
+	if metricsServer != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+			defer cancel()
+			if err := metricsServer.Shutdown(ctx); err != nil {
+				slog.Error("Metrics server shutdown error", "error", err)
+			}
+		}()
+	}
+
+	for _, poolServer := range poolServers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+			defer cancel()
+			if err := poolServer.Shutdown(ctx); err != nil {
+				slog.Error("Agent pool server shutdown error", "address", poolServer.Addr, "error", err)
+			}
+		}()
+	}
+
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
@@ -182,5 +459,6 @@ func main() {
 	}()
 
 	wg.Wait()
+	registry.Close()
 	slog.Info("Shutdown complete")
 }
@@ -0,0 +1,151 @@
+package access
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/netip"
+	"slices"
+	"strings"
+
+	"github.com/EternisAI/silo-proxy/internal/auth"
+	"github.com/EternisAI/silo-proxy/internal/db/sqlc"
+	"github.com/EternisAI/silo-proxy/internal/users"
+)
+
+const minAPIKeyLength = 16
+
+// defaultJWTRoles are the roles let through by RequireJWT when a policy names
+// no roles or users.
+var defaultJWTRoles = []string{string(sqlc.UserRoleAdmin)}
+
+var ErrInvalidPolicy = errors.New("invalid access policy")
+
+// Policy restricts who may reach an agent's local services. Requests must come
+// from one of AllowedCIDRs, if any are set, and present one of the enabled
+// credentials: a JWT issued by the auth package to a user with one of
+// JWTRoles or JWTUsers, the agent's API key in the X-API-Key header, or its
+// basic auth credentials. The zero Policy allows everyone.
+type Policy struct {
+	AllowedCIDRs []string   `json:"allowed_cidrs,omitempty"`
+	RequireJWT   bool       `json:"require_jwt,omitempty"`
+	JWTRoles     []string   `json:"jwt_roles,omitempty"`
+	JWTUsers     []string   `json:"jwt_users,omitempty"`
+	APIKeyHash   string     `json:"api_key_hash,omitempty"`
+	BasicAuth    *BasicAuth `json:"basic_auth,omitempty"`
+}
+
+// BasicAuth holds a username and the bcrypt hash of its password.
+type BasicAuth struct {
+	Username     string `json:"username"`
+	PasswordHash string `json:"password_hash"`
+}
+
+// Settings is a policy as entered by an admin, with secrets in plain text.
+// JWTRoles and JWTUsers default to the Admin role when RequireJWT is set.
+type Settings struct {
+	AllowedCIDRs      []string
+	RequireJWT        bool
+	JWTRoles          []string
+	JWTUsers          []string
+	APIKey            string
+	BasicAuthUsername string
+	BasicAuthPassword string
+}
+
+// NewPolicy validates settings and hashes their secrets.
+func NewPolicy(settings Settings) (Policy, error) {
+	var policy Policy
+
+	for _, cidr := range settings.AllowedCIDRs {
+		prefix, err := parsePrefix(cidr)
+		if err != nil {
+			return Policy{}, fmt.Errorf("%w: %v", ErrInvalidPolicy, err)
+		}
+		policy.AllowedCIDRs = append(policy.AllowedCIDRs, prefix.String())
+	}
+
+	policy.RequireJWT = settings.RequireJWT
+	if !settings.RequireJWT && (len(settings.JWTRoles) > 0 || len(settings.JWTUsers) > 0) {
+		return Policy{}, fmt.Errorf("%w: jwt roles and users require require_jwt", ErrInvalidPolicy)
+	}
+	for _, role := range settings.JWTRoles {
+		switch sqlc.UserRole(role) {
+		case sqlc.UserRoleAdmin, sqlc.UserRoleUser:
+		default:
+			return Policy{}, fmt.Errorf("%w: unknown role %q", ErrInvalidPolicy, role)
+		}
+	}
+	if settings.RequireJWT {
+		policy.JWTRoles = settings.JWTRoles
+		policy.JWTUsers = settings.JWTUsers
+		if len(policy.JWTRoles) == 0 && len(policy.JWTUsers) == 0 {
+			policy.JWTRoles = defaultJWTRoles
+		}
+	}
+
+	if settings.APIKey != "" {
+		if len(settings.APIKey) < minAPIKeyLength {
+			return Policy{}, fmt.Errorf("%w: api key must be at least %d characters", ErrInvalidPolicy, minAPIKeyLength)
+		}
+		policy.APIKeyHash = hashAPIKey(settings.APIKey)
+	}
+
+	if settings.BasicAuthUsername != "" || settings.BasicAuthPassword != "" {
+		if settings.BasicAuthUsername == "" || strings.Contains(settings.BasicAuthUsername, ":") {
+			return Policy{}, fmt.Errorf("%w: basic auth username must be non-empty and must not contain ':'", ErrInvalidPolicy)
+		}
+		if settings.BasicAuthPassword == "" {
+			return Policy{}, fmt.Errorf("%w: basic auth password must not be empty", ErrInvalidPolicy)
+		}
+		hash, err := users.HashPassword(settings.BasicAuthPassword)
+		if err != nil {
+			return Policy{}, err
+		}
+		policy.BasicAuth = &BasicAuth{Username: settings.BasicAuthUsername, PasswordHash: hash}
+	}
+
+	return policy, nil
+}
+
+// allowsClaims reports whether a JWT with claims may pass RequireJWT. Policies
+// stored before JWTRoles and JWTUsers existed let only Admin users through.
+func (p Policy) allowsClaims(claims *auth.Claims) bool {
+	roles := p.JWTRoles
+	if len(roles) == 0 && len(p.JWTUsers) == 0 {
+		roles = defaultJWTRoles
+	}
+	return slices.Contains(roles, claims.Role) || slices.Contains(p.JWTUsers, claims.Username)
+}
+
+// IsOpen reports whether the policy allows everyone.
+func (p Policy) IsOpen() bool {
+	return len(p.AllowedCIDRs) == 0 && !p.requiresCredentials()
+}
+
+func (p Policy) requiresCredentials() bool {
+	return p.RequireJWT || p.APIKeyHash != "" || p.BasicAuth != nil
+}
+
+// parsePrefix accepts a CIDR or a single address.
+func parsePrefix(s string) (netip.Prefix, error) {
+	if strings.Contains(s, "/") {
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+		return prefix.Masked(), nil
+	}
+
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,248 @@
+package access
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync"
+
+	"github.com/EternisAI/silo-proxy/internal/auth"
+	"github.com/EternisAI/silo-proxy/internal/db/sqlc"
+	"github.com/EternisAI/silo-proxy/internal/forwarded"
+	"github.com/EternisAI/silo-proxy/internal/users"
+)
+
+const apiKeyHeader = "X-API-Key"
+
+var (
+	// ErrUnauthorized is returned when a request lacks valid credentials.
+	ErrUnauthorized = errors.New("unauthorized")
+	// ErrForbidden is returned when a request comes from an address that is
+	// not allowed.
+	ErrForbidden = errors.New("forbidden")
+)
+
+// Service holds the access policies of all agents. Policies are stored with
+// the agent record in Postgres and mirrored in memory so that requests can be
+// checked without a database round trip.
+type Service struct {
+	queries   *sqlc.Queries
+	jwtSecret string
+	proxies   *forwarded.TrustedProxies
+
+	mu       sync.RWMutex
+	policies map[string]*compiledPolicy
+}
+
+// compiledPolicy is a Policy with its networks parsed. verifiedBasic caches
+// the digest of the last basic auth credentials that matched, so that bcrypt
+// runs once rather than on every request.
+type compiledPolicy struct {
+	policy   Policy
+	networks []netip.Prefix
+
+	mu            sync.Mutex
+	verifiedBasic [sha256.Size]byte
+	basicVerified bool
+}
+
+func NewService(queries *sqlc.Queries, jwtSecret string) *Service {
+	return &Service{
+		queries:   queries,
+		jwtSecret: jwtSecret,
+		policies:  make(map[string]*compiledPolicy),
+	}
+}
+
+// SetTrustedProxies makes allowed_cidrs apply to the client address that
+// trusted proxies forwarded requests for, rather than to the proxies. It must
+// be called before requests are authorized.
+func (s *Service) SetTrustedProxies(proxies *forwarded.TrustedProxies) {
+	s.proxies = proxies
+}
+
+// Load replaces the in-memory policies with the ones in the database.
+func (s *Service) Load(ctx context.Context) error {
+	rows, err := s.queries.ListAgentAccessPolicies(ctx)
+	if err != nil {
+		return fmt.Errorf("list agent access policies: %w", err)
+	}
+
+	policies := make(map[string]*compiledPolicy, len(rows))
+	for _, row := range rows {
+		var policy Policy
+		if err := json.Unmarshal(row.AccessPolicy, &policy); err != nil {
+			return fmt.Errorf("decode access policy of agent %s: %w", row.ID, err)
+		}
+		compiled, err := compile(policy)
+		if err != nil {
+			return fmt.Errorf("access policy of agent %s: %w", row.ID, err)
+		}
+		if !policy.IsOpen() {
+			policies[row.ID] = compiled
+		}
+	}
+
+	s.mu.Lock()
+	s.policies = policies
+	s.mu.Unlock()
+	return nil
+}
+
+// Get returns the policy of agentID.
+func (s *Service) Get(agentID string) Policy {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if compiled, ok := s.policies[agentID]; ok {
+		return compiled.policy
+	}
+	return Policy{}
+}
+
+// Set replaces the policy of agentID. Setting the zero Policy opens the agent
+// to everyone.
+func (s *Service) Set(ctx context.Context, agentID string, policy Policy) error {
+	compiled, err := compile(policy)
+	if err != nil {
+		return err
+	}
+
+	encoded, err := json.Marshal(policy)
+	if err != nil {
+		return fmt.Errorf("encode access policy: %w", err)
+	}
+	if err := s.queries.SetAgentAccessPolicy(ctx, sqlc.SetAgentAccessPolicyParams{
+		ID:           agentID,
+		AccessPolicy: encoded,
+	}); err != nil {
+		return fmt.Errorf("set agent access policy: %w", err)
+	}
+
+	s.mu.Lock()
+	if policy.IsOpen() {
+		delete(s.policies, agentID)
+	} else {
+		s.policies[agentID] = compiled
+	}
+	s.mu.Unlock()
+
+	slog.Info("Agent access policy updated",
+		"agent_id", agentID,
+		"allowed_cidrs", policy.AllowedCIDRs,
+		"require_jwt", policy.RequireJWT,
+		"jwt_roles", policy.JWTRoles,
+		"jwt_users", policy.JWTUsers,
+		"api_key", policy.APIKeyHash != "",
+		"basic_auth", policy.BasicAuth != nil)
+	return nil
+}
+
+// Authorize checks r against the policy of agentID, returning ErrForbidden or
+// ErrUnauthorized if it is refused. The address checked is the client address
+// the trusted proxies forwarded the request for. On success the header
+// carrying the credentials is removed, so they are not forwarded to the agent.
+func (s *Service) Authorize(agentID string, r *http.Request) error {
+	s.mu.RLock()
+	compiled, ok := s.policies[agentID]
+	s.mu.RUnlock()
+
+	if !ok {
+		return nil
+	}
+
+	if len(compiled.networks) > 0 && !compiled.allows(s.proxies.ClientAddr(r)) {
+		return ErrForbidden
+	}
+	if !compiled.policy.requiresCredentials() {
+		return nil
+	}
+
+	if compiled.policy.APIKeyHash != "" {
+		if key := r.Header.Get(apiKeyHeader); key != "" &&
+			subtle.ConstantTimeCompare([]byte(hashAPIKey(key)), []byte(compiled.policy.APIKeyHash)) == 1 {
+			r.Header.Del(apiKeyHeader)
+			return nil
+		}
+	}
+
+	if compiled.policy.BasicAuth != nil {
+		if username, password, ok := r.BasicAuth(); ok && compiled.checkBasic(username, password) {
+			r.Header.Del("Authorization")
+			return nil
+		}
+	}
+
+	if compiled.policy.RequireJWT {
+		header := r.Header.Get("Authorization")
+		if token, found := strings.CutPrefix(header, "Bearer "); found {
+			if claims, err := auth.ValidateToken(s.jwtSecret, token); err == nil && compiled.policy.allowsClaims(claims) {
+				r.Header.Del("Authorization")
+				return nil
+			}
+		}
+	}
+
+	return ErrUnauthorized
+}
+
+// RequiresBasicAuth reports whether agentID accepts basic auth, in which case
+// refused requests should be challenged for it.
+func (s *Service) RequiresBasicAuth(agentID string) bool {
+	return s.Get(agentID).BasicAuth != nil
+}
+
+func compile(policy Policy) (*compiledPolicy, error) {
+	compiled := &compiledPolicy{policy: policy}
+	for _, cidr := range policy.AllowedCIDRs {
+		prefix, err := parsePrefix(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidPolicy, err)
+		}
+		compiled.networks = append(compiled.networks, prefix)
+	}
+	return compiled, nil
+}
+
+func (c *compiledPolicy) allows(addr netip.Addr) bool {
+	if !addr.IsValid() {
+		return false
+	}
+	for _, network := range c.networks {
+		if network.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *compiledPolicy) checkBasic(username, password string) bool {
+	if username != c.policy.BasicAuth.Username {
+		return false
+	}
+
+	digest := sha256.Sum256([]byte(password))
+	c.mu.Lock()
+	cached := c.basicVerified && subtle.ConstantTimeCompare(digest[:], c.verifiedBasic[:]) == 1
+	c.mu.Unlock()
+	if cached {
+		return true
+	}
+
+	if !users.CheckPassword(password, c.policy.BasicAuth.PasswordHash) {
+		return false
+	}
+
+	c.mu.Lock()
+	c.verifiedBasic = digest
+	c.basicVerified = true
+	c.mu.Unlock()
+	return true
+}
@@ -0,0 +1,222 @@
+package access
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/EternisAI/silo-proxy/internal/auth"
+	"github.com/EternisAI/silo-proxy/internal/forwarded"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testJWTSecret = "test-secret"
+
+func newTestService(t *testing.T, policies map[string]Settings) *Service {
+	s := NewService(nil, testJWTSecret)
+	for agentID, settings := range policies {
+		policy, err := NewPolicy(settings)
+		require.NoError(t, err)
+		compiled, err := compile(policy)
+		require.NoError(t, err)
+		s.policies[agentID] = compiled
+	}
+	return s
+}
+
+func newRequest(remoteAddr string) *http.Request {
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = remoteAddr
+	return r
+}
+
+func TestNewPolicy(t *testing.T) {
+	policy, err := NewPolicy(Settings{
+		AllowedCIDRs:      []string{"10.1.2.3/8", "192.168.1.10", "::1"},
+		APIKey:            "0123456789abcdef",
+		BasicAuthUsername: "admin",
+		BasicAuthPassword: "secret",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"10.0.0.0/8", "192.168.1.10/32", "::1/128"}, policy.AllowedCIDRs)
+	assert.NotEqual(t, "0123456789abcdef", policy.APIKeyHash)
+	require.NotNil(t, policy.BasicAuth)
+	assert.NotEqual(t, "secret", policy.BasicAuth.PasswordHash)
+	assert.False(t, policy.IsOpen())
+
+	for _, settings := range []Settings{
+		{AllowedCIDRs: []string{"10.0.0.0/33"}},
+		{AllowedCIDRs: []string{"not-an-ip"}},
+		{APIKey: "short"},
+		{BasicAuthUsername: "admin"},
+		{BasicAuthPassword: "secret"},
+		{BasicAuthUsername: "ad:min", BasicAuthPassword: "secret"},
+		{JWTRoles: []string{"Admin"}},
+		{RequireJWT: true, JWTRoles: []string{"Superuser"}},
+	} {
+		_, err := NewPolicy(settings)
+		assert.ErrorIs(t, err, ErrInvalidPolicy, "%+v", settings)
+	}
+
+	open, err := NewPolicy(Settings{})
+	require.NoError(t, err)
+	assert.True(t, open.IsOpen())
+
+	jwt, err := NewPolicy(Settings{RequireJWT: true})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Admin"}, jwt.JWTRoles)
+}
+
+func TestAuthorize_NoPolicy(t *testing.T) {
+	s := newTestService(t, nil)
+	assert.NoError(t, s.Authorize("agent-1", newRequest("203.0.113.1:1234")))
+}
+
+func TestAuthorize_AllowedCIDRs(t *testing.T) {
+	s := newTestService(t, map[string]Settings{
+		"agent-1": {AllowedCIDRs: []string{"10.0.0.0/8", "2001:db8::/32"}},
+	})
+
+	assert.NoError(t, s.Authorize("agent-1", newRequest("10.2.3.4:1234")))
+	assert.NoError(t, s.Authorize("agent-1", newRequest("[::ffff:10.2.3.4]:1234")))
+	assert.NoError(t, s.Authorize("agent-1", newRequest("[2001:db8::1]:1234")))
+	assert.ErrorIs(t, s.Authorize("agent-1", newRequest("192.168.0.1:1234")), ErrForbidden)
+
+	// Forwarding headers do not change the address that is checked
+	r := newRequest("192.168.0.1:1234")
+	r.Header.Set("X-Forwarded-For", "10.2.3.4")
+	assert.ErrorIs(t, s.Authorize("agent-1", r), ErrForbidden)
+}
+
+func TestAuthorize_AllowedCIDRsBehindTrustedProxy(t *testing.T) {
+	s := newTestService(t, map[string]Settings{
+		"agent-1": {AllowedCIDRs: []string{"10.0.0.0/8"}},
+	})
+	proxies, err := forwarded.ParseTrustedProxies([]string{"192.168.0.1"})
+	require.NoError(t, err)
+	s.SetTrustedProxies(proxies)
+
+	// The address the proxy forwarded for is checked, not the proxy's
+	r := newRequest("192.168.0.1:1234")
+	r.Header.Set("X-Forwarded-For", "10.2.3.4")
+	assert.NoError(t, s.Authorize("agent-1", r))
+
+	r = newRequest("192.168.0.1:1234")
+	r.Header.Set("X-Forwarded-For", "203.0.113.1")
+	assert.ErrorIs(t, s.Authorize("agent-1", r), ErrForbidden)
+
+	// Other peers cannot pose as an allowed address
+	r = newRequest("192.168.0.2:1234")
+	r.Header.Set("X-Forwarded-For", "10.2.3.4")
+	assert.ErrorIs(t, s.Authorize("agent-1", r), ErrForbidden)
+}
+
+func TestAuthorize_APIKey(t *testing.T) {
+	s := newTestService(t, map[string]Settings{
+		"agent-1": {APIKey: "0123456789abcdef"},
+	})
+
+	r := newRequest("10.0.0.1:1234")
+	assert.ErrorIs(t, s.Authorize("agent-1", r), ErrUnauthorized)
+
+	r.Header.Set("X-API-Key", "fedcba9876543210")
+	assert.ErrorIs(t, s.Authorize("agent-1", r), ErrUnauthorized)
+
+	r.Header.Set("X-API-Key", "0123456789abcdef")
+	assert.NoError(t, s.Authorize("agent-1", r))
+	assert.Empty(t, r.Header.Get("X-API-Key"), "credentials are not forwarded")
+
+	// Other agents are not affected
+	assert.NoError(t, s.Authorize("agent-2", newRequest("10.0.0.1:1234")))
+}
+
+func TestAuthorize_BasicAuth(t *testing.T) {
+	s := newTestService(t, map[string]Settings{
+		"agent-1": {BasicAuthUsername: "admin", BasicAuthPassword: "secret"},
+	})
+	assert.True(t, s.RequiresBasicAuth("agent-1"))
+	assert.False(t, s.RequiresBasicAuth("agent-2"))
+
+	r := newRequest("10.0.0.1:1234")
+	r.SetBasicAuth("admin", "wrong")
+	assert.ErrorIs(t, s.Authorize("agent-1", r), ErrUnauthorized)
+
+	r.SetBasicAuth("other", "secret")
+	assert.ErrorIs(t, s.Authorize("agent-1", r), ErrUnauthorized)
+
+	// The second check is answered from the cache
+	for i := 0; i < 2; i++ {
+		r.SetBasicAuth("admin", "secret")
+		assert.NoError(t, s.Authorize("agent-1", r))
+		assert.Empty(t, r.Header.Get("Authorization"))
+	}
+
+	r.SetBasicAuth("admin", "wrong")
+	assert.ErrorIs(t, s.Authorize("agent-1", r), ErrUnauthorized)
+}
+
+func TestAuthorize_JWT(t *testing.T) {
+	s := newTestService(t, map[string]Settings{
+		"agent-1": {RequireJWT: true},
+	})
+
+	token, err := auth.GenerateToken(auth.Config{Secret: testJWTSecret, ExpirationMinutes: 5}, "user-1", "alice", "Admin")
+	require.NoError(t, err)
+	otherToken, err := auth.GenerateToken(auth.Config{Secret: "other-secret", ExpirationMinutes: 5}, "user-1", "alice", "Admin")
+	require.NoError(t, err)
+	userToken, err := auth.GenerateToken(auth.Config{Secret: testJWTSecret, ExpirationMinutes: 5}, "user-2", "bob", "User")
+	require.NoError(t, err)
+
+	r := newRequest("10.0.0.1:1234")
+	r.Header.Set("Authorization", "Bearer "+otherToken)
+	assert.ErrorIs(t, s.Authorize("agent-1", r), ErrUnauthorized)
+
+	// Without roles or users, only Admin users are let through.
+	r.Header.Set("Authorization", "Bearer "+userToken)
+	assert.ErrorIs(t, s.Authorize("agent-1", r), ErrUnauthorized)
+
+	r.Header.Set("Authorization", "Bearer "+token)
+	assert.NoError(t, s.Authorize("agent-1", r))
+	assert.Empty(t, r.Header.Get("Authorization"))
+}
+
+func TestAuthorize_JWTRolesAndUsers(t *testing.T) {
+	s := newTestService(t, map[string]Settings{
+		"agent-1": {RequireJWT: true, JWTUsers: []string{"carol"}},
+		"agent-2": {RequireJWT: true, JWTRoles: []string{"User"}},
+	})
+
+	cfg := auth.Config{Secret: testJWTSecret, ExpirationMinutes: 5}
+	admin, err := auth.GenerateToken(cfg, "user-1", "alice", "Admin")
+	require.NoError(t, err)
+	bob, err := auth.GenerateToken(cfg, "user-2", "bob", "User")
+	require.NoError(t, err)
+	carol, err := auth.GenerateToken(cfg, "user-3", "carol", "User")
+	require.NoError(t, err)
+
+	authorize := func(agentID, token string) error {
+		r := newRequest("10.0.0.1:1234")
+		r.Header.Set("Authorization", "Bearer "+token)
+		return s.Authorize(agentID, r)
+	}
+
+	assert.NoError(t, authorize("agent-1", carol))
+	assert.ErrorIs(t, authorize("agent-1", bob), ErrUnauthorized)
+	assert.ErrorIs(t, authorize("agent-1", admin), ErrUnauthorized, "listing users replaces the Admin default")
+
+	assert.NoError(t, authorize("agent-2", bob))
+	assert.ErrorIs(t, authorize("agent-2", admin), ErrUnauthorized)
+}
+
+func TestAuthorize_AddressAndCredentials(t *testing.T) {
+	s := newTestService(t, map[string]Settings{
+		"agent-1": {AllowedCIDRs: []string{"10.0.0.0/8"}, APIKey: "0123456789abcdef"},
+	})
+
+	r := newRequest("192.168.0.1:1234")
+	r.Header.Set("X-API-Key", "0123456789abcdef")
+	assert.ErrorIs(t, s.Authorize("agent-1", r), ErrForbidden)
+
+	assert.ErrorIs(t, s.Authorize("agent-1", newRequest("10.0.0.1:1234")), ErrUnauthorized)
+}
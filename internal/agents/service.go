@@ -0,0 +1,343 @@
+package agents
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/EternisAI/silo-proxy/internal/db/sqlc"
+	grpcserver "github.com/EternisAI/silo-proxy/internal/grpc/server"
+	"github.com/EternisAI/silo-proxy/internal/metrics"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+var ErrAgentNotFound = errors.New("agent not found")
+
+const (
+	eventBuffer    = 1024
+	enqueueTimeout = time.Second
+	writeTimeout   = 5 * time.Second
+)
+
+// DisconnectReasonServerRestart ends the sessions a previous server process
+// left open.
+const DisconnectReasonServerRestart = "server_restart"
+
+// Agent is an agent that has connected at least once.
+type Agent struct {
+	ID                 string
+	CreatedAt          time.Time
+	LastConnectedAt    *time.Time
+	LastDisconnectedAt *time.Time
+	LastRemoteAddr     string
+	Port               int
+	CertSerial         string
+	Labels             map[string]string
+}
+
+// Session is one connection of an agent. DisconnectedAt is nil while it is
+// still open.
+type Session struct {
+	ID               string
+	RemoteAddr       string
+	Port             int
+	CertSerial       string
+	ConnectedAt      time.Time
+	DisconnectedAt   *time.Time
+	DisconnectReason string
+}
+
+// event is a session start (started != nil) or end queued for writing.
+type event struct {
+	agentID string
+	started *grpcserver.SessionInfo
+	reason  string
+	at      time.Time
+}
+
+// Service is the persistent registry of agents and their connection history.
+// It implements grpcserver.SessionRecorder: connection events are queued and
+// written by a single goroutine, in order, so the connection path never waits
+// on the database.
+type Service struct {
+	queries *sqlc.Queries
+
+	events chan event
+	done   chan struct{}
+	mu     sync.RWMutex
+	closed bool
+
+	// openSessions maps agent IDs to their open session. Only the writer
+	// goroutine touches it.
+	openSessions map[string]pgtype.UUID
+}
+
+func NewService(queries *sqlc.Queries) *Service {
+	s := &Service{
+		queries:      queries,
+		events:       make(chan event, eventBuffer),
+		done:         make(chan struct{}),
+		openSessions: make(map[string]pgtype.UUID),
+	}
+	go s.run()
+	return s
+}
+
+// SessionStarted records that an agent connected.
+func (s *Service) SessionStarted(session grpcserver.SessionInfo) {
+	s.enqueue(event{agentID: session.AgentID, started: &session, at: session.ConnectedAt})
+}
+
+// SessionEnded records that an agent's connection ended.
+func (s *Service) SessionEnded(agentID, reason string, at time.Time) {
+	s.enqueue(event{agentID: agentID, reason: reason, at: at})
+}
+
+// enqueue queues e for writing. If the queue is full, because the database
+// is slow or down, it waits up to enqueueTimeout for room before dropping e.
+func (s *Service) enqueue(e event) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.closed {
+		return
+	}
+	select {
+	case s.events <- e:
+		return
+	default:
+	}
+
+	timer := time.NewTimer(enqueueTimeout)
+	defer timer.Stop()
+	select {
+	case s.events <- e:
+	case <-timer.C:
+		metrics.AgentSessionEventsDropped.Inc()
+		slog.Warn("Agent session event dropped: queue full", "agent_id", e.agentID)
+	}
+}
+
+// CloseOpenSessions ends every session still open in the database, which
+// a previous server process left behind when it stopped without recording
+// its agents' disconnects, and returns the number of agents affected. It
+// must be called before agents connect.
+func (s *Service) CloseOpenSessions(ctx context.Context) (int64, error) {
+	closed, err := s.queries.CloseOpenAgentSessions(ctx, sqlc.CloseOpenAgentSessionsParams{
+		DisconnectedAt:   pgtype.Timestamp{Time: time.Now().UTC(), Valid: true},
+		DisconnectReason: pgtype.Text{String: DisconnectReasonServerRestart, Valid: true},
+	})
+	if err != nil {
+		return 0, fmt.Errorf("close open agent sessions: %w", err)
+	}
+	return closed, nil
+}
+
+// Close writes the queued events and stops the writer.
+func (s *Service) Close() {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+	s.closed = true
+	close(s.events)
+	s.mu.Unlock()
+
+	<-s.done
+}
+
+func (s *Service) run() {
+	defer close(s.done)
+
+	for e := range s.events {
+		ctx, cancel := context.WithTimeout(context.Background(), writeTimeout)
+		var err error
+		if e.started != nil {
+			err = s.writeSessionStarted(ctx, *e.started)
+		} else {
+			err = s.writeSessionEnded(ctx, e.agentID, e.reason, e.at)
+		}
+		cancel()
+
+		if err != nil {
+			slog.Error("Failed to record agent session", "agent_id", e.agentID, "error", err)
+		}
+	}
+}
+
+func (s *Service) writeSessionStarted(ctx context.Context, info grpcserver.SessionInfo) error {
+	connectedAt := pgtype.Timestamp{Time: info.ConnectedAt.UTC(), Valid: true}
+	remoteAddr := pgtype.Text{String: info.RemoteAddr, Valid: info.RemoteAddr != ""}
+	port := pgtype.Int4{Int32: int32(info.Port), Valid: info.Port != 0}
+	certSerial := pgtype.Text{String: info.CertSerial, Valid: info.CertSerial != ""}
+
+	if err := s.queries.UpsertConnectedAgent(ctx, sqlc.UpsertConnectedAgentParams{
+		ID:              info.AgentID,
+		LastConnectedAt: connectedAt,
+		LastRemoteAddr:  remoteAddr,
+		Port:            port,
+		CertSerial:      certSerial,
+	}); err != nil {
+		return fmt.Errorf("upsert agent: %w", err)
+	}
+
+	id, err := s.queries.CreateAgentSession(ctx, sqlc.CreateAgentSessionParams{
+		AgentID:     info.AgentID,
+		RemoteAddr:  remoteAddr,
+		Port:        port,
+		CertSerial:  certSerial,
+		ConnectedAt: connectedAt,
+	})
+	if err != nil {
+		return fmt.Errorf("create agent session: %w", err)
+	}
+	s.openSessions[info.AgentID] = id
+	return nil
+}
+
+func (s *Service) writeSessionEnded(ctx context.Context, agentID, reason string, at time.Time) error {
+	disconnectedAt := pgtype.Timestamp{Time: at.UTC(), Valid: true}
+
+	if err := s.queries.SetAgentDisconnected(ctx, sqlc.SetAgentDisconnectedParams{
+		ID:                 agentID,
+		LastDisconnectedAt: disconnectedAt,
+	}); err != nil {
+		return fmt.Errorf("update agent: %w", err)
+	}
+
+	id, ok := s.openSessions[agentID]
+	if !ok {
+		return nil
+	}
+	delete(s.openSessions, agentID)
+
+	if err := s.queries.EndAgentSession(ctx, sqlc.EndAgentSessionParams{
+		ID:               id,
+		DisconnectedAt:   disconnectedAt,
+		DisconnectReason: pgtype.Text{String: reason, Valid: true},
+	}); err != nil {
+		return fmt.Errorf("end agent session: %w", err)
+	}
+	return nil
+}
+
+// List returns every agent that has connected at least once.
+func (s *Service) List(ctx context.Context) ([]Agent, error) {
+	rows, err := s.queries.ListAgents(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("list agents: %w", err)
+	}
+
+	result := make([]Agent, len(rows))
+	for i, row := range rows {
+		if result[i], err = toAgent(row); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+func (s *Service) Get(ctx context.Context, agentID string) (Agent, error) {
+	row, err := s.queries.GetAgent(ctx, agentID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Agent{}, ErrAgentNotFound
+	}
+	if err != nil {
+		return Agent{}, fmt.Errorf("get agent: %w", err)
+	}
+	return toAgent(row)
+}
+
+// Sessions returns a page of agentID's sessions, newest first, and the total
+// number of sessions.
+func (s *Service) Sessions(ctx context.Context, agentID string, limit, offset int) ([]Session, int64, error) {
+	if _, err := s.Get(ctx, agentID); err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := s.queries.ListAgentSessions(ctx, sqlc.ListAgentSessionsParams{
+		AgentID: agentID,
+		Limit:   int32(limit),
+		Offset:  int32(offset),
+	})
+	if err != nil {
+		return nil, 0, fmt.Errorf("list agent sessions: %w", err)
+	}
+
+	total, err := s.queries.CountAgentSessions(ctx, agentID)
+	if err != nil {
+		return nil, 0, fmt.Errorf("count agent sessions: %w", err)
+	}
+
+	result := make([]Session, len(rows))
+	for i, row := range rows {
+		result[i] = Session{
+			ID:               uuid.UUID(row.ID.Bytes).String(),
+			RemoteAddr:       row.RemoteAddr.String,
+			Port:             int(row.Port.Int32),
+			CertSerial:       row.CertSerial.String,
+			ConnectedAt:      row.ConnectedAt.Time,
+			DisconnectedAt:   timePtr(row.DisconnectedAt),
+			DisconnectReason: row.DisconnectReason.String,
+		}
+	}
+	return result, total, nil
+}
+
+// SetLabels replaces the labels of agentID.
+func (s *Service) SetLabels(ctx context.Context, agentID string, labels map[string]string) error {
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	encoded, err := json.Marshal(labels)
+	if err != nil {
+		return fmt.Errorf("encode labels: %w", err)
+	}
+
+	updated, err := s.queries.UpdateAgentLabels(ctx, sqlc.UpdateAgentLabelsParams{
+		ID:     agentID,
+		Labels: encoded,
+	})
+	if err != nil {
+		return fmt.Errorf("update agent labels: %w", err)
+	}
+	if updated == 0 {
+		return ErrAgentNotFound
+	}
+	return nil
+}
+
+func toAgent(row sqlc.Agent) (Agent, error) {
+	labels := map[string]string{}
+	if len(row.Labels) > 0 {
+		if err := json.Unmarshal(row.Labels, &labels); err != nil {
+			return Agent{}, fmt.Errorf("decode labels of agent %s: %w", row.ID, err)
+		}
+	}
+
+	return Agent{
+		ID:                 row.ID,
+		CreatedAt:          row.CreatedAt.Time,
+		LastConnectedAt:    timePtr(row.LastConnectedAt),
+		LastDisconnectedAt: timePtr(row.LastDisconnectedAt),
+		LastRemoteAddr:     row.LastRemoteAddr.String,
+		Port:               int(row.Port.Int32),
+		CertSerial:         row.CertSerial.String,
+		Labels:             labels,
+	}, nil
+}
+
+func timePtr(ts pgtype.Timestamp) *time.Time {
+	if !ts.Valid {
+		return nil
+	}
+	t := ts.Time
+	return &t
+}
@@ -0,0 +1,62 @@
+package http
+
+import (
+	"github.com/EternisAI/silo-proxy/internal/api/http/handler"
+	"github.com/EternisAI/silo-proxy/internal/api/http/middleware"
+	"github.com/EternisAI/silo-proxy/internal/forwarded"
+	grpcserver "github.com/EternisAI/silo-proxy/internal/grpc/server"
+	"github.com/gin-gonic/gin"
+)
+
+// AgentForwarder forwards requests to agents the way an agent's own port does:
+// the agent's access policy is checked first, then its rate limits, and the
+// request is proxied to the agent. The per-agent servers, virtual hosts and
+// pools all forward through one.
+type AgentForwarder struct {
+	access  middleware.AgentAuthorizer
+	limiter middleware.AgentLimiter
+	proxies *forwarded.TrustedProxies
+	proxy   *handler.ProxyHandler
+}
+
+// NewAgentForwarder creates a forwarder to the agents connected to gs, with
+// no access policies or rate limits.
+func NewAgentForwarder(gs *grpcserver.Server) *AgentForwarder {
+	return &AgentForwarder{proxy: handler.NewProxyHandler(gs)}
+}
+
+// SetAccessPolicies enforces the agents' access policies. It must be called
+// before requests are forwarded.
+func (f *AgentForwarder) SetAccessPolicies(authorizer middleware.AgentAuthorizer) {
+	f.access = authorizer
+}
+
+// SetRateLimits applies limiter to requests. It must be called before
+// requests are forwarded.
+func (f *AgentForwarder) SetRateLimits(limiter middleware.AgentLimiter) {
+	f.limiter = limiter
+}
+
+// SetTrustedProxies sets the peers whose forwarding headers are kept and
+// whose clients the rate limits apply to. It must be called before requests
+// are forwarded.
+func (f *AgentForwarder) SetTrustedProxies(proxies *forwarded.TrustedProxies) {
+	f.proxies = proxies
+	f.proxy.SetTrustedProxies(proxies)
+}
+
+// Forward sends the request to agentID if its access policy and rate limits
+// admit it, and otherwise writes the refusal.
+func (f *AgentForwarder) Forward(c *gin.Context, agentID string) {
+	if f.access != nil && !middleware.AuthorizeAgent(c, f.access, agentID) {
+		return
+	}
+	if f.limiter != nil {
+		release, ok := middleware.LimitAgent(c, f.limiter, f.proxies, agentID)
+		if !ok {
+			return
+		}
+		defer release()
+	}
+	f.proxy.ProxyRequestDirect(c, agentID)
+}
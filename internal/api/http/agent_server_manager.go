@@ -10,7 +10,6 @@ import (
 	"sync"
 	"time"
 
-	"github.com/EternisAI/silo-proxy/internal/api/http/handler"
 	"github.com/EternisAI/silo-proxy/internal/api/http/middleware"
 	grpcserver "github.com/EternisAI/silo-proxy/internal/grpc/server"
 	"github.com/gin-gonic/gin"
@@ -32,8 +31,11 @@ type AgentServerInfo struct {
 
 // AgentServerManager manages the lifecycle of per-agent HTTP servers.
 // Each connected agent gets its own dedicated HTTP server listening on
-// a unique port allocated from the PortManager.
+// a unique port allocated from the PortManager, which forwards every request
+// to the agent through the embedded AgentForwarder.
 type AgentServerManager struct {
+	*AgentForwarder
+
 	servers     map[string]*AgentServerInfo // agentID -> server info
 	mu          sync.RWMutex
 	portManager *PortManager
@@ -46,9 +48,10 @@ type AgentServerManager struct {
 // for forwarding requests to agents.
 func NewAgentServerManager(pm *PortManager, gs *grpcserver.Server) *AgentServerManager {
 	return &AgentServerManager{
-		servers:     make(map[string]*AgentServerInfo),
-		portManager: pm,
-		grpcServer:  gs,
+		AgentForwarder: NewAgentForwarder(gs),
+		servers:        make(map[string]*AgentServerInfo),
+		portManager:    pm,
+		grpcServer:     gs,
 	}
 }
 
@@ -70,6 +73,15 @@ func (asm *AgentServerManager) StartAgentServer(agentID string) (int, error) {
 	var srv *http.Server
 	var lastErr error
 
+	// Ports that failed to bind are only released once we are done, so a
+	// retry does not get the same reserved port back.
+	var unbindable []int
+	defer func() {
+		for _, p := range unbindable {
+			asm.portManager.Release(p)
+		}
+	}()
+
 	for attempt := 1; attempt <= maxPortBindRetries; attempt++ {
 		// Allocate port from pool
 		allocatedPort, err := asm.portManager.Allocate(agentID)
@@ -80,8 +92,8 @@ func (asm *AgentServerManager) StartAgentServer(agentID string) (int, error) {
 		// Try to bind the port synchronously to verify it's available
 		listener, err := net.Listen("tcp", fmt.Sprintf(":%d", allocatedPort))
 		if err != nil {
-			// Binding failed, release port and retry
-			asm.portManager.Release(allocatedPort)
+			// Binding failed, retry with another port
+			unbindable = append(unbindable, allocatedPort)
 			lastErr = fmt.Errorf("failed to bind port %d: %w", allocatedPort, err)
 			slog.Warn("Port binding failed, retrying",
 				"agent_id", agentID,
@@ -258,13 +270,9 @@ func (asm *AgentServerManager) createAgentEngine(agentID string) *gin.Engine {
 	engine.Use(middleware.RequestLogger())
 	engine.Use(gin.Recovery())
 
-	// Create proxy handler for this specific agent
-	proxyHandler := handler.NewProxyHandler(asm.grpcServer)
-
 	// All requests route directly to this agent (no agent_id prefix needed)
 	engine.NoRoute(func(c *gin.Context) {
-		// Forward all requests to this specific agent
-		proxyHandler.ProxyRequestDirect(c, agentID)
+		asm.Forward(c, agentID)
 	})
 
 	return engine
@@ -1,14 +1,128 @@
 package dto
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+)
 
 type AgentInfo struct {
-	AgentID  string    `json:"agent_id"`
-	Port     int       `json:"port"`
-	LastSeen time.Time `json:"last_seen"`
+	AgentID         string         `json:"agent_id"`
+	Port            int            `json:"port"`
+	TCPTunnels      map[string]int `json:"tcp_tunnels,omitempty"`
+	LastSeen        time.Time      `json:"last_seen"`
+	ProtocolVersion int            `json:"protocol_version"`
+	Version         string         `json:"version,omitempty"`
+	OS              string         `json:"os,omitempty"`
+	Arch            string         `json:"arch,omitempty"`
+	Hostname        string         `json:"hostname,omitempty"`
+	Capabilities    []string       `json:"capabilities"`
+	Streams         int            `json:"streams"`
+	Draining        bool           `json:"draining"`
+	PendingRequests int            `json:"pending_requests"`
 }
 
 type AgentsResponse struct {
 	Agents []AgentInfo `json:"agents"`
 	Count  int         `json:"count"`
 }
+
+type AgentDisconnectResponse struct {
+	AgentID         string     `json:"agent_id"`
+	Draining        bool       `json:"draining,omitempty"`
+	PendingRequests int        `json:"pending_requests,omitempty"`
+	BlockedUntil    *time.Time `json:"blocked_until,omitempty"`
+}
+
+type RegisteredAgent struct {
+	AgentID            string            `json:"agent_id"`
+	Online             bool              `json:"online"`
+	CreatedAt          time.Time         `json:"created_at"`
+	LastConnectedAt    *time.Time        `json:"last_connected_at,omitempty"`
+	LastDisconnectedAt *time.Time        `json:"last_disconnected_at,omitempty"`
+	LastRemoteAddr     string            `json:"last_remote_addr,omitempty"`
+	Port               int               `json:"port,omitempty"`
+	CertSerial         string            `json:"cert_serial,omitempty"`
+	Labels             map[string]string `json:"labels"`
+}
+
+type RegisteredAgentsResponse struct {
+	Agents []RegisteredAgent `json:"agents"`
+	Count  int               `json:"count"`
+}
+
+type AgentSession struct {
+	ID               string     `json:"id"`
+	RemoteAddr       string     `json:"remote_addr,omitempty"`
+	Port             int        `json:"port,omitempty"`
+	CertSerial       string     `json:"cert_serial,omitempty"`
+	ConnectedAt      time.Time  `json:"connected_at"`
+	DisconnectedAt   *time.Time `json:"disconnected_at,omitempty"`
+	DisconnectReason string     `json:"disconnect_reason,omitempty"`
+}
+
+type AgentSessionsResponse struct {
+	Sessions []AgentSession `json:"sessions"`
+	Total    int64          `json:"total"`
+	Page     int            `json:"page"`
+	PageSize int            `json:"page_size"`
+}
+
+type UpdateAgentLabelsRequest struct {
+	Labels map[string]string `json:"labels" binding:"required"`
+}
+
+type AgentPortReservation struct {
+	AgentID string `json:"agent_id"`
+	Port    int    `json:"port"`
+	Pinned  bool   `json:"pinned"`
+}
+
+type PinAgentPortRequest struct {
+	Port int `json:"port" binding:"required"`
+}
+
+type AgentDomainsResponse struct {
+	AgentID string   `json:"agent_id"`
+	Domains []string `json:"domains"`
+}
+
+type AddAgentDomainRequest struct {
+	Domain string `json:"domain" binding:"required"`
+}
+
+type AgentAccessPolicy struct {
+	AgentID           string   `json:"agent_id"`
+	AllowedCIDRs      []string `json:"allowed_cidrs"`
+	RequireJWT        bool     `json:"require_jwt"`
+	JWTRoles          []string `json:"jwt_roles,omitempty"`
+	JWTUsers          []string `json:"jwt_users,omitempty"`
+	APIKeySet         bool     `json:"api_key_set"`
+	BasicAuthUsername string   `json:"basic_auth_username,omitempty"`
+}
+
+type UpdateAgentAccessPolicyRequest struct {
+	AllowedCIDRs []string              `json:"allowed_cidrs"`
+	RequireJWT   bool                  `json:"require_jwt"`
+	JWTRoles     []string              `json:"jwt_roles"`
+	JWTUsers     []string              `json:"jwt_users"`
+	APIKey       string                `json:"api_key"`
+	BasicAuth    *BasicAuthCredentials `json:"basic_auth"`
+}
+
+type BasicAuthCredentials struct {
+	Username string `json:"username" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
+
+type RunAgentCommandRequest struct {
+	Command        string          `json:"command" binding:"required"`
+	Args           json.RawMessage `json:"args"`
+	TimeoutSeconds float64         `json:"timeout_seconds"`
+}
+
+type AgentCommandResult struct {
+	AgentID    string          `json:"agent_id"`
+	Command    string          `json:"command"`
+	Result     json.RawMessage `json:"result"`
+	DurationMs int64           `json:"duration_ms"`
+}
@@ -0,0 +1,20 @@
+package dto
+
+type PoolMember struct {
+	AgentID         string `json:"agent_id"`
+	Available       bool   `json:"available"`
+	PendingRequests int    `json:"pending_requests"`
+}
+
+type Pool struct {
+	Name    string       `json:"name"`
+	Balance string       `json:"balance"`
+	Port    int          `json:"port,omitempty"`
+	Host    string       `json:"host,omitempty"`
+	Agents  []PoolMember `json:"agents"`
+}
+
+type PoolsResponse struct {
+	Pools []Pool `json:"pools"`
+	Count int    `json:"count"`
+}
@@ -23,13 +23,19 @@ type ProvisionKeyInfo struct {
 	ExpiresAt time.Time `json:"expires_at"`
 }
 
+// ProvisionRequest exchanges a provision key for a certificate. CSR is a PEM
+// encoded PKCS#10 request whose subject CommonName is the key's agent ID. It
+// may only be omitted when the server allows server-generated keys.
 type ProvisionRequest struct {
 	Key string `json:"key" binding:"required"`
+	CSR string `json:"csr,omitempty"`
 }
 
+// ProvisionResponse carries the issued certificate. KeyPEM is only set when
+// the server generated the key pair.
 type ProvisionResponse struct {
 	AgentID   string `json:"agent_id"`
 	CertPEM   string `json:"cert_pem"`
-	KeyPEM    string `json:"key_pem"`
+	KeyPEM    string `json:"key_pem,omitempty"`
 	CACertPEM string `json:"ca_cert_pem"`
 }
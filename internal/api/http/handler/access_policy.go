@@ -0,0 +1,97 @@
+package handler
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/EternisAI/silo-proxy/internal/access"
+	"github.com/EternisAI/silo-proxy/internal/api/http/dto"
+	"github.com/gin-gonic/gin"
+)
+
+// AccessPolicyHandler manages who may reach each agent's local services.
+// Secrets are write-only: responses only say whether they are set.
+type AccessPolicyHandler struct {
+	policies *access.Service
+}
+
+func NewAccessPolicyHandler(policies *access.Service) *AccessPolicyHandler {
+	return &AccessPolicyHandler{policies: policies}
+}
+
+func (h *AccessPolicyHandler) GetPolicy(ctx *gin.Context) {
+	agentID := ctx.Param("id")
+	ctx.JSON(http.StatusOK, toAccessPolicyDTO(agentID, h.policies.Get(agentID)))
+}
+
+// UpdatePolicy replaces the agent's policy, including its secrets.
+func (h *AccessPolicyHandler) UpdatePolicy(ctx *gin.Context) {
+	var req dto.UpdateAgentAccessPolicyRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	settings := access.Settings{
+		AllowedCIDRs: req.AllowedCIDRs,
+		RequireJWT:   req.RequireJWT,
+		JWTRoles:     req.JWTRoles,
+		JWTUsers:     req.JWTUsers,
+		APIKey:       req.APIKey,
+	}
+	if req.BasicAuth != nil {
+		settings.BasicAuthUsername = req.BasicAuth.Username
+		settings.BasicAuthPassword = req.BasicAuth.Password
+	}
+
+	policy, err := access.NewPolicy(settings)
+	if err != nil {
+		h.respondError(ctx, err)
+		return
+	}
+
+	agentID := ctx.Param("id")
+	if err := h.policies.Set(ctx.Request.Context(), agentID, policy); err != nil {
+		h.respondError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, toAccessPolicyDTO(agentID, policy))
+}
+
+// DeletePolicy opens the agent to everyone who can reach its port.
+func (h *AccessPolicyHandler) DeletePolicy(ctx *gin.Context) {
+	if err := h.policies.Set(ctx.Request.Context(), ctx.Param("id"), access.Policy{}); err != nil {
+		h.respondError(ctx, err)
+		return
+	}
+	ctx.Status(http.StatusNoContent)
+}
+
+func (h *AccessPolicyHandler) respondError(ctx *gin.Context, err error) {
+	if errors.Is(err, access.ErrInvalidPolicy) {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	slog.Error("Access policy request failed", "error", err, "agent_id", ctx.Param("id"))
+	ctx.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+}
+
+func toAccessPolicyDTO(agentID string, policy access.Policy) dto.AgentAccessPolicy {
+	result := dto.AgentAccessPolicy{
+		AgentID:      agentID,
+		AllowedCIDRs: policy.AllowedCIDRs,
+		RequireJWT:   policy.RequireJWT,
+		JWTRoles:     policy.JWTRoles,
+		JWTUsers:     policy.JWTUsers,
+		APIKeySet:    policy.APIKeyHash != "",
+	}
+	if result.AllowedCIDRs == nil {
+		result.AllowedCIDRs = []string{}
+	}
+	if policy.BasicAuth != nil {
+		result.BasicAuthUsername = policy.BasicAuth.Username
+	}
+	return result
+}
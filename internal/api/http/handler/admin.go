@@ -1,7 +1,13 @@
 package handler
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/EternisAI/silo-proxy/internal/api/http/dto"
 	grpcserver "github.com/EternisAI/silo-proxy/internal/grpc/server"
@@ -24,12 +30,27 @@ func (h *AdminHandler) ListAgents(ctx *gin.Context) {
 
 	agents := make([]dto.AgentInfo, 0, len(agentIDs))
 	for _, agentID := range agentIDs {
-		conn, ok := connManager.GetConnection(agentID)
-		if ok {
+		conns := connManager.Connections(agentID)
+		if len(conns) > 0 {
+			conn := conns[0]
+			capabilities := conn.Info.Capabilities
+			if capabilities == nil {
+				capabilities = []string{}
+			}
 			agents = append(agents, dto.AgentInfo{
-				AgentID:  conn.ID,
-				Port:     conn.Port,
-				LastSeen: conn.LastSeen,
+				AgentID:         conn.ID,
+				Port:            conn.Port,
+				TCPTunnels:      conn.TCPTunnels,
+				LastSeen:        conn.LastSeen,
+				ProtocolVersion: conn.Info.ProtocolVersion,
+				Version:         conn.Info.Version,
+				OS:              conn.Info.OS,
+				Arch:            conn.Info.Arch,
+				Hostname:        conn.Info.Hostname,
+				Capabilities:    capabilities,
+				Streams:         len(conns),
+				Draining:        conn.Draining(),
+				PendingRequests: h.grpcServer.PendingRequests(conn.ID),
 			})
 		}
 	}
@@ -39,3 +60,105 @@ func (h *AdminHandler) ListAgents(ctx *gin.Context) {
 		Count:  len(agents),
 	})
 }
+
+// defaultDrainTimeout bounds a drain when no timeout_seconds is given.
+const defaultDrainTimeout = time.Minute
+
+// DisconnectAgent closes the agent's connection. With ?block_seconds=N the
+// agent is refused when it reconnects for the next N seconds.
+func (h *AdminHandler) DisconnectAgent(ctx *gin.Context) {
+	agentID := ctx.Param("id")
+	blockFor, err := secondsQuery(ctx, "block_seconds", 0)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.grpcServer.DisconnectAgent(agentID, blockFor); err != nil {
+		h.respondError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, h.disconnectResponse(agentID))
+}
+
+// DrainAgent stops new requests to the agent, which get 503, and disconnects
+// it in the background once its in-flight requests have completed, or after
+// ?timeout_seconds (default 60). ?block_seconds applies as for
+// DisconnectAgent once the agent is disconnected.
+func (h *AdminHandler) DrainAgent(ctx *gin.Context) {
+	agentID := ctx.Param("id")
+	timeout, err := secondsQuery(ctx, "timeout_seconds", defaultDrainTimeout)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	blockFor, err := secondsQuery(ctx, "block_seconds", 0)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	conn, ok := h.grpcServer.GetConnectionManager().GetConnection(agentID)
+	if !ok {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "agent not found"})
+		return
+	}
+	if conn.Draining() {
+		ctx.JSON(http.StatusConflict, gin.H{"error": "agent is already draining"})
+		return
+	}
+
+	go func() {
+		if err := h.grpcServer.DrainAgent(context.Background(), agentID, timeout, blockFor); err != nil {
+			slog.Warn("Agent drain ended early", "agent_id", agentID, "error", err)
+		}
+	}()
+
+	ctx.JSON(http.StatusAccepted, dto.AgentDisconnectResponse{
+		AgentID:         agentID,
+		Draining:        true,
+		PendingRequests: h.grpcServer.PendingRequests(agentID),
+	})
+}
+
+// UnblockAgent lets a blocked agent reconnect right away.
+func (h *AdminHandler) UnblockAgent(ctx *gin.Context) {
+	if !h.grpcServer.UnblockAgent(ctx.Param("id")) {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "agent is not blocked"})
+		return
+	}
+	ctx.Status(http.StatusNoContent)
+}
+
+func (h *AdminHandler) disconnectResponse(agentID string) dto.AgentDisconnectResponse {
+	resp := dto.AgentDisconnectResponse{AgentID: agentID}
+	if until, ok := h.grpcServer.BlockedUntil(agentID); ok {
+		resp.BlockedUntil = &until
+	}
+	return resp
+}
+
+func (h *AdminHandler) respondError(ctx *gin.Context, err error) {
+	switch {
+	case errors.Is(err, grpcserver.ErrAgentNotFound):
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "agent not found"})
+	default:
+		slog.Error("Agent admin request failed", "error", err, "agent_id", ctx.Param("id"))
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+	}
+}
+
+// secondsQuery parses the query parameter name as a non-negative number of
+// seconds, returning def if it is missing.
+func secondsQuery(ctx *gin.Context, name string, def time.Duration) (time.Duration, error) {
+	value := ctx.Query(name)
+	if value == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("%s must be a non-negative integer", name)
+	}
+	return time.Duration(n) * time.Second, nil
+}
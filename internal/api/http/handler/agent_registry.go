@@ -0,0 +1,152 @@
+package handler
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/EternisAI/silo-proxy/internal/agents"
+	"github.com/EternisAI/silo-proxy/internal/api/http/dto"
+	grpcserver "github.com/EternisAI/silo-proxy/internal/grpc/server"
+	"github.com/gin-gonic/gin"
+)
+
+// AgentRegistryHandler serves the persistent agent registry: every agent that
+// has ever connected, whether it is online now, and its session history.
+type AgentRegistryHandler struct {
+	registry   *agents.Service
+	grpcServer *grpcserver.Server
+}
+
+func NewAgentRegistryHandler(registry *agents.Service, grpcServer *grpcserver.Server) *AgentRegistryHandler {
+	return &AgentRegistryHandler{
+		registry:   registry,
+		grpcServer: grpcServer,
+	}
+}
+
+func (h *AgentRegistryHandler) ListAgents(ctx *gin.Context) {
+	agentList, err := h.registry.List(ctx.Request.Context())
+	if err != nil {
+		slog.Error("Failed to list registered agents", "error", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+		return
+	}
+
+	result := make([]dto.RegisteredAgent, len(agentList))
+	for i, a := range agentList {
+		result[i] = h.toDTO(a)
+	}
+
+	ctx.JSON(http.StatusOK, dto.RegisteredAgentsResponse{
+		Agents: result,
+		Count:  len(result),
+	})
+}
+
+func (h *AgentRegistryHandler) GetAgent(ctx *gin.Context) {
+	agent, err := h.registry.Get(ctx.Request.Context(), ctx.Param("id"))
+	if err != nil {
+		h.respondError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, h.toDTO(agent))
+}
+
+func (h *AgentRegistryHandler) ListSessions(ctx *gin.Context) {
+	page, _ := strconv.Atoi(ctx.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(ctx.DefaultQuery("page_size", "20"))
+
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 || pageSize > 100 {
+		pageSize = 20
+	}
+
+	offset := (page - 1) * pageSize
+	sessions, total, err := h.registry.Sessions(ctx.Request.Context(), ctx.Param("id"), pageSize, offset)
+	if err != nil {
+		h.respondError(ctx, err)
+		return
+	}
+
+	result := make([]dto.AgentSession, len(sessions))
+	for i, s := range sessions {
+		result[i] = dto.AgentSession{
+			ID:               s.ID,
+			RemoteAddr:       s.RemoteAddr,
+			Port:             s.Port,
+			CertSerial:       s.CertSerial,
+			ConnectedAt:      s.ConnectedAt,
+			DisconnectedAt:   s.DisconnectedAt,
+			DisconnectReason: s.DisconnectReason,
+		}
+	}
+
+	ctx.JSON(http.StatusOK, dto.AgentSessionsResponse{
+		Sessions: result,
+		Total:    total,
+		Page:     page,
+		PageSize: pageSize,
+	})
+}
+
+func (h *AgentRegistryHandler) UpdateLabels(ctx *gin.Context) {
+	var req dto.UpdateAgentLabelsRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	for key := range req.Labels {
+		if key == "" {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "label keys must not be empty"})
+			return
+		}
+	}
+
+	agentID := ctx.Param("id")
+	if err := h.registry.SetLabels(ctx.Request.Context(), agentID, req.Labels); err != nil {
+		h.respondError(ctx, err)
+		return
+	}
+
+	agent, err := h.registry.Get(ctx.Request.Context(), agentID)
+	if err != nil {
+		h.respondError(ctx, err)
+		return
+	}
+
+	slog.Info("Agent labels updated", "agent_id", agentID, "labels", req.Labels)
+	ctx.JSON(http.StatusOK, h.toDTO(agent))
+}
+
+func (h *AgentRegistryHandler) respondError(ctx *gin.Context, err error) {
+	if errors.Is(err, agents.ErrAgentNotFound) {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "agent not found"})
+		return
+	}
+	slog.Error("Agent registry request failed", "error", err, "agent_id", ctx.Param("id"))
+	ctx.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+}
+
+func (h *AgentRegistryHandler) toDTO(a agents.Agent) dto.RegisteredAgent {
+	online := false
+	if h.grpcServer != nil {
+		_, online = h.grpcServer.GetConnectionManager().GetConnection(a.ID)
+	}
+
+	return dto.RegisteredAgent{
+		AgentID:            a.ID,
+		Online:             online,
+		CreatedAt:          a.CreatedAt,
+		LastConnectedAt:    a.LastConnectedAt,
+		LastDisconnectedAt: a.LastDisconnectedAt,
+		LastRemoteAddr:     a.LastRemoteAddr,
+		Port:               a.Port,
+		CertSerial:         a.CertSerial,
+		Labels:             a.Labels,
+	}
+}
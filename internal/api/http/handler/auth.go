@@ -4,6 +4,7 @@ import (
 	"errors"
 	"log/slog"
 	"net/http"
+	"strings"
 
 	"github.com/EternisAI/silo-proxy/internal/api/http/dto"
 	"github.com/EternisAI/silo-proxy/internal/auth"
@@ -43,6 +44,24 @@ func (h *AuthHandler) Register(c *gin.Context) {
 	})
 }
 
+// StartProxySession sets the auth.ProxySessionCookie for /proxy/:agent_id to
+// the token the request was authenticated with, so that a browser can then
+// navigate the agent's app. The cookie lasts for the browser session and is
+// only valid as long as the token.
+func (h *AuthHandler) StartProxySession(c *gin.Context) {
+	token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     auth.ProxySessionCookie,
+		Value:    token,
+		Path:     "/proxy/" + c.Param("agent_id") + "/",
+		HttpOnly: true,
+		Secure:   c.Request.TLS != nil,
+		SameSite: http.SameSiteStrictMode,
+	})
+	c.Status(http.StatusNoContent)
+}
+
 func (h *AuthHandler) Login(c *gin.Context) {
 	var req dto.LoginRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -8,8 +8,12 @@ import (
 	"fmt"
 	"log/slog"
 	"net/http"
+	"slices"
 
 	"github.com/EternisAI/silo-proxy/internal/cert"
+	grpcserver "github.com/EternisAI/silo-proxy/internal/grpc/server"
+	"github.com/EternisAI/silo-proxy/internal/renewal"
+	"github.com/EternisAI/silo-proxy/internal/revocation"
 	"github.com/gin-gonic/gin"
 )
 
@@ -26,12 +30,18 @@ func (h *CertHandler) validateAgentID(ctx *gin.Context) (string, bool) {
 }
 
 type CertHandler struct {
-	certService *cert.Service
+	certService       *cert.Service
+	revocationService *revocation.Service
+	renewalService    *renewal.Service
+	grpcServer        *grpcserver.Server
 }
 
-func NewCertHandler(certService *cert.Service) *CertHandler {
+func NewCertHandler(certService *cert.Service, revocationService *revocation.Service, renewalService *renewal.Service, grpcServer *grpcserver.Server) *CertHandler {
 	return &CertHandler{
-		certService: certService,
+		certService:       certService,
+		revocationService: revocationService,
+		renewalService:    renewalService,
+		grpcServer:        grpcServer,
 	}
 }
 
@@ -173,7 +183,57 @@ func (h *CertHandler) DeleteAgentCertificate(ctx *gin.Context) {
 		return
 	}
 
-	slog.Info("Deleting agent certificate", "agent_id", agentID)
+	reason := ctx.DefaultQuery("reason", "unspecified")
+	if err := revocation.ValidateReason(reason); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	slog.Info("Deleting agent certificate", "agent_id", agentID, "reason", reason)
+
+	agentCert, err := h.certService.LoadAgentCert(agentID)
+	if err != nil {
+		slog.Error("Failed to load agent certificate for revocation", "error", err, "agent_id", agentID)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to load agent certificate",
+		})
+		return
+	}
+
+	revokedSerials := []string{revocation.SerialKey(agentCert.SerialNumber)}
+	if h.revocationService != nil {
+		if err := h.revocationService.Revoke(ctx.Request.Context(), agentID, agentCert, reason); err != nil {
+			slog.Error("Failed to revoke agent certificate", "error", err, "agent_id", agentID)
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"error": "Failed to revoke agent certificate",
+			})
+			return
+		}
+	}
+	// Renewals leave earlier certificates valid until the agent connects with
+	// the new one, so every certificate issued to the agent is revoked.
+	if h.renewalService != nil {
+		serials, err := h.renewalService.RevokeAgentCerts(ctx.Request.Context(), agentID, reason)
+		if err != nil {
+			slog.Error("Failed to revoke renewed agent certificates", "error", err, "agent_id", agentID)
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"error": "Failed to revoke agent certificate",
+			})
+			return
+		}
+		for _, serial := range serials {
+			if !slices.Contains(revokedSerials, serial) {
+				revokedSerials = append(revokedSerials, serial)
+			}
+		}
+	}
+
+	var disconnected []string
+	if h.grpcServer != nil {
+		disconnected = h.grpcServer.DisconnectRevokedAgents()
+	}
 
 	certDir := h.certService.GetAgentCertDir(agentID)
 	if err := h.certService.DeleteAgentCert(agentID); err != nil {
@@ -185,12 +245,41 @@ func (h *CertHandler) DeleteAgentCertificate(ctx *gin.Context) {
 	}
 
 	ctx.JSON(http.StatusOK, gin.H{
-		"message":       "Successfully deleted agent certificate",
-		"deleted_paths": []string{certDir},
+		"message":             "Successfully revoked and deleted agent certificate",
+		"deleted_paths":       []string{certDir},
+		"revoked_serial":      revocation.SerialKey(agentCert.SerialNumber),
+		"revoked_serials":     revokedSerials,
+		"disconnected_agents": disconnected,
 	})
 	slog.Info("Agent certificate deleted successfully", "agent_id", agentID)
 }
 
+// GetCRL serves the CA-signed revocation list, DER encoded by default or PEM
+// with ?format=pem.
+func (h *CertHandler) GetCRL(ctx *gin.Context) {
+	if h.revocationService == nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": "TLS is not enabled on this server",
+		})
+		return
+	}
+
+	crl, err := h.revocationService.CRL(ctx.Request.Context())
+	if err != nil {
+		slog.Error("Failed to create CRL", "error", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to create certificate revocation list",
+		})
+		return
+	}
+
+	if ctx.Query("format") == "pem" {
+		ctx.Data(http.StatusOK, "application/x-pem-file", cert.CRLToPEM(crl))
+		return
+	}
+	ctx.Data(http.StatusOK, "application/pkix-crl", crl)
+}
+
 func (h *CertHandler) createCertZip(agentID string, agentCert *x509.Certificate, agentKey *rsa.PrivateKey, caCertBytes []byte) (*bytes.Buffer, error) {
 	agentCertPEM, err := cert.CertToPEM(agentCert)
 	if err != nil {
@@ -212,9 +301,12 @@ func (h *CertHandler) createCertZipFromBytes(agentID string, certPEM, keyPEM, ca
 
 	files := map[string][]byte{
 		fmt.Sprintf("%s-cert.pem", agentID): certPEM,
-		fmt.Sprintf("%s-key.pem", agentID):  keyPEM,
 		"ca-cert.pem":                       caCertBytes,
 	}
+	// Agents provisioned from a CSR keep their key; there is none to bundle.
+	if keyPEM != nil {
+		files[fmt.Sprintf("%s-key.pem", agentID)] = keyPEM
+	}
 
 	for filename, content := range files {
 		f, err := zipWriter.Create(filename)
@@ -0,0 +1,112 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/EternisAI/silo-proxy/internal/api/http/dto"
+	"github.com/EternisAI/silo-proxy/internal/commands"
+	"github.com/EternisAI/silo-proxy/internal/grpc/protocol"
+	grpcserver "github.com/EternisAI/silo-proxy/internal/grpc/server"
+	"github.com/gin-gonic/gin"
+)
+
+// CommandSender runs commands on connected agents. The gRPC server satisfies
+// it.
+type CommandSender interface {
+	SendCommand(ctx context.Context, agentID, name string, args json.RawMessage, timeout time.Duration) (json.RawMessage, error)
+}
+
+// CommandHandler lets admins run the commands allowed by a commands.Policy on
+// agents.
+type CommandHandler struct {
+	sender CommandSender
+	policy *commands.Policy
+}
+
+func NewCommandHandler(sender CommandSender, policy *commands.Policy) *CommandHandler {
+	return &CommandHandler{sender: sender, policy: policy}
+}
+
+// RunCommand runs a command on the agent and returns its result. Callers with
+// the admin API key may run every allowed command; signed-in users only those
+// allowed for their role.
+func (h *CommandHandler) RunCommand(ctx *gin.Context) {
+	var req dto.RunAgentCommandRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.TimeoutSeconds < 0 {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "timeout_seconds must not be negative"})
+		return
+	}
+
+	role := ""
+	if !ctx.GetBool("admin_api_key") {
+		role = ctx.GetString("role")
+		if role == "" {
+			ctx.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+			return
+		}
+	}
+
+	requested := time.Duration(math.Min(req.TimeoutSeconds, maxRequestedTimeout.Seconds()) * float64(time.Second))
+	timeout, err := h.policy.Authorize(req.Command, role, requested)
+	if err != nil {
+		ctx.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+
+	args := req.Args
+	if bytes.Equal(bytes.TrimSpace(args), []byte("null")) {
+		args = nil
+	}
+
+	agentID := ctx.Param("id")
+	start := time.Now()
+	result, err := h.sender.SendCommand(ctx.Request.Context(), agentID, req.Command, args, timeout)
+	if err != nil {
+		h.respondError(ctx, agentID, req.Command, err)
+		return
+	}
+
+	slog.Info("Agent command completed", "agent_id", agentID, "command", req.Command, "duration", time.Since(start))
+	if len(result) == 0 {
+		result = json.RawMessage("null")
+	}
+	ctx.JSON(http.StatusOK, dto.AgentCommandResult{
+		AgentID:    agentID,
+		Command:    req.Command,
+		Result:     result,
+		DurationMs: time.Since(start).Milliseconds(),
+	})
+}
+
+func (h *CommandHandler) respondError(ctx *gin.Context, agentID, command string, err error) {
+	var commandErr *grpcserver.CommandError
+	switch {
+	case errors.Is(err, grpcserver.ErrAgentNotFound):
+		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, grpcserver.ErrCommandsUnsupported):
+		ctx.JSON(http.StatusNotImplemented, gin.H{"error": err.Error()})
+	case errors.As(err, &commandErr):
+		status := http.StatusBadGateway
+		switch commandErr.Code {
+		case protocol.CommandNotFound:
+			status = http.StatusNotImplemented
+		case protocol.CommandTimeout:
+			status = http.StatusGatewayTimeout
+		}
+		ctx.JSON(status, gin.H{"error": commandErr.Message, "code": commandErr.Code})
+	default:
+		slog.Error("Agent command failed", "error", err, "agent_id", agentID, "command", command)
+		ctx.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+	}
+}
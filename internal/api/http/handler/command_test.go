@@ -0,0 +1,124 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/EternisAI/silo-proxy/internal/api/http/dto"
+	"github.com/EternisAI/silo-proxy/internal/commands"
+	"github.com/EternisAI/silo-proxy/internal/grpc/protocol"
+	grpcserver "github.com/EternisAI/silo-proxy/internal/grpc/server"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeCommandSender struct {
+	name    string
+	args    json.RawMessage
+	timeout time.Duration
+	result  json.RawMessage
+	err     error
+}
+
+func (f *fakeCommandSender) SendCommand(_ context.Context, _, name string, args json.RawMessage, timeout time.Duration) (json.RawMessage, error) {
+	f.name, f.args, f.timeout = name, args, timeout
+	return f.result, f.err
+}
+
+func setupCommandRouter(sender CommandSender, role string) *gin.Engine {
+	policy := commands.NewPolicy(commands.Config{
+		DefaultTimeoutSeconds: 5,
+		MaxTimeoutSeconds:     30,
+		Allowed: []commands.CommandConfig{
+			{Name: "status", Roles: []string{"Admin"}},
+			{Name: "config"},
+		},
+	})
+	h := NewCommandHandler(sender, policy)
+
+	r := gin.New()
+	r.POST("/agents/:id/commands", func(c *gin.Context) {
+		if role == "" {
+			c.Set("admin_api_key", true)
+		} else {
+			c.Set("role", role)
+		}
+	}, h.RunCommand)
+	return r
+}
+
+func runCommand(t *testing.T, r *gin.Engine, req dto.RunAgentCommandRequest) *httptest.ResponseRecorder {
+	t.Helper()
+	body, err := json.Marshal(req)
+	require.NoError(t, err)
+
+	httpReq, _ := http.NewRequest("POST", "/agents/agent-1/commands", bytes.NewBuffer(body))
+	httpReq.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httpReq)
+	return w
+}
+
+func TestRunCommand(t *testing.T) {
+	sender := &fakeCommandSender{result: json.RawMessage(`{"ok":true}`)}
+	r := setupCommandRouter(sender, "")
+
+	w := runCommand(t, r, dto.RunAgentCommandRequest{
+		Command:        "status",
+		Args:           json.RawMessage(`{"verbose":true}`),
+		TimeoutSeconds: 2,
+	})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var resp dto.AgentCommandResult
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, "agent-1", resp.AgentID)
+	assert.Equal(t, "status", resp.Command)
+	assert.JSONEq(t, `{"ok":true}`, string(resp.Result))
+
+	assert.Equal(t, "status", sender.name)
+	assert.JSONEq(t, `{"verbose":true}`, string(sender.args))
+	assert.Equal(t, 2*time.Second, sender.timeout)
+}
+
+func TestRunCommand_Authorization(t *testing.T) {
+	sender := &fakeCommandSender{result: json.RawMessage(`{}`)}
+
+	w := runCommand(t, setupCommandRouter(sender, "User"), dto.RunAgentCommandRequest{Command: "status"})
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w = runCommand(t, setupCommandRouter(sender, "Admin"), dto.RunAgentCommandRequest{Command: "config"})
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w = runCommand(t, setupCommandRouter(sender, ""), dto.RunAgentCommandRequest{Command: "flush_logs"})
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w = runCommand(t, setupCommandRouter(sender, "Admin"), dto.RunAgentCommandRequest{Command: "status"})
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, 5*time.Second, sender.timeout)
+}
+
+func TestRunCommand_Errors(t *testing.T) {
+	tests := []struct {
+		err    error
+		status int
+	}{
+		{grpcserver.ErrAgentNotFound, http.StatusNotFound},
+		{grpcserver.ErrCommandsUnsupported, http.StatusNotImplemented},
+		{&grpcserver.CommandError{Code: protocol.CommandNotFound, Message: "unknown command"}, http.StatusNotImplemented},
+		{&grpcserver.CommandError{Code: protocol.CommandTimeout, Message: "timed out"}, http.StatusGatewayTimeout},
+		{&grpcserver.CommandError{Code: protocol.CommandFailed, Message: "boom"}, http.StatusBadGateway},
+	}
+
+	for _, tt := range tests {
+		r := setupCommandRouter(&fakeCommandSender{err: tt.err}, "")
+		w := runCommand(t, r, dto.RunAgentCommandRequest{Command: "config"})
+		assert.Equal(t, tt.status, w.Code, tt.err.Error())
+	}
+}
@@ -0,0 +1,69 @@
+package handler
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/EternisAI/silo-proxy/internal/api/http/dto"
+	"github.com/EternisAI/silo-proxy/internal/domains"
+	"github.com/gin-gonic/gin"
+)
+
+// DomainHandler manages the custom domains that route to an agent.
+type DomainHandler struct {
+	domains *domains.Service
+}
+
+func NewDomainHandler(domainService *domains.Service) *DomainHandler {
+	return &DomainHandler{domains: domainService}
+}
+
+func (h *DomainHandler) ListDomains(ctx *gin.Context) {
+	agentID := ctx.Param("id")
+	ctx.JSON(http.StatusOK, dto.AgentDomainsResponse{
+		AgentID: agentID,
+		Domains: h.domains.List(agentID),
+	})
+}
+
+func (h *DomainHandler) AddDomain(ctx *gin.Context) {
+	var req dto.AddAgentDomainRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	agentID := ctx.Param("id")
+	if err := h.domains.Add(ctx.Request.Context(), agentID, req.Domain); err != nil {
+		h.respondError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, dto.AgentDomainsResponse{
+		AgentID: agentID,
+		Domains: h.domains.List(agentID),
+	})
+}
+
+func (h *DomainHandler) RemoveDomain(ctx *gin.Context) {
+	if err := h.domains.Remove(ctx.Request.Context(), ctx.Param("id"), ctx.Param("domain")); err != nil {
+		h.respondError(ctx, err)
+		return
+	}
+	ctx.Status(http.StatusNoContent)
+}
+
+func (h *DomainHandler) respondError(ctx *gin.Context, err error) {
+	switch {
+	case errors.Is(err, domains.ErrInvalidDomain):
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, domains.ErrDomainTaken):
+		ctx.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, domains.ErrDomainNotFound):
+		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	default:
+		slog.Error("Agent domain request failed", "error", err, "agent_id", ctx.Param("id"))
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+	}
+}
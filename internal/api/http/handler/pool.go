@@ -0,0 +1,59 @@
+package handler
+
+import (
+	"net/http"
+	"slices"
+
+	"github.com/EternisAI/silo-proxy/internal/api/http/dto"
+	"github.com/EternisAI/silo-proxy/internal/pools"
+	"github.com/gin-gonic/gin"
+)
+
+// PoolHandler reports on the configured agent pools.
+type PoolHandler struct {
+	pools  *pools.Set
+	agents pools.Agents
+}
+
+func NewPoolHandler(set *pools.Set, agents pools.Agents) *PoolHandler {
+	return &PoolHandler{pools: set, agents: agents}
+}
+
+func (h *PoolHandler) ListPools(ctx *gin.Context) {
+	all := h.pools.All()
+	response := dto.PoolsResponse{Pools: make([]dto.Pool, 0, len(all)), Count: len(all)}
+	for _, pool := range all {
+		response.Pools = append(response.Pools, h.pool(pool))
+	}
+	ctx.JSON(http.StatusOK, response)
+}
+
+func (h *PoolHandler) GetPool(ctx *gin.Context) {
+	pool, ok := h.pools.Get(ctx.Param("name"))
+	if !ok {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "pool not found"})
+		return
+	}
+	ctx.JSON(http.StatusOK, h.pool(pool))
+}
+
+func (h *PoolHandler) pool(pool *pools.Pool) dto.Pool {
+	available := pool.Available()
+	members := pool.Members()
+
+	agents := make([]dto.PoolMember, 0, len(members))
+	for _, agentID := range members {
+		agents = append(agents, dto.PoolMember{
+			AgentID:         agentID,
+			Available:       slices.Contains(available, agentID),
+			PendingRequests: h.agents.PendingRequests(agentID),
+		})
+	}
+	return dto.Pool{
+		Name:    pool.Name,
+		Balance: pool.Balance(),
+		Port:    pool.Port,
+		Host:    pool.Host,
+		Agents:  agents,
+	}
+}
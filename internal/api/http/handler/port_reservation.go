@@ -0,0 +1,90 @@
+package handler
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/EternisAI/silo-proxy/internal/api/http/dto"
+	"github.com/EternisAI/silo-proxy/internal/reservations"
+	"github.com/gin-gonic/gin"
+)
+
+// PortReserver manages the port reserved for each agent. The HTTP package's
+// PortManager satisfies it.
+type PortReserver interface {
+	Reservation(owner string) (reservations.Reservation, bool)
+	Pin(owner string, port int) error
+	Unpin(owner string) error
+}
+
+// PortReservationHandler lets admins inspect and pin the HTTP port of an
+// agent.
+type PortReservationHandler struct {
+	ports PortReserver
+}
+
+func NewPortReservationHandler(ports PortReserver) *PortReservationHandler {
+	return &PortReservationHandler{ports: ports}
+}
+
+func (h *PortReservationHandler) GetPort(ctx *gin.Context) {
+	agentID := ctx.Param("id")
+	r, ok := h.ports.Reservation(agentID)
+	if !ok {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "no port reserved for agent"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, dto.AgentPortReservation{
+		AgentID: agentID,
+		Port:    r.Port,
+		Pinned:  r.Pinned,
+	})
+}
+
+// PinPort pins a port to an agent. An agent that is connected on another
+// port moves to the pinned one when it reconnects.
+func (h *PortReservationHandler) PinPort(ctx *gin.Context) {
+	var req dto.PinAgentPortRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	agentID := ctx.Param("id")
+	if err := h.ports.Pin(agentID, req.Port); err != nil {
+		h.respondError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, dto.AgentPortReservation{
+		AgentID: agentID,
+		Port:    req.Port,
+		Pinned:  true,
+	})
+}
+
+// UnpinPort keeps the agent's port reserved but lets other agents reclaim it
+// once the pool runs out of ports.
+func (h *PortReservationHandler) UnpinPort(ctx *gin.Context) {
+	if err := h.ports.Unpin(ctx.Param("id")); err != nil {
+		h.respondError(ctx, err)
+		return
+	}
+	ctx.Status(http.StatusNoContent)
+}
+
+func (h *PortReservationHandler) respondError(ctx *gin.Context, err error) {
+	switch {
+	case errors.Is(err, reservations.ErrPortOutOfRange):
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, reservations.ErrPortUnavailable):
+		ctx.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, reservations.ErrNotReserved):
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "no port reserved for agent"})
+	default:
+		slog.Error("Port reservation request failed", "error", err, "agent_id", ctx.Param("id"))
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+	}
+}
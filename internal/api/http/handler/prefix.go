@@ -0,0 +1,77 @@
+package handler
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// rewriteForPrefix adjusts a response header from an agent served under
+// prefix on host, so that the redirects and cookies of an app that does not
+// know about the prefix stay within it.
+func rewriteForPrefix(name, value, prefix, host string) string {
+	switch http.CanonicalHeaderKey(name) {
+	case "Location", "Content-Location":
+		return rewriteLocation(value, prefix, host)
+	case "Set-Cookie":
+		return rewriteCookiePath(value, prefix)
+	default:
+		return value
+	}
+}
+
+// rewriteLocation prefixes absolute paths and URLs pointing back at host.
+// Relative references already resolve below the prefix and URLs of other
+// hosts are left alone.
+func rewriteLocation(location, prefix, host string) string {
+	u, err := url.Parse(location)
+	if err != nil {
+		return location
+	}
+
+	if u.Host != "" && !strings.EqualFold(u.Host, host) {
+		return location
+	}
+	if u.Host == "" && u.Scheme != "" {
+		return location
+	}
+	if !strings.HasPrefix(u.Path, "/") || hasPathPrefix(u.Path, prefix) {
+		return location
+	}
+
+	u.Path = prefix + u.Path
+	if u.RawPath != "" {
+		u.RawPath = prefix + u.RawPath
+	}
+	return u.String()
+}
+
+// rewriteCookiePath moves the Path attribute of a Set-Cookie value below
+// prefix. Cookies without a Path already default to the request's directory,
+// which is below the prefix.
+func rewriteCookiePath(setCookie, prefix string) string {
+	parts := strings.Split(setCookie, ";")
+	for i, part := range parts[1:] {
+		name, value, found := strings.Cut(part, "=")
+		if !found || !strings.EqualFold(strings.TrimSpace(name), "path") {
+			continue
+		}
+
+		path := strings.TrimSpace(value)
+		if !strings.HasPrefix(path, "/") || hasPathPrefix(path, prefix) {
+			continue
+		}
+		if path == "/" {
+			path = prefix
+		} else {
+			path = prefix + path
+		}
+		parts[i+1] = " Path=" + path
+	}
+	return strings.Join(parts, ";")
+}
+
+// hasPathPrefix reports whether path is prefix or below it.
+func hasPathPrefix(path, prefix string) bool {
+	return path == prefix || strings.HasPrefix(path, prefix+"/")
+}
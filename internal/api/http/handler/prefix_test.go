@@ -0,0 +1,59 @@
+package handler
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRewriteLocation(t *testing.T) {
+	const prefix = "/proxy/agent-1"
+	const host = "proxy.example.com"
+
+	tests := []struct {
+		location string
+		want     string
+	}{
+		{"/login", "/proxy/agent-1/login"},
+		{"/", "/proxy/agent-1/"},
+		{"/search?q=a%2Fb#top", "/proxy/agent-1/search?q=a%2Fb#top"},
+		{"/files/a%2Fb", "/proxy/agent-1/files/a%2Fb"},
+		{"https://proxy.example.com/login", "https://proxy.example.com/proxy/agent-1/login"},
+		{"https://PROXY.example.com/login", "https://PROXY.example.com/proxy/agent-1/login"},
+		{"/proxy/agent-1/login", "/proxy/agent-1/login"},
+		{"login", "login"},
+		{"../up", "../up"},
+		{"https://other.example.com/login", "https://other.example.com/login"},
+		{"//other.example.com/login", "//other.example.com/login"},
+		{"mailto:admin@example.com", "mailto:admin@example.com"},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, rewriteLocation(tt.location, prefix, host), tt.location)
+	}
+}
+
+func TestRewriteCookiePath(t *testing.T) {
+	const prefix = "/proxy/agent-1"
+
+	tests := []struct {
+		setCookie string
+		want      string
+	}{
+		{"session=abc; Path=/; HttpOnly", "session=abc; Path=/proxy/agent-1; HttpOnly"},
+		{"session=abc; path=/app", "session=abc; Path=/proxy/agent-1/app"},
+		{"session=abc; Path=/proxy/agent-1/app", "session=abc; Path=/proxy/agent-1/app"},
+		{"session=abc; HttpOnly; Secure", "session=abc; HttpOnly; Secure"},
+		{"path=/value-not-attribute", "path=/value-not-attribute"},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, rewriteCookiePath(tt.setCookie, prefix), tt.setCookie)
+	}
+}
+
+func TestRewriteForPrefix(t *testing.T) {
+	assert.Equal(t, "/proxy/a/x", rewriteForPrefix("location", "/x", "/proxy/a", "h"))
+	assert.Equal(t, "c=1; Path=/proxy/a", rewriteForPrefix("Set-Cookie", "c=1; Path=/", "/proxy/a", "h"))
+	assert.Equal(t, "/x", rewriteForPrefix("Link", "/x", "/proxy/a", "h"))
+}
@@ -1,11 +1,14 @@
 package handler
 
 import (
+	"crypto/x509"
+	"errors"
 	"log/slog"
 	"net/http"
 
 	"github.com/EternisAI/silo-proxy/internal/api/http/dto"
 	"github.com/EternisAI/silo-proxy/internal/cert"
+	"github.com/EternisAI/silo-proxy/internal/metrics"
 	"github.com/EternisAI/silo-proxy/internal/provision"
 	"github.com/gin-gonic/gin"
 )
@@ -13,6 +16,8 @@ import (
 type ProvisionHandler struct {
 	keyStore    *provision.KeyStore
 	certService *cert.Service
+
+	allowServerGeneratedKeys bool
 }
 
 func NewProvisionHandler(keyStore *provision.KeyStore, certService *cert.Service) *ProvisionHandler {
@@ -22,6 +27,13 @@ func NewProvisionHandler(keyStore *provision.KeyStore, certService *cert.Service
 	}
 }
 
+// SetAllowServerGeneratedKeys keeps the legacy flow available: provision
+// requests without a CSR get a key pair generated by the server and returned
+// in the response.
+func (h *ProvisionHandler) SetAllowServerGeneratedKeys(allow bool) {
+	h.allowServerGeneratedKeys = allow
+}
+
 func (h *ProvisionHandler) CreateProvisionKey(ctx *gin.Context) {
 	var req dto.CreateProvisionKeyRequest
 	if err := ctx.ShouldBindJSON(&req); err != nil {
@@ -80,7 +92,11 @@ func (h *ProvisionHandler) RevokeProvisionKey(ctx *gin.Context) {
 }
 
 func (h *ProvisionHandler) Provision(ctx *gin.Context) {
+	result := "error"
+	defer func() { metrics.ProvisionRequests.WithLabelValues(result).Inc() }()
+
 	if h.certService == nil {
+		result = "tls_disabled"
 		slog.Warn("Provision requested but TLS is disabled")
 		ctx.JSON(http.StatusBadRequest, gin.H{"error": "TLS is not enabled on this server"})
 		return
@@ -88,12 +104,20 @@ func (h *ProvisionHandler) Provision(ctx *gin.Context) {
 
 	var req dto.ProvisionRequest
 	if err := ctx.ShouldBindJSON(&req); err != nil {
+		result = "bad_request"
 		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
+	if req.CSR == "" && !h.allowServerGeneratedKeys {
+		result = "bad_request"
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "csr is required"})
+		return
+	}
+
 	pk, err := h.keyStore.Validate(req.Key)
 	if err != nil {
+		result = "unauthorized"
 		slog.Warn("Provision key validation failed", "error", err)
 		ctx.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
 		return
@@ -101,14 +125,30 @@ func (h *ProvisionHandler) Provision(ctx *gin.Context) {
 
 	agentID := pk.AgentID
 
-	agentCert, agentKey, created, err := h.certService.GenerateAgentCertIfNotExists(agentID)
+	var (
+		agentCert *x509.Certificate
+		keyPEM    []byte
+		created   bool
+	)
+	if req.CSR != "" {
+		agentCert, created, err = h.certService.SignAgentCSRIfNotExists(agentID, []byte(req.CSR))
+		if errors.Is(err, cert.ErrInvalidCSR) {
+			result = "bad_request"
+			slog.Warn("Rejected agent CSR", "error", err, "agent_id", agentID)
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	} else {
+		agentCert, keyPEM, created, err = h.generateAgentCert(agentID)
+	}
 	if err != nil {
-		slog.Error("Failed to generate agent certificate", "error", err, "agent_id", agentID)
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate agent certificate"})
+		slog.Error("Failed to issue agent certificate", "error", err, "agent_id", agentID)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to issue agent certificate"})
 		return
 	}
 
 	if !created {
+		result = "conflict"
 		slog.Warn("Certificate already exists for agent", "agent_id", agentID)
 		ctx.JSON(http.StatusConflict, gin.H{"error": "Certificate already exists for this agent"})
 		return
@@ -121,13 +161,6 @@ func (h *ProvisionHandler) Provision(ctx *gin.Context) {
 		return
 	}
 
-	keyPEM, err := cert.KeyToPEM(agentKey)
-	if err != nil {
-		slog.Error("Failed to encode key", "error", err, "agent_id", agentID)
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to encode key"})
-		return
-	}
-
 	caCertBytes, err := h.certService.GetCACert()
 	if err != nil {
 		slog.Error("Failed to read CA certificate", "error", err, "agent_id", agentID)
@@ -136,8 +169,9 @@ func (h *ProvisionHandler) Provision(ctx *gin.Context) {
 	}
 
 	h.keyStore.MarkUsed(req.Key)
+	result = "provisioned"
 
-	slog.Info("Agent provisioned successfully", "agent_id", agentID)
+	slog.Info("Agent provisioned successfully", "agent_id", agentID, "server_generated_key", keyPEM != nil)
 	ctx.JSON(http.StatusOK, dto.ProvisionResponse{
 		AgentID:   agentID,
 		CertPEM:   string(certPEM),
@@ -145,3 +179,18 @@ func (h *ProvisionHandler) Provision(ctx *gin.Context) {
 		CACertPEM: string(caCertBytes),
 	})
 }
+
+// generateAgentCert is the legacy flow: the server generates the agent's key
+// pair and hands the key back PEM encoded.
+func (h *ProvisionHandler) generateAgentCert(agentID string) (*x509.Certificate, []byte, bool, error) {
+	agentCert, agentKey, created, err := h.certService.GenerateAgentCertIfNotExists(agentID)
+	if err != nil || !created {
+		return nil, nil, created, err
+	}
+
+	keyPEM, err := cert.KeyToPEM(agentKey)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	return agentCert, keyPEM, true, nil
+}
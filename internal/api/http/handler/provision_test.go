@@ -5,12 +5,16 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"path/filepath"
 	"testing"
 	"time"
 
 	"github.com/EternisAI/silo-proxy/internal/api/http/dto"
+	"github.com/EternisAI/silo-proxy/internal/cert"
+	"github.com/EternisAI/silo-proxy/internal/metrics"
 	"github.com/EternisAI/silo-proxy/internal/provision"
 	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -179,3 +183,117 @@ func TestProvisionMissingKey(t *testing.T) {
 	// TLS check happens first
 	assert.Equal(t, http.StatusBadRequest, w.Code)
 }
+
+func newTestCertService(t *testing.T) *cert.Service {
+	dir := t.TempDir()
+	cs, err := cert.New(
+		filepath.Join(dir, "ca-cert.pem"),
+		filepath.Join(dir, "ca-key.pem"),
+		filepath.Join(dir, "server-cert.pem"),
+		filepath.Join(dir, "server-key.pem"),
+		filepath.Join(dir, "agents"),
+		"localhost",
+		"127.0.0.1",
+	)
+	require.NoError(t, err)
+	return cs
+}
+
+func postProvision(r *gin.Engine, req dto.ProvisionRequest) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(req)
+	httpReq, _ := http.NewRequest("POST", "/api/v1/provision", bytes.NewBuffer(body))
+	httpReq.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httpReq)
+	return w
+}
+
+func TestProvisionWithCSR(t *testing.T) {
+	cs := newTestCertService(t)
+	ks := provision.NewKeyStore(1 * time.Hour)
+	r := setupProvisionRouter(NewProvisionHandler(ks, cs))
+
+	t.Run("subject must match the provision key", func(t *testing.T) {
+		pk, err := ks.Create("agent-1")
+		require.NoError(t, err)
+		_, csrPEM, err := cert.GenerateAgentCSR("agent-2")
+		require.NoError(t, err)
+
+		w := postProvision(r, dto.ProvisionRequest{Key: pk.Key, CSR: string(csrPEM)})
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Contains(t, w.Body.String(), `does not match agent \"agent-1\"`)
+		assert.False(t, cs.AgentCertExists("agent-1"))
+	})
+
+	t.Run("csr is required without legacy flow", func(t *testing.T) {
+		pk, err := ks.Create("agent-1")
+		require.NoError(t, err)
+
+		w := postProvision(r, dto.ProvisionRequest{Key: pk.Key})
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("signed certificate stored without key", func(t *testing.T) {
+		pk, err := ks.Create("agent-1")
+		require.NoError(t, err)
+		agentKey, csrPEM, err := cert.GenerateAgentCSR("agent-1")
+		require.NoError(t, err)
+
+		w := postProvision(r, dto.ProvisionRequest{Key: pk.Key, CSR: string(csrPEM)})
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+		var resp dto.ProvisionResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		assert.Equal(t, "agent-1", resp.AgentID)
+		assert.Empty(t, resp.KeyPEM)
+
+		issued, err := cs.LoadAgentCert("agent-1")
+		require.NoError(t, err)
+		assert.Equal(t, "agent-1", issued.Subject.CommonName)
+		assert.True(t, agentKey.PublicKey.Equal(issued.PublicKey))
+		assert.NoFileExists(t, cs.GetAgentKeyPath("agent-1"))
+
+		_, err = ks.Validate(pk.Key)
+		assert.ErrorIs(t, err, provision.ErrKeyAlreadyUsed)
+	})
+}
+
+func TestProvisionServerGeneratedKey(t *testing.T) {
+	cs := newTestCertService(t)
+	ks := provision.NewKeyStore(1 * time.Hour)
+	h := NewProvisionHandler(ks, cs)
+	h.SetAllowServerGeneratedKeys(true)
+	r := setupProvisionRouter(h)
+
+	pk, err := ks.Create("agent-1")
+	require.NoError(t, err)
+
+	w := postProvision(r, dto.ProvisionRequest{Key: pk.Key})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var resp dto.ProvisionResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Contains(t, resp.KeyPEM, "PRIVATE KEY")
+}
+
+func TestProvisionMetrics(t *testing.T) {
+	cs := newTestCertService(t)
+	ks := provision.NewKeyStore(1 * time.Hour)
+	r := setupProvisionRouter(NewProvisionHandler(ks, cs))
+
+	provisioned := testutil.ToFloat64(metrics.ProvisionRequests.WithLabelValues("provisioned"))
+	conflicts := testutil.ToFloat64(metrics.ProvisionRequests.WithLabelValues("conflict"))
+	issued := testutil.ToFloat64(metrics.CertificatesIssued.WithLabelValues("csr"))
+
+	for range 2 {
+		pk, err := ks.Create("agent-1")
+		require.NoError(t, err)
+		_, csrPEM, err := cert.GenerateAgentCSR("agent-1")
+		require.NoError(t, err)
+		postProvision(r, dto.ProvisionRequest{Key: pk.Key, CSR: string(csrPEM)})
+	}
+
+	assert.Equal(t, provisioned+1, testutil.ToFloat64(metrics.ProvisionRequests.WithLabelValues("provisioned")))
+	assert.Equal(t, conflicts+1, testutil.ToFloat64(metrics.ProvisionRequests.WithLabelValues("conflict")))
+	assert.Equal(t, issued+1, testutil.ToFloat64(metrics.CertificatesIssued.WithLabelValues("csr")))
+}
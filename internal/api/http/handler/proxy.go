@@ -1,19 +1,34 @@
 package handler
 
 import (
+	"errors"
 	"io"
 	"log/slog"
 	"net/http"
 	"strconv"
+	"time"
 
+	"github.com/EternisAI/silo-proxy/internal/forwarded"
+	"github.com/EternisAI/silo-proxy/internal/grpc/chunk"
+	"github.com/EternisAI/silo-proxy/internal/grpc/headers"
 	"github.com/EternisAI/silo-proxy/internal/grpc/server"
+	"github.com/EternisAI/silo-proxy/internal/grpc/tunnel"
+	"github.com/EternisAI/silo-proxy/internal/metrics"
 	"github.com/EternisAI/silo-proxy/proto"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
 )
 
+// wsUpgrader accepts any origin: the Origin header is forwarded to the local
+// service, which remains responsible for enforcing its own origin policy.
+var wsUpgrader = websocket.Upgrader{
+	CheckOrigin: func(*http.Request) bool { return true },
+}
+
 type ProxyHandler struct {
-	grpcServer *server.Server
+	grpcServer     *server.Server
+	trustedProxies *forwarded.TrustedProxies
 }
 
 func NewProxyHandler(grpcServer *server.Server) *ProxyHandler {
@@ -22,48 +37,87 @@ func NewProxyHandler(grpcServer *server.Server) *ProxyHandler {
 	}
 }
 
+// SetTrustedProxies sets the peers whose forwarding headers are kept and
+// extended. By default, forwarding headers from clients are replaced.
+func (h *ProxyHandler) SetTrustedProxies(proxies *forwarded.TrustedProxies) {
+	h.trustedProxies = proxies
+}
+
 // ProxyRequestDirect forwards a request directly to a specific agent
 // without requiring agent_id in the URL path. Used by per-agent HTTP servers.
 func (h *ProxyHandler) ProxyRequestDirect(c *gin.Context, agentID string) {
 	targetPath := c.Request.URL.Path
-	h.forwardRequest(c, agentID, targetPath)
+	h.forwardRequest(c, agentID, targetPath, "")
 }
 
-func (h *ProxyHandler) forwardRequest(c *gin.Context, agentID, targetPath string) {
-	conn, ok := h.grpcServer.GetConnectionManager().GetConnection(agentID)
-	if !ok {
+// ProxyRequest forwards a request for /proxy/:agent_id/*path on the main
+// server to the agent with the /proxy/:agent_id prefix stripped. Redirects and
+// cookie paths in the response are rewritten to include the prefix again, and
+// the credentials used to reach the proxy are not forwarded.
+func (h *ProxyHandler) ProxyRequest(c *gin.Context) {
+	agentID := c.Param("agent_id")
+	prefix := "/proxy/" + agentID
+
+	c.Request.Header.Del("Authorization")
+
+	h.forwardRequest(c, agentID, c.Param("path"), prefix)
+}
+
+// forwardRequest sends the request to the agent as targetPath. A non-empty
+// prefix is the path the agent is mounted under on this server, which is sent
+// as X-Forwarded-Prefix and added back to Location headers and cookie paths
+// in the response.
+//
+// Requests for an agent that is reconnecting wait for it, and get 503 if it
+// does not return within its grace period.
+func (h *ProxyHandler) forwardRequest(c *gin.Context, agentID, targetPath, prefix string) {
+	conn, err := h.grpcServer.GetConnectionManager().AwaitConnection(c.Request.Context(), agentID)
+	switch {
+	case errors.Is(err, server.ErrAgentNotFound):
 		c.JSON(http.StatusNotFound, gin.H{"error": "agent not found"})
 		return
+	case err != nil:
+		slog.Warn("Agent unavailable", "agent_id", agentID, "error", err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		return
 	}
 
-	body, err := io.ReadAll(c.Request.Body)
-	if err != nil {
-		slog.Error("Failed to read request body", "error", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to read request body"})
+	if websocket.IsWebSocketUpgrade(c.Request) {
+		h.proxyWebSocket(c, conn, targetPath, prefix)
 		return
 	}
 
-	headers := make(map[string]string)
-	for key, values := range c.Request.Header {
-		if len(values) > 0 {
-			headers[key] = values[0]
-		}
-	}
+	start := time.Now()
+	defer func() {
+		metrics.ProxyRequests.WithLabelValues(conn.ID, strconv.Itoa(c.Writer.Status())).Inc()
+		metrics.ProxyRequestDuration.WithLabelValues(conn.ID).Observe(time.Since(start).Seconds())
+	}()
+
+	requested := requestedTimeout(c.Request)
+
+	requestHeader := h.forwardedHeader(c.Request, prefix)
+	headers.DeclareTrailer(requestHeader, c.Request.Trailer)
 
 	requestMsg := &proto.ProxyMessage{
-		Id:      uuid.New().String(),
-		Type:    proto.MessageType_REQUEST,
-		Payload: body,
+		Id:   uuid.New().String(),
+		Type: proto.MessageType_REQUEST_START,
 		Metadata: map[string]string{
 			"method":       c.Request.Method,
+			"host":         c.Request.Host,
 			"path":         targetPath,
 			"query":        c.Request.URL.RawQuery,
 			"content_type": c.ContentType(),
 		},
+		Headers: headers.ToProto(requestHeader),
 	}
 
-	for key, value := range headers {
-		requestMsg.Metadata["header_"+key] = value
+	if c.Request.ContentLength >= 0 {
+		requestMsg.Metadata["content_length"] = strconv.FormatInt(c.Request.ContentLength, 10)
+	}
+
+	var body io.Reader = c.Request.Body
+	if len(c.Request.Trailer) > 0 {
+		body = requestBody{c.Request}
 	}
 
 	slog.Info("Forwarding request to agent",
@@ -72,35 +126,177 @@ func (h *ProxyHandler) forwardRequest(c *gin.Context, agentID, targetPath string
 		"method", c.Request.Method,
 		"path", targetPath)
 
-	response, err := h.grpcServer.SendRequestToAgent(c.Request.Context(), conn.ID, requestMsg)
+	timeout := h.grpcServer.RequestTimeout(agentID, targetPath, requested)
+	response, err := h.grpcServer.SendRequestToAgent(c.Request.Context(), conn.ID, requestMsg, body, timeout)
+	if errors.Is(err, server.ErrAgentDraining) {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		return
+	}
+	if errors.Is(err, server.ErrBodyTooLarge) {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": err.Error()})
+		return
+	}
 	if err != nil {
 		slog.Error("Failed to forward request", "error", err, "agent_id", agentID)
 		c.JSON(http.StatusGatewayTimeout, gin.H{"error": err.Error()})
 		return
 	}
+	defer response.Body.Close()
 
 	statusCode := http.StatusOK
-	if statusStr, ok := response.Metadata["status_code"]; ok {
+	if statusStr, ok := response.Start.Metadata["status_code"]; ok {
 		if code, err := strconv.Atoi(statusStr); err == nil {
 			statusCode = code
 		}
 	}
 
-	for key, value := range response.Metadata {
-		if key != "status_code" && len(value) > 0 {
-			headerName := key
-			if len(key) > 7 && key[:7] == "header_" {
-				headerName = key[7:]
+	responseHeader := headers.FromMessage(response.Start)
+	trailer := headers.Announced(responseHeader)
+	headers.RemoveHopByHop(responseHeader)
+	headers.DeclareTrailer(responseHeader, trailer)
+
+	for name, values := range responseHeader {
+		for _, value := range values {
+			if prefix != "" {
+				value = rewriteForPrefix(name, value, prefix, c.Request.Host)
 			}
-			c.Header(headerName, value)
+			c.Writer.Header().Add(name, value)
 		}
 	}
 
 	slog.Info("Received response from agent",
 		"agent_id", agentID,
-		"message_id", response.Id,
+		"message_id", requestMsg.Id,
 		"status_code", statusCode)
 
 	c.Status(statusCode)
-	c.Writer.Write(response.Payload)
+
+	written, err := copyAndFlush(c.Writer, response.Body)
+	if err != nil {
+		slog.Error("Failed to stream response body",
+			"agent_id", agentID,
+			"message_id", requestMsg.Id,
+			"bytes_written", written,
+			"error", err)
+	}
+
+	for name, values := range response.Trailer() {
+		c.Writer.Header()[http.TrailerPrefix+name] = values
+	}
+}
+
+// forwardedHeader returns the headers of r to send to the agent: without
+// hop-by-hop fields, and with forwarding headers describing the client.
+func (h *ProxyHandler) forwardedHeader(r *http.Request, prefix string) http.Header {
+	header := r.Header.Clone()
+	headers.RemoveHopByHop(header)
+	h.trustedProxies.SetHeaders(header, r)
+	if prefix != "" {
+		header.Set("X-Forwarded-Prefix", prefix)
+	}
+	return header
+}
+
+// requestBody is the body of an incoming request followed by its trailers,
+// which the server forwards to the agent once the body has been sent.
+type requestBody struct {
+	req *http.Request
+}
+
+func (b requestBody) Read(p []byte) (int, error) {
+	return b.req.Body.Read(p)
+}
+
+func (b requestBody) Trailer() http.Header {
+	return b.req.Trailer
+}
+
+// proxyWebSocket tunnels a WebSocket upgrade to the agent. The client is only
+// upgraded once the agent has completed the handshake with its local service,
+// so handshake failures are returned to the client as regular HTTP responses.
+func (h *ProxyHandler) proxyWebSocket(c *gin.Context, conn *server.AgentConnection, targetPath, prefix string) {
+	agentID := conn.ID
+	openHeader := h.forwardedHeader(c.Request, prefix)
+
+	openMsg := &proto.ProxyMessage{
+		Id:   uuid.New().String(),
+		Type: proto.MessageType_WS_OPEN,
+		Metadata: map[string]string{
+			"host":  c.Request.Host,
+			"path":  targetPath,
+			"query": c.Request.URL.RawQuery,
+		},
+		Headers: headers.ToProto(openHeader),
+	}
+
+	slog.Info("Opening websocket to agent",
+		"agent_id", agentID,
+		"session_id", openMsg.Id,
+		"path", targetPath)
+
+	session, ack, err := h.grpcServer.OpenWebSocket(c.Request.Context(), agentID, openMsg)
+	if errors.Is(err, server.ErrAgentDraining) {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		slog.Error("Failed to open websocket", "error", err, "agent_id", agentID)
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+
+	if session == nil {
+		statusCode := http.StatusBadGateway
+		if code, err := strconv.Atoi(ack.Metadata["status_code"]); err == nil {
+			statusCode = code
+		}
+		slog.Warn("Local websocket handshake rejected",
+			"agent_id", agentID,
+			"session_id", openMsg.Id,
+			"status_code", statusCode)
+		c.Data(statusCode, "text/plain; charset=utf-8", ack.Payload)
+		return
+	}
+
+	responseHeader := http.Header{}
+	if subprotocol := ack.Metadata["subprotocol"]; subprotocol != "" {
+		responseHeader.Set("Sec-WebSocket-Protocol", subprotocol)
+	}
+
+	wsConn, err := wsUpgrader.Upgrade(c.Writer, c.Request, responseHeader)
+	if err != nil {
+		slog.Error("Failed to upgrade client connection", "error", err, "agent_id", agentID)
+		_ = session.Send(tunnel.WebSocketCloseMessage(openMsg.Id, websocket.CloseGoingAway, "client upgrade failed"))
+		session.Close()
+		return
+	}
+
+	slog.Info("WebSocket session established", "agent_id", agentID, "session_id", openMsg.Id)
+	tunnel.PumpWebSocket(wsConn, session)
+	slog.Info("WebSocket session closed", "agent_id", agentID, "session_id", openMsg.Id)
+}
+
+// copyAndFlush streams src to the client, flushing after every chunk so that
+// large or long-running responses are delivered as they arrive.
+func copyAndFlush(w gin.ResponseWriter, src io.Reader) (int64, error) {
+	buf := make([]byte, chunk.Size)
+	var written int64
+
+	for {
+		n, readErr := src.Read(buf)
+		if n > 0 {
+			m, err := w.Write(buf[:n])
+			written += int64(m)
+			if err != nil {
+				return written, err
+			}
+			w.Flush()
+		}
+		if readErr == io.EOF {
+			return written, nil
+		}
+		if readErr != nil {
+			return written, readErr
+		}
+	}
 }
@@ -0,0 +1,52 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/EternisAI/silo-proxy/internal/forwarded"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestForwardedHeader(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "http://proxy.example.com/proxy/agent-1/app", nil)
+	r.RemoteAddr = "203.0.113.7:5000"
+	r.Header.Set("Connection", "keep-alive, X-Hop")
+	r.Header.Set("X-Hop", "1")
+	r.Header.Set("Proxy-Authorization", "Basic Zm9vOmJhcg==")
+	r.Header.Set("X-Forwarded-For", "1.2.3.4")
+	r.Header.Set("X-Forwarded-Prefix", "/evil")
+	r.Header.Add("Accept", "text/html")
+	r.Header.Add("Accept", "application/json")
+
+	h := NewProxyHandler(nil)
+	header := h.forwardedHeader(r, "/proxy/agent-1")
+
+	assert.Empty(t, header.Get("Connection"))
+	assert.Empty(t, header.Get("X-Hop"))
+	assert.Empty(t, header.Get("Proxy-Authorization"))
+	assert.Equal(t, []string{"text/html", "application/json"}, header.Values("Accept"))
+	assert.Equal(t, "203.0.113.7", header.Get("X-Forwarded-For"))
+	assert.Equal(t, "/proxy/agent-1", header.Get("X-Forwarded-Prefix"))
+	assert.Equal(t, "1", r.Header.Get("X-Hop"), "the incoming request is left alone")
+}
+
+func TestForwardedHeader_TrustedProxy(t *testing.T) {
+	proxies, err := forwarded.ParseTrustedProxies([]string{"10.0.0.0/8"})
+	require.NoError(t, err)
+
+	r := httptest.NewRequest(http.MethodGet, "http://agent.example.com/", nil)
+	r.RemoteAddr = "10.0.0.2:5000"
+	r.Header.Set("X-Forwarded-For", "198.51.100.1")
+	r.Header.Set("X-Forwarded-Proto", "https")
+
+	h := NewProxyHandler(nil)
+	h.SetTrustedProxies(proxies)
+	header := h.forwardedHeader(r, "")
+
+	assert.Equal(t, "198.51.100.1, 10.0.0.2", header.Get("X-Forwarded-For"))
+	assert.Equal(t, "https", header.Get("X-Forwarded-Proto"))
+	assert.Empty(t, header.Get("X-Forwarded-Prefix"))
+}
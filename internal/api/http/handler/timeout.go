@@ -0,0 +1,37 @@
+package handler
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// timeoutHeader lets a client ask for a longer or shorter wait for the agent,
+// as a number of seconds or a Go duration such as "5m". It is not forwarded.
+const timeoutHeader = "X-Proxy-Timeout"
+
+// maxRequestedTimeout bounds the parsed header so it cannot overflow.
+const maxRequestedTimeout = 24 * time.Hour
+
+// requestedTimeout removes the timeout header from r and returns its value,
+// or zero if it is missing or malformed.
+func requestedTimeout(r *http.Request) time.Duration {
+	value := r.Header.Get(timeoutHeader)
+	if value == "" {
+		return 0
+	}
+	r.Header.Del(timeoutHeader)
+
+	var d time.Duration
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		if !(seconds > 0) {
+			return 0
+		}
+		// Anything longer is capped by the server's maximum anyway
+		d = time.Duration(math.Min(seconds, maxRequestedTimeout.Seconds()) * float64(time.Second))
+	} else if d, err = time.ParseDuration(value); err != nil {
+		return 0
+	}
+	return max(d, 0)
+}
@@ -0,0 +1,34 @@
+package handler
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequestedTimeout(t *testing.T) {
+	tests := []struct {
+		header string
+		want   time.Duration
+	}{
+		{"", 0},
+		{"90", 90 * time.Second},
+		{"1.5", 1500 * time.Millisecond},
+		{"5m", 5 * time.Minute},
+		{"-10", 0},
+		{"-1m", 0},
+		{"NaN", 0},
+		{"soon", 0},
+		{"1e30", maxRequestedTimeout},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		if tt.header != "" {
+			r.Header.Set(timeoutHeader, tt.header)
+		}
+		assert.Equal(t, tt.want, requestedTimeout(r), "header %q", tt.header)
+		assert.Empty(t, r.Header.Get(timeoutHeader), "the header is not forwarded")
+	}
+}
@@ -1,12 +1,46 @@
 package http
 
+import (
+	"github.com/EternisAI/silo-proxy/internal/commands"
+	"github.com/EternisAI/silo-proxy/internal/pools"
+	"github.com/EternisAI/silo-proxy/internal/ratelimit"
+)
+
 type Config struct {
-	Port           uint      `mapstructure:"port"`
-	AgentPortRange PortRange `mapstructure:"agent_port_range"`
-	AdminAPIKey    string    `mapstructure:"admin_api_key"`
+	Port           uint              `mapstructure:"port"`
+	AgentPortRange PortRange         `mapstructure:"agent_port_range"`
+	AdminAPIKey    string            `mapstructure:"admin_api_key"`
+	VirtualHosts   VirtualHostConfig `mapstructure:"virtual_hosts"`
+	RateLimit      ratelimit.Limits  `mapstructure:"rate_limit"`
+	// MetricsPort serves /metrics without authentication on a port of its
+	// own, for scrapers on a private network. 0 disables it; /metrics on the
+	// main port always requires the admin API key.
+	MetricsPort uint `mapstructure:"metrics_port"`
+	// TrustedProxies lists the addresses and CIDR ranges of proxies in front
+	// of the server, whose X-Forwarded-* and Forwarded headers are trusted.
+	TrustedProxies []string `mapstructure:"trusted_proxies"`
+	// Commands lists the commands admins may run on agents.
+	Commands commands.Config `mapstructure:"commands"`
+	// Pools groups agents serving the same service behind one port or
+	// hostname.
+	Pools []pools.Config `mapstructure:"pools"`
+	// ProxyRoles lists the roles of signed-in users who may reach agents
+	// through /proxy/:agent_id. Empty means Admin only.
+	ProxyRoles []string `mapstructure:"proxy_roles"`
+	// APIHosts lists the host names the API is served on. Virtual hosts and
+	// pools never claim them, so that they cannot shadow the API.
+	APIHosts []string `mapstructure:"api_hosts"`
 }
 
 type PortRange struct {
 	Start int `mapstructure:"start"`
 	End   int `mapstructure:"end"`
 }
+
+// VirtualHostConfig enables routing to agents by Host header. Port 0 serves
+// virtual hosts on the main HTTP port, next to the API.
+type VirtualHostConfig struct {
+	Enabled    bool   `mapstructure:"enabled"`
+	BaseDomain string `mapstructure:"base_domain"`
+	Port       uint   `mapstructure:"port"`
+}
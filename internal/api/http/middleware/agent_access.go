@@ -0,0 +1,59 @@
+package middleware
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/EternisAI/silo-proxy/internal/access"
+	"github.com/gin-gonic/gin"
+)
+
+// AgentAuthorizer checks requests against the access policy of an agent.
+// The access package's Service satisfies it.
+type AgentAuthorizer interface {
+	Authorize(agentID string, r *http.Request) error
+	RequiresBasicAuth(agentID string) bool
+}
+
+// AgentAccess enforces the access policy of the agent that agentID returns
+// for each request.
+func AgentAccess(authorizer AgentAuthorizer, agentID func(*gin.Context) string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if AuthorizeAgent(c, authorizer, agentID(c)) {
+			c.Next()
+		}
+	}
+}
+
+// AuthorizeAgent checks the request against the access policy of agentID.
+// If it is refused, the response is written, the context aborted and false
+// returned.
+func AuthorizeAgent(c *gin.Context, authorizer AgentAuthorizer, agentID string) bool {
+	err := authorizer.Authorize(agentID, c.Request)
+	if err == nil {
+		return true
+	}
+	DenyAgentAccess(c, authorizer, agentID, err)
+	return false
+}
+
+// DenyAgentAccess writes the response to a request the access policy of
+// agentID refused with err, and aborts the context.
+func DenyAgentAccess(c *gin.Context, authorizer AgentAuthorizer, agentID string, err error) {
+	slog.Warn("Agent access denied",
+		"agent_id", agentID,
+		"path", c.Request.URL.Path,
+		"remote_addr", c.Request.RemoteAddr,
+		"reason", err)
+
+	if errors.Is(err, access.ErrForbidden) {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+		return
+	}
+	if authorizer.RequiresBasicAuth(agentID) {
+		c.Header("WWW-Authenticate", fmt.Sprintf("Basic realm=%q", agentID))
+	}
+	c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+}
@@ -0,0 +1,89 @@
+package middleware
+
+import (
+	"errors"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/EternisAI/silo-proxy/internal/forwarded"
+	"github.com/EternisAI/silo-proxy/internal/metrics"
+	"github.com/EternisAI/silo-proxy/internal/ratelimit"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+// AgentLimiter admits requests for agents. The ratelimit package's
+// AgentLimiter satisfies it.
+type AgentLimiter interface {
+	Acquire(agentID, clientIP string) (release func(), err error)
+	Allow(agentID, clientIP string) error
+}
+
+// AgentRateLimit applies limiter to requests for the agent that agentID
+// returns for each request. Clients are told apart by the address proxies
+// forwarded the request for.
+func AgentRateLimit(limiter AgentLimiter, proxies *forwarded.TrustedProxies, agentID func(*gin.Context) string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		release, ok := LimitAgent(c, limiter, proxies, agentID(c))
+		if !ok {
+			return
+		}
+		defer release()
+		c.Next()
+	}
+}
+
+// LimitAgent admits the request to agentID, or writes a 429 response, aborts
+// the context and returns false. On success release must be called once the
+// request has been served.
+func LimitAgent(c *gin.Context, limiter AgentLimiter, proxies *forwarded.TrustedProxies, agentID string) (release func(), ok bool) {
+	release, err := AdmitAgent(c, limiter, proxies, agentID)
+	if err != nil {
+		DenyRateLimited(c, agentID, err)
+		return nil, false
+	}
+	return release, true
+}
+
+// AdmitAgent admits the request to agentID, returning the limiter's error
+// without responding if it is refused. On success release must be called
+// once the request has been served. The per-client limits apply to the
+// address proxies forwarded the request for. WebSocket upgrades only count
+// against the rate limits, since they hold a connection open for as long as
+// they are used.
+func AdmitAgent(c *gin.Context, limiter AgentLimiter, proxies *forwarded.TrustedProxies, agentID string) (release func(), err error) {
+	clientIP := c.Request.RemoteAddr
+	if addr := proxies.ClientAddr(c.Request); addr.IsValid() {
+		clientIP = addr.String()
+	}
+	if websocket.IsWebSocketUpgrade(c.Request) {
+		return func() {}, limiter.Allow(agentID, clientIP)
+	}
+	return limiter.Acquire(agentID, clientIP)
+}
+
+// DenyRateLimited writes the response to a request to agentID that
+// AdmitAgent refused with err, and aborts the context.
+func DenyRateLimited(c *gin.Context, agentID string, err error) {
+	var limitErr *ratelimit.Error
+	if !errors.As(err, &limitErr) {
+		slog.Error("Rate limiter failed", "agent_id", agentID, "error", err)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+		return
+	}
+
+	metrics.RateLimitedRequests.WithLabelValues(agentID, limitErr.Limit).Inc()
+	slog.Debug("Request rate limited",
+		"agent_id", agentID,
+		"remote_addr", c.Request.RemoteAddr,
+		"limit", limitErr.Limit)
+
+	retryAfter := int(math.Ceil(limitErr.RetryAfter.Seconds()))
+	if retryAfter < 1 {
+		retryAfter = 1
+	}
+	c.Header("Retry-After", strconv.Itoa(retryAfter))
+	c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": "too many requests"})
+}
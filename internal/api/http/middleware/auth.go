@@ -36,6 +36,35 @@ func JWTAuth(secret string) gin.HandlerFunc {
 	}
 }
 
+// JWTOrProxySessionAuth is JWTAuth that also accepts the token in the
+// auth.ProxySessionCookie, as set by the /auth/proxy-session endpoint. The
+// cookie is removed from the request either way, so that it is never
+// forwarded to an agent.
+func JWTOrProxySessionAuth(secret string) gin.HandlerFunc {
+	jwtAuth := JWTAuth(secret)
+
+	return func(c *gin.Context) {
+		if cookie, err := c.Request.Cookie(auth.ProxySessionCookie); err == nil {
+			if c.GetHeader("Authorization") == "" {
+				c.Request.Header.Set("Authorization", "Bearer "+cookie.Value)
+			}
+			removeCookie(c.Request, auth.ProxySessionCookie)
+		}
+		jwtAuth(c)
+	}
+}
+
+// removeCookie drops the cookies called name from the Cookie header of r.
+func removeCookie(r *http.Request, name string) {
+	cookies := r.Cookies()
+	r.Header.Del("Cookie")
+	for _, cookie := range cookies {
+		if cookie.Name != name {
+			r.AddCookie(cookie)
+		}
+	}
+}
+
 func RequireRole(roles ...string) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		role, exists := c.Get("role")
@@ -94,3 +123,21 @@ func APIKeyAuth(apiKey string) gin.HandlerFunc {
 		c.Next()
 	}
 }
+
+// APIKeyOrJWTAuth accepts either the admin API key or a user's token. Requests
+// with an X-API-Key header are checked against apiKey and marked with
+// "admin_api_key"; any others need a valid token, as with JWTAuth.
+func APIKeyOrJWTAuth(apiKey, secret string) gin.HandlerFunc {
+	apiKeyAuth := APIKeyAuth(apiKey)
+	jwtAuth := JWTAuth(secret)
+
+	return func(c *gin.Context) {
+		if c.GetHeader(apiKeyHeader) == "" {
+			jwtAuth(c)
+			return
+		}
+
+		c.Set("admin_api_key", true)
+		apiKeyAuth(c)
+	}
+}
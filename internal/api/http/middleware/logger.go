@@ -14,8 +14,8 @@ func RequestLogger() gin.HandlerFunc {
 		path := c.Request.URL.Path
 		query := c.Request.URL.RawQuery
 
-		// Skip logging for health checks
-		if path == "/health" {
+		// Skip logging for health checks and metrics scrapes
+		if path == "/health" || path == "/metrics" {
 			c.Next()
 			return
 		}
@@ -0,0 +1,173 @@
+package http
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/EternisAI/silo-proxy/internal/api/http/middleware"
+	"github.com/EternisAI/silo-proxy/internal/domains"
+	grpcserver "github.com/EternisAI/silo-proxy/internal/grpc/server"
+	"github.com/EternisAI/silo-proxy/internal/metrics"
+	"github.com/EternisAI/silo-proxy/internal/pools"
+	"github.com/gin-gonic/gin"
+)
+
+// PoolRouter routes requests to agent pools, by Host header or by the port a
+// pool has to itself. Each request goes to an agent the pool picks among
+// those whose access policy admits it and that are within their rate limits,
+// and is forwarded exactly like one arriving on that agent's own port, with
+// the embedded AgentForwarder's access policies and rate limits.
+type PoolRouter struct {
+	*AgentForwarder
+
+	pools  *pools.Set
+	engine *gin.Engine
+}
+
+// NewPoolRouter creates a router for the pools in set.
+func NewPoolRouter(set *pools.Set, gs *grpcserver.Server) *PoolRouter {
+	r := &PoolRouter{
+		AgentForwarder: NewAgentForwarder(gs),
+		pools:          set,
+	}
+	r.engine = r.newEngine(r.Resolve)
+	return r
+}
+
+// Resolve returns the pool that host routes to.
+func (r *PoolRouter) Resolve(host string) (*pools.Pool, bool) {
+	return r.pools.ByHost(domains.Normalize(host))
+}
+
+// Handler forwards requests whose Host header routes to a pool and passes all
+// others to next.
+func (r *PoolRouter) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if _, ok := r.Resolve(req.Host); ok {
+			r.engine.ServeHTTP(w, req)
+			return
+		}
+		next.ServeHTTP(w, req)
+	})
+}
+
+// Servers returns an HTTP server for each pool with a port of its own, which
+// sends every request to that pool.
+func (r *PoolRouter) Servers() []*http.Server {
+	var servers []*http.Server
+	for _, pool := range r.pools.All() {
+		if pool.Port == 0 {
+			continue
+		}
+		servers = append(servers, &http.Server{
+			Addr:    fmt.Sprintf(":%d", pool.Port),
+			Handler: r.newEngine(func(string) (*pools.Pool, bool) { return pool, true }),
+		})
+	}
+	return servers
+}
+
+// newEngine creates an engine forwarding requests to the pool resolve
+// returns for their Host header.
+func (r *PoolRouter) newEngine(resolve func(host string) (*pools.Pool, bool)) *gin.Engine {
+	gin.SetMode(gin.ReleaseMode)
+	engine := gin.New()
+	engine.Use(middleware.RequestLogger())
+	engine.Use(gin.Recovery())
+
+	engine.NoRoute(func(c *gin.Context) {
+		pool, ok := resolve(c.Request.Host)
+		if !ok {
+			c.JSON(http.StatusNotFound, gin.H{"error": "unknown host"})
+			return
+		}
+		r.forward(c, pool)
+	})
+	return engine
+}
+
+// forward sends the request to an agent of pool. Only agents whose access
+// policy admits the request are picked, so that whether it is admitted does
+// not depend on the pick, and an agent over its rate limits is passed over
+// for another. The request is refused only if no available agent can take it.
+func (r *PoolRouter) forward(c *gin.Context, pool *pools.Pool) {
+	denied := make(map[string]error)  // Access policy refusals, nil if admitted
+	limited := make(map[string]error) // Rate limit refusals
+	eligible := func(agentID string) bool {
+		if _, ok := limited[agentID]; ok {
+			return false
+		}
+		err, ok := denied[agentID]
+		if !ok {
+			err = r.authorize(c.Request, agentID)
+			denied[agentID] = err
+		}
+		return err == nil
+	}
+
+	for {
+		agentID, err := pool.Pick(c.Request, eligible)
+		if err != nil {
+			r.refuse(c, pool, err, denied, limited)
+			return
+		}
+
+		release := func() {}
+		if r.limiter != nil {
+			if release, err = middleware.AdmitAgent(c, r.limiter, r.proxies, agentID); err != nil {
+				limited[agentID] = err
+				continue
+			}
+		}
+		defer release()
+
+		// Authorized again on the request itself, so that the credentials
+		// are removed before it is forwarded.
+		if r.access != nil && !middleware.AuthorizeAgent(c, r.access, agentID) {
+			return
+		}
+
+		metrics.AgentPoolRequests.WithLabelValues(pool.Name, agentID).Inc()
+		slog.Debug("Pool picked agent", "pool", pool.Name, "agent_id", agentID, "balance", pool.Balance())
+		r.proxy.ProxyRequestDirect(c, agentID)
+		return
+	}
+}
+
+// authorize checks req against the access policy of agentID without changing
+// it.
+func (r *PoolRouter) authorize(req *http.Request, agentID string) error {
+	if r.access == nil {
+		return nil
+	}
+	return r.access.Authorize(agentID, req.Clone(req.Context()))
+}
+
+// refuse responds to a request no agent of pool could take, because of err
+// from Pick. If some agent admitted it but was over its rate limits the
+// response is a 429, otherwise it is the refusal of the first agent.
+func (r *PoolRouter) refuse(c *gin.Context, pool *pools.Pool, err error, denied, limited map[string]error) {
+	metrics.AgentPoolRequests.WithLabelValues(pool.Name, "").Inc()
+	if !errors.Is(err, pools.ErrNoEligibleAgent) {
+		slog.Warn("No agent available in pool", "pool", pool.Name, "agents", pool.Members())
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		return
+	}
+
+	members := pool.Members()
+	for _, agentID := range members {
+		if err, ok := limited[agentID]; ok {
+			middleware.DenyRateLimited(c, agentID, err)
+			return
+		}
+	}
+	for _, agentID := range members {
+		if err := denied[agentID]; err != nil {
+			middleware.DenyAgentAccess(c, r.access, agentID, err)
+			return
+		}
+	}
+	c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+}
@@ -0,0 +1,103 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/EternisAI/silo-proxy/internal/access"
+	grpcserver "github.com/EternisAI/silo-proxy/internal/grpc/server"
+	"github.com/EternisAI/silo-proxy/internal/pools"
+	"github.com/EternisAI/silo-proxy/internal/ratelimit"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPoolRouter_Handler(t *testing.T) {
+	gs := grpcserver.NewServer(0, nil)
+	set, err := pools.NewSet([]pools.Config{
+		{Name: "api", Agents: []string{"agent-1", "agent-2"}, Host: "api.example.com", Port: 8090},
+	}, gs)
+	require.NoError(t, err)
+	r := NewPoolRouter(set, gs)
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+	h := r.Handler(next)
+
+	// No agent of the pool is connected.
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Host = "API.example.com:8080"
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+
+	req = httptest.NewRequest("GET", "/", nil)
+	req.Host = "other.example.com"
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusTeapot, w.Code)
+
+	servers := r.Servers()
+	require.Len(t, servers, 1)
+	assert.Equal(t, ":8090", servers[0].Addr)
+}
+
+// allAvailable reports every agent as available and idle.
+type allAvailable struct{}
+
+func (allAvailable) AgentAvailable(string) bool { return true }
+
+func (allAvailable) PendingRequests(string) int { return 0 }
+
+func newTestPoolRouter(t *testing.T) (*PoolRouter, http.Handler) {
+	set, err := pools.NewSet([]pools.Config{
+		{Name: "api", Agents: []string{"agent-1", "agent-2"}, Host: "api.example.com"},
+	}, allAvailable{})
+	require.NoError(t, err)
+	r := NewPoolRouter(set, grpcserver.NewServer(0, nil))
+	return r, r.Handler(http.NotFoundHandler())
+}
+
+func servePool(h http.Handler) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", "http://api.example.com/", nil)
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestPoolRouter_PicksAdmittedAgents(t *testing.T) {
+	r, h := newTestPoolRouter(t)
+	authorizer := fakeAuthorizer{"agent-1": access.ErrForbidden}
+	r.SetAccessPolicies(authorizer)
+
+	// Every request goes to agent-2, which is admitted but offline.
+	for range 4 {
+		rr := servePool(h)
+		assert.Equal(t, http.StatusNotFound, rr.Code)
+		assert.Contains(t, rr.Body.String(), "agent not found")
+	}
+
+	// Refused by all, the request gets the first agent's refusal.
+	authorizer["agent-1"] = access.ErrUnauthorized
+	authorizer["agent-2"] = access.ErrForbidden
+	rr := servePool(h)
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	assert.Equal(t, `Basic realm="agent-1"`, rr.Header().Get("WWW-Authenticate"))
+}
+
+func TestPoolRouter_PassesOverRateLimitedAgents(t *testing.T) {
+	r, h := newTestPoolRouter(t)
+	r.SetRateLimits(ratelimit.NewAgentLimiter(ratelimit.Limits{
+		PerAgent: ratelimit.Config{RequestsPerSecond: 0.25, Burst: 1},
+	}))
+
+	// Each agent admits one request; the second goes to the other agent.
+	assert.Equal(t, http.StatusNotFound, servePool(h).Code)
+	assert.Equal(t, http.StatusNotFound, servePool(h).Code)
+
+	rr := servePool(h)
+	assert.Equal(t, http.StatusTooManyRequests, rr.Code)
+	assert.NotEmpty(t, rr.Header().Get("Retry-After"))
+}
@@ -1,21 +1,57 @@
 package http
 
 import (
+	"context"
 	"fmt"
 	"log/slog"
 	"maps"
+	"slices"
 	"sync"
+	"time"
+
+	"github.com/EternisAI/silo-proxy/internal/metrics"
+	"github.com/EternisAI/silo-proxy/internal/reservations"
 )
 
+const reservationStoreTimeout = 5 * time.Second
+
+// ReservationStore persists the port reservations of a pool.
+type ReservationStore interface {
+	Load(ctx context.Context, pool string) (map[string]reservations.Reservation, error)
+	Save(ctx context.Context, pool, owner string, r reservations.Reservation) error
+	Delete(ctx context.Context, pool, owner string) error
+}
+
 // PortManager manages dynamic port allocation for per-agent HTTP servers.
-// It uses a channel-based pool for thread-safe port allocation and tracks
-// port-to-agent mappings for administrative purposes.
+// Every owner that is allocated a port keeps it reserved, so it gets the same
+// port back after a reconnect for as long as no other owner needed it.
+// Reservations can be pinned by an admin and, with a ReservationStore, also
+// survive server restarts. Changes are persisted in order by a single
+// goroutine, so allocating a port never waits on the store.
 type PortManager struct {
-	availablePorts chan int       // Buffered channel for thread-safe port pool
-	allocatedPorts map[int]string // Port -> agent_id mapping
-	mu             sync.RWMutex   // Protects allocatedPorts map
-	rangeStart     int            // First port in range
-	rangeEnd       int            // Last port in range (inclusive)
+	available      []int                               // Free ports, least recently released first
+	allocatedPorts map[int]string                      // Port -> owner mapping
+	reservations   map[string]reservations.Reservation // Owner -> reserved port
+	reservedBy     map[int]string                      // Port -> owner of its reservation
+	store          ReservationStore                    // Persists reservations (nil if not persisted)
+	writes         []reservationWrite                  // Changes queued for the store, oldest first
+	closed         bool                                // Set once no more changes are persisted
+	mu             sync.RWMutex                        // Protects all of the above
+	queued         chan struct{}                       // Signalled when writes are queued
+	written        chan struct{}                       // Closed once the writer has stopped
+	rangeStart     int                                 // First port in range
+	rangeEnd       int                                 // Last port in range (inclusive)
+	pool           string                              // Pool name for metrics and the store (empty if not set)
+}
+
+// reservationWrite is a change queued for the ReservationStore: a save of
+// reservation, or a delete of owner's reservation if it is nil. A write
+// without an owner changes nothing and only tells Flush when the writes
+// before it are done. done receives the result.
+type reservationWrite struct {
+	owner       string
+	reservation *reservations.Reservation
+	done        chan error
 }
 
 // NewPortManager creates a new PortManager with the specified port range.
@@ -34,15 +70,17 @@ func NewPortManager(start, end int) (*PortManager, error) {
 
 	poolSize := end - start + 1
 	pm := &PortManager{
-		availablePorts: make(chan int, poolSize),
+		available:      make([]int, 0, poolSize),
 		allocatedPorts: make(map[int]string),
+		reservations:   make(map[string]reservations.Reservation),
+		reservedBy:     make(map[int]string),
 		rangeStart:     start,
 		rangeEnd:       end,
 	}
 
 	// Pre-fill the port pool
 	for port := start; port <= end; port++ {
-		pm.availablePorts <- port
+		pm.available = append(pm.available, port)
 	}
 
 	slog.Info("PortManager initialized",
@@ -53,54 +91,381 @@ func NewPortManager(start, end int) (*PortManager, error) {
 	return pm, nil
 }
 
-// Allocate assigns an available port to the specified agent.
-// Returns an error if no ports are available (pool exhausted).
-// This operation is thread-safe and non-blocking.
-func (pm *PortManager) Allocate(agentID string) (int, error) {
-	// Non-blocking receive from channel
-	select {
-	case port := <-pm.availablePorts:
-		pm.mu.Lock()
-		pm.allocatedPorts[port] = agentID
+// SetPoolName exports the pool's size and utilisation as metrics labelled
+// with name.
+func (pm *PortManager) SetPoolName(name string) {
+	pm.mu.Lock()
+	pm.pool = name
+	pm.mu.Unlock()
+
+	metrics.PortPoolSize.WithLabelValues(name).Set(float64(pm.rangeEnd - pm.rangeStart + 1))
+	pm.updateMetrics()
+}
+
+// SetReservationStore loads the pool's reservations from store and persists
+// every later change to it in the background until Close is called. The pool
+// name must be set first. Reservations for ports outside the range are
+// ignored.
+func (pm *PortManager) SetReservationStore(store ReservationStore) error {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	if pm.pool == "" {
+		return fmt.Errorf("pool name must be set before the reservation store")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), reservationStoreTimeout)
+	defer cancel()
+
+	loaded, err := store.Load(ctx, pm.pool)
+	if err != nil {
+		return fmt.Errorf("failed to load port reservations: %w", err)
+	}
+
+	for owner, r := range loaded {
+		if !pm.inRange(r.Port) {
+			slog.Warn("Ignoring port reservation outside the pool range",
+				"pool", pm.pool,
+				"owner", owner,
+				"port", r.Port)
+			continue
+		}
+		if holder, taken := pm.reservedBy[r.Port]; taken && holder != owner {
+			slog.Warn("Ignoring duplicate port reservation",
+				"pool", pm.pool,
+				"owner", owner,
+				"port", r.Port,
+				"reserved_by", holder)
+			continue
+		}
+		pm.reserve(owner, r)
+	}
+	pm.store = store
+	pm.queued = make(chan struct{}, 1)
+	pm.written = make(chan struct{})
+	go pm.writeReservations()
+
+	slog.Info("Port reservations loaded", "pool", pm.pool, "count", len(pm.reservations))
+	return nil
+}
+
+// Flush waits until the changes made so far have been persisted.
+func (pm *PortManager) Flush() {
+	pm.mu.Lock()
+	done := pm.persist("", nil)
+	pm.mu.Unlock()
+
+	if done != nil {
+		<-done
+	}
+}
+
+// Close persists the changes still queued and stops persisting later ones.
+func (pm *PortManager) Close() {
+	pm.mu.Lock()
+	if pm.store == nil || pm.closed {
 		pm.mu.Unlock()
+		return
+	}
+	pm.closed = true
+	pm.mu.Unlock()
 
-		slog.Info("Port allocated",
-			"port", port,
-			"agent_id", agentID,
-			"available_ports", len(pm.availablePorts))
+	pm.signal()
+	<-pm.written
+}
 
-		return port, nil
-	default:
-		// Pool exhausted
+// updateMetrics publishes the number of allocated ports once a pool name
+// has been set.
+func (pm *PortManager) updateMetrics() {
+	pm.mu.RLock()
+	defer pm.mu.RUnlock()
+
+	if pm.pool != "" {
+		metrics.PortPoolAllocated.WithLabelValues(pm.pool).Set(float64(len(pm.allocatedPorts)))
+	}
+}
+
+// Allocate assigns a port to the specified owner, preferring the port
+// reserved for it. Otherwise it takes a free port nobody has reserved and, if
+// the pool has none left, one whose unpinned reservation belongs to an owner
+// that is not using it. The owner keeps the first port it gets reserved.
+// Returns an error if no ports are available (pool exhausted).
+// This operation is thread-safe and non-blocking.
+func (pm *PortManager) Allocate(owner string) (int, error) {
+	pm.mu.Lock()
+	port, ok := pm.take(owner)
+	available := len(pm.available)
+	pm.mu.Unlock()
+
+	if !ok {
 		slog.Error("Port allocation failed: pool exhausted",
-			"agent_id", agentID,
+			"owner", owner,
 			"range_start", pm.rangeStart,
 			"range_end", pm.rangeEnd)
 		return 0, fmt.Errorf("no available ports in range %d-%d", pm.rangeStart, pm.rangeEnd)
 	}
+	pm.updateMetrics()
+
+	slog.Info("Port allocated",
+		"port", port,
+		"owner", owner,
+		"available_ports", available)
+
+	return port, nil
+}
+
+// take picks a port for owner and marks it allocated. pm.mu must be held.
+func (pm *PortManager) take(owner string) (int, bool) {
+	r, reserved := pm.reservations[owner]
+	if reserved {
+		if i := slices.Index(pm.available, r.Port); i >= 0 {
+			return pm.takeAt(i, owner), true
+		}
+	}
+
+	for i, port := range pm.available {
+		if _, taken := pm.reservedBy[port]; !taken {
+			if !reserved {
+				pm.reserveAndSave(owner, reservations.Reservation{Port: port})
+			}
+			return pm.takeAt(i, owner), true
+		}
+	}
+
+	for i, port := range pm.available {
+		holder := pm.reservedBy[port]
+		if pm.reservations[holder].Pinned {
+			continue
+		}
+		slog.Info("Reclaiming port reserved for another owner",
+			"port", port,
+			"owner", owner,
+			"reserved_by", holder)
+		pm.unreserveAndDelete(holder)
+		if !reserved {
+			pm.reserveAndSave(owner, reservations.Reservation{Port: port})
+		}
+		return pm.takeAt(i, owner), true
+	}
+
+	return 0, false
 }
 
-// Release returns a port to the available pool.
+func (pm *PortManager) takeAt(i int, owner string) int {
+	port := pm.available[i]
+	pm.available = slices.Delete(pm.available, i, i+1)
+	pm.allocatedPorts[port] = owner
+	return port
+}
+
+// Release returns a port to the available pool. Its reservation is kept.
 // This operation is idempotent - releasing an unallocated port is a safe no-op.
 // Logs a warning if attempting to release a port not in the allocation map.
 func (pm *PortManager) Release(port int) {
 	pm.mu.Lock()
-	agentID, exists := pm.allocatedPorts[port]
+	owner, exists := pm.allocatedPorts[port]
 	if !exists {
 		pm.mu.Unlock()
 		slog.Warn("Attempted to release unallocated port", "port", port)
 		return
 	}
 	delete(pm.allocatedPorts, port)
-	pm.mu.Unlock()
 
 	// Return port to pool
-	pm.availablePorts <- port
+	pm.available = append(pm.available, port)
+	available := len(pm.available)
+	pm.mu.Unlock()
+	pm.updateMetrics()
 
 	slog.Info("Port released",
 		"port", port,
-		"agent_id", agentID,
-		"available_ports", len(pm.availablePorts))
+		"owner", owner,
+		"available_ports", available)
+}
+
+// Pin reserves port for owner so that it is never handed to another owner.
+// The owner moves to the port the next time it is allocated one. Returns
+// reservations.ErrPortOutOfRange if port is outside the pool and
+// reservations.ErrPortUnavailable if it is pinned to or in use by another
+// owner. An unpinned reservation of another owner is taken over. Pin waits
+// for the change to be persisted and returns the store's error if it was
+// not; the pin still holds until the server restarts.
+func (pm *PortManager) Pin(owner string, port int) error {
+	pm.mu.Lock()
+	if !pm.inRange(port) {
+		pm.mu.Unlock()
+		return fmt.Errorf("%w: %d not in %d-%d", reservations.ErrPortOutOfRange, port, pm.rangeStart, pm.rangeEnd)
+	}
+
+	holder, reserved := pm.reservedBy[port]
+	if reserved && holder != owner && pm.reservations[holder].Pinned {
+		pm.mu.Unlock()
+		return fmt.Errorf("%w: %d is pinned to %s", reservations.ErrPortUnavailable, port, holder)
+	}
+	if user, inUse := pm.allocatedPorts[port]; inUse && user != owner {
+		pm.mu.Unlock()
+		return fmt.Errorf("%w: %d is in use by %s", reservations.ErrPortUnavailable, port, user)
+	}
+
+	var deleted <-chan error
+	if reserved && holder != owner {
+		deleted = pm.unreserveAndDelete(holder)
+	}
+	pm.unreserve(owner)
+	saved := pm.reserveAndSave(owner, reservations.Reservation{Port: port, Pinned: true})
+	pm.mu.Unlock()
+
+	slog.Info("Port pinned", "pool", pm.pool, "owner", owner, "port", port)
+	return wait(deleted, saved)
+}
+
+// Unpin turns owner's pinned reservation back into an ordinary one, which may
+// be reclaimed by other owners once the pool runs out of ports. Returns
+// reservations.ErrNotReserved if owner has no reservation. Like Pin, it waits
+// for the change to be persisted.
+func (pm *PortManager) Unpin(owner string) error {
+	pm.mu.Lock()
+	r, ok := pm.reservations[owner]
+	if !ok {
+		pm.mu.Unlock()
+		return reservations.ErrNotReserved
+	}
+	if !r.Pinned {
+		pm.mu.Unlock()
+		return nil
+	}
+	r.Pinned = false
+	saved := pm.reserveAndSave(owner, r)
+	pm.mu.Unlock()
+
+	slog.Info("Port unpinned", "pool", pm.pool, "owner", owner, "port", r.Port)
+	return wait(saved)
+}
+
+// Reservation returns the port reserved for owner.
+func (pm *PortManager) Reservation(owner string) (reservations.Reservation, bool) {
+	pm.mu.RLock()
+	defer pm.mu.RUnlock()
+
+	r, ok := pm.reservations[owner]
+	return r, ok
+}
+
+func (pm *PortManager) inRange(port int) bool {
+	return port >= pm.rangeStart && port <= pm.rangeEnd
+}
+
+func (pm *PortManager) reserve(owner string, r reservations.Reservation) {
+	pm.reservations[owner] = r
+	pm.reservedBy[r.Port] = owner
+}
+
+func (pm *PortManager) unreserve(owner string) {
+	if r, ok := pm.reservations[owner]; ok {
+		delete(pm.reservedBy, r.Port)
+		delete(pm.reservations, owner)
+	}
+}
+
+// reserveAndSave reserves a port and queues the reservation for the store.
+// pm.mu must be held. The returned channel, nil without a store, receives
+// the result; a failure is also logged, and the reservation still holds until
+// the server restarts.
+func (pm *PortManager) reserveAndSave(owner string, r reservations.Reservation) <-chan error {
+	pm.reserve(owner, r)
+	return pm.persist(owner, &r)
+}
+
+// unreserveAndDelete drops owner's reservation and queues its deletion from
+// the store, like reserveAndSave.
+func (pm *PortManager) unreserveAndDelete(owner string) <-chan error {
+	pm.unreserve(owner)
+	return pm.persist(owner, nil)
+}
+
+// persist queues a write for the store, returning the channel that receives
+// its result, or nil if the manager has no store or has been closed. pm.mu
+// must be held.
+func (pm *PortManager) persist(owner string, r *reservations.Reservation) <-chan error {
+	if pm.store == nil || pm.closed {
+		return nil
+	}
+	done := make(chan error, 1)
+	pm.writes = append(pm.writes, reservationWrite{owner: owner, reservation: r, done: done})
+	pm.signal()
+	return done
+}
+
+func (pm *PortManager) signal() {
+	select {
+	case pm.queued <- struct{}{}:
+	default:
+	}
+}
+
+// writeReservations writes the queued changes to the store in order until
+// the manager is closed.
+func (pm *PortManager) writeReservations() {
+	defer close(pm.written)
+
+	for range pm.queued {
+		pm.mu.Lock()
+		writes, closed := pm.writes, pm.closed
+		pm.writes = nil
+		pm.mu.Unlock()
+
+		for _, w := range writes {
+			w.done <- pm.write(w)
+		}
+		if closed {
+			return
+		}
+	}
+}
+
+func (pm *PortManager) write(w reservationWrite) error {
+	if w.owner == "" {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), reservationStoreTimeout)
+	defer cancel()
+
+	if w.reservation == nil {
+		if err := pm.store.Delete(ctx, pm.pool, w.owner); err != nil {
+			slog.Error("Failed to delete port reservation",
+				"pool", pm.pool,
+				"owner", w.owner,
+				"error", err)
+			return err
+		}
+		return nil
+	}
+
+	if err := pm.store.Save(ctx, pm.pool, w.owner, *w.reservation); err != nil {
+		slog.Error("Failed to persist port reservation",
+			"pool", pm.pool,
+			"owner", w.owner,
+			"port", w.reservation.Port,
+			"error", err)
+		return err
+	}
+	return nil
+}
+
+// wait waits for the results of queued writes, returning the first error.
+// Nil channels are skipped.
+func wait(results ...<-chan error) error {
+	var first error
+	for _, done := range results {
+		if done == nil {
+			continue
+		}
+		if err := <-done; err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
 }
 
 // GetAllocations returns a thread-safe copy of current port allocations.
@@ -1,9 +1,14 @@
 package http
 
 import (
+	"context"
 	"sync"
 	"testing"
+	"time"
 
+	"github.com/EternisAI/silo-proxy/internal/metrics"
+	"github.com/EternisAI/silo-proxy/internal/reservations"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -266,3 +271,260 @@ func TestPortManager_SimulateAgentChurn(t *testing.T) {
 		pm.Release(port)
 	}
 }
+
+func TestPortManager_PoolMetrics(t *testing.T) {
+	pm, err := NewPortManager(8100, 8104)
+	require.NoError(t, err)
+	pm.SetPoolName("test_pool")
+
+	assert.Equal(t, float64(5), testutil.ToFloat64(metrics.PortPoolSize.WithLabelValues("test_pool")))
+	assert.Equal(t, float64(0), testutil.ToFloat64(metrics.PortPoolAllocated.WithLabelValues("test_pool")))
+
+	port, err := pm.Allocate("agent-1")
+	require.NoError(t, err)
+	_, err = pm.Allocate("agent-2")
+	require.NoError(t, err)
+	assert.Equal(t, float64(2), testutil.ToFloat64(metrics.PortPoolAllocated.WithLabelValues("test_pool")))
+
+	pm.Release(port)
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.PortPoolAllocated.WithLabelValues("test_pool")))
+}
+
+// memoryReservationStore is a ReservationStore for a single pool.
+type memoryReservationStore struct {
+	mu    sync.Mutex
+	saved map[string]reservations.Reservation
+}
+
+func newMemoryReservationStore() *memoryReservationStore {
+	return &memoryReservationStore{saved: make(map[string]reservations.Reservation)}
+}
+
+func (s *memoryReservationStore) Load(ctx context.Context, pool string) (map[string]reservations.Reservation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	loaded := make(map[string]reservations.Reservation, len(s.saved))
+	for owner, r := range s.saved {
+		loaded[owner] = r
+	}
+	return loaded, nil
+}
+
+func (s *memoryReservationStore) Save(ctx context.Context, pool, owner string, r reservations.Reservation) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.saved[owner] = r
+	return nil
+}
+
+func (s *memoryReservationStore) Delete(ctx context.Context, pool, owner string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.saved, owner)
+	return nil
+}
+
+func TestPortManager_StickyAcrossReconnects(t *testing.T) {
+	pm, err := NewPortManager(8100, 8105)
+	require.NoError(t, err)
+
+	port1, err := pm.Allocate("agent-1")
+	require.NoError(t, err)
+	port2, err := pm.Allocate("agent-2")
+	require.NoError(t, err)
+
+	// agent-1 disconnects and another agent connects before it comes back
+	pm.Release(port1)
+	port3, err := pm.Allocate("agent-3")
+	require.NoError(t, err)
+	assert.NotEqual(t, port1, port3, "a reserved port should not go to a new agent while others are free")
+
+	port, err := pm.Allocate("agent-1")
+	require.NoError(t, err)
+	assert.Equal(t, port1, port)
+
+	// Both disconnect and reconnect in the opposite order
+	pm.Release(port1)
+	pm.Release(port2)
+	port, err = pm.Allocate("agent-2")
+	require.NoError(t, err)
+	assert.Equal(t, port2, port)
+	port, err = pm.Allocate("agent-1")
+	require.NoError(t, err)
+	assert.Equal(t, port1, port)
+}
+
+func TestPortManager_ReservationsSurviveRestart(t *testing.T) {
+	store := newMemoryReservationStore()
+
+	pm, err := NewPortManager(8100, 8105)
+	require.NoError(t, err)
+	pm.SetPoolName("test_restart")
+	require.NoError(t, pm.SetReservationStore(store))
+
+	for _, owner := range []string{"agent-1", "agent-2", "agent-3"} {
+		_, err := pm.Allocate(owner)
+		require.NoError(t, err)
+	}
+	require.NoError(t, pm.Pin("agent-4", 8105))
+	before := pm.GetAllocations()
+
+	// A new manager loads the same reservations
+	restarted, err := NewPortManager(8100, 8105)
+	require.NoError(t, err)
+	restarted.SetPoolName("test_restart")
+	require.NoError(t, restarted.SetReservationStore(store))
+
+	for _, owner := range []string{"agent-3", "agent-1", "agent-2"} {
+		port, err := restarted.Allocate(owner)
+		require.NoError(t, err)
+		assert.Equal(t, owner, before[port], "%s should get its port back", owner)
+	}
+	port, err := restarted.Allocate("agent-4")
+	require.NoError(t, err)
+	assert.Equal(t, 8105, port)
+}
+
+func TestPortManager_SetReservationStoreRequiresPoolName(t *testing.T) {
+	pm, err := NewPortManager(8100, 8105)
+	require.NoError(t, err)
+
+	assert.Error(t, pm.SetReservationStore(newMemoryReservationStore()))
+}
+
+func TestPortManager_SetReservationStoreSkipsOutOfRange(t *testing.T) {
+	store := newMemoryReservationStore()
+	store.saved["agent-1"] = reservations.Reservation{Port: 9000}
+	store.saved["agent-2"] = reservations.Reservation{Port: 8101, Pinned: true}
+
+	pm, err := NewPortManager(8100, 8105)
+	require.NoError(t, err)
+	pm.SetPoolName("test_out_of_range")
+	require.NoError(t, pm.SetReservationStore(store))
+
+	_, ok := pm.Reservation("agent-1")
+	assert.False(t, ok)
+	r, ok := pm.Reservation("agent-2")
+	require.True(t, ok)
+	assert.Equal(t, reservations.Reservation{Port: 8101, Pinned: true}, r)
+}
+
+func TestPortManager_ReclaimsUnpinnedReservations(t *testing.T) {
+	store := newMemoryReservationStore()
+
+	pm, err := NewPortManager(8100, 8101)
+	require.NoError(t, err)
+	pm.SetPoolName("test_reclaim")
+	require.NoError(t, pm.SetReservationStore(store))
+
+	port1, err := pm.Allocate("agent-1")
+	require.NoError(t, err)
+	port2, err := pm.Allocate("agent-2")
+	require.NoError(t, err)
+	require.NoError(t, pm.Pin("agent-2", port2))
+	pm.Release(port1)
+	pm.Release(port2)
+
+	// Every port is reserved: agent-3 takes over agent-1's unpinned one
+	port, err := pm.Allocate("agent-3")
+	require.NoError(t, err)
+	assert.Equal(t, port1, port)
+
+	_, ok := pm.Reservation("agent-1")
+	assert.False(t, ok)
+	pm.Flush()
+	assert.NotContains(t, store.saved, "agent-1")
+	assert.Equal(t, reservations.Reservation{Port: port1}, store.saved["agent-3"])
+
+	// The pinned port is never handed out to another agent
+	_, err = pm.Allocate("agent-4")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "no available ports")
+
+	port, err = pm.Allocate("agent-2")
+	require.NoError(t, err)
+	assert.Equal(t, port2, port)
+}
+
+// blockingReservationStore is a memoryReservationStore whose writes wait
+// until unblock is closed.
+type blockingReservationStore struct {
+	*memoryReservationStore
+	unblock chan struct{}
+}
+
+func (s *blockingReservationStore) Save(ctx context.Context, pool, owner string, r reservations.Reservation) error {
+	<-s.unblock
+	return s.memoryReservationStore.Save(ctx, pool, owner, r)
+}
+
+func TestPortManager_AllocateDoesNotWaitForStore(t *testing.T) {
+	store := &blockingReservationStore{newMemoryReservationStore(), make(chan struct{})}
+
+	pm, err := NewPortManager(8100, 8105)
+	require.NoError(t, err)
+	pm.SetPoolName("test_async")
+	require.NoError(t, pm.SetReservationStore(store))
+
+	allocated := make(chan int)
+	go func() {
+		port, err := pm.Allocate("agent-1")
+		assert.NoError(t, err)
+		allocated <- port
+	}()
+
+	var port int
+	select {
+	case port = <-allocated:
+	case <-time.After(time.Second):
+		t.Fatal("Allocate waited for the store")
+	}
+	_, err = pm.Allocate("agent-2")
+	require.NoError(t, err)
+
+	// Close persists the queued reservations
+	close(store.unblock)
+	pm.Close()
+	assert.Equal(t, reservations.Reservation{Port: port}, store.saved["agent-1"])
+	assert.Len(t, store.saved, 2)
+}
+
+func TestPortManager_Pin(t *testing.T) {
+	pm, err := NewPortManager(8100, 8105)
+	require.NoError(t, err)
+
+	port, err := pm.Allocate("agent-1")
+	require.NoError(t, err)
+
+	err = pm.Pin("agent-2", 9000)
+	assert.ErrorIs(t, err, reservations.ErrPortOutOfRange)
+
+	err = pm.Pin("agent-2", port)
+	assert.ErrorIs(t, err, reservations.ErrPortUnavailable, "port is in use by agent-1")
+
+	// agent-1 moves to its pinned port on its next allocation
+	require.NoError(t, pm.Pin("agent-1", 8104))
+	pm.Release(port)
+	port, err = pm.Allocate("agent-1")
+	require.NoError(t, err)
+	assert.Equal(t, 8104, port)
+	pm.Release(port)
+
+	err = pm.Pin("agent-2", 8104)
+	assert.ErrorIs(t, err, reservations.ErrPortUnavailable, "port is pinned to agent-1")
+
+	// Unpinning keeps the reservation, which another pin may then take over
+	require.NoError(t, pm.Unpin("agent-1"))
+	r, ok := pm.Reservation("agent-1")
+	require.True(t, ok)
+	assert.Equal(t, reservations.Reservation{Port: 8104}, r)
+
+	require.NoError(t, pm.Pin("agent-2", 8104))
+	_, ok = pm.Reservation("agent-1")
+	assert.False(t, ok)
+
+	assert.ErrorIs(t, pm.Unpin("agent-1"), reservations.ErrNotReserved)
+}
@@ -1,12 +1,22 @@
 package http
 
 import (
+	"github.com/EternisAI/silo-proxy/internal/access"
+	"github.com/EternisAI/silo-proxy/internal/agents"
 	"github.com/EternisAI/silo-proxy/internal/api/http/handler"
 	"github.com/EternisAI/silo-proxy/internal/api/http/middleware"
 	"github.com/EternisAI/silo-proxy/internal/auth"
 	"github.com/EternisAI/silo-proxy/internal/cert"
+	"github.com/EternisAI/silo-proxy/internal/commands"
+	"github.com/EternisAI/silo-proxy/internal/domains"
+	"github.com/EternisAI/silo-proxy/internal/forwarded"
 	grpcserver "github.com/EternisAI/silo-proxy/internal/grpc/server"
+	"github.com/EternisAI/silo-proxy/internal/metrics"
+	"github.com/EternisAI/silo-proxy/internal/pools"
 	"github.com/EternisAI/silo-proxy/internal/provision"
+	"github.com/EternisAI/silo-proxy/internal/ratelimit"
+	"github.com/EternisAI/silo-proxy/internal/renewal"
+	"github.com/EternisAI/silo-proxy/internal/revocation"
 	"github.com/EternisAI/silo-proxy/internal/users"
 	"github.com/gin-gonic/gin"
 )
@@ -14,9 +24,31 @@ import (
 type Services struct {
 	GrpcServer  *grpcserver.Server
 	CertService *cert.Service
+	Revocations *revocation.Service
+	Renewals    *renewal.Service
 	AuthService *auth.Service
 	UserService *users.Service
 	KeyStore    *provision.KeyStore
+	Registry    *agents.Service
+	AgentPorts  handler.PortReserver
+	Domains     *domains.Service
+	Access      *access.Service
+	RateLimits  *ratelimit.AgentLimiter
+	// TrustedProxies are the peers whose forwarding headers are kept when
+	// proxying to agents.
+	TrustedProxies *forwarded.TrustedProxies
+	// Commands decides which commands may be run on agents, by whom and for
+	// how long.
+	Commands *commands.Policy
+	// Pools are the configured agent pools.
+	Pools *pools.Set
+	// ProxyRoles are the roles of users who may use /proxy/:agent_id, Admin
+	// if empty.
+	ProxyRoles []string
+
+	// AllowServerGeneratedKeys keeps the legacy provisioning flow, where the
+	// server generates agent keys, available alongside CSR-based provisioning.
+	AllowServerGeneratedKeys bool
 }
 
 func SetupRoute(engine *gin.Engine, srvs *Services, adminAPIKey string, jwtSecret string) {
@@ -24,6 +56,7 @@ func SetupRoute(engine *gin.Engine, srvs *Services, adminAPIKey string, jwtSecre
 
 	healthHandler := handler.NewHealthHandler()
 	engine.GET("/health", healthHandler.Check)
+	engine.GET("/metrics", middleware.APIKeyAuth(adminAPIKey), metrics.Handler())
 
 	authHandler := handler.NewAuthHandler(srvs.AuthService)
 	authRoutes := engine.Group("/auth")
@@ -40,14 +73,41 @@ func SetupRoute(engine *gin.Engine, srvs *Services, adminAPIKey string, jwtSecre
 		usersGroup.GET("", middleware.RequireRole("Admin"), userHandler.ListUsers)
 	}
 
+	certHandler := handler.NewCertHandler(srvs.CertService, srvs.Revocations, srvs.Renewals, srvs.GrpcServer)
+	engine.GET("/api/v1/crl", certHandler.GetCRL)
+
+	if srvs.GrpcServer != nil {
+		proxyHandler := handler.NewProxyHandler(srvs.GrpcServer)
+		proxyHandler.SetTrustedProxies(srvs.TrustedProxies)
+		proxyAgentID := func(c *gin.Context) string { return c.Param("agent_id") }
+		proxyRoles := srvs.ProxyRoles
+		if len(proxyRoles) == 0 {
+			proxyRoles = []string{"Admin"}
+		}
+		authRoutes.POST("/proxy-session/:agent_id", middleware.JWTAuth(jwtSecret), middleware.RequireRole(proxyRoles...), authHandler.StartProxySession)
+
+		proxyChain := []gin.HandlerFunc{middleware.JWTOrProxySessionAuth(jwtSecret), middleware.RequireRole(proxyRoles...)}
+		if srvs.Access != nil {
+			proxyChain = append(proxyChain, middleware.AgentAccess(srvs.Access, proxyAgentID))
+		}
+		if srvs.RateLimits != nil {
+			proxyChain = append(proxyChain, middleware.AgentRateLimit(srvs.RateLimits, srvs.TrustedProxies, proxyAgentID))
+		}
+		engine.Any("/proxy/:agent_id/*path", append(proxyChain, proxyHandler.ProxyRequest)...)
+	}
+
 	agents := engine.Group("/agents")
 	{
 		if srvs.GrpcServer != nil {
 			adminHandler := handler.NewAdminHandler(srvs.GrpcServer)
 			agents.GET("", adminHandler.ListAgents)
+
+			if srvs.Commands != nil {
+				commandHandler := handler.NewCommandHandler(srvs.GrpcServer, srvs.Commands)
+				agents.POST("/:id/commands", middleware.APIKeyOrJWTAuth(adminAPIKey, jwtSecret), commandHandler.RunCommand)
+			}
 		}
 
-		certHandler := handler.NewCertHandler(srvs.CertService)
 		certRoutes := agents.Group("")
 		certRoutes.Use(middleware.APIKeyAuth(adminAPIKey))
 		{
@@ -55,10 +115,83 @@ func SetupRoute(engine *gin.Engine, srvs *Services, adminAPIKey string, jwtSecre
 			certRoutes.GET("/:id/certificate", certHandler.GetAgentCertificate)
 			certRoutes.DELETE("/:id/certificate", certHandler.DeleteAgentCertificate)
 		}
+
+		if srvs.GrpcServer != nil {
+			adminHandler := handler.NewAdminHandler(srvs.GrpcServer)
+
+			connectionRoutes := agents.Group("")
+			connectionRoutes.Use(middleware.APIKeyAuth(adminAPIKey))
+			{
+				connectionRoutes.POST("/:id/disconnect", adminHandler.DisconnectAgent)
+				connectionRoutes.POST("/:id/drain", adminHandler.DrainAgent)
+				connectionRoutes.DELETE("/:id/block", adminHandler.UnblockAgent)
+			}
+		}
+	}
+
+	if srvs.Registry != nil {
+		registryHandler := handler.NewAgentRegistryHandler(srvs.Registry, srvs.GrpcServer)
+
+		registryRoutes := engine.Group("/api/v1/agents")
+		registryRoutes.Use(middleware.APIKeyAuth(adminAPIKey))
+		{
+			registryRoutes.GET("", registryHandler.ListAgents)
+			registryRoutes.GET("/:id", registryHandler.GetAgent)
+			registryRoutes.GET("/:id/sessions", registryHandler.ListSessions)
+			registryRoutes.PUT("/:id/labels", registryHandler.UpdateLabels)
+		}
+	}
+
+	if srvs.AgentPorts != nil {
+		portHandler := handler.NewPortReservationHandler(srvs.AgentPorts)
+
+		portRoutes := engine.Group("/api/v1/agents")
+		portRoutes.Use(middleware.APIKeyAuth(adminAPIKey))
+		{
+			portRoutes.GET("/:id/port", portHandler.GetPort)
+			portRoutes.PUT("/:id/port", portHandler.PinPort)
+			portRoutes.DELETE("/:id/port", portHandler.UnpinPort)
+		}
+	}
+
+	if srvs.Domains != nil {
+		domainHandler := handler.NewDomainHandler(srvs.Domains)
+
+		domainRoutes := engine.Group("/api/v1/agents")
+		domainRoutes.Use(middleware.APIKeyAuth(adminAPIKey))
+		{
+			domainRoutes.GET("/:id/domains", domainHandler.ListDomains)
+			domainRoutes.POST("/:id/domains", domainHandler.AddDomain)
+			domainRoutes.DELETE("/:id/domains/:domain", domainHandler.RemoveDomain)
+		}
+	}
+
+	if srvs.Access != nil {
+		accessHandler := handler.NewAccessPolicyHandler(srvs.Access)
+
+		accessRoutes := engine.Group("/api/v1/agents")
+		accessRoutes.Use(middleware.APIKeyAuth(adminAPIKey))
+		{
+			accessRoutes.GET("/:id/access-policy", accessHandler.GetPolicy)
+			accessRoutes.PUT("/:id/access-policy", accessHandler.UpdatePolicy)
+			accessRoutes.DELETE("/:id/access-policy", accessHandler.DeletePolicy)
+		}
+	}
+
+	if srvs.Pools != nil && srvs.GrpcServer != nil {
+		poolHandler := handler.NewPoolHandler(srvs.Pools, srvs.GrpcServer)
+
+		poolRoutes := engine.Group("/api/v1/pools")
+		poolRoutes.Use(middleware.APIKeyAuth(adminAPIKey))
+		{
+			poolRoutes.GET("", poolHandler.ListPools)
+			poolRoutes.GET("/:name", poolHandler.GetPool)
+		}
 	}
 
 	if srvs.KeyStore != nil {
 		provisionHandler := handler.NewProvisionHandler(srvs.KeyStore, srvs.CertService)
+		provisionHandler.SetAllowServerGeneratedKeys(srvs.AllowServerGeneratedKeys)
 
 		provisionAdmin := engine.Group("/api/v1/provision-keys")
 		provisionAdmin.Use(middleware.APIKeyAuth(adminAPIKey))
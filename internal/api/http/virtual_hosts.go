@@ -0,0 +1,107 @@
+package http
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/EternisAI/silo-proxy/internal/api/http/middleware"
+	"github.com/EternisAI/silo-proxy/internal/domains"
+	grpcserver "github.com/EternisAI/silo-proxy/internal/grpc/server"
+	"github.com/gin-gonic/gin"
+)
+
+// DomainResolver maps custom domains to agents.
+type DomainResolver interface {
+	Lookup(domain string) (string, bool)
+}
+
+// VirtualHostRouter routes requests to agents by their Host header instead
+// of by port: "<agent-id>.<base domain>" reaches that agent, as does any
+// custom domain mapped to it. Requests are forwarded exactly like those
+// arriving on an agent's own port, through the embedded AgentForwarder.
+type VirtualHostRouter struct {
+	*AgentForwarder
+
+	baseDomain string
+	apiHosts   map[string]bool
+	domains    DomainResolver
+	engine     *gin.Engine
+}
+
+// NewVirtualHostRouter creates a router for subdomains of baseDomain and the
+// custom domains known to resolver. Either may be empty or nil to disable
+// that kind of routing.
+func NewVirtualHostRouter(baseDomain string, resolver DomainResolver, gs *grpcserver.Server) *VirtualHostRouter {
+	r := &VirtualHostRouter{
+		AgentForwarder: NewAgentForwarder(gs),
+		baseDomain:     domains.Normalize(baseDomain),
+		domains:        resolver,
+	}
+
+	gin.SetMode(gin.ReleaseMode)
+	r.engine = gin.New()
+	r.engine.Use(middleware.RequestLogger())
+	r.engine.Use(gin.Recovery())
+
+	r.engine.NoRoute(func(c *gin.Context) {
+		agentID, ok := r.Resolve(c.Request.Host)
+		if !ok {
+			c.JSON(http.StatusNotFound, gin.H{"error": "unknown host"})
+			return
+		}
+		r.Forward(c, agentID)
+	})
+
+	return r
+}
+
+// SetAPIHosts keeps requests for the API's host names from routing to
+// agents, so that neither an agent called like the API's subdomain nor a
+// custom domain can shadow it. It must be called before the router serves
+// requests.
+func (r *VirtualHostRouter) SetAPIHosts(hosts []string) {
+	r.apiHosts = make(map[string]bool, len(hosts))
+	for _, host := range hosts {
+		r.apiHosts[domains.Normalize(host)] = true
+	}
+}
+
+// Resolve returns the agent that host routes to. API hosts route to none.
+func (r *VirtualHostRouter) Resolve(host string) (string, bool) {
+	host = domains.Normalize(host)
+	if r.apiHosts[host] {
+		return "", false
+	}
+
+	if r.baseDomain != "" {
+		if agentID, found := strings.CutSuffix(host, "."+r.baseDomain); found {
+			if agentID == "" || strings.Contains(agentID, ".") {
+				return "", false
+			}
+			return agentID, true
+		}
+	}
+
+	if r.domains != nil {
+		return r.domains.Lookup(host)
+	}
+	return "", false
+}
+
+// ServeHTTP forwards the request to the agent its Host header routes to, or
+// responds 404. It serves a dedicated ingress listener.
+func (r *VirtualHostRouter) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.engine.ServeHTTP(w, req)
+}
+
+// Handler forwards requests whose Host header routes to an agent and passes
+// all others to next, so that virtual hosts can share a port with the API.
+func (r *VirtualHostRouter) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if _, ok := r.Resolve(req.Host); ok {
+			r.engine.ServeHTTP(w, req)
+			return
+		}
+		next.ServeHTTP(w, req)
+	})
+}
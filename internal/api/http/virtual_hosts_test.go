@@ -0,0 +1,170 @@
+package http
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/EternisAI/silo-proxy/internal/access"
+	grpcserver "github.com/EternisAI/silo-proxy/internal/grpc/server"
+	"github.com/EternisAI/silo-proxy/internal/ratelimit"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeDomains map[string]string
+
+func (f fakeDomains) Lookup(domain string) (string, bool) {
+	agentID, ok := f[domain]
+	return agentID, ok
+}
+
+// fakeAuthorizer refuses every request for the agents it lists.
+type fakeAuthorizer map[string]error
+
+func (f fakeAuthorizer) Authorize(agentID string, r *http.Request) error {
+	return f[agentID]
+}
+
+func (f fakeAuthorizer) RequiresBasicAuth(agentID string) bool {
+	return errors.Is(f[agentID], access.ErrUnauthorized)
+}
+
+func TestVirtualHostRouter_Resolve(t *testing.T) {
+	r := NewVirtualHostRouter("Tunnel.Example.com", fakeDomains{"app.customer.io": "agent-2"}, grpcserver.NewServer(0, nil))
+
+	tests := []struct {
+		host    string
+		agentID string
+		ok      bool
+	}{
+		{"agent-1.tunnel.example.com", "agent-1", true},
+		{"agent-1.tunnel.example.com:8080", "agent-1", true},
+		{"AGENT-1.Tunnel.Example.com.", "agent-1", true},
+		{"app.customer.io", "agent-2", true},
+		{"App.Customer.io:443", "agent-2", true},
+		{"tunnel.example.com", "", false},
+		{".tunnel.example.com", "", false},
+		{"a.b.tunnel.example.com", "", false},
+		{"agent-1.tunnel.example.com.evil.io", "", false},
+		{"other.customer.io", "", false},
+		{"localhost:8080", "", false},
+	}
+
+	for _, tt := range tests {
+		agentID, ok := r.Resolve(tt.host)
+		assert.Equal(t, tt.ok, ok, tt.host)
+		assert.Equal(t, tt.agentID, agentID, tt.host)
+	}
+}
+
+func TestVirtualHostRouter_APIHosts(t *testing.T) {
+	r := NewVirtualHostRouter("example.com", fakeDomains{"admin.customer.io": "agent-2"}, grpcserver.NewServer(0, nil))
+	r.SetAPIHosts([]string{"API.example.com", "admin.customer.io"})
+
+	_, ok := r.Resolve("api.example.com:8080")
+	assert.False(t, ok)
+	_, ok = r.Resolve("admin.customer.io")
+	assert.False(t, ok)
+	agentID, ok := r.Resolve("agent-1.example.com")
+	assert.True(t, ok)
+	assert.Equal(t, "agent-1", agentID)
+
+	// Requests for the API host reach the API on a shared port
+	h := r.Handler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}))
+	req := httptest.NewRequest("GET", "http://api.example.com/health", nil)
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusTeapot, rr.Code)
+}
+
+func TestVirtualHostRouter_WithoutBaseDomain(t *testing.T) {
+	r := NewVirtualHostRouter("", fakeDomains{"app.customer.io": "agent-2"}, grpcserver.NewServer(0, nil))
+
+	_, ok := r.Resolve("agent-1.tunnel.example.com")
+	assert.False(t, ok)
+
+	agentID, ok := r.Resolve("app.customer.io")
+	assert.True(t, ok)
+	assert.Equal(t, "agent-2", agentID)
+}
+
+func TestVirtualHostRouter_Handler(t *testing.T) {
+	r := NewVirtualHostRouter("tunnel.example.com", nil, grpcserver.NewServer(0, nil))
+	next := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+	h := r.Handler(next)
+
+	// Hosts that route to an agent are forwarded; this one is not connected
+	req := httptest.NewRequest("GET", "http://agent-1.tunnel.example.com/health", nil)
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+	assert.Contains(t, rr.Body.String(), "agent not found")
+
+	// Everything else reaches the API
+	req = httptest.NewRequest("GET", "http://api.example.com/health", nil)
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusTeapot, rr.Code)
+}
+
+func TestVirtualHostRouter_UnknownHost(t *testing.T) {
+	r := NewVirtualHostRouter("tunnel.example.com", nil, grpcserver.NewServer(0, nil))
+
+	req := httptest.NewRequest("GET", "http://api.example.com/", nil)
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+	assert.Contains(t, rr.Body.String(), "unknown host")
+}
+
+func TestVirtualHostRouter_AccessPolicy(t *testing.T) {
+	r := NewVirtualHostRouter("tunnel.example.com", nil, grpcserver.NewServer(0, nil))
+	r.SetAccessPolicies(fakeAuthorizer{
+		"agent-1": access.ErrUnauthorized,
+		"agent-2": access.ErrForbidden,
+	})
+
+	req := httptest.NewRequest("GET", "http://agent-1.tunnel.example.com/", nil)
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	assert.Equal(t, `Basic realm="agent-1"`, rr.Header().Get("WWW-Authenticate"))
+
+	req = httptest.NewRequest("GET", "http://agent-2.tunnel.example.com/", nil)
+	rr = httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+	assert.Empty(t, rr.Header().Get("WWW-Authenticate"))
+}
+
+func TestVirtualHostRouter_RateLimit(t *testing.T) {
+	r := NewVirtualHostRouter("tunnel.example.com", nil, grpcserver.NewServer(0, nil))
+	r.SetRateLimits(ratelimit.NewAgentLimiter(ratelimit.Limits{
+		PerAgent: ratelimit.Config{RequestsPerSecond: 0.25, Burst: 1},
+	}))
+
+	// The first request is admitted and fails because the agent is offline
+	req := httptest.NewRequest("GET", "http://agent-1.tunnel.example.com/", nil)
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+
+	rr = httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusTooManyRequests, rr.Code)
+	retryAfter, err := time.ParseDuration(rr.Header().Get("Retry-After") + "s")
+	assert.NoError(t, err)
+	assert.InDelta(t, 4, retryAfter.Seconds(), 1)
+
+	// Other agents have their own budget
+	req = httptest.NewRequest("GET", "http://agent-2.tunnel.example.com/", nil)
+	rr = httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
@@ -14,6 +14,11 @@ type Claims struct {
 	jwt.RegisteredClaims
 }
 
+// ProxySessionCookie carries a user's token for /proxy/:agent_id, so that a
+// browser can navigate an agent's app without an Authorization header. It is
+// scoped to the agent's prefix.
+const ProxySessionCookie = "silo_proxy_session"
+
 type Config struct {
 	Secret            string `mapstructure:"secret"`
 	ExpirationMinutes int    `mapstructure:"expiration_minutes"`
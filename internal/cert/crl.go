@@ -0,0 +1,63 @@
+package cert
+
+import (
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+	"time"
+)
+
+// CRLValidity is how long a generated CRL stays current; clients should
+// fetch a fresh one before its NextUpdate.
+const CRLValidity = 24 * time.Hour
+
+// LoadAgentCert parses the agent's certificate from AgentCertDir.
+func (s *Service) LoadAgentCert(agentID string) (*x509.Certificate, error) {
+	certBytes, err := os.ReadFile(s.GetAgentCertPath(agentID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read agent certificate: %w", err)
+	}
+
+	block, _ := pem.Decode(certBytes)
+	if block == nil {
+		return nil, fmt.Errorf("failed to decode agent certificate PEM")
+	}
+
+	agentCert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse agent certificate: %w", err)
+	}
+	return agentCert, nil
+}
+
+// CreateCRL returns a DER encoded certificate revocation list listing entries,
+// signed by the CA. CAs generated before CRL support lack the cRLSign key
+// usage and must be regenerated to issue CRLs.
+func (s *Service) CreateCRL(entries []x509.RevocationListEntry) ([]byte, error) {
+	caCert, caKey, err := loadCA(s.CaCertPath, s.CaKeyPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load CA: %w", err)
+	}
+
+	now := time.Now()
+	template := &x509.RevocationList{
+		Number:                    big.NewInt(now.UnixNano()),
+		ThisUpdate:                now,
+		NextUpdate:                now.Add(CRLValidity),
+		RevokedCertificateEntries: entries,
+	}
+
+	crl, err := x509.CreateRevocationList(rand.Reader, template, caCert, caKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create CRL: %w", err)
+	}
+	return crl, nil
+}
+
+// CRLToPEM encodes a DER CRL as PEM.
+func CRLToPEM(crl []byte) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: crl})
+}
@@ -0,0 +1,154 @@
+package cert
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/EternisAI/silo-proxy/internal/metrics"
+)
+
+// ErrInvalidCSR is returned for certificate signing requests that are
+// malformed, badly signed or do not match the agent they are submitted for.
+var ErrInvalidCSR = errors.New("invalid certificate signing request")
+
+const minRSAKeyBits = 2048
+
+// GenerateAgentCSR creates an agent key pair and a PEM encoded PKCS#10 CSR
+// for it with agentID as the subject CommonName. It runs on the agent so the
+// private key never leaves the device.
+func GenerateAgentCSR(agentID string) (*rsa.PrivateKey, []byte, error) {
+	agentKey, err := rsa.GenerateKey(rand.Reader, 4096)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate agent key: %w", err)
+	}
+
+	template := &x509.CertificateRequest{
+		Subject: pkix.Name{
+			Organization: []string{"Silo Proxy"},
+			CommonName:   agentID,
+		},
+	}
+
+	csrBytes, err := x509.CreateCertificateRequest(rand.Reader, template, agentKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create CSR: %w", err)
+	}
+
+	csrPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csrBytes})
+	return agentKey, csrPEM, nil
+}
+
+// ParseAgentCSR decodes a PEM CSR and checks that it is self-signed, carries a
+// strong enough key and names agentID as its subject CommonName.
+func ParseAgentCSR(csrPEM []byte, agentID string) (*x509.CertificateRequest, error) {
+	block, _ := pem.Decode(csrPEM)
+	if block == nil || block.Type != "CERTIFICATE REQUEST" {
+		return nil, fmt.Errorf("%w: expected a PEM encoded CERTIFICATE REQUEST", ErrInvalidCSR)
+	}
+
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCSR, err)
+	}
+	if err := csr.CheckSignature(); err != nil {
+		return nil, fmt.Errorf("%w: bad signature: %v", ErrInvalidCSR, err)
+	}
+
+	switch pub := csr.PublicKey.(type) {
+	case *rsa.PublicKey:
+		if pub.N.BitLen() < minRSAKeyBits {
+			return nil, fmt.Errorf("%w: RSA key must be at least %d bits", ErrInvalidCSR, minRSAKeyBits)
+		}
+	case *ecdsa.PublicKey:
+		if pub.Curve != elliptic.P256() && pub.Curve != elliptic.P384() {
+			return nil, fmt.Errorf("%w: ECDSA key must use P-256 or P-384", ErrInvalidCSR)
+		}
+	default:
+		return nil, fmt.Errorf("%w: unsupported key type %T", ErrInvalidCSR, csr.PublicKey)
+	}
+
+	if csr.Subject.CommonName != agentID {
+		return nil, fmt.Errorf("%w: subject %q does not match agent %q", ErrInvalidCSR, csr.Subject.CommonName, agentID)
+	}
+	return csr, nil
+}
+
+// SignAgentCSRIfNotExists signs csrPEM for agentID unless the agent already
+// has a certificate. Only the certificate is stored; the private key stays
+// with the agent. The bool reports whether a certificate was issued.
+func (s *Service) SignAgentCSRIfNotExists(agentID string, csrPEM []byte) (*x509.Certificate, bool, error) {
+	csr, err := ParseAgentCSR(csrPEM, agentID)
+	if err != nil {
+		return nil, false, err
+	}
+
+	s.agentCertMu.Lock()
+	defer s.agentCertMu.Unlock()
+
+	if s.AgentCertExists(agentID) {
+		return nil, false, nil
+	}
+
+	agentCert, err := s.signAgentCert(agentID, csr.PublicKey)
+	if err != nil {
+		return nil, false, err
+	}
+
+	certPath := s.GetAgentCertPath(agentID)
+	if err := s.ensureDirectory(certPath); err != nil {
+		return nil, false, fmt.Errorf("failed to create agent cert directory: %w", err)
+	}
+	if err := writeCertToFile(agentCert, certPath); err != nil {
+		slog.Error("Failed to write agent certificate", "error", err, "path", certPath)
+		return nil, false, fmt.Errorf("failed to write agent certificate: %w", err)
+	}
+
+	slog.Info("Signed agent CSR", "agent_id", agentID, "cert_path", certPath)
+	metrics.CertificatesIssued.WithLabelValues("csr").Inc()
+	return agentCert, true, nil
+}
+
+// RenewAgentCert signs csrPEM for an agent that already holds a certificate
+// and replaces the stored certificate with the new one. A server-generated key
+// left over from the previous certificate no longer matches and is removed.
+func (s *Service) RenewAgentCert(agentID string, csrPEM []byte) (*x509.Certificate, error) {
+	csr, err := ParseAgentCSR(csrPEM, agentID)
+	if err != nil {
+		return nil, err
+	}
+
+	s.agentCertMu.Lock()
+	defer s.agentCertMu.Unlock()
+
+	agentCert, err := s.signAgentCert(agentID, csr.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	certPath := s.GetAgentCertPath(agentID)
+	if err := s.ensureDirectory(certPath); err != nil {
+		return nil, fmt.Errorf("failed to create agent cert directory: %w", err)
+	}
+	if err := writeCertToFile(agentCert, certPath); err != nil {
+		slog.Error("Failed to write agent certificate", "error", err, "path", certPath)
+		return nil, fmt.Errorf("failed to write agent certificate: %w", err)
+	}
+
+	keyPath := s.GetAgentKeyPath(agentID)
+	if err := os.Remove(keyPath); err != nil && !os.IsNotExist(err) {
+		slog.Warn("Failed to remove superseded agent key", "error", err, "path", keyPath)
+	}
+
+	slog.Info("Renewed agent certificate", "agent_id", agentID, "cert_path", certPath, "not_after", agentCert.NotAfter)
+	metrics.CertificatesIssued.WithLabelValues("renewal").Inc()
+	return agentCert, nil
+}
@@ -10,6 +10,8 @@ import (
 	"math/big"
 	"net"
 	"time"
+
+	"github.com/EternisAI/silo-proxy/internal/metrics"
 )
 
 func (s *Service) GenerateServerCert(caCert *x509.Certificate, caKey *rsa.PrivateKey, domainNames []string, ipAddresses []net.IP) (*x509.Certificate, *rsa.PrivateKey, error) {
@@ -75,7 +77,7 @@ func (s *Service) GenerateCA() (*x509.Certificate, *rsa.PrivateKey, error) {
 		},
 		NotBefore:             time.Now(),
 		NotAfter:              time.Now().Add(10 * 365 * 24 * time.Hour),
-		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
 		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
 		BasicConstraintsValid: true,
 		IsCA:                  true,
@@ -98,43 +100,14 @@ func (s *Service) GenerateCA() (*x509.Certificate, *rsa.PrivateKey, error) {
 func (s *Service) GenerateAgentCert(agentID string) (*x509.Certificate, *rsa.PrivateKey, error) {
 	slog.Info("Generating agent certificate", "agent_id", agentID)
 
-	caCert, caKey, err := loadCA(s.CaCertPath, s.CaKeyPath)
-	if err != nil {
-		slog.Error("Failed to load CA for agent cert generation", "error", err, "agent_id", agentID)
-		return nil, nil, fmt.Errorf("failed to load CA: %w", err)
-	}
-
 	agentKey, err := rsa.GenerateKey(rand.Reader, 4096)
 	if err != nil {
 		return nil, nil, fmt.Errorf("failed to generate agent key: %w", err)
 	}
 
-	serialNumber, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	agentCert, err := s.signAgentCert(agentID, &agentKey.PublicKey)
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to generate serial number: %w", err)
-	}
-
-	agentTemplate := &x509.Certificate{
-		SerialNumber: serialNumber,
-		Subject: pkix.Name{
-			Organization: []string{"Silo Proxy"},
-			CommonName:   agentID,
-		},
-		NotBefore:             time.Now(),
-		NotAfter:              time.Now().Add(365 * 24 * time.Hour),
-		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
-		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
-		BasicConstraintsValid: true,
-	}
-
-	agentCertBytes, err := x509.CreateCertificate(rand.Reader, agentTemplate, caCert, &agentKey.PublicKey, caKey)
-	if err != nil {
-		return nil, nil, fmt.Errorf("failed to create agent certificate: %w", err)
-	}
-
-	agentCert, err := x509.ParseCertificate(agentCertBytes)
-	if err != nil {
-		return nil, nil, fmt.Errorf("failed to parse agent certificate: %w", err)
+		return nil, nil, err
 	}
 
 	certPath := s.GetAgentCertPath(agentID)
@@ -155,6 +128,7 @@ func (s *Service) GenerateAgentCert(agentID string) (*x509.Certificate, *rsa.Pri
 	}
 
 	slog.Info("Generated and saved agent certificate", "agent_id", agentID, "cert_path", certPath, "key_path", keyPath)
+	metrics.CertificatesIssued.WithLabelValues("server_key").Inc()
 	return agentCert, agentKey, nil
 }
 
@@ -173,3 +147,42 @@ func (s *Service) GenerateAgentCertIfNotExists(agentID string) (*x509.Certificat
 
 	return agentCert, agentKey, true, nil
 }
+
+// signAgentCert issues a client certificate for agentID over publicKey, signed
+// by the CA.
+func (s *Service) signAgentCert(agentID string, publicKey any) (*x509.Certificate, error) {
+	caCert, caKey, err := loadCA(s.CaCertPath, s.CaKeyPath)
+	if err != nil {
+		slog.Error("Failed to load CA for agent cert generation", "error", err, "agent_id", agentID)
+		return nil, fmt.Errorf("failed to load CA: %w", err)
+	}
+
+	serialNumber, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate serial number: %w", err)
+	}
+
+	agentTemplate := &x509.Certificate{
+		SerialNumber: serialNumber,
+		Subject: pkix.Name{
+			Organization: []string{"Silo Proxy"},
+			CommonName:   agentID,
+		},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(365 * 24 * time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+	}
+
+	agentCertBytes, err := x509.CreateCertificate(rand.Reader, agentTemplate, caCert, publicKey, caKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create agent certificate: %w", err)
+	}
+
+	agentCert, err := x509.ParseCertificate(agentCertBytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse agent certificate: %w", err)
+	}
+	return agentCert, nil
+}
@@ -171,12 +171,15 @@ func (s *Service) GetAgentKeyPath(agentID string) string {
 	return filepath.Join(s.GetAgentCertDir(agentID), fmt.Sprintf("%s-key.pem", agentID))
 }
 
+// AgentCertExists reports whether a certificate has been issued to the agent.
+// Agents provisioned from a CSR hold their own key, so only the certificate is
+// stored for them.
 func (s *Service) AgentCertExists(agentID string) bool {
-	certPath := s.GetAgentCertPath(agentID)
-	keyPath := s.GetAgentKeyPath(agentID)
-	return fileExists(certPath) && fileExists(keyPath)
+	return fileExists(s.GetAgentCertPath(agentID))
 }
 
+// GetAgentCert reads the agent's certificate and, when the server generated
+// it, its key. keyBytes is nil for agents provisioned from a CSR.
 func (s *Service) GetAgentCert(agentID string) (certBytes, keyBytes []byte, err error) {
 	certPath := s.GetAgentCertPath(agentID)
 	keyPath := s.GetAgentKeyPath(agentID)
@@ -186,6 +189,10 @@ func (s *Service) GetAgentCert(agentID string) (certBytes, keyBytes []byte, err
 		return nil, nil, fmt.Errorf("failed to read agent certificate: %w", err)
 	}
 
+	if !fileExists(keyPath) {
+		return certBytes, nil, nil
+	}
+
 	keyBytes, err = os.ReadFile(keyPath)
 	if err != nil {
 		return nil, nil, fmt.Errorf("failed to read agent key: %w", err)
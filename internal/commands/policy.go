@@ -0,0 +1,91 @@
+// Package commands decides which commands may be sent to agents through the
+// admin API, by whom, and how long they may run.
+package commands
+
+import (
+	"errors"
+	"slices"
+	"time"
+)
+
+// Defaults of Config.DefaultTimeoutSeconds and Config.MaxTimeoutSeconds.
+const (
+	defaultTimeout = 10 * time.Second
+	maxTimeout     = 60 * time.Second
+)
+
+var (
+	// ErrNotAllowed is returned for commands missing from Config.Allowed.
+	ErrNotAllowed = errors.New("command not allowed")
+	// ErrForbidden is returned when the caller's role may not run the
+	// command.
+	ErrForbidden = errors.New("role may not run this command")
+)
+
+// Config lists the commands that may be run on agents. Commands that are not
+// listed are refused, whatever the agent supports.
+type Config struct {
+	DefaultTimeoutSeconds int             `mapstructure:"default_timeout_seconds"`
+	MaxTimeoutSeconds     int             `mapstructure:"max_timeout_seconds"`
+	Allowed               []CommandConfig `mapstructure:"allowed"`
+}
+
+// CommandConfig allows one command. The admin API key may run every allowed
+// command; signed-in users only those whose Roles include their role.
+// TimeoutSeconds replaces the default timeout for the command.
+type CommandConfig struct {
+	Name           string   `mapstructure:"name"`
+	TimeoutSeconds int      `mapstructure:"timeout_seconds"`
+	Roles          []string `mapstructure:"roles"`
+}
+
+// Policy applies a Config.
+type Policy struct {
+	defaultTimeout time.Duration
+	maxTimeout     time.Duration
+	commands       map[string]CommandConfig
+}
+
+func NewPolicy(config Config) *Policy {
+	p := &Policy{
+		defaultTimeout: defaultTimeout,
+		maxTimeout:     maxTimeout,
+		commands:       make(map[string]CommandConfig, len(config.Allowed)),
+	}
+	if config.DefaultTimeoutSeconds > 0 {
+		p.defaultTimeout = seconds(config.DefaultTimeoutSeconds)
+	}
+	if config.MaxTimeoutSeconds > 0 {
+		p.maxTimeout = seconds(config.MaxTimeoutSeconds)
+	}
+	for _, command := range config.Allowed {
+		p.commands[command.Name] = command
+	}
+	return p
+}
+
+// Authorize checks that the caller may run the command called name and
+// returns the timeout to run it with. role is the caller's user role, or
+// empty for the admin API key. requested is the timeout the caller asked
+// for, capped at the maximum; zero uses the command's timeout.
+func (p *Policy) Authorize(name, role string, requested time.Duration) (time.Duration, error) {
+	command, ok := p.commands[name]
+	if !ok {
+		return 0, ErrNotAllowed
+	}
+	if role != "" && !slices.Contains(command.Roles, role) {
+		return 0, ErrForbidden
+	}
+
+	if requested > 0 {
+		return min(requested, p.maxTimeout), nil
+	}
+	if command.TimeoutSeconds > 0 {
+		return min(seconds(command.TimeoutSeconds), p.maxTimeout), nil
+	}
+	return min(p.defaultTimeout, p.maxTimeout), nil
+}
+
+func seconds(n int) time.Duration {
+	return time.Duration(n) * time.Second
+}
@@ -0,0 +1,70 @@
+package commands
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testPolicy() *Policy {
+	return NewPolicy(Config{
+		DefaultTimeoutSeconds: 5,
+		MaxTimeoutSeconds:     30,
+		Allowed: []CommandConfig{
+			{Name: "status", Roles: []string{"Admin", "User"}},
+			{Name: "health_check", TimeoutSeconds: 20, Roles: []string{"Admin"}},
+			{Name: "config"},
+		},
+	})
+}
+
+func TestAuthorize_Roles(t *testing.T) {
+	p := testPolicy()
+
+	_, err := p.Authorize("status", "User", 0)
+	assert.NoError(t, err)
+	_, err = p.Authorize("health_check", "User", 0)
+	assert.ErrorIs(t, err, ErrForbidden)
+	_, err = p.Authorize("config", "Admin", 0)
+	assert.ErrorIs(t, err, ErrForbidden)
+
+	// The admin API key may run every allowed command
+	_, err = p.Authorize("config", "", 0)
+	assert.NoError(t, err)
+	_, err = p.Authorize("flush_logs", "", 0)
+	assert.ErrorIs(t, err, ErrNotAllowed)
+}
+
+func TestAuthorize_Timeouts(t *testing.T) {
+	p := testPolicy()
+
+	timeout, err := p.Authorize("status", "", 0)
+	require.NoError(t, err)
+	assert.Equal(t, 5*time.Second, timeout)
+
+	timeout, err = p.Authorize("health_check", "", 0)
+	require.NoError(t, err)
+	assert.Equal(t, 20*time.Second, timeout)
+
+	timeout, err = p.Authorize("status", "", 2*time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, 2*time.Second, timeout)
+
+	timeout, err = p.Authorize("status", "", time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, 30*time.Second, timeout)
+}
+
+func TestNewPolicy_Defaults(t *testing.T) {
+	p := NewPolicy(Config{Allowed: []CommandConfig{{Name: "status"}}})
+
+	timeout, err := p.Authorize("status", "", 0)
+	require.NoError(t, err)
+	assert.Equal(t, defaultTimeout, timeout)
+
+	timeout, err = p.Authorize("status", "", time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, maxTimeout, timeout)
+}
@@ -0,0 +1,18 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS revoked_certificates (
+    serial_number VARCHAR(64) PRIMARY KEY,
+    agent_id VARCHAR(255) NOT NULL,
+    reason VARCHAR(64) NOT NULL DEFAULT 'unspecified',
+    revoked_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_revoked_certificates_agent_id ON revoked_certificates(agent_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS idx_revoked_certificates_agent_id;
+DROP TABLE IF EXISTS revoked_certificates;
+-- +goose StatementEnd
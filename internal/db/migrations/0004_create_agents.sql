@@ -0,0 +1,33 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS agents (
+    id VARCHAR(255) PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    last_connected_at TIMESTAMP,
+    last_disconnected_at TIMESTAMP,
+    last_remote_addr VARCHAR(255),
+    port INTEGER,
+    cert_serial VARCHAR(64),
+    labels JSONB NOT NULL DEFAULT '{}'
+);
+
+CREATE TABLE IF NOT EXISTS agent_sessions (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    agent_id VARCHAR(255) NOT NULL REFERENCES agents(id) ON DELETE CASCADE,
+    remote_addr VARCHAR(255),
+    port INTEGER,
+    cert_serial VARCHAR(64),
+    connected_at TIMESTAMP NOT NULL,
+    disconnected_at TIMESTAMP,
+    disconnect_reason VARCHAR(32)
+);
+
+CREATE INDEX IF NOT EXISTS idx_agent_sessions_agent_id_connected_at ON agent_sessions(agent_id, connected_at DESC);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS idx_agent_sessions_agent_id_connected_at;
+DROP TABLE IF EXISTS agent_sessions;
+DROP TABLE IF EXISTS agents;
+-- +goose StatementEnd
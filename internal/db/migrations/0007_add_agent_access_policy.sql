@@ -0,0 +1,9 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE agents ADD COLUMN IF NOT EXISTS access_policy JSONB NOT NULL DEFAULT '{}';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE agents DROP COLUMN IF EXISTS access_policy;
+-- +goose StatementEnd
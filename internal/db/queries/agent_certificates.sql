@@ -0,0 +1,17 @@
+-- name: CreateAgentCertificate :exec
+INSERT INTO agent_certificates (serial_number, agent_id, replaces_serial, expires_at)
+VALUES ($1, $2, $3, $4)
+ON CONFLICT (serial_number) DO NOTHING;
+
+-- name: GetAgentCertificate :one
+SELECT * FROM agent_certificates
+WHERE serial_number = $1 LIMIT 1;
+
+-- name: RetireAgentCertificate :execrows
+UPDATE agent_certificates SET retired_at = NOW()
+WHERE serial_number = $1 AND retired_at IS NULL;
+
+-- name: ListActiveAgentCertificates :many
+SELECT * FROM agent_certificates
+WHERE agent_id = $1 AND retired_at IS NULL
+ORDER BY issued_at;
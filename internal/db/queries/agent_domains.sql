@@ -0,0 +1,13 @@
+-- name: ListAgentDomains :many
+SELECT * FROM agent_domains
+ORDER BY domain;
+
+-- name: CreateAgentDomain :execrows
+INSERT INTO agent_domains (domain, agent_id)
+VALUES ($1, $2)
+ON CONFLICT (domain) DO UPDATE SET agent_id = agent_domains.agent_id
+WHERE agent_domains.agent_id = EXCLUDED.agent_id;
+
+-- name: DeleteAgentDomain :execrows
+DELETE FROM agent_domains
+WHERE domain = $1 AND agent_id = $2;
@@ -0,0 +1,27 @@
+-- name: CreateAgentSession :one
+INSERT INTO agent_sessions (agent_id, remote_addr, port, cert_serial, connected_at)
+VALUES ($1, $2, $3, $4, $5)
+RETURNING id;
+
+-- name: EndAgentSession :exec
+UPDATE agent_sessions SET disconnected_at = $2, disconnect_reason = $3
+WHERE id = $1 AND disconnected_at IS NULL;
+
+-- name: ListAgentSessions :many
+SELECT * FROM agent_sessions
+WHERE agent_id = $1
+ORDER BY connected_at DESC
+LIMIT $2 OFFSET $3;
+
+-- name: CountAgentSessions :one
+SELECT count(*) FROM agent_sessions
+WHERE agent_id = $1;
+
+-- name: CloseOpenAgentSessions :execrows
+WITH closed AS (
+    UPDATE agent_sessions SET disconnected_at = $1, disconnect_reason = $2
+    WHERE disconnected_at IS NULL
+    RETURNING agent_id
+)
+UPDATE agents SET last_disconnected_at = $1
+WHERE id IN (SELECT agent_id FROM closed);
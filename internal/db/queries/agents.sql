@@ -0,0 +1,34 @@
+-- name: UpsertConnectedAgent :exec
+INSERT INTO agents (id, last_connected_at, last_remote_addr, port, cert_serial)
+VALUES ($1, $2, $3, $4, $5)
+ON CONFLICT (id) DO UPDATE SET
+    last_connected_at = EXCLUDED.last_connected_at,
+    last_remote_addr = EXCLUDED.last_remote_addr,
+    port = EXCLUDED.port,
+    cert_serial = EXCLUDED.cert_serial;
+
+-- name: SetAgentDisconnected :exec
+UPDATE agents SET last_disconnected_at = $2
+WHERE id = $1;
+
+-- name: GetAgent :one
+SELECT * FROM agents
+WHERE id = $1 LIMIT 1;
+
+-- name: ListAgents :many
+SELECT * FROM agents ORDER BY id;
+
+-- name: UpdateAgentLabels :execrows
+UPDATE agents SET labels = $2
+WHERE id = $1;
+
+-- name: ListAgentAccessPolicies :many
+SELECT id, access_policy FROM agents
+WHERE access_policy <> '{}'
+ORDER BY id;
+
+-- name: SetAgentAccessPolicy :exec
+INSERT INTO agents (id, access_policy)
+VALUES ($1, $2)
+ON CONFLICT (id) DO UPDATE SET
+    access_policy = EXCLUDED.access_policy;
@@ -0,0 +1,16 @@
+-- name: ListPortReservations :many
+SELECT * FROM port_reservations
+WHERE pool = $1
+ORDER BY port;
+
+-- name: UpsertPortReservation :exec
+INSERT INTO port_reservations (pool, owner, port, pinned)
+VALUES ($1, $2, $3, $4)
+ON CONFLICT (pool, owner) DO UPDATE SET
+    port = EXCLUDED.port,
+    pinned = EXCLUDED.pinned,
+    updated_at = NOW();
+
+-- name: DeletePortReservation :exec
+DELETE FROM port_reservations
+WHERE pool = $1 AND owner = $2;
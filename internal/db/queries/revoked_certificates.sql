@@ -0,0 +1,7 @@
+-- name: RevokeCertificate :exec
+INSERT INTO revoked_certificates (serial_number, agent_id, reason, expires_at)
+VALUES ($1, $2, $3, $4)
+ON CONFLICT (serial_number) DO NOTHING;
+
+-- name: ListRevokedCertificates :many
+SELECT * FROM revoked_certificates ORDER BY revoked_at;
@@ -0,0 +1,32 @@
+package db
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// Loader replaces state mirrored in memory with the state in the database.
+type Loader interface {
+	Load(ctx context.Context) error
+}
+
+// Refresh calls loader.Load every interval until ctx is cancelled, so that the
+// state it mirrors picks up changes made through other server instances.
+// Failures are logged with name, what is loaded, and retried at the next
+// interval.
+func Refresh(ctx context.Context, interval time.Duration, name string, loader Loader) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := loader.Load(ctx); err != nil {
+				slog.Error("Failed to refresh from the database", "name", name, "error", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type countingLoader struct {
+	loads atomic.Int32
+}
+
+func (l *countingLoader) Load(ctx context.Context) error {
+	l.loads.Add(1)
+	return errors.New("database unavailable")
+}
+
+func TestRefresh(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	loader := &countingLoader{}
+
+	done := make(chan struct{})
+	go func() {
+		Refresh(ctx, 5*time.Millisecond, "test", loader)
+		close(done)
+	}()
+
+	// Failed loads are retried at the next interval
+	assert.Eventually(t, func() bool { return loader.loads.Load() >= 2 }, time.Second, time.Millisecond)
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Refresh did not return after ctx was cancelled")
+	}
+}
@@ -53,6 +53,61 @@ func (ns NullUserRole) Value() (driver.Value, error) {
 	return string(ns.UserRole), nil
 }
 
+type Agent struct {
+	ID                 string           `json:"id"`
+	CreatedAt          pgtype.Timestamp `json:"created_at"`
+	LastConnectedAt    pgtype.Timestamp `json:"last_connected_at"`
+	LastDisconnectedAt pgtype.Timestamp `json:"last_disconnected_at"`
+	LastRemoteAddr     pgtype.Text      `json:"last_remote_addr"`
+	Port               pgtype.Int4      `json:"port"`
+	CertSerial         pgtype.Text      `json:"cert_serial"`
+	Labels             []byte           `json:"labels"`
+	AccessPolicy       []byte           `json:"access_policy"`
+}
+
+type AgentCertificate struct {
+	SerialNumber   string           `json:"serial_number"`
+	AgentID        string           `json:"agent_id"`
+	ReplacesSerial pgtype.Text      `json:"replaces_serial"`
+	IssuedAt       pgtype.Timestamp `json:"issued_at"`
+	ExpiresAt      pgtype.Timestamp `json:"expires_at"`
+	RetiredAt      pgtype.Timestamp `json:"retired_at"`
+}
+
+type AgentDomain struct {
+	Domain    string           `json:"domain"`
+	AgentID   string           `json:"agent_id"`
+	CreatedAt pgtype.Timestamp `json:"created_at"`
+}
+
+type AgentSession struct {
+	ID               pgtype.UUID      `json:"id"`
+	AgentID          string           `json:"agent_id"`
+	RemoteAddr       pgtype.Text      `json:"remote_addr"`
+	Port             pgtype.Int4      `json:"port"`
+	CertSerial       pgtype.Text      `json:"cert_serial"`
+	ConnectedAt      pgtype.Timestamp `json:"connected_at"`
+	DisconnectedAt   pgtype.Timestamp `json:"disconnected_at"`
+	DisconnectReason pgtype.Text      `json:"disconnect_reason"`
+}
+
+type PortReservation struct {
+	Pool      string           `json:"pool"`
+	Owner     string           `json:"owner"`
+	Port      int32            `json:"port"`
+	Pinned    bool             `json:"pinned"`
+	CreatedAt pgtype.Timestamp `json:"created_at"`
+	UpdatedAt pgtype.Timestamp `json:"updated_at"`
+}
+
+type RevokedCertificate struct {
+	SerialNumber string           `json:"serial_number"`
+	AgentID      string           `json:"agent_id"`
+	Reason       string           `json:"reason"`
+	RevokedAt    pgtype.Timestamp `json:"revoked_at"`
+	ExpiresAt    pgtype.Timestamp `json:"expires_at"`
+}
+
 type User struct {
 	ID           pgtype.UUID      `json:"id"`
 	Username     string           `json:"username"`
@@ -11,12 +11,36 @@ import (
 )
 
 type Querier interface {
+	CloseOpenAgentSessions(ctx context.Context, arg CloseOpenAgentSessionsParams) (int64, error)
+	CountAgentSessions(ctx context.Context, agentID string) (int64, error)
 	CountUsers(ctx context.Context) (int64, error)
+	CreateAgentCertificate(ctx context.Context, arg CreateAgentCertificateParams) error
+	CreateAgentDomain(ctx context.Context, arg CreateAgentDomainParams) (int64, error)
+	CreateAgentSession(ctx context.Context, arg CreateAgentSessionParams) (pgtype.UUID, error)
 	CreateUser(ctx context.Context, arg CreateUserParams) (User, error)
+	DeleteAgentDomain(ctx context.Context, arg DeleteAgentDomainParams) (int64, error)
+	DeletePortReservation(ctx context.Context, arg DeletePortReservationParams) error
 	DeleteUser(ctx context.Context, id pgtype.UUID) error
+	EndAgentSession(ctx context.Context, arg EndAgentSessionParams) error
+	GetAgent(ctx context.Context, id string) (Agent, error)
+	GetAgentCertificate(ctx context.Context, serialNumber string) (AgentCertificate, error)
 	GetUser(ctx context.Context, id pgtype.UUID) (User, error)
 	GetUserByUsername(ctx context.Context, username string) (User, error)
+	ListActiveAgentCertificates(ctx context.Context, agentID string) ([]AgentCertificate, error)
+	ListAgentAccessPolicies(ctx context.Context) ([]ListAgentAccessPoliciesRow, error)
+	ListAgentDomains(ctx context.Context) ([]AgentDomain, error)
+	ListAgentSessions(ctx context.Context, arg ListAgentSessionsParams) ([]AgentSession, error)
+	ListAgents(ctx context.Context) ([]Agent, error)
+	ListPortReservations(ctx context.Context, pool string) ([]PortReservation, error)
+	ListRevokedCertificates(ctx context.Context) ([]RevokedCertificate, error)
 	ListUsersPaginated(ctx context.Context, arg ListUsersPaginatedParams) ([]User, error)
+	RetireAgentCertificate(ctx context.Context, serialNumber string) (int64, error)
+	RevokeCertificate(ctx context.Context, arg RevokeCertificateParams) error
+	SetAgentAccessPolicy(ctx context.Context, arg SetAgentAccessPolicyParams) error
+	SetAgentDisconnected(ctx context.Context, arg SetAgentDisconnectedParams) error
+	UpdateAgentLabels(ctx context.Context, arg UpdateAgentLabelsParams) (int64, error)
+	UpsertConnectedAgent(ctx context.Context, arg UpsertConnectedAgentParams) error
+	UpsertPortReservation(ctx context.Context, arg UpsertPortReservationParams) error
 }
 
 var _ Querier = (*Queries)(nil)
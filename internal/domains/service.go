@@ -0,0 +1,193 @@
+package domains
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/EternisAI/silo-proxy/internal/db/sqlc"
+)
+
+var (
+	ErrInvalidDomain  = errors.New("invalid domain")
+	ErrDomainTaken    = errors.New("domain is mapped to another agent")
+	ErrDomainNotFound = errors.New("domain not found")
+)
+
+// Normalize returns host in the form domains are stored and looked up in:
+// lower case, without a port or trailing dot.
+func Normalize(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
+
+// Validate checks that domain is a normalized DNS name with at least two
+// labels.
+func Validate(domain string) error {
+	if len(domain) > 253 {
+		return fmt.Errorf("%w: longer than 253 characters", ErrInvalidDomain)
+	}
+
+	labels := strings.Split(domain, ".")
+	if len(labels) < 2 {
+		return fmt.Errorf("%w: %q must have at least two labels", ErrInvalidDomain, domain)
+	}
+	for _, label := range labels {
+		if !validLabel(label) {
+			return fmt.Errorf("%w: %q", ErrInvalidDomain, domain)
+		}
+	}
+	return nil
+}
+
+func validLabel(label string) bool {
+	if len(label) == 0 || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+		return false
+	}
+	for _, r := range label {
+		if (r < 'a' || r > 'z') && (r < '0' || r > '9') && r != '-' {
+			return false
+		}
+	}
+	return true
+}
+
+// Service maps custom domains to agents. Mappings are persisted in Postgres
+// and mirrored in memory so that requests can be routed without a database
+// round trip.
+type Service struct {
+	queries    *sqlc.Queries
+	baseDomain string
+	apiHosts   []string
+
+	mu     sync.RWMutex
+	agents map[string]string // domain -> agent ID
+}
+
+func NewService(queries *sqlc.Queries) *Service {
+	return &Service{
+		queries: queries,
+		agents:  make(map[string]string),
+	}
+}
+
+// SetBaseDomain rejects custom domains at or below base, whose subdomains
+// already name agents.
+func (s *Service) SetBaseDomain(base string) {
+	s.baseDomain = Normalize(base)
+}
+
+// SetAPIHosts rejects custom domains that are one of hosts, the host names
+// the API is served on.
+func (s *Service) SetAPIHosts(hosts []string) {
+	s.apiHosts = make([]string, len(hosts))
+	for i, host := range hosts {
+		s.apiHosts[i] = Normalize(host)
+	}
+}
+
+// Load replaces the in-memory domain mappings with the ones in the database.
+func (s *Service) Load(ctx context.Context) error {
+	rows, err := s.queries.ListAgentDomains(ctx)
+	if err != nil {
+		return fmt.Errorf("list agent domains: %w", err)
+	}
+
+	agents := make(map[string]string, len(rows))
+	for _, row := range rows {
+		agents[row.Domain] = row.AgentID
+	}
+
+	s.mu.Lock()
+	s.agents = agents
+	s.mu.Unlock()
+	return nil
+}
+
+// Add maps domain to agentID. Adding a domain the agent already has is a
+// no-op.
+func (s *Service) Add(ctx context.Context, agentID, domain string) error {
+	domain = Normalize(domain)
+	if err := Validate(domain); err != nil {
+		return err
+	}
+	if s.baseDomain != "" && (domain == s.baseDomain || strings.HasSuffix(domain, "."+s.baseDomain)) {
+		return fmt.Errorf("%w: %q is under the base domain %s", ErrInvalidDomain, domain, s.baseDomain)
+	}
+	if slices.Contains(s.apiHosts, domain) {
+		return fmt.Errorf("%w: %q is an API host", ErrInvalidDomain, domain)
+	}
+
+	created, err := s.queries.CreateAgentDomain(ctx, sqlc.CreateAgentDomainParams{
+		Domain:  domain,
+		AgentID: agentID,
+	})
+	if err != nil {
+		return fmt.Errorf("create agent domain: %w", err)
+	}
+	if created == 0 {
+		return ErrDomainTaken
+	}
+
+	s.mu.Lock()
+	s.agents[domain] = agentID
+	s.mu.Unlock()
+
+	slog.Info("Agent domain added", "agent_id", agentID, "domain", domain)
+	return nil
+}
+
+// Remove unmaps domain from agentID.
+func (s *Service) Remove(ctx context.Context, agentID, domain string) error {
+	domain = Normalize(domain)
+
+	deleted, err := s.queries.DeleteAgentDomain(ctx, sqlc.DeleteAgentDomainParams{
+		Domain:  domain,
+		AgentID: agentID,
+	})
+	if err != nil {
+		return fmt.Errorf("delete agent domain: %w", err)
+	}
+	if deleted == 0 {
+		return ErrDomainNotFound
+	}
+
+	s.mu.Lock()
+	delete(s.agents, domain)
+	s.mu.Unlock()
+
+	slog.Info("Agent domain removed", "agent_id", agentID, "domain", domain)
+	return nil
+}
+
+// List returns the domains mapped to agentID in alphabetical order.
+func (s *Service) List(agentID string) []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	result := []string{}
+	for domain, owner := range s.agents {
+		if owner == agentID {
+			result = append(result, domain)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+// Lookup returns the agent a normalized domain is mapped to.
+func (s *Service) Lookup(domain string) (string, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	agentID, ok := s.agents[domain]
+	return agentID, ok
+}
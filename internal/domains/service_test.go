@@ -0,0 +1,63 @@
+package domains
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalize(t *testing.T) {
+	assert.Equal(t, "app.example.com", Normalize("App.Example.COM"))
+	assert.Equal(t, "app.example.com", Normalize("app.example.com:8080"))
+	assert.Equal(t, "app.example.com", Normalize("app.example.com."))
+}
+
+func TestValidate(t *testing.T) {
+	assert.NoError(t, Validate("app.example.com"))
+	assert.NoError(t, Validate("my-app.example.io"))
+
+	for _, domain := range []string{
+		"localhost",
+		"app..example.com",
+		"-app.example.com",
+		"app-.example.com",
+		"app_1.example.com",
+		"App.example.com",
+		"*.example.com",
+	} {
+		assert.ErrorIs(t, Validate(domain), ErrInvalidDomain, domain)
+	}
+}
+
+func TestAddRejectsBaseDomain(t *testing.T) {
+	s := NewService(nil)
+	s.SetBaseDomain("Tunnel.Example.com")
+
+	assert.ErrorIs(t, s.Add(context.Background(), "agent-1", "tunnel.example.com"), ErrInvalidDomain)
+	assert.ErrorIs(t, s.Add(context.Background(), "agent-1", "agent-2.tunnel.example.com"), ErrInvalidDomain)
+}
+
+func TestAddRejectsAPIHosts(t *testing.T) {
+	s := NewService(nil)
+	s.SetAPIHosts([]string{"API.Example.com"})
+
+	assert.ErrorIs(t, s.Add(context.Background(), "agent-1", "api.example.com"), ErrInvalidDomain)
+}
+
+func TestLookupAndList(t *testing.T) {
+	s := NewService(nil)
+	s.agents["b.example.com"] = "agent-1"
+	s.agents["a.example.com"] = "agent-1"
+	s.agents["c.example.com"] = "agent-2"
+
+	agentID, ok := s.Lookup("c.example.com")
+	assert.True(t, ok)
+	assert.Equal(t, "agent-2", agentID)
+
+	_, ok = s.Lookup("d.example.com")
+	assert.False(t, ok)
+
+	assert.Equal(t, []string{"a.example.com", "b.example.com"}, s.List("agent-1"))
+	assert.Empty(t, s.List("agent-3"))
+}
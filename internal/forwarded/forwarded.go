@@ -0,0 +1,172 @@
+// Package forwarded sets the X-Forwarded-For, X-Forwarded-Proto,
+// X-Forwarded-Host and Forwarded (RFC 7239) headers that tell a local service
+// where a proxied request came from.
+package forwarded
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// TrustedProxies are the addresses, such as load balancers in front of the
+// server, whose forwarding headers are extended rather than replaced. A nil
+// *TrustedProxies trusts nobody.
+type TrustedProxies struct {
+	networks []netip.Prefix
+}
+
+// ParseTrustedProxies parses a list of IP addresses and CIDR ranges.
+func ParseTrustedProxies(entries []string) (*TrustedProxies, error) {
+	p := &TrustedProxies{}
+	for _, entry := range entries {
+		prefix, err := parsePrefix(strings.TrimSpace(entry))
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %w", entry, err)
+		}
+		p.networks = append(p.networks, prefix)
+	}
+	return p, nil
+}
+
+func parsePrefix(s string) (netip.Prefix, error) {
+	if strings.Contains(s, "/") {
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+		return prefix.Masked(), nil
+	}
+
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// Trusts reports whether addr is a trusted proxy.
+func (p *TrustedProxies) Trusts(addr netip.Addr) bool {
+	if p == nil || !addr.IsValid() {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, network := range p.networks {
+		if network.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientAddr returns the address r came from: the peer address, or, if the
+// peer is a trusted proxy, the nearest address in its X-Forwarded-For chain
+// that is not one. The chain is read right to left and only as far as
+// trusted proxies added to it, so that a client cannot pose as another
+// address by sending the header itself.
+func (p *TrustedProxies) ClientAddr(r *http.Request) netip.Addr {
+	client := peerAddr(r.RemoteAddr)
+
+	var chain []string
+	for _, value := range r.Header.Values("X-Forwarded-For") {
+		chain = append(chain, strings.Split(value, ",")...)
+	}
+	for i := len(chain) - 1; i >= 0 && p.Trusts(client); i-- {
+		addr, err := netip.ParseAddr(strings.TrimSpace(chain[i]))
+		if err != nil {
+			break
+		}
+		client = addr.Unmap()
+	}
+	return client
+}
+
+// SetHeaders sets the forwarding headers of h, the headers to forward for r.
+// When r comes from a trusted proxy, the proxy's own forwarding headers are
+// kept and the proxy is appended to the chain; otherwise any forwarding
+// headers sent by the client are dropped, so that it cannot pose as another
+// address.
+func (p *TrustedProxies) SetHeaders(h http.Header, r *http.Request) {
+	peer := peerAddr(r.RemoteAddr)
+
+	proto := "http"
+	if r.TLS != nil {
+		proto = "https"
+	}
+
+	if !p.Trusts(peer) {
+		h.Del("X-Forwarded-For")
+		h.Del("X-Forwarded-Proto")
+		h.Del("X-Forwarded-Host")
+		h.Del("X-Forwarded-Prefix")
+		h.Del("Forwarded")
+	}
+
+	forwardedFor := peer.String()
+	if !peer.IsValid() {
+		forwardedFor = "unknown"
+	}
+	if prior := h.Values("X-Forwarded-For"); len(prior) > 0 {
+		h.Set("X-Forwarded-For", strings.Join(prior, ", ")+", "+forwardedFor)
+	} else {
+		h.Set("X-Forwarded-For", forwardedFor)
+	}
+	if h.Get("X-Forwarded-Proto") == "" {
+		h.Set("X-Forwarded-Proto", proto)
+	}
+	if h.Get("X-Forwarded-Host") == "" {
+		h.Set("X-Forwarded-Host", r.Host)
+	}
+
+	element := fmt.Sprintf("for=%s;host=%s;proto=%s", forwardedNode(peer), quote(r.Host), proto)
+	if prior := h.Values("Forwarded"); len(prior) > 0 {
+		h.Set("Forwarded", strings.Join(prior, ", ")+", "+element)
+	} else {
+		h.Set("Forwarded", element)
+	}
+}
+
+func peerAddr(remoteAddr string) netip.Addr {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}
+	}
+	return addr.Unmap()
+}
+
+// forwardedNode formats addr as the node of a Forwarded "for" parameter, in
+// which IPv6 addresses are bracketed and quoted.
+func forwardedNode(addr netip.Addr) string {
+	switch {
+	case !addr.IsValid():
+		return "unknown"
+	case addr.Is6():
+		return `"[` + addr.String() + `]"`
+	default:
+		return addr.String()
+	}
+}
+
+// quote returns value as a Forwarded parameter value: a token as is,
+// anything else as a quoted string.
+func quote(value string) string {
+	if value != "" && strings.IndexFunc(value, func(r rune) bool { return !isTokenChar(r) }) < 0 {
+		return value
+	}
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(value) + `"`
+}
+
+func isTokenChar(r rune) bool {
+	switch {
+	case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		return true
+	default:
+		return strings.ContainsRune("!#$%&'*+-.^_`|~", r)
+	}
+}
@@ -0,0 +1,116 @@
+package forwarded
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTrustedProxies(t *testing.T) {
+	p, err := ParseTrustedProxies([]string{"10.0.0.0/8", "192.168.1.5", "::1"})
+	require.NoError(t, err)
+
+	assert.True(t, p.Trusts(netip.MustParseAddr("10.1.2.3")))
+	assert.True(t, p.Trusts(netip.MustParseAddr("::ffff:10.1.2.3")))
+	assert.True(t, p.Trusts(netip.MustParseAddr("192.168.1.5")))
+	assert.True(t, p.Trusts(netip.MustParseAddr("::1")))
+	assert.False(t, p.Trusts(netip.MustParseAddr("192.168.1.6")))
+
+	_, err = ParseTrustedProxies([]string{"not-an-ip"})
+	assert.Error(t, err)
+
+	var none *TrustedProxies
+	assert.False(t, none.Trusts(netip.MustParseAddr("10.1.2.3")))
+}
+
+func TestSetHeaders_UntrustedPeer(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "http://app.example.com/", nil)
+	r.RemoteAddr = "203.0.113.7:5000"
+	r.Header.Set("X-Forwarded-For", "1.2.3.4")
+	r.Header.Set("X-Forwarded-Host", "evil.example.com")
+	r.Header.Set("X-Forwarded-Prefix", "/evil")
+	r.Header.Set("Forwarded", "for=1.2.3.4")
+
+	var p *TrustedProxies
+	h := r.Header.Clone()
+	p.SetHeaders(h, r)
+
+	assert.Equal(t, "203.0.113.7", h.Get("X-Forwarded-For"))
+	assert.Equal(t, "http", h.Get("X-Forwarded-Proto"))
+	assert.Equal(t, "app.example.com", h.Get("X-Forwarded-Host"))
+	assert.Empty(t, h.Get("X-Forwarded-Prefix"))
+	assert.Equal(t, "for=203.0.113.7;host=app.example.com;proto=http", h.Get("Forwarded"))
+}
+
+func TestSetHeaders_TrustedPeerExtendsChain(t *testing.T) {
+	p, err := ParseTrustedProxies([]string{"10.0.0.0/8"})
+	require.NoError(t, err)
+
+	r := httptest.NewRequest(http.MethodGet, "http://app.example.com:8080/", nil)
+	r.RemoteAddr = "10.0.0.2:5000"
+	r.Header.Add("X-Forwarded-For", "198.51.100.1")
+	r.Header.Add("X-Forwarded-For", "10.0.0.9")
+	r.Header.Set("X-Forwarded-Proto", "https")
+	r.Header.Set("X-Forwarded-Host", "app.example.com")
+	r.Header.Set("Forwarded", `for=198.51.100.1;proto=https`)
+
+	h := r.Header.Clone()
+	p.SetHeaders(h, r)
+
+	assert.Equal(t, "198.51.100.1, 10.0.0.9, 10.0.0.2", h.Get("X-Forwarded-For"))
+	assert.Equal(t, "https", h.Get("X-Forwarded-Proto"))
+	assert.Equal(t, "app.example.com", h.Get("X-Forwarded-Host"))
+	assert.Equal(t, `for=198.51.100.1;proto=https, for=10.0.0.2;host="app.example.com:8080";proto=http`, h.Get("Forwarded"))
+}
+
+func TestSetHeaders_IPv6AndTLS(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "https://app.example.com/", nil)
+	r.RemoteAddr = "[2001:db8::1]:443"
+	r.TLS = &tls.ConnectionState{}
+
+	h := http.Header{}
+	(*TrustedProxies)(nil).SetHeaders(h, r)
+
+	assert.Equal(t, "2001:db8::1", h.Get("X-Forwarded-For"))
+	assert.Equal(t, "https", h.Get("X-Forwarded-Proto"))
+	assert.Equal(t, `for="[2001:db8::1]";host=app.example.com;proto=https`, h.Get("Forwarded"))
+}
+
+func TestClientAddr(t *testing.T) {
+	p, err := ParseTrustedProxies([]string{"10.0.0.0/8"})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name         string
+		remoteAddr   string
+		forwardedFor []string
+		want         string
+	}{
+		{"untrusted peer", "203.0.113.7:5000", []string{"1.2.3.4"}, "203.0.113.7"},
+		{"trusted peer", "10.0.0.2:5000", []string{"198.51.100.1"}, "198.51.100.1"},
+		{"chain of proxies", "10.0.0.2:5000", []string{"1.2.3.4, 198.51.100.1", "10.0.0.9"}, "198.51.100.1"},
+		{"trusted peer without header", "10.0.0.2:5000", nil, "10.0.0.2"},
+		{"all trusted", "10.0.0.2:5000", []string{"10.0.0.9"}, "10.0.0.9"},
+		{"invalid entry", "10.0.0.2:5000", []string{"1.2.3.4, garbage"}, "10.0.0.2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			for _, value := range tt.forwardedFor {
+				r.Header.Add("X-Forwarded-For", value)
+			}
+			assert.Equal(t, tt.want, p.ClientAddr(r).String())
+		})
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = "10.0.0.2:5000"
+	r.Header.Set("X-Forwarded-For", "198.51.100.1")
+	assert.Equal(t, "10.0.0.2", (*TrustedProxies)(nil).ClientAddr(r).String())
+}
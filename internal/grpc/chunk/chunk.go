@@ -0,0 +1,240 @@
+package chunk
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/EternisAI/silo-proxy/proto"
+)
+
+// Size is the maximum number of body bytes carried by a single BODY_CHUNK frame.
+// It keeps every frame far below gRPC's default 4 MB message limit.
+const Size = 32 * 1024
+
+// MaxBuffered is how many body bytes a Reader holds for its consumer. A
+// body that gets further ahead of its consumer fails with ErrBufferFull;
+// senders that respect a flow control Window never do.
+const MaxBuffered = 4 << 20
+
+var (
+	ErrClosed      = errors.New("body reader closed")
+	ErrIdleTimeout = errors.New("timed out waiting for body chunk")
+	// ErrBufferFull fails a body whose consumer fell more than MaxBuffered
+	// bytes behind.
+	ErrBufferFull = errors.New("body buffer full")
+)
+
+// SendFunc delivers a frame to the peer. It is expected to block until the
+// frame has been queued on the stream.
+type SendFunc func(msg *proto.ProxyMessage) error
+
+// Send streams r to the peer as BODY_CHUNK frames followed by a BODY_END frame,
+// all keyed by id. If reading r fails, the error is reported to the peer in the
+// BODY_END metadata so it can abort its side of the exchange.
+func Send(id string, r io.Reader, send SendFunc) (int64, error) {
+	return SendWithTrailer(id, r, nil, send)
+}
+
+// SendWithTrailer is like Send, and also carries the trailers returned by
+// trailer in the BODY_END frame. trailer is called once r has reached EOF.
+func SendWithTrailer(id string, r io.Reader, trailer func() []*proto.Header, send SendFunc) (int64, error) {
+	buf := make([]byte, Size)
+	var total int64
+
+	for {
+		n, readErr := r.Read(buf)
+		if n > 0 {
+			payload := make([]byte, n)
+			copy(payload, buf[:n])
+
+			if err := send(&proto.ProxyMessage{
+				Id:      id,
+				Type:    proto.MessageType_BODY_CHUNK,
+				Payload: payload,
+			}); err != nil {
+				return total, fmt.Errorf("failed to send body chunk: %w", err)
+			}
+			total += int64(n)
+		}
+
+		if readErr == nil {
+			continue
+		}
+
+		end := &proto.ProxyMessage{
+			Id:       id,
+			Type:     proto.MessageType_BODY_END,
+			Metadata: map[string]string{},
+		}
+		if readErr != io.EOF {
+			end.Metadata["error"] = readErr.Error()
+		} else if trailer != nil {
+			end.Trailers = trailer()
+		}
+
+		if err := send(end); err != nil {
+			return total, fmt.Errorf("failed to send body end: %w", err)
+		}
+
+		if readErr != io.EOF {
+			return total, fmt.Errorf("failed to read body: %w", readErr)
+		}
+		return total, nil
+	}
+}
+
+// Reader reassembles a body from BODY_CHUNK frames pushed by a stream's receive
+// loop. Push never blocks, so that a slow consumer cannot hold up the other
+// requests on the stream; instead the body fails once its consumer is
+// MaxBuffered bytes behind. A body that lost a frame never ends in io.EOF.
+type Reader struct {
+	mu       sync.Mutex
+	frames   []*proto.ProxyMessage
+	buffered int           // Payload bytes in frames
+	failure  error         // Set once the reader is closed or has failed
+	ready    chan struct{} // Signalled when frames or failure change
+
+	idleTimeout time.Duration
+	acker       *Acker
+	pending     []byte
+	trailer     []*proto.Header
+	err         error
+}
+
+// NewReader creates a Reader. If idleTimeout is positive, Read fails with
+// ErrIdleTimeout when no frame arrives within that duration.
+func NewReader(idleTimeout time.Duration) *Reader {
+	return &Reader{
+		ready:       make(chan struct{}, 1),
+		idleTimeout: idleTimeout,
+	}
+}
+
+// SetAcker makes Read report the payload bytes it takes to a, so that the
+// sender is granted more credit. It must be called before the first Read.
+func (r *Reader) SetAcker(a *Acker) {
+	r.acker = a
+}
+
+// Push hands a BODY_CHUNK or BODY_END frame to the reader. It returns
+// ErrClosed if the reader was closed or has already failed. If the frame does
+// not fit in the buffer, the reader fails and Push returns ErrBufferFull; the
+// caller should then abort the request the body belongs to.
+func (r *Reader) Push(msg *proto.ProxyMessage) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.failure != nil {
+		return ErrClosed
+	}
+	if r.buffered+len(msg.Payload) > MaxBuffered {
+		err := fmt.Errorf("%w: %s is more than %d bytes ahead of its reader", ErrBufferFull, msg.Id, MaxBuffered)
+		r.fail(err)
+		return err
+	}
+
+	r.frames = append(r.frames, msg)
+	r.buffered += len(msg.Payload)
+	r.signal()
+	return nil
+}
+
+// fail drops the buffered frames and makes Read return err. Callers must hold
+// r.mu.
+func (r *Reader) fail(err error) {
+	if r.failure != nil {
+		return
+	}
+	r.failure = err
+	r.frames = nil
+	r.buffered = 0
+	r.signal()
+}
+
+func (r *Reader) signal() {
+	select {
+	case r.ready <- struct{}{}:
+	default:
+	}
+}
+
+func (r *Reader) Read(p []byte) (int, error) {
+	for len(r.pending) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+
+		msg, err := r.next()
+		if err != nil {
+			r.err = err
+			return 0, err
+		}
+
+		if msg.Type == proto.MessageType_BODY_END {
+			if reason := msg.Metadata["error"]; reason != "" {
+				r.err = fmt.Errorf("body aborted by peer: %s", reason)
+			} else {
+				r.trailer = msg.Trailers
+				r.err = io.EOF
+			}
+			return 0, r.err
+		}
+
+		r.pending = msg.Payload
+		r.acker.Consumed(len(msg.Payload))
+	}
+
+	n := copy(p, r.pending)
+	r.pending = r.pending[n:]
+	return n, nil
+}
+
+// Trailer returns the trailers of the BODY_END frame. It is only set once Read
+// has returned io.EOF.
+func (r *Reader) Trailer() []*proto.Header {
+	return r.trailer
+}
+
+func (r *Reader) next() (*proto.ProxyMessage, error) {
+	var timeout <-chan time.Time
+	if r.idleTimeout > 0 {
+		timer := time.NewTimer(r.idleTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	for {
+		r.mu.Lock()
+		if r.failure != nil {
+			err := r.failure
+			r.mu.Unlock()
+			return nil, err
+		}
+		if len(r.frames) > 0 {
+			msg := r.frames[0]
+			r.frames[0] = nil
+			r.frames = r.frames[1:]
+			r.buffered -= len(msg.Payload)
+			r.mu.Unlock()
+			return msg, nil
+		}
+		r.mu.Unlock()
+
+		select {
+		case <-r.ready:
+		case <-timeout:
+			return nil, ErrIdleTimeout
+		}
+	}
+}
+
+// Close releases the reader. Frames pushed afterwards are rejected with ErrClosed.
+func (r *Reader) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.fail(ErrClosed)
+	return nil
+}
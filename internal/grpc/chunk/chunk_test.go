@@ -0,0 +1,163 @@
+package chunk
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/EternisAI/silo-proxy/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSend_SplitsBodyIntoChunks(t *testing.T) {
+	body := bytes.Repeat([]byte("a"), Size*2+10)
+
+	var frames []*proto.ProxyMessage
+	n, err := Send("req-1", bytes.NewReader(body), func(msg *proto.ProxyMessage) error {
+		frames = append(frames, msg)
+		return nil
+	})
+
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(body)), n)
+	require.Len(t, frames, 4)
+
+	for _, f := range frames[:3] {
+		assert.Equal(t, "req-1", f.Id)
+		assert.Equal(t, proto.MessageType_BODY_CHUNK, f.Type)
+	}
+	assert.Len(t, frames[2].Payload, 10)
+	assert.Equal(t, proto.MessageType_BODY_END, frames[3].Type)
+	assert.Empty(t, frames[3].Metadata["error"])
+}
+
+func TestSend_EmptyBody(t *testing.T) {
+	var frames []*proto.ProxyMessage
+	n, err := Send("req-1", bytes.NewReader(nil), func(msg *proto.ProxyMessage) error {
+		frames = append(frames, msg)
+		return nil
+	})
+
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), n)
+	require.Len(t, frames, 1)
+	assert.Equal(t, proto.MessageType_BODY_END, frames[0].Type)
+}
+
+type failingReader struct{}
+
+func (failingReader) Read([]byte) (int, error) {
+	return 0, errors.New("connection reset")
+}
+
+func TestSend_ReadErrorReportedToPeer(t *testing.T) {
+	var frames []*proto.ProxyMessage
+	_, err := Send("req-1", failingReader{}, func(msg *proto.ProxyMessage) error {
+		frames = append(frames, msg)
+		return nil
+	})
+
+	assert.Error(t, err)
+	require.Len(t, frames, 1)
+	assert.Equal(t, proto.MessageType_BODY_END, frames[0].Type)
+	assert.Equal(t, "connection reset", frames[0].Metadata["error"])
+}
+
+func TestSend_SendError(t *testing.T) {
+	_, err := Send("req-1", bytes.NewReader([]byte("data")), func(msg *proto.ProxyMessage) error {
+		return assert.AnError
+	})
+
+	assert.ErrorIs(t, err, assert.AnError)
+}
+
+func TestReader_RoundTrip(t *testing.T) {
+	body := bytes.Repeat([]byte("0123456789"), Size/2)
+	r := NewReader(time.Second)
+
+	go func() {
+		_, _ = Send("req-1", bytes.NewReader(body), r.Push)
+	}()
+
+	got, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, body, got)
+}
+
+func TestReader_AbortedByPeer(t *testing.T) {
+	r := NewReader(time.Second)
+
+	require.NoError(t, r.Push(&proto.ProxyMessage{Type: proto.MessageType_BODY_CHUNK, Payload: []byte("partial")}))
+	require.NoError(t, r.Push(&proto.ProxyMessage{
+		Type:     proto.MessageType_BODY_END,
+		Metadata: map[string]string{"error": "client went away"},
+	}))
+
+	got, err := io.ReadAll(r)
+	assert.Equal(t, "partial", string(got))
+	assert.ErrorContains(t, err, "client went away")
+}
+
+func TestReader_IdleTimeout(t *testing.T) {
+	r := NewReader(20 * time.Millisecond)
+
+	_, err := r.Read(make([]byte, 10))
+	assert.ErrorIs(t, err, ErrIdleTimeout)
+}
+
+func TestReader_PushAfterClose(t *testing.T) {
+	r := NewReader(time.Second)
+	require.NoError(t, r.Close())
+
+	err := r.Push(&proto.ProxyMessage{Type: proto.MessageType_BODY_CHUNK})
+	assert.ErrorIs(t, err, ErrClosed)
+
+	_, err = r.Read(make([]byte, 10))
+	assert.ErrorIs(t, err, ErrClosed)
+}
+
+func TestReader_Trailer(t *testing.T) {
+	r := NewReader(time.Second)
+	trailer := []*proto.Header{{Name: "Grpc-Status", Values: []string{"0"}}}
+
+	go func() {
+		_, _ = SendWithTrailer("req-1", bytes.NewReader([]byte("body")), func() []*proto.Header {
+			return trailer
+		}, r.Push)
+	}()
+
+	got, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, "body", string(got))
+	assert.Equal(t, trailer, r.Trailer())
+}
+
+func TestReader_StalledConsumerFailsBody(t *testing.T) {
+	r := NewReader(time.Second)
+	body := bytes.Repeat([]byte("x"), MaxBuffered+Size)
+
+	// Nothing reads while the whole body arrives: pushing never blocks, and
+	// the body fails once it no longer fits.
+	var pushErr error
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, pushErr = Send("req-1", bytes.NewReader(body), r.Push)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Push blocked on a stalled consumer")
+	}
+	assert.ErrorIs(t, pushErr, ErrBufferFull)
+
+	err := r.Push(&proto.ProxyMessage{Type: proto.MessageType_BODY_END})
+	assert.ErrorIs(t, err, ErrClosed, "frames after the loss are rejected")
+
+	got, err := io.ReadAll(r)
+	assert.ErrorIs(t, err, ErrBufferFull, "a body that lost frames never ends cleanly")
+	assert.Empty(t, got)
+}
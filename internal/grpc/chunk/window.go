@@ -0,0 +1,160 @@
+package chunk
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+
+	"github.com/EternisAI/silo-proxy/proto"
+)
+
+// WindowSize is the credit a body or tunnel session starts with: how many
+// payload bytes its sender may have in flight before the receiver
+// acknowledges consuming them. It matches MaxBuffered, so a sender that
+// respects its window never overflows the receiver.
+const WindowSize = MaxBuffered
+
+// ackThreshold is how many consumed bytes an Acker collects before granting
+// them back. It leaves the sender room for at least one more frame, so that
+// a sender waiting for credit is always granted some eventually.
+const ackThreshold = WindowSize / 4
+
+// ErrWindowClosed is returned by Acquire once the window has been closed.
+var ErrWindowClosed = errors.New("flow control window closed")
+
+// Window is the sender's side of per-request flow control. The sender takes
+// credit for every payload before sending it and the receiver grants credit
+// back with WINDOW_UPDATE frames as its consumer reads, so a slow consumer
+// pauses its own sender instead of the stream. A nil *Window is unlimited,
+// for peers that did not negotiate flow control.
+type Window struct {
+	mu     sync.Mutex
+	credit int
+	closed bool
+	ready  chan struct{} // Signalled when credit or closed change
+}
+
+// NewWindow creates a window holding WindowSize bytes of credit.
+func NewWindow() *Window {
+	return &Window{
+		credit: WindowSize,
+		ready:  make(chan struct{}, 1),
+	}
+}
+
+// Acquire takes n bytes of credit, waiting until the receiver has granted
+// enough. It returns ErrWindowClosed if the window is closed first.
+func (w *Window) Acquire(n int) error {
+	if w == nil {
+		return nil
+	}
+	n = min(n, WindowSize)
+
+	for {
+		w.mu.Lock()
+		if w.closed {
+			w.mu.Unlock()
+			return ErrWindowClosed
+		}
+		if w.credit >= n {
+			w.credit -= n
+			w.mu.Unlock()
+			return nil
+		}
+		w.mu.Unlock()
+
+		<-w.ready
+	}
+}
+
+// Grant returns n bytes of credit, as announced by a WINDOW_UPDATE frame.
+func (w *Window) Grant(n int) {
+	if w == nil || n <= 0 {
+		return
+	}
+	w.mu.Lock()
+	w.credit += n
+	w.mu.Unlock()
+	w.signal()
+}
+
+// Close wakes a sender waiting for credit; Acquire fails from then on.
+func (w *Window) Close() {
+	if w == nil {
+		return
+	}
+	w.mu.Lock()
+	w.closed = true
+	w.mu.Unlock()
+	w.signal()
+}
+
+func (w *Window) signal() {
+	select {
+	case w.ready <- struct{}{}:
+	default:
+	}
+}
+
+// Throttle returns a SendFunc that takes credit from w for the payload of
+// every BODY_CHUNK before passing it to send.
+func (w *Window) Throttle(send SendFunc) SendFunc {
+	if w == nil {
+		return send
+	}
+	return func(msg *proto.ProxyMessage) error {
+		if msg.Type == proto.MessageType_BODY_CHUNK {
+			if err := w.Acquire(len(msg.Payload)); err != nil {
+				return err
+			}
+		}
+		return send(msg)
+	}
+}
+
+// Acker is the receiver's side of flow control. It counts the payload bytes
+// a consumer has taken and grants them back to the sender in batches. A nil
+// *Acker grants nothing.
+type Acker struct {
+	grant   func(n int)
+	pending int
+}
+
+// NewAcker creates an Acker that calls grant, typically to send a
+// WINDOW_UPDATE frame, from the consumer's goroutine.
+func NewAcker(grant func(n int)) *Acker {
+	return &Acker{grant: grant}
+}
+
+// Consumed records that the consumer took n payload bytes. It must not be
+// called concurrently.
+func (a *Acker) Consumed(n int) {
+	if a == nil || n <= 0 {
+		return
+	}
+	a.pending += n
+	if a.pending >= ackThreshold {
+		a.grant(a.pending)
+		a.pending = 0
+	}
+}
+
+// WindowUpdate builds the WINDOW_UPDATE frame granting n bytes of credit for
+// the body or session id.
+func WindowUpdate(id string, n int) *proto.ProxyMessage {
+	return &proto.ProxyMessage{
+		Id:       id,
+		Type:     proto.MessageType_WINDOW_UPDATE,
+		Metadata: map[string]string{"bytes": strconv.Itoa(n)},
+	}
+}
+
+// Granted returns the credit a WINDOW_UPDATE frame grants, or zero if it is
+// malformed.
+func Granted(msg *proto.ProxyMessage) int {
+	n, err := strconv.Atoi(msg.Metadata["bytes"])
+	if err != nil || n < 0 {
+		return 0
+	}
+	return n
+}
@@ -0,0 +1,86 @@
+package chunk
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/EternisAI/silo-proxy/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWindow_AcquireWaitsForGrant(t *testing.T) {
+	w := NewWindow()
+	require.NoError(t, w.Acquire(WindowSize))
+
+	acquired := make(chan error, 1)
+	go func() { acquired <- w.Acquire(Size) }()
+
+	select {
+	case <-acquired:
+		t.Fatal("Acquire returned without credit")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	w.Grant(Size)
+	select {
+	case err := <-acquired:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("Acquire did not return after Grant")
+	}
+}
+
+func TestWindow_CloseWakesSender(t *testing.T) {
+	w := NewWindow()
+	require.NoError(t, w.Acquire(WindowSize))
+
+	acquired := make(chan error, 1)
+	go func() { acquired <- w.Acquire(Size) }()
+	w.Close()
+
+	select {
+	case err := <-acquired:
+		assert.ErrorIs(t, err, ErrWindowClosed)
+	case <-time.After(time.Second):
+		t.Fatal("Acquire did not return after Close")
+	}
+}
+
+func TestWindow_NilIsUnlimited(t *testing.T) {
+	var w *Window
+	assert.NoError(t, w.Acquire(WindowSize*2))
+	w.Grant(Size)
+	w.Close()
+}
+
+func TestWindowUpdate_RoundTrip(t *testing.T) {
+	msg := WindowUpdate("req-1", 1234)
+
+	assert.Equal(t, "req-1", msg.Id)
+	assert.Equal(t, proto.MessageType_WINDOW_UPDATE, msg.Type)
+	assert.Equal(t, 1234, Granted(msg))
+	assert.Zero(t, Granted(&proto.ProxyMessage{Metadata: map[string]string{"bytes": "-1"}}))
+}
+
+func TestReader_FlowControlledBodyLargerThanBuffer(t *testing.T) {
+	r := NewReader(time.Second)
+	w := NewWindow()
+	r.SetAcker(NewAcker(w.Grant))
+	body := bytes.Repeat([]byte("x"), MaxBuffered*2)
+
+	// The sender pauses whenever it is a window ahead of the reader, so the
+	// body never overflows the buffer however large it is.
+	sendErr := make(chan error, 1)
+	go func() {
+		_, err := Send("req-1", bytes.NewReader(body), w.Throttle(r.Push))
+		sendErr <- err
+	}()
+
+	got, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, len(body), len(got))
+	assert.NoError(t, <-sendErr)
+}
@@ -5,9 +5,11 @@ import (
 	"fmt"
 	"io"
 	"log/slog"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/EternisAI/silo-proxy/internal/metrics"
 	"github.com/EternisAI/silo-proxy/proto"
 	"github.com/google/uuid"
 	"google.golang.org/grpc"
@@ -18,16 +20,22 @@ import (
 
 const (
 	sendChannelBuffer = 100
-	pingInterval      = 30 * time.Second
 	initialDelay      = 1 * time.Second
 	maxDelay          = 30 * time.Second
 	backoffFactor     = 2
+
+	// Defaults of Timeouts.Send and Timeouts.PingInterval.
+	sendTimeout  = 5 * time.Second
+	pingInterval = 30 * time.Second
 )
 
 type Client struct {
 	serverAddr string
 	agentID    string
+	version    string
 	tlsConfig  *TLSConfig
+	router     *Router
+	startedAt  time.Time
 	conn       *grpc.ClientConn
 	stream     proto.ProxyService_StreamClient
 
@@ -37,8 +45,16 @@ type Client struct {
 
 	reconnectDelay    time.Duration
 	maxReconnectDelay time.Duration
+	sendTimeout       time.Duration
+	pingInterval      time.Duration
+
+	requestHandler   *RequestHandler
+	websocketHandler *WebSocketHandler
+	tcpHandler       *TCPHandler
+	commandHandler   *CommandHandler
 
-	requestHandler *RequestHandler
+	renewID string
+	renewCh chan *proto.ProxyMessage
 
 	ctx    context.Context
 	cancel context.CancelFunc
@@ -51,23 +67,68 @@ type TLSConfig struct {
 	KeyFile            string
 	CAFile             string
 	ServerNameOverride string
+	// RenewBefore is how long before its expiry the client certificate is
+	// renewed over the stream, capped at half its lifetime; zero disables
+	// renewal.
+	RenewBefore time.Duration
 }
 
-func NewClient(serverAddr, agentID, localURL string, tlsConfig *TLSConfig) *Client {
+func NewClient(serverAddr, agentID string, router *Router, tcpTunnels []TCPTunnel, tlsConfig *TLSConfig) *Client {
 	ctx, cancel := context.WithCancel(context.Background())
-	return &Client{
+	c := &Client{
 		serverAddr:        serverAddr,
 		agentID:           agentID,
 		tlsConfig:         tlsConfig,
+		router:            router,
+		startedAt:         time.Now(),
 		sendCh:            make(chan *proto.ProxyMessage, sendChannelBuffer),
 		stopCh:            make(chan struct{}),
 		doneCh:            make(chan struct{}),
 		reconnectDelay:    initialDelay,
 		maxReconnectDelay: maxDelay,
-		requestHandler:    NewRequestHandler(localURL),
+		sendTimeout:       sendTimeout,
+		pingInterval:      pingInterval,
 		ctx:               ctx,
 		cancel:            cancel,
 	}
+	c.requestHandler = NewRequestHandler(router, c.sendBlocking)
+	c.websocketHandler = NewWebSocketHandler(router, c.sendBlocking)
+	c.tcpHandler = NewTCPHandler(tcpTunnels, c.sendBlocking)
+	c.commandHandler = NewCommandHandler(c.sendBlocking)
+	c.commandHandler.Register("status", c.statusCommand)
+	c.commandHandler.Register("health_check", c.healthCheckCommand)
+	return c
+}
+
+// Timeouts tunes how long the agent waits. Zero durations keep the defaults.
+type Timeouts struct {
+	// ResponseHeader bounds the wait for a local service's response headers
+	// when the server sends no timeout with the request.
+	ResponseHeader time.Duration
+	// BodyIdle bounds the wait for the next slice of a request body.
+	BodyIdle time.Duration
+	// Send bounds the wait for room in the send channel.
+	Send time.Duration
+	// PingInterval is how often the agent pings the server. It must stay
+	// below the server's stale connection timeout.
+	PingInterval time.Duration
+}
+
+// SetTimeouts replaces the default timeouts. It must be called before Start.
+func (c *Client) SetTimeouts(timeouts Timeouts) {
+	if timeouts.Send > 0 {
+		c.sendTimeout = timeouts.Send
+	}
+	if timeouts.PingInterval > 0 {
+		c.pingInterval = timeouts.PingInterval
+	}
+	c.requestHandler.SetTimeouts(timeouts.ResponseHeader, timeouts.BodyIdle)
+}
+
+// SetWorkerPool bounds the requests sent to the local services at once, see
+// RequestHandler.StartWorkers. It must be called before Start.
+func (c *Client) SetWorkerPool(workers, queueSize int) {
+	c.requestHandler.StartWorkers(c.ctx, workers, queueSize)
 }
 
 func (c *Client) Start() error {
@@ -93,6 +154,25 @@ func (c *Client) Send(msg *proto.ProxyMessage) error {
 	}
 }
 
+// SendQueueDepth returns the number of messages waiting to be sent.
+func (c *Client) SendQueueDepth() int {
+	return len(c.sendCh)
+}
+
+// sendBlocking queues msg, waiting for room in the send channel. It is used for
+// streamed bodies, where dropping a frame would corrupt the transfer.
+func (c *Client) sendBlocking(msg *proto.ProxyMessage) error {
+	select {
+	case c.sendCh <- msg:
+		return nil
+	case <-time.After(c.sendTimeout):
+		metrics.AgentSendTimeouts.Inc()
+		return fmt.Errorf("timeout queueing message %s", msg.Id)
+	case <-c.ctx.Done():
+		return fmt.Errorf("client stopped")
+	}
+}
+
 func (c *Client) connectionLoop() {
 	defer close(c.doneCh)
 
@@ -104,6 +184,8 @@ func (c *Client) connectionLoop() {
 		default:
 			if err := c.connect(); err != nil {
 				slog.Error("Connection failed", "error", err, "retry_in", c.reconnectDelay)
+				metrics.AgentReconnectAttempts.Inc()
+				metrics.AgentReconnectBackoff.Set(c.reconnectDelay.Seconds())
 				select {
 				case <-time.After(c.reconnectDelay):
 					c.increaseReconnectDelay()
@@ -114,22 +196,32 @@ func (c *Client) connectionLoop() {
 			}
 
 			c.reconnectDelay = initialDelay
+			metrics.AgentConnected.Set(1)
+			metrics.AgentReconnectBackoff.Set(0)
 
 			if err := c.handleStream(); err != nil {
 				if err == io.EOF {
 					slog.Info("Server closed connection")
+				} else if err == errCertRenewed {
+					slog.Info("Reconnecting with renewed certificate")
 				} else {
 					slog.Error("Stream error", "error", err)
 				}
 			}
 
 			c.disconnect()
+			metrics.AgentConnected.Set(0)
+			c.requestHandler.CancelAll()
+			c.websocketHandler.CloseAll()
+			c.tcpHandler.CloseAll()
 
 			select {
 			case <-c.stopCh:
 				return
 			default:
 				slog.Info("Reconnecting", "delay", c.reconnectDelay)
+				metrics.AgentReconnectAttempts.Inc()
+				metrics.AgentReconnectBackoff.Set(c.reconnectDelay.Seconds())
 				time.Sleep(c.reconnectDelay)
 				c.increaseReconnectDelay()
 			}
@@ -172,12 +264,9 @@ func (c *Client) connect() error {
 		return fmt.Errorf("failed to create stream: %w", err)
 	}
 
-	firstMsg := &proto.ProxyMessage{
-		Id:   uuid.New().String(),
-		Type: proto.MessageType_PING,
-		Metadata: map[string]string{
-			"agent_id": c.agentID,
-		},
+	firstMsg := c.helloMessage()
+	if names := c.tcpHandler.Names(); len(names) > 0 {
+		firstMsg.Metadata["tcp_tunnels"] = strings.Join(names, ",")
 	}
 
 	if err := stream.Send(firstMsg); err != nil {
@@ -186,6 +275,11 @@ func (c *Client) connect() error {
 		return fmt.Errorf("failed to send first message: %w", err)
 	}
 
+	c.requestHandler.SetLegacyHeaders(true)
+	c.requestHandler.SetFlowControl(false)
+	c.websocketHandler.SetFlowControl(false)
+	c.tcpHandler.SetFlowControl(false)
+
 	c.mu.Lock()
 	c.conn = conn
 	c.stream = stream
@@ -219,11 +313,12 @@ func (c *Client) increaseReconnectDelay() {
 
 func (c *Client) handleStream() error {
 	done := make(chan struct{})
-	errChan := make(chan error, 3)
+	errChan := make(chan error, 4)
 
 	go c.receiveLoop(done, errChan)
 	go c.sendLoop(done, errChan)
-	go c.pingLoop(done, errChan)
+	go c.pingLoop(done)
+	go c.renewLoop(done, errChan)
 
 	err := <-errChan
 	close(done)
@@ -293,8 +388,11 @@ func (c *Client) sendLoop(done chan struct{}, errChan chan error) {
 	}
 }
 
-func (c *Client) pingLoop(done chan struct{}, errChan chan error) {
-	ticker := time.NewTicker(pingInterval)
+// pingLoop sends a PING every ping interval. A tick is skipped while the send
+// queue is full: the frames filling it keep the stream alive just as well,
+// and backpressure is no reason to drop the stream.
+func (c *Client) pingLoop(done chan struct{}) {
+	ticker := time.NewTicker(c.pingInterval)
 	defer ticker.Stop()
 
 	for {
@@ -309,9 +407,8 @@ func (c *Client) pingLoop(done chan struct{}, errChan chan error) {
 			}
 
 			if err := c.Send(ping); err != nil {
-				slog.Error("Failed to send PING", "error", err)
-				errChan <- err
-				return
+				slog.Debug("Skipping PING", "error", err)
+				continue
 			}
 
 			slog.Debug("PING sent", "message_id", ping.Id)
@@ -324,39 +421,50 @@ func (c *Client) processMessage(msg *proto.ProxyMessage) error {
 	case proto.MessageType_PONG:
 		slog.Debug("PONG received", "message_id", msg.Id)
 
-	case proto.MessageType_REQUEST:
-		slog.Debug("REQUEST received", "message_id", msg.Id)
-		go c.handleRequest(msg)
+	case proto.MessageType_HELLO_ACK:
+		c.handleHelloAck(msg)
 
-	default:
-		slog.Warn("Unknown message type", "type", msg.Type)
-	}
+	case proto.MessageType_REQUEST_START:
+		slog.Debug("REQUEST received", "message_id", msg.Id)
+		c.requestHandler.StartRequest(msg)
 
-	return nil
-}
+	case proto.MessageType_BODY_CHUNK, proto.MessageType_BODY_END:
+		c.requestHandler.HandleBodyFrame(msg)
 
-func (c *Client) handleRequest(msg *proto.ProxyMessage) {
-	response, err := c.requestHandler.HandleRequest(msg)
-	if err != nil {
-		slog.Error("Failed to handle request", "error", err, "message_id", msg.Id)
-
-		errorResponse := &proto.ProxyMessage{
-			Id:      msg.Id,
-			Type:    proto.MessageType_RESPONSE,
-			Payload: []byte(err.Error()),
-			Metadata: map[string]string{
-				"status_code": "502",
-				"error":       err.Error(),
-			},
+	case proto.MessageType_WINDOW_UPDATE:
+		// The credit goes to the request or tunnel session the frame names.
+		if !c.requestHandler.HandleWindowUpdate(msg) {
+			c.websocketHandler.HandleFrame(msg)
+			c.tcpHandler.HandleFrame(msg)
 		}
 
-		if sendErr := c.Send(errorResponse); sendErr != nil {
-			slog.Error("Failed to send error response", "error", sendErr)
-		}
-		return
-	}
+	case proto.MessageType_CANCEL:
+		c.requestHandler.Cancel(msg)
 
-	if err := c.Send(response); err != nil {
-		slog.Error("Failed to send response", "error", err, "message_id", msg.Id)
+	case proto.MessageType_WS_OPEN:
+		slog.Debug("WS_OPEN received", "session_id", msg.Id)
+		c.websocketHandler.Open(msg)
+
+	case proto.MessageType_WS_FRAME, proto.MessageType_WS_CLOSE:
+		c.websocketHandler.HandleFrame(msg)
+
+	case proto.MessageType_TCP_OPEN:
+		slog.Debug("TCP_OPEN received", "session_id", msg.Id, "tunnel", msg.Metadata["tunnel"])
+		c.tcpHandler.Open(msg)
+
+	case proto.MessageType_TCP_DATA, proto.MessageType_TCP_CLOSE_WRITE, proto.MessageType_TCP_CLOSE:
+		c.tcpHandler.HandleFrame(msg)
+
+	case proto.MessageType_CERT_RENEW_RESPONSE:
+		c.deliverRenewal(msg)
+
+	case proto.MessageType_COMMAND:
+		slog.Debug("COMMAND received", "message_id", msg.Id, "command", msg.Metadata["command"])
+		c.commandHandler.Handle(msg)
+
+	default:
+		slog.Warn("Unknown message type", "type", msg.Type)
 	}
+
+	return nil
 }
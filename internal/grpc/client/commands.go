@@ -0,0 +1,221 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/EternisAI/silo-proxy/internal/grpc/chunk"
+	"github.com/EternisAI/silo-proxy/internal/grpc/protocol"
+	"github.com/EternisAI/silo-proxy/proto"
+)
+
+// commandTimeout applies to COMMAND frames from servers that send no
+// timeout with them.
+const commandTimeout = 30 * time.Second
+
+// CommandFunc runs a command sent by the server. args holds the JSON
+// arguments given to the command, or is empty. The result is sent back
+// encoded as JSON. The function should return once ctx is done.
+type CommandFunc func(ctx context.Context, args json.RawMessage) (any, error)
+
+// CommandHandler runs the commands the server sends over the stream and
+// answers each with a COMMAND_RESULT frame.
+type CommandHandler struct {
+	send chunk.SendFunc
+
+	commands map[string]CommandFunc
+	mu       sync.RWMutex
+}
+
+func NewCommandHandler(send chunk.SendFunc) *CommandHandler {
+	return &CommandHandler{
+		send:     send,
+		commands: make(map[string]CommandFunc),
+	}
+}
+
+// Register makes fn the handler of the command called name, replacing any
+// previous one.
+func (ch *CommandHandler) Register(name string, fn CommandFunc) {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	ch.commands[name] = fn
+}
+
+// Names returns the registered commands, sorted.
+func (ch *CommandHandler) Names() []string {
+	ch.mu.RLock()
+	defer ch.mu.RUnlock()
+
+	names := make([]string, 0, len(ch.commands))
+	for name := range ch.commands {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// Handle runs the command of a COMMAND frame in the background.
+func (ch *CommandHandler) Handle(msg *proto.ProxyMessage) {
+	go ch.run(msg)
+}
+
+func (ch *CommandHandler) run(msg *proto.ProxyMessage) {
+	name := msg.Metadata["command"]
+	timeout := commandTimeout
+	if ms, err := strconv.ParseInt(msg.Metadata["timeout_ms"], 10, 64); err == nil && ms > 0 {
+		timeout = time.Duration(ms) * time.Millisecond
+	}
+
+	ch.mu.RLock()
+	fn, ok := ch.commands[name]
+	ch.mu.RUnlock()
+
+	if !ok {
+		slog.Warn("Unknown command", "message_id", msg.Id, "command", name)
+		ch.sendResult(msg.Id, nil, protocol.CommandNotFound, fmt.Errorf("unknown command: %s", name))
+		return
+	}
+
+	slog.Info("Running command", "message_id", msg.Id, "command", name, "timeout", timeout)
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	result, err := fn(ctx, msg.Payload)
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		slog.Warn("Command timed out", "message_id", msg.Id, "command", name)
+		ch.sendResult(msg.Id, nil, protocol.CommandTimeout, fmt.Errorf("command timed out after %s", timeout))
+		return
+	case err != nil:
+		slog.Warn("Command failed", "message_id", msg.Id, "command", name, "error", err)
+		ch.sendResult(msg.Id, nil, protocol.CommandFailed, err)
+		return
+	}
+
+	payload, err := json.Marshal(result)
+	if err != nil {
+		ch.sendResult(msg.Id, nil, protocol.CommandFailed, fmt.Errorf("failed to encode result: %w", err))
+		return
+	}
+	ch.sendResult(msg.Id, payload, "", nil)
+}
+
+func (ch *CommandHandler) sendResult(id string, payload []byte, code string, cause error) {
+	result := &proto.ProxyMessage{
+		Id:       id,
+		Type:     proto.MessageType_COMMAND_RESULT,
+		Payload:  payload,
+		Metadata: map[string]string{},
+	}
+	if cause != nil {
+		result.Metadata["error"] = cause.Error()
+		result.Metadata["error_code"] = code
+	}
+
+	if err := ch.send(result); err != nil {
+		slog.Error("Failed to send command result", "message_id", id, "error", err)
+	}
+}
+
+// RegisterCommand adds a command the server can run on the agent. The
+// built-in "status" and "health_check" commands are always available. It
+// must be called before Start.
+func (c *Client) RegisterCommand(name string, fn CommandFunc) {
+	c.commandHandler.Register(name, fn)
+}
+
+// AgentStatus is the result of the "status" command.
+type AgentStatus struct {
+	AgentID           string   `json:"agent_id"`
+	Version           string   `json:"version"`
+	UptimeSeconds     int64    `json:"uptime_seconds"`
+	InFlightRequests  int      `json:"in_flight_requests"`
+	WebSocketSessions int      `json:"websocket_sessions"`
+	TCPSessions       int      `json:"tcp_sessions"`
+	SendQueueDepth    int      `json:"send_queue_depth"`
+	TCPTunnels        []string `json:"tcp_tunnels"`
+	Commands          []string `json:"commands"`
+}
+
+func (c *Client) statusCommand(context.Context, json.RawMessage) (any, error) {
+	return AgentStatus{
+		AgentID:           c.agentID,
+		Version:           c.version,
+		UptimeSeconds:     int64(time.Since(c.startedAt).Seconds()),
+		InFlightRequests:  c.requestHandler.InFlight(),
+		WebSocketSessions: c.websocketHandler.SessionCount(),
+		TCPSessions:       c.tcpHandler.SessionCount(),
+		SendQueueDepth:    c.SendQueueDepth(),
+		TCPTunnels:        c.tcpHandler.Names(),
+		Commands:          c.commandHandler.Names(),
+	}, nil
+}
+
+// UpstreamHealth is the outcome of probing one local upstream.
+type UpstreamHealth struct {
+	Upstream   string `json:"upstream"`
+	Healthy    bool   `json:"healthy"`
+	StatusCode int    `json:"status_code,omitempty"`
+	LatencyMs  int64  `json:"latency_ms"`
+	Error      string `json:"error,omitempty"`
+}
+
+// HealthReport is the result of the "health_check" command.
+type HealthReport struct {
+	Healthy   bool             `json:"healthy"`
+	Upstreams []UpstreamHealth `json:"upstreams"`
+}
+
+// healthCheckCommand sends a GET to every configured upstream. An upstream
+// is healthy if it answers with a status below 500.
+func (c *Client) healthCheckCommand(ctx context.Context, _ json.RawMessage) (any, error) {
+	upstreams := c.router.Upstreams()
+	report := HealthReport{Healthy: true, Upstreams: make([]UpstreamHealth, len(upstreams))}
+
+	var wg sync.WaitGroup
+	for i, upstream := range upstreams {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			report.Upstreams[i] = probeUpstream(ctx, c.requestHandler.httpClient, upstream)
+		}()
+	}
+	wg.Wait()
+
+	for _, u := range report.Upstreams {
+		report.Healthy = report.Healthy && u.Healthy
+	}
+	return report, ctx.Err()
+}
+
+func probeUpstream(ctx context.Context, client *http.Client, upstream string) UpstreamHealth {
+	result := UpstreamHealth{Upstream: upstream}
+	start := time.Now()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, upstream+"/", nil)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	resp, err := client.Do(req)
+	result.LatencyMs = time.Since(start).Milliseconds()
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	resp.Body.Close()
+
+	result.StatusCode = resp.StatusCode
+	result.Healthy = resp.StatusCode < http.StatusInternalServerError
+	return result
+}
@@ -0,0 +1,117 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/EternisAI/silo-proxy/internal/grpc/protocol"
+	"github.com/EternisAI/silo-proxy/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func runCommand(t *testing.T, ch *CommandHandler, results chan *proto.ProxyMessage, name, timeoutMs string, args []byte) *proto.ProxyMessage {
+	t.Helper()
+	ch.Handle(&proto.ProxyMessage{
+		Id:       "cmd-1",
+		Type:     proto.MessageType_COMMAND,
+		Payload:  args,
+		Metadata: map[string]string{"command": name, "timeout_ms": timeoutMs},
+	})
+
+	select {
+	case msg := <-results:
+		assert.Equal(t, proto.MessageType_COMMAND_RESULT, msg.Type)
+		assert.Equal(t, "cmd-1", msg.Id)
+		return msg
+	case <-time.After(2 * time.Second):
+		t.Fatal("no command result")
+		return nil
+	}
+}
+
+func newTestCommandHandler() (*CommandHandler, chan *proto.ProxyMessage) {
+	results := make(chan *proto.ProxyMessage, 1)
+	ch := NewCommandHandler(func(msg *proto.ProxyMessage) error {
+		results <- msg
+		return nil
+	})
+	ch.Register("echo", func(_ context.Context, args json.RawMessage) (any, error) {
+		return args, nil
+	})
+	ch.Register("fail", func(context.Context, json.RawMessage) (any, error) {
+		return nil, errors.New("disk full")
+	})
+	ch.Register("hang", func(ctx context.Context, _ json.RawMessage) (any, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	return ch, results
+}
+
+func TestCommandHandler_Result(t *testing.T) {
+	ch, results := newTestCommandHandler()
+
+	msg := runCommand(t, ch, results, "echo", "1000", []byte(`{"a":1}`))
+	assert.Empty(t, msg.Metadata["error"])
+	assert.JSONEq(t, `{"a":1}`, string(msg.Payload))
+	assert.Equal(t, []string{"echo", "fail", "hang"}, ch.Names())
+}
+
+func TestCommandHandler_Errors(t *testing.T) {
+	ch, results := newTestCommandHandler()
+
+	msg := runCommand(t, ch, results, "reboot", "1000", nil)
+	assert.Equal(t, protocol.CommandNotFound, msg.Metadata["error_code"])
+	assert.Contains(t, msg.Metadata["error"], "reboot")
+
+	msg = runCommand(t, ch, results, "fail", "1000", nil)
+	assert.Equal(t, protocol.CommandFailed, msg.Metadata["error_code"])
+	assert.Equal(t, "disk full", msg.Metadata["error"])
+
+	msg = runCommand(t, ch, results, "hang", "50", nil)
+	assert.Equal(t, protocol.CommandTimeout, msg.Metadata["error_code"])
+}
+
+func TestClient_StatusAndHealthCheck(t *testing.T) {
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer healthy.Close()
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer broken.Close()
+
+	router, err := NewRouter([]Route{
+		{PathPrefix: "/api", Upstream: healthy.URL},
+		{PathPrefix: "/", Upstream: broken.URL},
+		{PathPrefix: "/other", Upstream: healthy.URL},
+	})
+	require.NoError(t, err)
+
+	c := NewClient("localhost:0", "agent-1", router, []TCPTunnel{{Name: "ssh", Target: "localhost:22"}}, nil)
+	c.SetVersion("v1.2.3")
+
+	result, err := c.statusCommand(context.Background(), nil)
+	require.NoError(t, err)
+	status := result.(AgentStatus)
+	assert.Equal(t, "agent-1", status.AgentID)
+	assert.Equal(t, "v1.2.3", status.Version)
+	assert.Equal(t, []string{"ssh"}, status.TCPTunnels)
+	assert.Equal(t, []string{"health_check", "status"}, status.Commands)
+
+	result, err = c.healthCheckCommand(context.Background(), nil)
+	require.NoError(t, err)
+	report := result.(HealthReport)
+	assert.False(t, report.Healthy)
+	require.Len(t, report.Upstreams, 2)
+	for _, u := range report.Upstreams {
+		assert.Equal(t, u.Upstream == healthy.URL, u.Healthy, u.Upstream)
+	}
+}
@@ -0,0 +1,67 @@
+package client
+
+import (
+	"log/slog"
+	"os"
+	"runtime"
+	"slices"
+
+	"github.com/EternisAI/silo-proxy/internal/grpc/protocol"
+	"github.com/EternisAI/silo-proxy/proto"
+	"github.com/google/uuid"
+)
+
+// SetVersion sets the build version reported to the server. It must be
+// called before Start.
+func (c *Client) SetVersion(version string) {
+	c.version = version
+}
+
+// helloMessage is the first message of every stream. Servers that predate
+// the handshake ignore the HELLO and only read its metadata.
+func (c *Client) helloMessage() *proto.ProxyMessage {
+	hostname, err := os.Hostname()
+	if err != nil {
+		slog.Debug("Failed to get hostname", "error", err)
+	}
+
+	return &proto.ProxyMessage{
+		Id:   uuid.New().String(),
+		Type: proto.MessageType_HELLO,
+		Metadata: map[string]string{
+			"agent_id": c.agentID,
+		},
+		Hello: &proto.Hello{
+			ProtocolVersion:    protocol.Version,
+			MinProtocolVersion: protocol.MinVersion,
+			Version:            c.version,
+			Os:                 runtime.GOOS,
+			Arch:               runtime.GOARCH,
+			Hostname:           hostname,
+			Capabilities:       protocol.Capabilities,
+		},
+	}
+}
+
+// handleHelloAck applies what the server agreed to. Until it arrives, and
+// with servers that never send one, the agent sticks to what every server
+// understands. A rejected agent is disconnected by the server right after.
+func (c *Client) handleHelloAck(msg *proto.ProxyMessage) {
+	if reason := msg.Metadata["error"]; reason != "" {
+		slog.Error("Server rejected the agent", "error", reason)
+		return
+	}
+
+	hello := msg.GetHello()
+	capabilities := protocol.NegotiateCapabilities(protocol.Capabilities, hello.GetCapabilities())
+	c.requestHandler.SetLegacyHeaders(!slices.Contains(capabilities, protocol.Headers))
+	flowControl := slices.Contains(capabilities, protocol.FlowControl)
+	c.requestHandler.SetFlowControl(flowControl)
+	c.websocketHandler.SetFlowControl(flowControl)
+	c.tcpHandler.SetFlowControl(flowControl)
+
+	slog.Info("Handshake completed",
+		"protocol_version", hello.GetProtocolVersion(),
+		"server_version", hello.GetVersion(),
+		"capabilities", capabilities)
+}
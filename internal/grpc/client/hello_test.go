@@ -0,0 +1,43 @@
+package client
+
+import (
+	"testing"
+
+	"github.com/EternisAI/silo-proxy/internal/grpc/protocol"
+	"github.com/EternisAI/silo-proxy/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHelloMessage(t *testing.T) {
+	c := NewClient("localhost:0", "agent-1", nil, nil, nil)
+	c.SetVersion("v1.2.3")
+
+	msg := c.helloMessage()
+
+	assert.Equal(t, proto.MessageType_HELLO, msg.Type)
+	assert.Equal(t, "agent-1", msg.Metadata["agent_id"])
+	require.NotNil(t, msg.Hello)
+	assert.Equal(t, uint32(protocol.Version), msg.Hello.ProtocolVersion)
+	assert.Equal(t, uint32(protocol.MinVersion), msg.Hello.MinProtocolVersion)
+	assert.Equal(t, "v1.2.3", msg.Hello.Version)
+	assert.NotEmpty(t, msg.Hello.Os)
+	assert.Equal(t, protocol.Capabilities, msg.Hello.Capabilities)
+}
+
+func TestHandleHelloAck_NegotiatedHeaders(t *testing.T) {
+	c := NewClient("localhost:0", "agent-1", nil, nil, nil)
+	require.True(t, c.requestHandler.legacyHeaders.Load())
+
+	c.handleHelloAck(&proto.ProxyMessage{
+		Type:  proto.MessageType_HELLO_ACK,
+		Hello: &proto.Hello{ProtocolVersion: protocol.Version, Capabilities: []string{protocol.Cancel}},
+	})
+	assert.True(t, c.requestHandler.legacyHeaders.Load())
+
+	c.handleHelloAck(&proto.ProxyMessage{
+		Type:  proto.MessageType_HELLO_ACK,
+		Hello: &proto.Hello{ProtocolVersion: protocol.Version, Capabilities: []string{protocol.Headers}},
+	})
+	assert.False(t, c.requestHandler.legacyHeaders.Load())
+}
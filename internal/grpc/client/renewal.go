@@ -0,0 +1,283 @@
+package client
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/EternisAI/silo-proxy/internal/cert"
+	"github.com/EternisAI/silo-proxy/proto"
+	"github.com/google/uuid"
+)
+
+const (
+	renewCheckInterval   = time.Hour
+	renewResponseTimeout = time.Minute
+)
+
+// errCertRenewed ends the stream so the client reconnects with its renewed
+// certificate.
+var errCertRenewed = errors.New("client certificate renewed")
+
+func (c *Client) renewalEnabled() bool {
+	return c.tlsConfig != nil && c.tlsConfig.Enabled && c.tlsConfig.RenewBefore > 0
+}
+
+// renewLoop checks the client certificate when the stream comes up and every
+// renewCheckInterval after that. Once the certificate is within RenewBefore of
+// expiring it is renewed over the stream, which is then ended so the client
+// reconnects with the new certificate.
+func (c *Client) renewLoop(done chan struct{}, errChan chan error) {
+	if !c.renewalEnabled() {
+		return
+	}
+
+	ticker := time.NewTicker(renewCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		leaf, err := loadCertificate(c.tlsConfig.CertFile)
+		if err != nil {
+			slog.Error("Failed to check client certificate expiry", "error", err)
+		} else if time.Now().After(renewalTime(leaf, c.tlsConfig.RenewBefore)) {
+			slog.Info("Renewing client certificate", "not_after", leaf.NotAfter)
+			if err := c.renewCert(leaf, done); err != nil {
+				slog.Error("Certificate renewal failed", "error", err)
+			} else {
+				errChan <- errCertRenewed
+				return
+			}
+		}
+
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// renewalTime is when certificate should be renewed: renewBefore ahead of its
+// expiry, but never earlier than half way through its lifetime, so that a
+// window longer than the certificate's validity cannot trigger a renewal on
+// every reconnect.
+func renewalTime(certificate *x509.Certificate, renewBefore time.Duration) time.Time {
+	if half := certificate.NotAfter.Sub(certificate.NotBefore) / 2; renewBefore > half {
+		renewBefore = half
+	}
+	return certificate.NotAfter.Add(-renewBefore)
+}
+
+// renewCert requests a certificate for a freshly generated key and replaces
+// the configured key and certificate files with the result.
+func (c *Client) renewCert(current *x509.Certificate, done chan struct{}) error {
+	agentKey, csrPEM, err := cert.GenerateAgentCSR(current.Subject.CommonName)
+	if err != nil {
+		return err
+	}
+
+	id := uuid.New().String()
+	responseCh := make(chan *proto.ProxyMessage, 1)
+
+	c.mu.Lock()
+	c.renewID = id
+	c.renewCh = responseCh
+	c.mu.Unlock()
+
+	defer func() {
+		c.mu.Lock()
+		c.renewID = ""
+		c.renewCh = nil
+		c.mu.Unlock()
+	}()
+
+	if err := c.sendBlocking(&proto.ProxyMessage{
+		Id:       id,
+		Type:     proto.MessageType_CERT_RENEW_REQUEST,
+		Payload:  csrPEM,
+		Metadata: map[string]string{},
+	}); err != nil {
+		return err
+	}
+
+	var response *proto.ProxyMessage
+	select {
+	case response = <-responseCh:
+	case <-time.After(renewResponseTimeout):
+		return fmt.Errorf("timed out waiting for renewed certificate")
+	case <-done:
+		return fmt.Errorf("stream closed before certificate was renewed")
+	}
+
+	if errMsg := response.Metadata["error"]; errMsg != "" {
+		return fmt.Errorf("server refused renewal: %s", errMsg)
+	}
+
+	renewed, err := parseCertificate(response.Payload)
+	if err != nil {
+		return err
+	}
+	if !agentKey.PublicKey.Equal(renewed.PublicKey) {
+		return fmt.Errorf("renewed certificate does not match the generated key")
+	}
+
+	keyPEM, err := cert.KeyToPEM(agentKey)
+	if err != nil {
+		return fmt.Errorf("failed to encode key: %w", err)
+	}
+
+	if err := replaceFiles(
+		stagedFile{path: c.tlsConfig.KeyFile, data: keyPEM, perm: 0600},
+		stagedFile{path: c.tlsConfig.CertFile, data: response.Payload, perm: 0644},
+	); err != nil {
+		return err
+	}
+
+	slog.Info("Client certificate renewed",
+		"serial", renewed.SerialNumber.Text(16),
+		"not_after", renewed.NotAfter,
+		"cert_file", c.tlsConfig.CertFile)
+	return nil
+}
+
+// deliverRenewal hands a CERT_RENEW_RESPONSE to the pending renewal.
+func (c *Client) deliverRenewal(msg *proto.ProxyMessage) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if c.renewCh == nil || msg.Id != c.renewID {
+		slog.Warn("Unexpected certificate renewal response", "message_id", msg.Id)
+		return
+	}
+
+	select {
+	case c.renewCh <- msg:
+	default:
+	}
+}
+
+type stagedFile struct {
+	path string
+	data []byte
+	perm os.FileMode
+}
+
+// replaceFiles writes every file to a temporary file next to its target and
+// only renames them into place once all were written. The renames are not
+// atomic as a set, so if one fails the targets already replaced are restored
+// from their previous contents, which keeps the key and certificate a
+// matching pair.
+func replaceFiles(files ...stagedFile) error {
+	tmpPaths := make([]string, 0, len(files))
+	defer func() {
+		for _, tmpPath := range tmpPaths {
+			os.Remove(tmpPath)
+		}
+	}()
+
+	previous := make([]*stagedFile, 0, len(files))
+	for _, f := range files {
+		prev, err := readExisting(f.path)
+		if err != nil {
+			return err
+		}
+		previous = append(previous, prev)
+
+		tmpPath, err := writeTempFile(f)
+		if err != nil {
+			return err
+		}
+		tmpPaths = append(tmpPaths, tmpPath)
+	}
+
+	for i, f := range files {
+		if err := os.Rename(tmpPaths[i], f.path); err != nil {
+			err = fmt.Errorf("failed to replace %s: %w", f.path, err)
+			for j := i - 1; j >= 0; j-- {
+				err = errors.Join(err, restoreFile(files[j].path, previous[j]))
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// readExisting returns the current contents of path, or nil if it does not
+// exist.
+func readExisting(path string) (*stagedFile, error) {
+	info, err := os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	return &stagedFile{path: path, data: data, perm: info.Mode().Perm()}, nil
+}
+
+// restoreFile puts prev back at path, or removes path if prev is nil.
+func restoreFile(path string, prev *stagedFile) error {
+	if prev == nil {
+		if err := os.Remove(path); err != nil {
+			return fmt.Errorf("failed to restore %s: %w", path, err)
+		}
+		return nil
+	}
+	tmpPath, err := writeTempFile(*prev)
+	if err != nil {
+		return fmt.Errorf("failed to restore %s: %w", path, err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to restore %s: %w", path, err)
+	}
+	return nil
+}
+
+func writeTempFile(f stagedFile) (string, error) {
+	tmp, err := os.CreateTemp(filepath.Dir(f.path), "."+filepath.Base(f.path)+".*")
+	if err != nil {
+		return "", fmt.Errorf("failed to create temporary file for %s: %w", f.path, err)
+	}
+
+	_, err = tmp.Write(f.data)
+	if err == nil {
+		err = tmp.Chmod(f.perm)
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", fmt.Errorf("failed to write temporary file for %s: %w", f.path, err)
+	}
+	return tmp.Name(), nil
+}
+
+func loadCertificate(path string) (*x509.Certificate, error) {
+	certPEM, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read certificate: %w", err)
+	}
+	return parseCertificate(certPEM)
+}
+
+func parseCertificate(certPEM []byte) (*x509.Certificate, error) {
+	block, _ := pem.Decode(certPEM)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("failed to decode certificate PEM")
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
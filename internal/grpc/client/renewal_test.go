@@ -0,0 +1,109 @@
+package client
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/EternisAI/silo-proxy/internal/cert"
+	"github.com/EternisAI/silo-proxy/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRenewCert(t *testing.T) {
+	dir := t.TempDir()
+	cs, err := cert.New(
+		filepath.Join(dir, "ca-cert.pem"),
+		filepath.Join(dir, "ca-key.pem"),
+		filepath.Join(dir, "server-cert.pem"),
+		filepath.Join(dir, "server-key.pem"),
+		filepath.Join(dir, "agents"),
+		"localhost",
+		"127.0.0.1",
+	)
+	require.NoError(t, err)
+	_, _, err = cs.GenerateAgentCert("agent-1")
+	require.NoError(t, err)
+
+	// The agent keeps its own copy of the credentials.
+	agentDir := t.TempDir()
+	certPEM, keyPEM, err := cs.GetAgentCert("agent-1")
+	require.NoError(t, err)
+	tlsConfig := &TLSConfig{
+		Enabled:     true,
+		CertFile:    filepath.Join(agentDir, "agent-1-cert.pem"),
+		KeyFile:     filepath.Join(agentDir, "agent-1-key.pem"),
+		CAFile:      cs.CaCertPath,
+		RenewBefore: 2 * 365 * 24 * time.Hour,
+	}
+	require.NoError(t, os.WriteFile(tlsConfig.CertFile, certPEM, 0644))
+	require.NoError(t, os.WriteFile(tlsConfig.KeyFile, keyPEM, 0600))
+	c := NewClient("localhost:0", "agent-1", nil, nil, tlsConfig)
+	defer c.cancel()
+
+	current, err := loadCertificate(tlsConfig.CertFile)
+	require.NoError(t, err)
+
+	// Answer the renewal request as the server would.
+	go func() {
+		request := <-c.sendCh
+		response := &proto.ProxyMessage{Id: request.Id, Metadata: map[string]string{}}
+		renewed, err := cs.RenewAgentCert("agent-1", request.Payload)
+		if err == nil {
+			response.Payload, err = cert.CertToPEM(renewed)
+		}
+		if err != nil {
+			response.Metadata["error"] = err.Error()
+		}
+		c.deliverRenewal(response)
+	}()
+
+	require.NoError(t, c.renewCert(current, make(chan struct{})))
+
+	renewed, err := loadCertificate(tlsConfig.CertFile)
+	require.NoError(t, err)
+	assert.NotEqual(t, current.SerialNumber, renewed.SerialNumber)
+	assert.Equal(t, "agent-1", renewed.Subject.CommonName)
+
+	_, err = tls.LoadX509KeyPair(tlsConfig.CertFile, tlsConfig.KeyFile)
+	assert.NoError(t, err, "renewed key and certificate must match")
+
+	entries, err := os.ReadDir(agentDir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 2, "temporary files must be cleaned up")
+}
+
+func TestRenewalTime(t *testing.T) {
+	issued := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	leaf := &x509.Certificate{NotBefore: issued, NotAfter: issued.Add(100 * 24 * time.Hour)}
+
+	assert.Equal(t, issued.Add(70*24*time.Hour), renewalTime(leaf, 30*24*time.Hour))
+	assert.Equal(t, issued.Add(50*24*time.Hour), renewalTime(leaf, 365*24*time.Hour))
+}
+
+func TestReplaceFiles_RollsBackOnFailure(t *testing.T) {
+	dir := t.TempDir()
+	keyPath := filepath.Join(dir, "agent-key.pem")
+	certPath := filepath.Join(dir, "agent-cert.pem")
+	require.NoError(t, os.WriteFile(keyPath, []byte("old key"), 0600))
+	// A non-empty directory cannot be replaced by a rename.
+	require.NoError(t, os.MkdirAll(filepath.Join(certPath, "blocker"), 0755))
+
+	err := replaceFiles(
+		stagedFile{path: keyPath, data: []byte("new key"), perm: 0600},
+		stagedFile{path: certPath, data: []byte("new cert"), perm: 0644},
+	)
+	require.Error(t, err)
+
+	key, err := os.ReadFile(keyPath)
+	require.NoError(t, err)
+	assert.Equal(t, "old key", string(key))
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 2, "temporary files are removed")
+}
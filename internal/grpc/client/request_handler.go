@@ -1,93 +1,500 @@
 package client
 
 import (
-	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
 	"net/http"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/EternisAI/silo-proxy/internal/grpc/chunk"
+	"github.com/EternisAI/silo-proxy/internal/grpc/headers"
+	"github.com/EternisAI/silo-proxy/internal/metrics"
 	"github.com/EternisAI/silo-proxy/proto"
 )
 
+// Defaults of Timeouts.ResponseHeader and Timeouts.BodyIdle.
+const (
+	localResponseTimeout = 30 * time.Second
+	bodyIdleTimeout      = 30 * time.Second
+)
+
 type RequestHandler struct {
 	httpClient *http.Client
-	localURL   string
+	router     *Router
+	send       chunk.SendFunc
+
+	responseHeaderTimeout time.Duration
+	bodyIdleTimeout       time.Duration
+
+	// legacyHeaders also sends response headers as "header_" metadata, for
+	// servers that have not negotiated protocol.Headers.
+	legacyHeaders atomic.Bool
+	// flowControl exchanges WINDOW_UPDATE credit for both bodies of a
+	// request, with servers that negotiated protocol.FlowControl.
+	flowControl atomic.Bool
+
+	inflight   map[string]*inflightRequest
+	inflightMu sync.Mutex
+
+	// jobs feeds the worker pool; nil runs every request in its own goroutine.
+	jobs chan func()
+}
+
+// inflightRequest is a request that has been started and not finished yet.
+// cancel ends its context with errBodyLost as the cause if its body failed.
+// window is nil unless the response body is flow controlled.
+type inflightRequest struct {
+	body   *chunk.Reader
+	window *chunk.Window
+	cancel context.CancelCauseFunc
 }
 
-func NewRequestHandler(localURL string) *RequestHandler {
-	return &RequestHandler{
+var (
+	errBusy = errors.New("agent is busy: too many requests in progress")
+	// errBodyLost aborts requests whose body could not be delivered whole.
+	errBodyLost = errors.New("request body lost")
+)
+
+// NewRequestHandler creates a handler that forwards requests to the upstream
+// chosen by router and streams responses back through send, which must block
+// until a frame is queued.
+func NewRequestHandler(router *Router, send chunk.SendFunc) *RequestHandler {
+	// No overall client timeout: bodies may take arbitrarily long to stream,
+	// so only the wait for response headers is bounded, per request.
+	rh := &RequestHandler{
 		httpClient: &http.Client{
-			Timeout: 30 * time.Second,
+			Transport: http.DefaultTransport.(*http.Transport).Clone(),
+			// Redirects are the client's to follow: the Location is relative
+			// to the proxied URL, not to the local service.
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
 		},
-		localURL: localURL,
+		router:                router,
+		send:                  send,
+		responseHeaderTimeout: localResponseTimeout,
+		bodyIdleTimeout:       bodyIdleTimeout,
+		inflight:              make(map[string]*inflightRequest),
+	}
+	rh.legacyHeaders.Store(true)
+	return rh
+}
+
+// SetTimeouts replaces how long to wait for the local service's response
+// headers when the server sends no timeout with a request, and for the next
+// slice of a request body. Zero keeps the current value. It must be called
+// before the first request.
+func (rh *RequestHandler) SetTimeouts(responseHeader, bodyIdle time.Duration) {
+	if responseHeader > 0 {
+		rh.responseHeaderTimeout = responseHeader
+	}
+	if bodyIdle > 0 {
+		rh.bodyIdleTimeout = bodyIdle
+	}
+}
+
+// SetLegacyHeaders sets whether response headers are also sent as "header_"
+// metadata entries, which is all older servers read. It is on by default.
+func (rh *RequestHandler) SetLegacyHeaders(enabled bool) {
+	rh.legacyHeaders.Store(enabled)
+}
+
+// SetFlowControl sets whether request and response bodies are flow
+// controlled with WINDOW_UPDATE frames. It is off by default, and applies to
+// requests started afterwards.
+func (rh *RequestHandler) SetFlowControl(enabled bool) {
+	rh.flowControl.Store(enabled)
+}
+
+// StartWorkers limits the requests sent to the local services at once to
+// workers. Up to queueSize more wait for a free worker; any beyond that are
+// answered with 503. The workers stop when ctx is done. It must be called
+// before the first request.
+func (rh *RequestHandler) StartWorkers(ctx context.Context, workers, queueSize int) {
+	rh.jobs = make(chan func(), queueSize)
+	for i := 0; i < workers; i++ {
+		go func() {
+			for {
+				select {
+				case job := <-rh.jobs:
+					job()
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+	slog.Info("Request worker pool started", "workers", workers, "queue_size", queueSize)
+}
+
+// StartRequest begins forwarding a request announced by a REQUEST_START frame.
+// The request body is fed by subsequent HandleBodyFrame calls while the local
+// service is already processing the request. Body frames of a queued request
+// are buffered until a worker picks it up. With flow control the server then
+// waits for credit, which is granted as the body is read; without it, a
+// request whose body outgrows chunk.MaxBuffered is answered with 502 instead.
+// The request runs until it is finished or a CANCEL frame for it arrives.
+func (rh *RequestHandler) StartRequest(msg *proto.ProxyMessage) {
+	ctx, cancel := context.WithCancelCause(context.Background())
+	body := chunk.NewReader(rh.bodyIdleTimeout)
+
+	var window *chunk.Window
+	if rh.flowControl.Load() {
+		window = chunk.NewWindow()
+		// A response waiting for credit gives up with the request.
+		context.AfterFunc(ctx, window.Close)
+		body.SetAcker(chunk.NewAcker(func(n int) {
+			if err := rh.send(chunk.WindowUpdate(msg.Id, n)); err != nil {
+				slog.Debug("Failed to send WINDOW_UPDATE", "message_id", msg.Id, "error", err)
+			}
+		}))
+	}
+
+	rh.inflightMu.Lock()
+	rh.inflight[msg.Id] = &inflightRequest{body: body, window: window, cancel: cancel}
+	rh.inflightMu.Unlock()
+
+	finish := func() {
+		rh.inflightMu.Lock()
+		delete(rh.inflight, msg.Id)
+		rh.inflightMu.Unlock()
+		cancel(nil)
+		body.Close()
+	}
+
+	job := func() {
+		defer finish()
+
+		err := rh.HandleRequest(ctx, msg, body)
+		if errors.Is(err, context.Canceled) {
+			slog.Info("Request cancelled", "message_id", msg.Id)
+		} else if err != nil {
+			slog.Error("Failed to handle request", "error", err, "message_id", msg.Id)
+		}
+	}
+
+	if rh.jobs == nil {
+		go job()
+		return
+	}
+
+	select {
+	case rh.jobs <- job:
+	default:
+		finish()
+		metrics.AgentRejectedRequests.Inc()
+		slog.Warn("Rejecting request: worker pool full", "message_id", msg.Id)
+		// The receive loop must not wait for room in the send channel.
+		go rh.sendError(msg.Id, http.StatusServiceUnavailable, errBusy)
+	}
+}
+
+// HandleBodyFrame delivers a BODY_CHUNK or BODY_END frame to the in-flight
+// request it belongs to. It never blocks: a request whose body cannot take
+// the frame is aborted.
+func (rh *RequestHandler) HandleBodyFrame(msg *proto.ProxyMessage) {
+	rh.inflightMu.Lock()
+	req, ok := rh.inflight[msg.Id]
+	rh.inflightMu.Unlock()
+
+	if !ok {
+		slog.Debug("Body frame for unknown or finished request", "message_id", msg.Id, "type", msg.Type)
+		return
+	}
+
+	err := req.body.Push(msg)
+	switch {
+	case err == nil:
+	case errors.Is(err, chunk.ErrClosed):
+		slog.Debug("Dropping body frame", "message_id", msg.Id, "error", err)
+	default:
+		slog.Warn("Request body lost, aborting request", "message_id", msg.Id, "error", err)
+		req.cancel(fmt.Errorf("%w: %w", errBodyLost, err))
+	}
+}
+
+// HandleWindowUpdate grants the credit of a WINDOW_UPDATE frame to the
+// response body of the in-flight request it names. It returns false if no
+// such request is in flight.
+func (rh *RequestHandler) HandleWindowUpdate(msg *proto.ProxyMessage) bool {
+	rh.inflightMu.Lock()
+	req, ok := rh.inflight[msg.Id]
+	rh.inflightMu.Unlock()
+
+	if ok {
+		req.window.Grant(chunk.Granted(msg))
+	}
+	return ok
+}
+
+// Cancel aborts the request a CANCEL frame refers to. The call to the local
+// service is interrupted and nothing more is sent for the request.
+func (rh *RequestHandler) Cancel(msg *proto.ProxyMessage) {
+	rh.inflightMu.Lock()
+	req, ok := rh.inflight[msg.Id]
+	rh.inflightMu.Unlock()
+
+	if !ok {
+		slog.Debug("CANCEL for unknown or finished request", "message_id", msg.Id)
+		return
+	}
+
+	slog.Info("Cancelling request", "message_id", msg.Id, "reason", msg.Metadata["reason"])
+	req.cancel(nil)
+	req.body.Close()
+}
+
+// InFlight returns the number of requests started and not finished yet.
+func (rh *RequestHandler) InFlight() int {
+	rh.inflightMu.Lock()
+	defer rh.inflightMu.Unlock()
+	return len(rh.inflight)
+}
+
+// CancelAll aborts every in-flight request. It is used when the stream is
+// lost, since the server has given up on their responses.
+func (rh *RequestHandler) CancelAll() {
+	rh.inflightMu.Lock()
+	defer rh.inflightMu.Unlock()
+
+	for _, req := range rh.inflight {
+		req.cancel(nil)
+		req.body.Close()
 	}
 }
 
-func (rh *RequestHandler) HandleRequest(msg *proto.ProxyMessage) (*proto.ProxyMessage, error) {
+// HandleRequest forwards a single request to the local service and streams the
+// response back as RESPONSE_START, BODY_CHUNK and BODY_END frames, within the
+// credit of the request's window if it is flow controlled. Once ctx is done
+// the call is aborted and, unless its body was lost, no further frames are
+// sent.
+func (rh *RequestHandler) HandleRequest(ctx context.Context, msg *proto.ProxyMessage, body io.Reader) error {
+	if ctx.Err() != nil {
+		return rh.aborted(ctx, msg.Id, false)
+	}
+	send := func(frame *proto.ProxyMessage) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		return rh.send(frame)
+	}
+
 	method := msg.Metadata["method"]
+	host := msg.Metadata["host"]
 	path := msg.Metadata["path"]
-	query := msg.Metadata["query"]
 
-	url := rh.localURL + path
-	if query != "" {
-		url += "?" + query
+	route, ok := rh.router.Match(host, path)
+	if !ok {
+		slog.Warn("No route for request", "message_id", msg.Id, "host", host, "path", path)
+		return rh.sendError(msg.Id, http.StatusNotFound, fmt.Errorf("no route for host %q and path %q", host, path))
 	}
+	url := route.TargetURL(path, msg.Metadata["query"])
 
 	slog.Info("Forwarding request to local service",
 		"message_id", msg.Id,
 		"method", method,
 		"url", url)
 
-	req, err := http.NewRequest(method, url, bytes.NewReader(msg.Payload))
-	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
+	contentLength := int64(-1)
+	if cl, ok := msg.Metadata["content_length"]; ok {
+		if parsed, err := strconv.ParseInt(cl, 10, 64); err == nil {
+			contentLength = parsed
+		}
+	}
+	if contentLength == 0 {
+		body = http.NoBody
 	}
 
-	for key, value := range msg.Metadata {
-		if strings.HasPrefix(key, "header_") {
-			headerName := strings.TrimPrefix(key, "header_")
-			req.Header.Set(headerName, value)
-		}
+	// The call is aborted if the headers take longer than the timeout the
+	// server waits for them; the body may then take as long as it needs.
+	headerTimeout := rh.responseHeaderTimeout
+	if ms, err := strconv.ParseInt(msg.Metadata["timeout_ms"], 10, 64); err == nil && ms > 0 {
+		headerTimeout = time.Duration(ms) * time.Millisecond
+	}
+	callCtx, cancelCall := context.WithCancel(ctx)
+	defer cancelCall()
+
+	header := headers.FromMessage(msg)
+	trailer := headers.Announced(header)
+	headers.RemoveHopByHop(header)
+	if r, ok := body.(trailerReader); ok && trailer != nil {
+		body = &requestTrailerBody{Reader: body, source: r, trailer: trailer}
 	}
 
+	req, err := http.NewRequestWithContext(callCtx, method, url, body)
+	if err != nil {
+		return rh.sendError(msg.Id, http.StatusBadGateway, fmt.Errorf("failed to create request: %w", err))
+	}
+	req.ContentLength = contentLength
+	req.Header = header
+	req.Trailer = trailer
+
 	if contentType, ok := msg.Metadata["content_type"]; ok && contentType != "" {
 		req.Header.Set("Content-Type", contentType)
 	}
 
+	headerTimer := time.AfterFunc(headerTimeout, cancelCall)
 	resp, err := rh.httpClient.Do(req)
+	headersInTime := headerTimer.Stop()
+	if ctx.Err() != nil {
+		if resp != nil {
+			resp.Body.Close()
+		}
+		return rh.aborted(ctx, msg.Id, false)
+	}
+	if !headersInTime {
+		if resp != nil {
+			resp.Body.Close()
+		}
+		return rh.sendError(msg.Id, http.StatusGatewayTimeout, fmt.Errorf("local service did not respond within %s", headerTimeout))
+	}
 	if err != nil {
-		return nil, fmt.Errorf("failed to execute request: %w", err)
+		return rh.sendError(msg.Id, http.StatusBadGateway, fmt.Errorf("failed to execute request: %w", err))
 	}
 	defer resp.Body.Close()
 
-	body, err := io.ReadAll(resp.Body)
+	responseHeader := resp.Header.Clone()
+	headers.RemoveHopByHop(responseHeader)
+	headers.DeclareTrailer(responseHeader, resp.Trailer)
+
+	// The body is forwarded as the transport decoded it, which differs from
+	// what the local service declared if it was compressed. Only a length
+	// that still holds is passed on.
+	if _, declared := resp.Header["Content-Length"]; declared && resp.ContentLength >= 0 {
+		responseHeader.Set("Content-Length", strconv.FormatInt(resp.ContentLength, 10))
+	} else {
+		responseHeader.Del("Content-Length")
+	}
+
+	startMsg := &proto.ProxyMessage{
+		Id:   msg.Id,
+		Type: proto.MessageType_RESPONSE_START,
+		Metadata: map[string]string{
+			"status_code": strconv.Itoa(resp.StatusCode),
+		},
+		Headers: headers.ToProto(responseHeader),
+	}
+	if rh.legacyHeaders.Load() {
+		headers.SetLegacy(startMsg.Metadata, responseHeader)
+	}
+
+	if err := send(startMsg); err != nil {
+		if ctx.Err() != nil {
+			return rh.aborted(ctx, msg.Id, false)
+		}
+		return fmt.Errorf("failed to send response start: %w", err)
+	}
+
+	written, err := chunk.SendWithTrailer(msg.Id, resp.Body, func() []*proto.Header {
+		return headers.ToProto(resp.Trailer)
+	}, rh.window(msg.Id).Throttle(send))
 	if err != nil {
-		return nil, fmt.Errorf("failed to read response body: %w", err)
+		if ctx.Err() != nil {
+			return rh.aborted(ctx, msg.Id, true)
+		}
+		return fmt.Errorf("failed to stream response body: %w", err)
 	}
 
 	slog.Info("Received response from local service",
 		"message_id", msg.Id,
 		"status_code", resp.StatusCode,
-		"content_length", len(body))
+		"content_length", written)
+
+	return nil
+}
+
+// window returns the flow control window of in-flight request id, or nil if
+// it has none.
+func (rh *RequestHandler) window(id string) *chunk.Window {
+	rh.inflightMu.Lock()
+	defer rh.inflightMu.Unlock()
+
+	if req, ok := rh.inflight[id]; ok {
+		return req.window
+	}
+	return nil
+}
+
+// aborted finishes request id once ctx is done. A request cancelled by the
+// server gets no answer. One whose body was lost is answered with 502, or has
+// its response body ended with the error if the response had started, so
+// that the server does not wait for it.
+func (rh *RequestHandler) aborted(ctx context.Context, id string, started bool) error {
+	cause := context.Cause(ctx)
+	if !errors.Is(cause, errBodyLost) {
+		return ctx.Err()
+	}
+	if !started {
+		return rh.sendError(id, http.StatusBadGateway, cause)
+	}
+
+	if err := rh.send(&proto.ProxyMessage{
+		Id:       id,
+		Type:     proto.MessageType_BODY_END,
+		Metadata: map[string]string{"error": cause.Error()},
+	}); err != nil {
+		slog.Error("Failed to send body end", "error", err, "message_id", id)
+	}
+	return cause
+}
 
-	responseMsg := &proto.ProxyMessage{
-		Id:      msg.Id,
-		Type:    proto.MessageType_RESPONSE,
-		Payload: body,
+// sendError answers a request that never reached the local service with
+// statusCode and the cause as a plain-text body.
+func (rh *RequestHandler) sendError(id string, statusCode int, cause error) error {
+	errorResponse := &proto.ProxyMessage{
+		Id:   id,
+		Type: proto.MessageType_RESPONSE_START,
 		Metadata: map[string]string{
-			"status_code": strconv.Itoa(resp.StatusCode),
+			"status_code": strconv.Itoa(statusCode),
+			"error":       cause.Error(),
+		},
+		Headers: []*proto.Header{
+			{Name: "Content-Type", Values: []string{"text/plain; charset=utf-8"}},
 		},
 	}
+	if rh.legacyHeaders.Load() {
+		errorResponse.Metadata["header_Content-Type"] = "text/plain; charset=utf-8"
+	}
 
-	for key, values := range resp.Header {
-		if len(values) > 0 {
-			responseMsg.Metadata["header_"+key] = values[0]
-		}
+	if err := rh.send(errorResponse); err != nil {
+		slog.Error("Failed to send error response", "error", err, "message_id", id)
+		return cause
 	}
 
-	return responseMsg, nil
+	if _, err := chunk.Send(id, strings.NewReader(cause.Error()), rh.send); err != nil {
+		slog.Error("Failed to send error response body", "error", err, "message_id", id)
+	}
+
+	return cause
+}
+
+// trailerReader is a request body whose trailers arrive with its end, such as
+// a *chunk.Reader.
+type trailerReader interface {
+	Trailer() []*proto.Header
+}
+
+// requestTrailerBody fills in the announced trailers of a request to the local
+// service once its body has been read, which is when the transport sends them.
+type requestTrailerBody struct {
+	io.Reader
+	source  trailerReader
+	trailer http.Header
+}
+
+func (b *requestTrailerBody) Read(p []byte) (int, error) {
+	n, err := b.Reader.Read(p)
+	if err == io.EOF {
+		for name, values := range headers.FromProto(b.source.Trailer()) {
+			b.trailer[name] = values
+		}
+	}
+	return n, err
 }
@@ -0,0 +1,499 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/EternisAI/silo-proxy/internal/grpc/chunk"
+	"github.com/EternisAI/silo-proxy/internal/grpc/headers"
+	"github.com/EternisAI/silo-proxy/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// collectResponse runs msg through a RequestHandler and returns the response
+// start frame and the reassembled body.
+func collectResponse(t *testing.T, router *Router, msg *proto.ProxyMessage) (*proto.ProxyMessage, string) {
+	var frames []*proto.ProxyMessage
+	rh := NewRequestHandler(router, func(frame *proto.ProxyMessage) error {
+		frames = append(frames, frame)
+		return nil
+	})
+
+	_ = rh.HandleRequest(context.Background(), msg, http.NoBody)
+	require.NotEmpty(t, frames)
+	require.Equal(t, proto.MessageType_RESPONSE_START, frames[0].Type)
+
+	body := chunk.NewReader(0)
+	for _, frame := range frames[1:] {
+		require.NoError(t, body.Push(frame))
+	}
+	data, err := io.ReadAll(body)
+	require.NoError(t, err)
+
+	return frames[0], string(data)
+}
+
+func TestHandleRequest_RoutesToUpstream(t *testing.T) {
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "api %s?%s", r.URL.Path, r.URL.RawQuery)
+	}))
+	defer api.Close()
+
+	router, err := NewRouter([]Route{{PathPrefix: "/api", Upstream: api.URL, StripPrefix: true}})
+	require.NoError(t, err)
+
+	start, body := collectResponse(t, router, &proto.ProxyMessage{
+		Id:   "req-1",
+		Type: proto.MessageType_REQUEST_START,
+		Metadata: map[string]string{
+			"method": "GET",
+			"host":   "example.com",
+			"path":   "/api/users",
+			"query":  "page=2",
+		},
+	})
+
+	assert.Equal(t, "200", start.Metadata["status_code"])
+	assert.Equal(t, "api /users?page=2", body)
+}
+
+func TestHandleRequest_NoRoute(t *testing.T) {
+	router, err := NewRouter([]Route{{PathPrefix: "/api", Upstream: "http://localhost:1"}})
+	require.NoError(t, err)
+
+	start, body := collectResponse(t, router, &proto.ProxyMessage{
+		Id:   "req-1",
+		Type: proto.MessageType_REQUEST_START,
+		Metadata: map[string]string{
+			"method": "GET",
+			"host":   "example.com",
+			"path":   "/admin",
+		},
+	})
+
+	assert.Equal(t, "404", start.Metadata["status_code"])
+	assert.Contains(t, body, `no route for host "example.com" and path "/admin"`)
+}
+
+func TestHandleRequest_PassesRedirectsThrough(t *testing.T) {
+	local := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/login", http.StatusFound)
+	}))
+	defer local.Close()
+
+	router, err := NewRouter([]Route{{PathPrefix: "/", Upstream: local.URL}})
+	require.NoError(t, err)
+
+	start, _ := collectResponse(t, router, &proto.ProxyMessage{
+		Id:   "req-1",
+		Type: proto.MessageType_REQUEST_START,
+		Metadata: map[string]string{
+			"method": "GET",
+			"host":   "example.com",
+			"path":   "/dashboard",
+		},
+	})
+
+	assert.Equal(t, "302", start.Metadata["status_code"])
+	assert.Equal(t, "/login", headers.FromMessage(start).Get("Location"))
+}
+
+func TestHandleRequest_KeepsRepeatedHeaders(t *testing.T) {
+	local := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header()["X-Seen"] = r.Header.Values("Accept")
+		w.Header().Add("Set-Cookie", "session=abc; Path=/")
+		w.Header().Add("Set-Cookie", "csrf=xyz; Path=/")
+	}))
+	defer local.Close()
+
+	router, err := NewRouter([]Route{{PathPrefix: "/", Upstream: local.URL}})
+	require.NoError(t, err)
+
+	start, _ := collectResponse(t, router, &proto.ProxyMessage{
+		Id:   "req-1",
+		Type: proto.MessageType_REQUEST_START,
+		Metadata: map[string]string{
+			"method": "GET",
+			"host":   "example.com",
+			"path":   "/login",
+		},
+		Headers: []*proto.Header{{Name: "Accept", Values: []string{"text/html", "application/json"}}},
+	})
+
+	header := headers.FromMessage(start)
+	assert.Equal(t, []string{"session=abc; Path=/", "csrf=xyz; Path=/"}, header.Values("Set-Cookie"))
+	assert.Equal(t, []string{"text/html", "application/json"}, header.Values("X-Seen"))
+	assert.Equal(t, "session=abc; Path=/", start.Metadata["header_Set-Cookie"], "older servers get the first value")
+}
+
+func TestHandleRequest_AcceptsLegacyHeaderMetadata(t *testing.T) {
+	local := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.Header.Get("X-Api-Key"))
+	}))
+	defer local.Close()
+
+	router, err := NewRouter([]Route{{PathPrefix: "/", Upstream: local.URL}})
+	require.NoError(t, err)
+
+	_, body := collectResponse(t, router, &proto.ProxyMessage{
+		Id:   "req-1",
+		Type: proto.MessageType_REQUEST_START,
+		Metadata: map[string]string{
+			"method":           "GET",
+			"host":             "example.com",
+			"path":             "/",
+			"header_X-Api-Key": "secret",
+		},
+	})
+
+	assert.Equal(t, "secret", body)
+}
+
+func TestHandleRequest_ForwardsTrailers(t *testing.T) {
+	local := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+		w.Header().Set("Trailer", "X-Checksum")
+		fmt.Fprint(w, "data")
+		w.Header().Set("X-Checksum", r.Trailer.Get("X-Request-Checksum")+"-ok")
+	}))
+	defer local.Close()
+
+	router, err := NewRouter([]Route{{PathPrefix: "/", Upstream: local.URL}})
+	require.NoError(t, err)
+
+	var frames []*proto.ProxyMessage
+	rh := NewRequestHandler(router, func(frame *proto.ProxyMessage) error {
+		frames = append(frames, frame)
+		return nil
+	})
+
+	body := chunk.NewReader(time.Second)
+	require.NoError(t, body.Push(&proto.ProxyMessage{Type: proto.MessageType_BODY_CHUNK, Payload: []byte("upload")}))
+	require.NoError(t, body.Push(&proto.ProxyMessage{
+		Type:     proto.MessageType_BODY_END,
+		Trailers: []*proto.Header{{Name: "X-Request-Checksum", Values: []string{"abc"}}},
+	}))
+
+	require.NoError(t, rh.HandleRequest(context.Background(), &proto.ProxyMessage{
+		Id:   "req-1",
+		Type: proto.MessageType_REQUEST_START,
+		Metadata: map[string]string{
+			"method": "POST",
+			"host":   "example.com",
+			"path":   "/upload",
+		},
+		Headers: []*proto.Header{{Name: "Trailer", Values: []string{"X-Request-Checksum"}}},
+	}, body))
+
+	require.NotEmpty(t, frames)
+	assert.Equal(t, "X-Checksum", headers.FromMessage(frames[0]).Get("Trailer"))
+	end := frames[len(frames)-1]
+	require.Equal(t, proto.MessageType_BODY_END, end.Type)
+	assert.Equal(t, "abc-ok", headers.FromProto(end.Trailers).Get("X-Checksum"))
+}
+
+func TestStartRequest_WorkerPoolFull(t *testing.T) {
+	release := make(chan struct{})
+	local := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		fmt.Fprint(w, "ok")
+	}))
+	defer local.Close()
+	defer close(release)
+
+	router, err := NewRouter([]Route{{PathPrefix: "/", Upstream: local.URL}})
+	require.NoError(t, err)
+
+	var mu sync.Mutex
+	statuses := map[string]string{}
+	rh := NewRequestHandler(router, func(frame *proto.ProxyMessage) error {
+		if frame.Type == proto.MessageType_RESPONSE_START {
+			mu.Lock()
+			statuses[frame.Id] = frame.Metadata["status_code"]
+			mu.Unlock()
+		}
+		return nil
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	rh.StartWorkers(ctx, 1, 1)
+
+	for _, id := range []string{"running", "queued", "rejected"} {
+		rh.StartRequest(&proto.ProxyMessage{
+			Id:   id,
+			Type: proto.MessageType_REQUEST_START,
+			Metadata: map[string]string{
+				"method":         "GET",
+				"host":           "example.com",
+				"path":           "/",
+				"content_length": "0",
+			},
+		})
+		if id == "running" {
+			// Let the worker take the first request off the queue
+			require.Eventually(t, func() bool { return len(rh.jobs) == 0 }, time.Second, time.Millisecond)
+		}
+	}
+
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return statuses["rejected"] != ""
+	}, time.Second, time.Millisecond)
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, "503", statuses["rejected"])
+	assert.Empty(t, statuses["running"])
+	assert.Empty(t, statuses["queued"])
+}
+
+// queueBehindBusyWorker starts a one-worker pool busy with a request that
+// waits for release, then queues a POST of body as request "queued", flow
+// controlled if flowControl is set. It returns the frames sent for "queued",
+// and how many bytes the local service read for it.
+func queueBehindBusyWorker(t *testing.T, body []byte, flowControl bool) (<-chan []*proto.ProxyMessage, *int64, chan struct{}) {
+	release := make(chan struct{})
+	var received int64
+	local := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/busy" {
+			<-release
+			return
+		}
+		n, _ := io.Copy(io.Discard, r.Body)
+		received = n
+		fmt.Fprint(w, n)
+	}))
+	t.Cleanup(local.Close)
+
+	router, err := NewRouter([]Route{{PathPrefix: "/", Upstream: local.URL}})
+	require.NoError(t, err)
+
+	var mu sync.Mutex
+	var frames []*proto.ProxyMessage
+	done := make(chan []*proto.ProxyMessage, 1)
+	window := chunk.NewWindow()
+	rh := NewRequestHandler(router, func(frame *proto.ProxyMessage) error {
+		if frame.Id != "queued" {
+			return nil
+		}
+		if frame.Type == proto.MessageType_WINDOW_UPDATE {
+			window.Grant(chunk.Granted(frame))
+			return nil
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		frames = append(frames, frame)
+		if frame.Type == proto.MessageType_BODY_END {
+			done <- frames
+		}
+		return nil
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	rh.StartWorkers(ctx, 1, 1)
+	rh.SetFlowControl(flowControl)
+
+	send := func(frame *proto.ProxyMessage) error {
+		rh.HandleBodyFrame(frame)
+		return nil
+	}
+	if flowControl {
+		send = window.Throttle(send)
+	}
+
+	start := func(id, path string, length int) {
+		rh.StartRequest(&proto.ProxyMessage{
+			Id:   id,
+			Type: proto.MessageType_REQUEST_START,
+			Metadata: map[string]string{
+				"method":         "POST",
+				"host":           "example.com",
+				"path":           path,
+				"content_length": fmt.Sprint(length),
+			},
+		})
+	}
+	start("busy", "/busy", 0)
+	require.Eventually(t, func() bool { return len(rh.jobs) == 0 }, time.Second, time.Millisecond)
+	start("queued", "/upload", len(body))
+
+	// Without flow control the receive loop delivers the whole body while the
+	// request waits. With it, the sender pauses once its window is spent.
+	delivered := make(chan struct{})
+	go func() {
+		defer close(delivered)
+		_, _ = chunk.Send("queued", bytes.NewReader(body), send)
+	}()
+	if flowControl {
+		select {
+		case <-delivered:
+			t.Fatal("the sender did not wait for credit")
+		case <-time.After(100 * time.Millisecond):
+		}
+		return done, &received, release
+	}
+	select {
+	case <-delivered:
+	case <-time.After(time.Second):
+		t.Fatal("delivering the body of a queued request blocked")
+	}
+
+	return done, &received, release
+}
+
+func TestStartRequest_QueuedRequestKeepsBody(t *testing.T) {
+	body := bytes.Repeat([]byte("x"), 40*chunk.Size)
+	done, received, release := queueBehindBusyWorker(t, body, false)
+	close(release)
+
+	frames := <-done
+	require.Equal(t, proto.MessageType_RESPONSE_START, frames[0].Type)
+	assert.Equal(t, "200", frames[0].Metadata["status_code"])
+	assert.Equal(t, int64(len(body)), *received)
+}
+
+func TestStartRequest_QueuedFlowControlledBodyWaitsForCredit(t *testing.T) {
+	body := bytes.Repeat([]byte("x"), 2*chunk.MaxBuffered)
+	done, received, release := queueBehindBusyWorker(t, body, true)
+	close(release)
+
+	frames := <-done
+	require.Equal(t, proto.MessageType_RESPONSE_START, frames[0].Type)
+	assert.Equal(t, "200", frames[0].Metadata["status_code"])
+	assert.Equal(t, int64(len(body)), *received)
+}
+
+func TestStartRequest_QueuedBodyOverflowWithoutFlowControl(t *testing.T) {
+	body := bytes.Repeat([]byte("x"), chunk.MaxBuffered+chunk.Size)
+	done, received, release := queueBehindBusyWorker(t, body, false)
+	close(release)
+
+	frames := <-done
+	require.Equal(t, proto.MessageType_RESPONSE_START, frames[0].Type)
+	assert.Equal(t, "502", frames[0].Metadata["status_code"])
+	assert.Zero(t, *received, "a truncated body never reaches the local service")
+}
+
+func TestCancel_AbortsLocalCall(t *testing.T) {
+	started := make(chan struct{})
+	aborted := make(chan struct{})
+	local := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-r.Context().Done()
+		close(aborted)
+	}))
+	defer local.Close()
+
+	router, err := NewRouter([]Route{{PathPrefix: "/", Upstream: local.URL}})
+	require.NoError(t, err)
+
+	var mu sync.Mutex
+	var frames []*proto.ProxyMessage
+	rh := NewRequestHandler(router, func(frame *proto.ProxyMessage) error {
+		mu.Lock()
+		frames = append(frames, frame)
+		mu.Unlock()
+		return nil
+	})
+
+	rh.StartRequest(&proto.ProxyMessage{
+		Id:   "req-1",
+		Type: proto.MessageType_REQUEST_START,
+		Metadata: map[string]string{
+			"method":         "GET",
+			"host":           "example.com",
+			"path":           "/slow",
+			"content_length": "0",
+		},
+	})
+
+	<-started
+	rh.Cancel(&proto.ProxyMessage{Id: "req-1", Type: proto.MessageType_CANCEL})
+
+	select {
+	case <-aborted:
+	case <-time.After(time.Second):
+		t.Fatal("local call was not aborted")
+	}
+
+	require.Eventually(t, func() bool {
+		rh.inflightMu.Lock()
+		defer rh.inflightMu.Unlock()
+		return len(rh.inflight) == 0
+	}, time.Second, time.Millisecond)
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Empty(t, frames, "nothing is sent for a cancelled request")
+}
+
+func TestHandleRequest_ResponseHeaderTimeout(t *testing.T) {
+	local := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer local.Close()
+
+	router, err := NewRouter([]Route{{PathPrefix: "/", Upstream: local.URL}})
+	require.NoError(t, err)
+
+	start, body := collectResponse(t, router, &proto.ProxyMessage{
+		Id:   "req-1",
+		Type: proto.MessageType_REQUEST_START,
+		Metadata: map[string]string{
+			"method":     "GET",
+			"host":       "example.com",
+			"path":       "/slow",
+			"timeout_ms": "50",
+		},
+	})
+
+	assert.Equal(t, "504", start.Metadata["status_code"])
+	assert.Contains(t, body, "did not respond within 50ms")
+}
+
+func TestHandleRequest_StripsHopByHopHeaders(t *testing.T) {
+	local := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Saw-Hop", r.Header.Get("X-Hop"))
+		w.Header().Set("Connection", "X-Private")
+		w.Header().Set("X-Private", "1")
+		w.Header().Set("Content-Length", "2")
+		fmt.Fprint(w, "ok")
+	}))
+	defer local.Close()
+
+	router, err := NewRouter([]Route{{PathPrefix: "/", Upstream: local.URL}})
+	require.NoError(t, err)
+
+	start, body := collectResponse(t, router, &proto.ProxyMessage{
+		Id:   "req-1",
+		Type: proto.MessageType_REQUEST_START,
+		Metadata: map[string]string{
+			"method": "GET",
+			"host":   "example.com",
+			"path":   "/",
+		},
+		Headers: []*proto.Header{
+			{Name: "Connection", Values: []string{"X-Hop"}},
+			{Name: "X-Hop", Values: []string{"1"}},
+			{Name: "Keep-Alive", Values: []string{"timeout=5"}},
+		},
+	})
+
+	header := headers.FromMessage(start)
+	assert.Equal(t, "ok", body)
+	assert.Empty(t, header.Get("X-Saw-Hop"))
+	assert.Empty(t, header.Get("Connection"))
+	assert.Empty(t, header.Get("X-Private"))
+	assert.Equal(t, "2", header.Get("Content-Length"))
+}
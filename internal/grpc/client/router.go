@@ -0,0 +1,114 @@
+package client
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// Route maps requests to a local upstream service. A route matches when the
+// request's Host equals Host (if set, port ignored) and its path equals
+// PathPrefix or continues it with a "/" (if set). With StripPrefix the prefix
+// is removed from the path before forwarding.
+type Route struct {
+	Host        string
+	PathPrefix  string
+	Upstream    string
+	StripPrefix bool
+}
+
+// Router selects the upstream for a request from a route table. Routes with a
+// Host are preferred over host-less ones, then the longest PathPrefix wins.
+type Router struct {
+	routes []Route
+}
+
+// NewRouter validates routes and builds a Router.
+func NewRouter(routes []Route) (*Router, error) {
+	sorted := make([]Route, 0, len(routes))
+
+	for i, route := range routes {
+		u, err := url.Parse(route.Upstream)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("route %d: invalid upstream %q", i, route.Upstream)
+		}
+		if route.PathPrefix != "" && !strings.HasPrefix(route.PathPrefix, "/") {
+			return nil, fmt.Errorf("route %d: path prefix %q must start with /", i, route.PathPrefix)
+		}
+
+		route.Upstream = strings.TrimSuffix(route.Upstream, "/")
+		route.PathPrefix = strings.TrimSuffix(route.PathPrefix, "/")
+		route.Host = strings.ToLower(route.Host)
+		sorted = append(sorted, route)
+	}
+
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if (sorted[i].Host != "") != (sorted[j].Host != "") {
+			return sorted[i].Host != ""
+		}
+		return len(sorted[i].PathPrefix) > len(sorted[j].PathPrefix)
+	})
+
+	return &Router{routes: sorted}, nil
+}
+
+// Match returns the route for a request, or false if no route matches.
+func (r *Router) Match(host, path string) (*Route, bool) {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(host)
+
+	for i := range r.routes {
+		route := &r.routes[i]
+		if route.Host != "" && route.Host != host {
+			continue
+		}
+		if !hasPathPrefix(path, route.PathPrefix) {
+			continue
+		}
+		return route, true
+	}
+	return nil, false
+}
+
+// Upstreams returns the distinct upstreams of the route table, in match
+// order.
+func (r *Router) Upstreams() []string {
+	var upstreams []string
+	for _, route := range r.routes {
+		if !slices.Contains(upstreams, route.Upstream) {
+			upstreams = append(upstreams, route.Upstream)
+		}
+	}
+	return upstreams
+}
+
+// TargetURL builds the upstream URL for path and the raw query.
+func (route *Route) TargetURL(path, query string) string {
+	if route.StripPrefix && route.PathPrefix != "" {
+		path = strings.TrimPrefix(path, route.PathPrefix)
+		if path == "" {
+			path = "/"
+		}
+	}
+
+	target := route.Upstream + path
+	if query != "" {
+		target += "?" + query
+	}
+	return target
+}
+
+func hasPathPrefix(path, prefix string) bool {
+	if prefix == "" {
+		return true
+	}
+	if !strings.HasPrefix(path, prefix) {
+		return false
+	}
+	return len(path) == len(prefix) || path[len(prefix)] == '/'
+}
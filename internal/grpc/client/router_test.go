@@ -0,0 +1,73 @@
+package client
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewRouter_InvalidRoutes(t *testing.T) {
+	_, err := NewRouter([]Route{{Upstream: "localhost:3000"}})
+	assert.Error(t, err)
+
+	_, err = NewRouter([]Route{{Upstream: "ftp://localhost"}})
+	assert.Error(t, err)
+
+	_, err = NewRouter([]Route{{PathPrefix: "api", Upstream: "http://localhost:3000"}})
+	assert.Error(t, err)
+}
+
+func TestRouter_Match(t *testing.T) {
+	router, err := NewRouter([]Route{
+		{PathPrefix: "/", Upstream: "http://web:3000"},
+		{PathPrefix: "/api", Upstream: "http://api:4000", StripPrefix: true},
+		{PathPrefix: "/api/admin", Upstream: "http://admin:5000"},
+		{Host: "metrics.example.com", Upstream: "http://metrics:9100/"},
+	})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name     string
+		host     string
+		path     string
+		query    string
+		expected string
+	}{
+		{"default route", "example.com", "/index.html", "", "http://web:3000/index.html"},
+		{"prefix stripped", "example.com", "/api/users", "page=2", "http://api:4000/users?page=2"},
+		{"bare prefix stripped to root", "example.com", "/api", "", "http://api:4000/"},
+		{"prefix is matched per segment", "example.com", "/apix", "", "http://web:3000/apix"},
+		{"longest prefix wins", "example.com", "/api/admin/stats", "", "http://admin:5000/api/admin/stats"},
+		{"host route wins over path routes", "metrics.example.com:8100", "/api/users", "", "http://metrics:9100/api/users"},
+		{"host is case-insensitive", "METRICS.example.com", "/metrics", "", "http://metrics:9100/metrics"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			route, ok := router.Match(tt.host, tt.path)
+			require.True(t, ok)
+			assert.Equal(t, tt.expected, route.TargetURL(tt.path, tt.query))
+		})
+	}
+}
+
+func TestRouter_NoMatch(t *testing.T) {
+	router, err := NewRouter([]Route{
+		{PathPrefix: "/api", Upstream: "http://api:4000"},
+		{Host: "admin.example.com", Upstream: "http://admin:5000"},
+	})
+	require.NoError(t, err)
+
+	_, ok := router.Match("example.com", "/index.html")
+	assert.False(t, ok)
+
+	_, ok = router.Match("other.example.com", "/admin")
+	assert.False(t, ok)
+
+	empty, err := NewRouter(nil)
+	require.NoError(t, err)
+
+	_, ok = empty.Match("example.com", "/")
+	assert.False(t, ok)
+}
@@ -0,0 +1,178 @@
+package client
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/EternisAI/silo-proxy/internal/grpc/chunk"
+	"github.com/EternisAI/silo-proxy/internal/grpc/tunnel"
+	"github.com/EternisAI/silo-proxy/proto"
+)
+
+const tcpDialTimeout = 10 * time.Second
+
+// TCPTunnel exposes a local TCP service through the server. Connections to the
+// port the server allocates for Name are forwarded to Target (host:port).
+type TCPTunnel struct {
+	Name   string
+	Target string
+}
+
+// TCPHandler dials tunnel targets on behalf of the server and relays bytes
+// between them and the agent stream.
+type TCPHandler struct {
+	targets map[string]string
+	send    chunk.SendFunc
+	// flowControl makes new sessions exchange WINDOW_UPDATE credit.
+	flowControl atomic.Bool
+
+	sessions map[string]*tunnel.Session
+	mu       sync.Mutex
+}
+
+func NewTCPHandler(tunnels []TCPTunnel, send chunk.SendFunc) *TCPHandler {
+	targets := make(map[string]string, len(tunnels))
+	for _, t := range tunnels {
+		targets[t.Name] = t.Target
+	}
+
+	return &TCPHandler{
+		targets:  targets,
+		send:     send,
+		sessions: make(map[string]*tunnel.Session),
+	}
+}
+
+// SetFlowControl sets whether sessions opened afterwards are flow controlled
+// with WINDOW_UPDATE frames. It is off by default.
+func (th *TCPHandler) SetFlowControl(enabled bool) {
+	th.flowControl.Store(enabled)
+}
+
+// Names returns the configured tunnel names in a stable order, as announced
+// to the server.
+func (th *TCPHandler) Names() []string {
+	names := make([]string, 0, len(th.targets))
+	for name := range th.targets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Open handles a TCP_OPEN frame: it dials the tunnel's target, acknowledges the
+// result with TCP_OPEN_ACK and, on success, pumps bytes until both sides close.
+func (th *TCPHandler) Open(msg *proto.ProxyMessage) {
+	go th.open(msg)
+}
+
+func (th *TCPHandler) open(msg *proto.ProxyMessage) {
+	name := msg.Metadata["tunnel"]
+	target, ok := th.targets[name]
+	if !ok {
+		th.rejectOpen(msg.Id, fmt.Errorf("unknown tunnel: %s", name))
+		return
+	}
+
+	slog.Info("Opening TCP connection to tunnel target",
+		"session_id", msg.Id,
+		"tunnel", name,
+		"target", target,
+		"remote_addr", msg.Metadata["remote_addr"])
+
+	conn, err := net.DialTimeout("tcp", target, tcpDialTimeout)
+	if err != nil {
+		th.rejectOpen(msg.Id, fmt.Errorf("failed to dial %s: %w", target, err))
+		return
+	}
+
+	session := tunnel.NewSession(msg.Id, th.send, func() {
+		th.mu.Lock()
+		delete(th.sessions, msg.Id)
+		th.mu.Unlock()
+	})
+	if th.flowControl.Load() {
+		session.EnableFlowControl()
+	}
+
+	th.mu.Lock()
+	th.sessions[msg.Id] = session
+	th.mu.Unlock()
+
+	ack := &proto.ProxyMessage{
+		Id:       msg.Id,
+		Type:     proto.MessageType_TCP_OPEN_ACK,
+		Metadata: map[string]string{},
+	}
+
+	if err := th.send(ack); err != nil {
+		slog.Error("Failed to send TCP ack", "session_id", msg.Id, "error", err)
+		session.Close()
+		conn.Close()
+		return
+	}
+
+	slog.Info("TCP session established", "session_id", msg.Id, "tunnel", name)
+	tunnel.PumpTCP(conn, session)
+	slog.Info("TCP session closed", "session_id", msg.Id, "tunnel", name)
+}
+
+func (th *TCPHandler) rejectOpen(id string, cause error) {
+	slog.Warn("Failed to open TCP tunnel", "session_id", id, "error", cause)
+
+	ack := &proto.ProxyMessage{
+		Id:   id,
+		Type: proto.MessageType_TCP_OPEN_ACK,
+		Metadata: map[string]string{
+			"error": cause.Error(),
+		},
+	}
+
+	if err := th.send(ack); err != nil {
+		slog.Error("Failed to send TCP rejection", "session_id", id, "error", err)
+	}
+}
+
+// HandleFrame delivers a TCP_DATA, TCP_CLOSE_WRITE, TCP_CLOSE or
+// WINDOW_UPDATE frame to its session.
+func (th *TCPHandler) HandleFrame(msg *proto.ProxyMessage) {
+	th.mu.Lock()
+	session, ok := th.sessions[msg.Id]
+	th.mu.Unlock()
+
+	if !ok {
+		slog.Debug("TCP frame for unknown session", "session_id", msg.Id, "type", msg.Type)
+		return
+	}
+
+	if err := session.Deliver(msg); err != nil {
+		slog.Warn("Failed to deliver TCP frame", "session_id", msg.Id, "error", err)
+		session.Abort(tunnel.TCPCloseMessage(msg.Id, "session overloaded"))
+	}
+}
+
+// SessionCount returns the number of open tunnelled connections.
+func (th *TCPHandler) SessionCount() int {
+	th.mu.Lock()
+	defer th.mu.Unlock()
+	return len(th.sessions)
+}
+
+// CloseAll ends every open session, e.g. after the server stream was lost.
+func (th *TCPHandler) CloseAll() {
+	th.mu.Lock()
+	sessions := make([]*tunnel.Session, 0, len(th.sessions))
+	for _, session := range th.sessions {
+		sessions = append(sessions, session)
+	}
+	th.mu.Unlock()
+
+	for _, session := range sessions {
+		session.Close()
+	}
+}
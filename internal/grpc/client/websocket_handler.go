@@ -0,0 +1,215 @@
+package client
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/EternisAI/silo-proxy/internal/grpc/chunk"
+	"github.com/EternisAI/silo-proxy/internal/grpc/headers"
+	"github.com/EternisAI/silo-proxy/internal/grpc/tunnel"
+	"github.com/EternisAI/silo-proxy/proto"
+	"github.com/gorilla/websocket"
+)
+
+const maxHandshakeErrorBody = 64 * 1024
+
+// handshakeHeaders are generated by the dialer itself and must not be copied
+// from the client's upgrade request.
+var handshakeHeaders = map[string]bool{
+	"Sec-Websocket-Key":        true,
+	"Sec-Websocket-Version":    true,
+	"Sec-Websocket-Extensions": true,
+}
+
+// WebSocketHandler dials WebSockets on the local service on behalf of the
+// server and relays frames between them and the agent stream.
+type WebSocketHandler struct {
+	router *Router
+	send   chunk.SendFunc
+	dialer *websocket.Dialer
+	// flowControl makes new sessions exchange WINDOW_UPDATE credit.
+	flowControl atomic.Bool
+
+	sessions map[string]*tunnel.Session
+	mu       sync.Mutex
+}
+
+func NewWebSocketHandler(router *Router, send chunk.SendFunc) *WebSocketHandler {
+	return &WebSocketHandler{
+		router: router,
+		send:   send,
+		dialer: &websocket.Dialer{
+			Proxy:            http.ProxyFromEnvironment,
+			HandshakeTimeout: localResponseTimeout,
+		},
+		sessions: make(map[string]*tunnel.Session),
+	}
+}
+
+// SetFlowControl sets whether sessions opened afterwards are flow controlled
+// with WINDOW_UPDATE frames. It is off by default.
+func (wh *WebSocketHandler) SetFlowControl(enabled bool) {
+	wh.flowControl.Store(enabled)
+}
+
+// Open handles a WS_OPEN frame: it dials the local service, acknowledges the
+// result with WS_OPEN_ACK and, on success, pumps frames until either side closes.
+func (wh *WebSocketHandler) Open(msg *proto.ProxyMessage) {
+	go wh.open(msg)
+}
+
+func (wh *WebSocketHandler) open(msg *proto.ProxyMessage) {
+	host := msg.Metadata["host"]
+	path := msg.Metadata["path"]
+
+	route, ok := wh.router.Match(host, path)
+	if !ok {
+		cause := fmt.Errorf("no route for host %q and path %q", host, path)
+		wh.sendReject(msg.Id, http.StatusNotFound, []byte(cause.Error()), cause)
+		return
+	}
+	url := toWebSocketURL(route.TargetURL(path, msg.Metadata["query"]))
+
+	header := headers.FromMessage(msg)
+	headers.RemoveHopByHop(header)
+	for name := range header {
+		if handshakeHeaders[name] {
+			header.Del(name)
+		}
+	}
+
+	slog.Info("Opening websocket to local service", "session_id", msg.Id, "url", url)
+
+	conn, resp, err := wh.dialer.Dial(url, header)
+	if err != nil {
+		wh.rejectOpen(msg.Id, resp, err)
+		return
+	}
+
+	session := tunnel.NewSession(msg.Id, wh.send, func() {
+		wh.mu.Lock()
+		delete(wh.sessions, msg.Id)
+		wh.mu.Unlock()
+	})
+	if wh.flowControl.Load() {
+		session.EnableFlowControl()
+	}
+
+	wh.mu.Lock()
+	wh.sessions[msg.Id] = session
+	wh.mu.Unlock()
+
+	ack := &proto.ProxyMessage{
+		Id:   msg.Id,
+		Type: proto.MessageType_WS_OPEN_ACK,
+		Metadata: map[string]string{
+			"status_code": strconv.Itoa(http.StatusSwitchingProtocols),
+			"subprotocol": conn.Subprotocol(),
+		},
+	}
+
+	if err := wh.send(ack); err != nil {
+		slog.Error("Failed to send websocket ack", "session_id", msg.Id, "error", err)
+		session.Close()
+		conn.Close()
+		return
+	}
+
+	slog.Info("WebSocket session established", "session_id", msg.Id)
+	tunnel.PumpWebSocket(conn, session)
+	slog.Info("WebSocket session closed", "session_id", msg.Id)
+}
+
+// rejectOpen reports a failed local handshake. If the local service answered
+// with an HTTP response, its status and body are passed back to the client.
+func (wh *WebSocketHandler) rejectOpen(id string, resp *http.Response, dialErr error) {
+	statusCode := http.StatusBadGateway
+	body := []byte(dialErr.Error())
+
+	if resp != nil {
+		statusCode = resp.StatusCode
+		if resp.Body != nil {
+			if b, err := io.ReadAll(io.LimitReader(resp.Body, maxHandshakeErrorBody)); err == nil {
+				body = b
+			}
+			resp.Body.Close()
+		}
+	}
+
+	wh.sendReject(id, statusCode, body, dialErr)
+}
+
+// sendReject answers a WS_OPEN with a failed handshake.
+func (wh *WebSocketHandler) sendReject(id string, statusCode int, body []byte, cause error) {
+	slog.Warn("Local websocket handshake failed", "session_id", id, "status_code", statusCode, "error", cause)
+
+	ack := &proto.ProxyMessage{
+		Id:      id,
+		Type:    proto.MessageType_WS_OPEN_ACK,
+		Payload: body,
+		Metadata: map[string]string{
+			"status_code": strconv.Itoa(statusCode),
+			"error":       cause.Error(),
+		},
+	}
+
+	if err := wh.send(ack); err != nil {
+		slog.Error("Failed to send websocket rejection", "session_id", id, "error", err)
+	}
+}
+
+// HandleFrame delivers a WS_FRAME, WS_CLOSE or WINDOW_UPDATE frame to its
+// session.
+func (wh *WebSocketHandler) HandleFrame(msg *proto.ProxyMessage) {
+	wh.mu.Lock()
+	session, ok := wh.sessions[msg.Id]
+	wh.mu.Unlock()
+
+	if !ok {
+		slog.Debug("WebSocket frame for unknown session", "session_id", msg.Id, "type", msg.Type)
+		return
+	}
+
+	if err := session.Deliver(msg); err != nil {
+		slog.Warn("Failed to deliver websocket frame", "session_id", msg.Id, "error", err)
+		session.Abort(tunnel.WebSocketCloseMessage(msg.Id, websocket.CloseInternalServerErr, "session overloaded"))
+	}
+}
+
+// SessionCount returns the number of open sessions.
+func (wh *WebSocketHandler) SessionCount() int {
+	wh.mu.Lock()
+	defer wh.mu.Unlock()
+	return len(wh.sessions)
+}
+
+// CloseAll ends every open session, e.g. after the server stream was lost.
+func (wh *WebSocketHandler) CloseAll() {
+	wh.mu.Lock()
+	sessions := make([]*tunnel.Session, 0, len(wh.sessions))
+	for _, session := range wh.sessions {
+		sessions = append(sessions, session)
+	}
+	wh.mu.Unlock()
+
+	for _, session := range sessions {
+		session.Close()
+	}
+}
+
+func toWebSocketURL(httpURL string) string {
+	switch {
+	case strings.HasPrefix(httpURL, "https://"):
+		return "wss://" + strings.TrimPrefix(httpURL, "https://")
+	case strings.HasPrefix(httpURL, "http://"):
+		return "ws://" + strings.TrimPrefix(httpURL, "http://")
+	default:
+		return httpURL
+	}
+}
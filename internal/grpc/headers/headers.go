@@ -0,0 +1,156 @@
+// Package headers converts HTTP headers and trailers to and from the repeated
+// Header fields of ProxyMessage frames, keeping every value of every field.
+package headers
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/EternisAI/silo-proxy/proto"
+)
+
+// hopByHop are the fields that only describe a single connection and must
+// not be forwarded by proxies (RFC 7230 section 6.1, plus the non-standard
+// Proxy-Connection).
+var hopByHop = []string{
+	"Connection",
+	"Proxy-Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// legacyPrefix marks a header in the metadata of frames from older peers,
+// which carried one value per name.
+const legacyPrefix = "header_"
+
+// ToProto converts h, sorted by name so that frames are deterministic.
+func ToProto(h http.Header) []*proto.Header {
+	if len(h) == 0 {
+		return nil
+	}
+
+	names := make([]string, 0, len(h))
+	for name := range h {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	fields := make([]*proto.Header, 0, len(names))
+	for _, name := range names {
+		if len(h[name]) == 0 {
+			continue
+		}
+		fields = append(fields, &proto.Header{Name: name, Values: slices.Clone(h[name])})
+	}
+	return fields
+}
+
+// FromProto converts fields back into an http.Header. Repeated names are
+// merged, in order.
+func FromProto(fields []*proto.Header) http.Header {
+	h := make(http.Header, len(fields))
+	for _, field := range fields {
+		for _, value := range field.GetValues() {
+			h.Add(field.GetName(), value)
+		}
+	}
+	return h
+}
+
+// FromMessage returns the headers of a REQUEST_START, RESPONSE_START or
+// WS_OPEN frame. Frames from older peers that only set "header_" metadata
+// entries are still understood.
+func FromMessage(msg *proto.ProxyMessage) http.Header {
+	if len(msg.GetHeaders()) > 0 {
+		return FromProto(msg.GetHeaders())
+	}
+
+	h := http.Header{}
+	for key, value := range msg.GetMetadata() {
+		if name, ok := strings.CutPrefix(key, legacyPrefix); ok && name != "" {
+			h.Add(name, value)
+		}
+	}
+	return h
+}
+
+// SetLegacy also writes the first value of each header to metadata as
+// "header_" entries, for older peers that do not read the Headers field.
+func SetLegacy(metadata map[string]string, h http.Header) {
+	for name, values := range h {
+		if len(values) > 0 {
+			metadata[legacyPrefix+name] = values[0]
+		}
+	}
+}
+
+// RemoveHopByHop deletes the hop-by-hop fields from h, including any named in
+// its Connection header. "TE: trailers" is kept, as it tells the next hop
+// that trailers will be read. Trailers to forward must be announced again
+// with DeclareTrailer afterwards.
+func RemoveHopByHop(h http.Header) {
+	for _, value := range h.Values("Connection") {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				h.Del(name)
+			}
+		}
+	}
+
+	acceptsTrailers := false
+	for _, value := range h.Values("Te") {
+		for _, coding := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(coding), "trailers") {
+				acceptsTrailers = true
+			}
+		}
+	}
+
+	for _, name := range hopByHop {
+		h.Del(name)
+	}
+	if acceptsTrailers {
+		h.Set("Te", "trailers")
+	}
+}
+
+// DeclareTrailer sets the Trailer header of h to the names in trailer. Go's
+// HTTP stack moves the names of announced trailers out of the headers, so
+// they have to be put back before the headers are forwarded.
+func DeclareTrailer(h, trailer http.Header) {
+	if len(trailer) == 0 {
+		return
+	}
+
+	names := make([]string, 0, len(trailer))
+	for name := range trailer {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	h.Set("Trailer", strings.Join(names, ", "))
+}
+
+// Announced returns a trailer map holding the names announced in the Trailer
+// header of h, with no values yet.
+func Announced(h http.Header) http.Header {
+	var trailer http.Header
+	for _, value := range h.Values("Trailer") {
+		for _, name := range strings.Split(value, ",") {
+			name = http.CanonicalHeaderKey(strings.TrimSpace(name))
+			if name == "" {
+				continue
+			}
+			if trailer == nil {
+				trailer = http.Header{}
+			}
+			trailer[name] = nil
+		}
+	}
+	return trailer
+}
@@ -0,0 +1,85 @@
+package headers
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/EternisAI/silo-proxy/proto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestToProto_RoundTrip(t *testing.T) {
+	h := http.Header{
+		"Set-Cookie": {"a=1", "b=2"},
+		"Vary":       {"Accept", "Origin"},
+		"Empty":      {},
+	}
+
+	fields := ToProto(h)
+
+	assert.Equal(t, []*proto.Header{
+		{Name: "Set-Cookie", Values: []string{"a=1", "b=2"}},
+		{Name: "Vary", Values: []string{"Accept", "Origin"}},
+	}, fields)
+	assert.Equal(t, http.Header{
+		"Set-Cookie": {"a=1", "b=2"},
+		"Vary":       {"Accept", "Origin"},
+	}, FromProto(fields))
+}
+
+func TestFromMessage_PrefersHeadersField(t *testing.T) {
+	msg := &proto.ProxyMessage{
+		Metadata: map[string]string{"header_Set-Cookie": "a=1"},
+		Headers:  []*proto.Header{{Name: "Set-Cookie", Values: []string{"a=1", "b=2"}}},
+	}
+
+	assert.Equal(t, []string{"a=1", "b=2"}, FromMessage(msg).Values("Set-Cookie"))
+}
+
+func TestFromMessage_LegacyMetadata(t *testing.T) {
+	msg := &proto.ProxyMessage{
+		Metadata: map[string]string{
+			"status_code":   "200",
+			"header_x-user": "alice",
+			"header_":       "ignored",
+		},
+	}
+
+	assert.Equal(t, http.Header{"X-User": {"alice"}}, FromMessage(msg))
+}
+
+func TestSetLegacy_KeepsFirstValue(t *testing.T) {
+	metadata := map[string]string{}
+	SetLegacy(metadata, http.Header{"Accept": {"text/html", "application/json"}})
+
+	assert.Equal(t, map[string]string{"header_Accept": "text/html"}, metadata)
+}
+
+func TestDeclareTrailer_Announced(t *testing.T) {
+	h := http.Header{}
+	DeclareTrailer(h, http.Header{"X-Checksum": nil, "Grpc-Status": nil})
+
+	assert.Equal(t, "Grpc-Status, X-Checksum", h.Get("Trailer"))
+	assert.Equal(t, http.Header{"Grpc-Status": nil, "X-Checksum": nil}, Announced(h))
+	assert.Nil(t, Announced(http.Header{}))
+}
+
+func TestRemoveHopByHop(t *testing.T) {
+	h := http.Header{
+		"Connection":        {"keep-alive, X-Session-Hop"},
+		"Keep-Alive":        {"timeout=5"},
+		"Transfer-Encoding": {"chunked"},
+		"Upgrade":           {"h2c"},
+		"Te":                {"gzip, trailers"},
+		"Trailer":           {"X-Checksum"},
+		"X-Session-Hop":     {"1"},
+		"Set-Cookie":        {"a=1", "b=2"},
+	}
+
+	RemoveHopByHop(h)
+
+	assert.Equal(t, http.Header{
+		"Te":         {"trailers"},
+		"Set-Cookie": {"a=1", "b=2"},
+	}, h)
+}
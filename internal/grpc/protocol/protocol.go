@@ -0,0 +1,67 @@
+// Package protocol defines the versions and optional capabilities of the
+// protocol spoken between agents and the server, and how both sides agree on
+// them during the HELLO/HELLO_ACK handshake.
+package protocol
+
+import "slices"
+
+const (
+	// Version is the protocol version spoken by this build.
+	Version = 2
+	// MinVersion is the oldest version this build still speaks. Version 1 is
+	// the original handshake, where the agent's first PING is all the server
+	// learns about it.
+	MinVersion = 1
+)
+
+// Optional features. Each side only relies on one once both have agreed on it.
+const (
+	// Headers carries headers and trailers only in the repeated fields of
+	// ProxyMessage, without the legacy "header_" metadata entries.
+	Headers = "headers"
+	// Cancel means the agent aborts requests when sent CANCEL.
+	Cancel = "cancel"
+	// Commands means the agent answers COMMAND frames with COMMAND_RESULT.
+	Commands = "commands"
+	// FlowControl means the sender of a body or tunnel session waits for
+	// WINDOW_UPDATE credit instead of letting its receiver's buffer overflow.
+	FlowControl = "flow_control"
+)
+
+// Capabilities lists the optional features supported by this build.
+var Capabilities = []string{Cancel, Commands, FlowControl, Headers}
+
+// Error codes of a COMMAND_RESULT whose command did not succeed.
+const (
+	// CommandNotFound means the agent has no handler for the command.
+	CommandNotFound = "not_found"
+	// CommandTimeout means the command did not finish within its timeout.
+	CommandTimeout = "timeout"
+	// CommandFailed means the handler ran and returned an error.
+	CommandFailed = "failed"
+)
+
+// NegotiateVersion returns the highest version both the local range
+// [lowest, highest] and the remote one speak, or false if there is none. A
+// remote lowest of zero, from a peer that does not send it, is taken as 1.
+func NegotiateVersion(lowest, highest, remoteLowest, remoteHighest int) (int, bool) {
+	remoteLowest = max(remoteLowest, 1)
+	version := min(highest, remoteHighest)
+	if version < lowest || version < remoteLowest {
+		return 0, false
+	}
+	return version, true
+}
+
+// NegotiateCapabilities returns the capabilities in both local and remote,
+// sorted.
+func NegotiateCapabilities(local, remote []string) []string {
+	var result []string
+	for _, capability := range remote {
+		if slices.Contains(local, capability) && !slices.Contains(result, capability) {
+			result = append(result, capability)
+		}
+	}
+	slices.Sort(result)
+	return result
+}
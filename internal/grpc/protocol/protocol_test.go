@@ -0,0 +1,29 @@
+package protocol
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNegotiateVersion(t *testing.T) {
+	version, ok := NegotiateVersion(1, 2, 1, 3)
+	assert.True(t, ok)
+	assert.Equal(t, 2, version)
+
+	version, ok = NegotiateVersion(1, 3, 0, 2)
+	assert.True(t, ok)
+	assert.Equal(t, 2, version)
+
+	_, ok = NegotiateVersion(2, 2, 1, 1)
+	assert.False(t, ok, "remote too old")
+
+	_, ok = NegotiateVersion(1, 2, 3, 4)
+	assert.False(t, ok, "remote too new")
+}
+
+func TestNegotiateCapabilities(t *testing.T) {
+	assert.Equal(t, []string{"cancel", "headers"},
+		NegotiateCapabilities([]string{Headers, Cancel}, []string{"headers", "teleport", "cancel", "headers"}))
+	assert.Nil(t, NegotiateCapabilities(Capabilities, nil))
+}
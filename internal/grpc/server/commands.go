@@ -0,0 +1,121 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/EternisAI/silo-proxy/internal/grpc/protocol"
+	"github.com/EternisAI/silo-proxy/internal/metrics"
+	"github.com/EternisAI/silo-proxy/proto"
+	"github.com/google/uuid"
+)
+
+// commandResultGrace is added to a command's timeout while waiting for its
+// result, so that the agent's own timeout error can still arrive.
+const commandResultGrace = time.Second
+
+var (
+	// ErrAgentNotFound is returned for commands to agents that are not
+	// connected.
+	ErrAgentNotFound = errors.New("agent not found")
+	// ErrCommandsUnsupported is returned for agents that did not negotiate
+	// protocol.Commands.
+	ErrCommandsUnsupported = errors.New("agent does not support commands")
+)
+
+// CommandError is a command that did not succeed. Code is one of
+// protocol.CommandNotFound, protocol.CommandTimeout and protocol.CommandFailed.
+type CommandError struct {
+	Code    string
+	Message string
+}
+
+func (e *CommandError) Error() string {
+	return fmt.Sprintf("command %s: %s", e.Code, e.Message)
+}
+
+// SendCommand runs the command called name on the agent with args, JSON or
+// nil, and waits up to timeout for its result. The agent stops the command
+// once timeout has passed.
+func (s *Server) SendCommand(ctx context.Context, agentID, name string, args json.RawMessage, timeout time.Duration) (json.RawMessage, error) {
+	conn, ok := s.connManager.pick(agentID)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrAgentNotFound, agentID)
+	}
+	if !conn.Info.Supports(protocol.Commands) {
+		return nil, ErrCommandsUnsupported
+	}
+
+	id := uuid.New().String()
+	resultCh := make(chan *proto.ProxyMessage, 1)
+
+	s.commandsMu.Lock()
+	s.commands[id] = resultCh
+	s.commandsMu.Unlock()
+
+	defer func() {
+		s.commandsMu.Lock()
+		delete(s.commands, id)
+		s.commandsMu.Unlock()
+	}()
+
+	if err := s.connManager.sendTo(conn, &proto.ProxyMessage{
+		Id:      id,
+		Type:    proto.MessageType_COMMAND,
+		Payload: args,
+		Metadata: map[string]string{
+			"command":    name,
+			"timeout_ms": strconv.FormatInt(timeout.Milliseconds(), 10),
+		},
+	}); err != nil {
+		return nil, fmt.Errorf("failed to send command to agent: %w", err)
+	}
+
+	slog.Info("Command sent to agent", "agent_id", agentID, "message_id", id, "command", name, "timeout", timeout)
+
+	var result *proto.ProxyMessage
+	select {
+	case result = <-resultCh:
+	case <-time.After(timeout + commandResultGrace):
+		metrics.AgentCommands.WithLabelValues(name, protocol.CommandTimeout).Inc()
+		return nil, &CommandError{Code: protocol.CommandTimeout, Message: fmt.Sprintf("no result within %s", timeout)}
+	case <-conn.ctx.Done():
+		return nil, fmt.Errorf("agent disconnected: %s", agentID)
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	if message := result.Metadata["error"]; message != "" {
+		code := result.Metadata["error_code"]
+		if code == "" {
+			code = protocol.CommandFailed
+		}
+		metrics.AgentCommands.WithLabelValues(name, code).Inc()
+		return nil, &CommandError{Code: code, Message: message}
+	}
+	metrics.AgentCommands.WithLabelValues(name, "ok").Inc()
+	return result.Payload, nil
+}
+
+// HandleCommandResult hands a COMMAND_RESULT frame to the command waiting for
+// it.
+func (s *Server) HandleCommandResult(msg *proto.ProxyMessage) {
+	s.commandsMu.Lock()
+	resultCh, ok := s.commands[msg.Id]
+	s.commandsMu.Unlock()
+
+	if !ok {
+		slog.Warn("Received result for unknown command", "message_id", msg.Id)
+		return
+	}
+
+	select {
+	case resultCh <- msg:
+	default:
+	}
+}
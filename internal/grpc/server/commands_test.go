@@ -0,0 +1,75 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/EternisAI/silo-proxy/internal/grpc/protocol"
+	"github.com/EternisAI/silo-proxy/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSendCommand(t *testing.T) {
+	s := NewServer(0, nil)
+	conn, err := s.connManager.Register("agent-1", NewMockStream(), currentAgent)
+	require.NoError(t, err)
+
+	go func() {
+		msg := nextFrame(t, conn)
+		assert.Equal(t, proto.MessageType_COMMAND, msg.Type)
+		assert.Equal(t, "status", msg.Metadata["command"])
+		assert.Equal(t, "5000", msg.Metadata["timeout_ms"])
+		assert.JSONEq(t, `{"verbose":true}`, string(msg.Payload))
+
+		s.HandleCommandResult(&proto.ProxyMessage{
+			Id:       msg.Id,
+			Type:     proto.MessageType_COMMAND_RESULT,
+			Payload:  []byte(`{"ok":true}`),
+			Metadata: map[string]string{},
+		})
+	}()
+
+	result, err := s.SendCommand(context.Background(), "agent-1", "status", json.RawMessage(`{"verbose":true}`), 5*time.Second)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"ok":true}`, string(result))
+	assert.Empty(t, s.commands)
+}
+
+func TestSendCommand_AgentError(t *testing.T) {
+	s := NewServer(0, nil)
+	conn, err := s.connManager.Register("agent-1", NewMockStream(), currentAgent)
+	require.NoError(t, err)
+
+	go func() {
+		msg := nextFrame(t, conn)
+		s.HandleCommandResult(&proto.ProxyMessage{
+			Id:   msg.Id,
+			Type: proto.MessageType_COMMAND_RESULT,
+			Metadata: map[string]string{
+				"error":      "unknown command: reboot",
+				"error_code": protocol.CommandNotFound,
+			},
+		})
+	}()
+
+	_, err = s.SendCommand(context.Background(), "agent-1", "reboot", nil, 5*time.Second)
+	var commandErr *CommandError
+	require.ErrorAs(t, err, &commandErr)
+	assert.Equal(t, protocol.CommandNotFound, commandErr.Code)
+	assert.Equal(t, "unknown command: reboot", commandErr.Message)
+}
+
+func TestSendCommand_Unavailable(t *testing.T) {
+	s := NewServer(0, nil)
+	_, err := s.connManager.Register("legacy", NewMockStream(), AgentInfo{ProtocolVersion: 1})
+	require.NoError(t, err)
+
+	_, err = s.SendCommand(context.Background(), "legacy", "status", nil, time.Second)
+	assert.ErrorIs(t, err, ErrCommandsUnsupported)
+
+	_, err = s.SendCommand(context.Background(), "missing", "status", nil, time.Second)
+	assert.ErrorIs(t, err, ErrAgentNotFound)
+}
@@ -2,12 +2,19 @@ package server
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
+	"slices"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/EternisAI/silo-proxy/internal/metrics"
 	"github.com/EternisAI/silo-proxy/proto"
+	"google.golang.org/grpc/peer"
+
+	grpctls "github.com/EternisAI/silo-proxy/internal/grpc/tls"
 )
 
 // AgentServerManager interface defines the per-agent HTTP server management methods.
@@ -19,28 +26,99 @@ type AgentServerManager interface {
 	Shutdown() error
 }
 
+// TCPTunnelManager interface defines the per-agent TCP tunnel listener management
+// methods. StartAgentTunnels opens one listener per tunnel name and returns the
+// port allocated to each.
+type TCPTunnelManager interface {
+	StartAgentTunnels(agentID string, tunnels []string) (map[string]int, error)
+	StopAgentTunnels(agentID string) error
+	Shutdown() error
+}
+
+// SessionRecorder is notified when agent connections begin and end so that
+// their history can be kept. It is called with the ConnectionManager lock held
+// and must not block.
+type SessionRecorder interface {
+	SessionStarted(session SessionInfo)
+	SessionEnded(agentID, reason string, at time.Time)
+}
+
+// SessionInfo describes a newly registered agent connection.
+type SessionInfo struct {
+	AgentID     string
+	RemoteAddr  string
+	Port        int
+	CertSerial  string // Serial of the verified client certificate, if any
+	ConnectedAt time.Time
+}
+
+// Reasons an agent connection ended, as passed to SessionRecorder.
 const (
-	sendChannelBuffer      = 100
+	DisconnectReasonClosed   = "closed"
+	DisconnectReasonReplaced = "replaced"
+	DisconnectReasonStale    = "stale"
+	DisconnectReasonRevoked  = "revoked"
+	DisconnectReasonShutdown = "shutdown"
+	DisconnectReasonAdmin    = "admin"
+	DisconnectReasonDrained  = "drained"
+)
+
+// errConnectionClosed is returned for frames sent to a connection that has
+// ended.
+var errConnectionClosed = errors.New("agent connection closed")
+
+const (
+	sendChannelBuffer = 100
+	cleanupInterval   = 30 * time.Second
+
+	// Defaults of Timeouts.Send and Timeouts.StaleConnection.
 	sendTimeout            = 5 * time.Second
 	staleConnectionTimeout = 2 * time.Minute
-	cleanupInterval        = 30 * time.Second
 )
 
+// AgentConnection is one stream of an agent. An agent may hold several at
+// once, which then share its HTTP server port and TCP tunnels.
 type AgentConnection struct {
-	ID       string
-	Port     int // HTTP server port for this agent (0 if no dedicated server)
-	Stream   proto.ProxyService_StreamServer
-	SendCh   chan *proto.ProxyMessage
-	LastSeen time.Time
-	ctx      context.Context
-	cancel   context.CancelFunc
+	ID         string
+	Port       int            // HTTP server port for this agent (0 if no dedicated server)
+	TCPTunnels map[string]int // Tunnel name -> listener port (empty if none)
+	Info       AgentInfo      // What the agent reported in its handshake
+	Stream     proto.ProxyService_StreamServer
+	SendCh     chan *proto.ProxyMessage // Never closed: the connection is over once ctx is done
+	LastSeen   time.Time
+	ctx        context.Context
+	cancel     context.CancelFunc
+	draining   atomic.Bool
+	inFlight   atomic.Int64   // Requests and tunnel sessions using this stream
+	picked     atomic.Uint64  // When work was last sent to this stream, in picks
+	reconnect  *reconnectWait // Requests that waited for this connection, until resumed
+}
+
+// Draining reports whether the agent is being drained and takes no new
+// requests.
+func (c *AgentConnection) Draining() bool {
+	return c.draining.Load()
+}
+
+// load is how busy the stream is: its work in flight and its queued frames.
+func (c *AgentConnection) load() int64 {
+	return c.inFlight.Load() + int64(len(c.SendCh))
 }
 
 type ConnectionManager struct {
-	agents             map[string]*AgentConnection
+	agents             map[string][]*AgentConnection // Oldest stream first
 	mu                 sync.RWMutex
 	stopCh             chan struct{}
 	agentServerManager AgentServerManager // Optional: manages per-agent HTTP servers
+	tcpTunnelManager   TCPTunnelManager   // Optional: manages per-agent TCP tunnel listeners
+	sessionRecorder    SessionRecorder    // Optional: records connection history
+	sendTimeout        time.Duration
+	staleTimeout       time.Duration
+	maxStreams         int                       // Streams per agent; more replace the oldest
+	picks              atomic.Uint64             // Counts streams picked, to rotate ties between them
+	reconnectGrace     time.Duration             // Zero disables holding agents that disconnect
+	maxQueued          int                       // Requests held per reconnecting agent
+	reconnecting       map[string]*reconnectWait // Agents within their reconnect grace period
 }
 
 // NewConnectionManager creates a new ConnectionManager.
@@ -48,9 +126,14 @@ type ConnectionManager struct {
 // per-agent HTTP server management when provided.
 func NewConnectionManager(agentServerManager AgentServerManager) *ConnectionManager {
 	cm := &ConnectionManager{
-		agents:             make(map[string]*AgentConnection),
+		agents:             make(map[string][]*AgentConnection),
 		stopCh:             make(chan struct{}),
 		agentServerManager: agentServerManager,
+		sendTimeout:        sendTimeout,
+		staleTimeout:       staleConnectionTimeout,
+		maxStreams:         1,
+		maxQueued:          defaultMaxQueued,
+		reconnecting:       make(map[string]*reconnectWait),
 	}
 	go cm.cleanupStaleConnections()
 	return cm
@@ -64,37 +147,89 @@ func (cm *ConnectionManager) SetAgentServerManager(asm AgentServerManager) {
 	cm.agentServerManager = asm
 }
 
-func (cm *ConnectionManager) Register(agentID string, stream proto.ProxyService_StreamServer) (*AgentConnection, error) {
+// SetTCPTunnelManager enables TCP tunnel forwarding. Without it, tunnels
+// announced by agents are ignored.
+func (cm *ConnectionManager) SetTCPTunnelManager(ttm TCPTunnelManager) {
 	cm.mu.Lock()
 	defer cm.mu.Unlock()
+	cm.tcpTunnelManager = ttm
+}
 
-	// Clean up existing connection if present
-	if existing, ok := cm.agents[agentID]; ok {
-		slog.Warn("Agent already connected, replacing connection", "agent_id", agentID)
-		existing.cancel()
-		close(existing.SendCh)
+// SetSessionRecorder makes the ConnectionManager report every agent
+// connection to sr as it begins and ends.
+func (cm *ConnectionManager) SetSessionRecorder(sr SessionRecorder) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	cm.sessionRecorder = sr
+}
 
-		// Stop existing agent server if manager available
-		if cm.agentServerManager != nil && existing.Port != 0 {
-			if err := cm.agentServerManager.StopAgentServer(agentID); err != nil {
-				slog.Error("Failed to stop existing agent server during re-registration",
-					"agent_id", agentID,
-					"port", existing.Port,
-					"error", err)
-			}
-		}
+// SetTimeouts replaces how long SendToAgent waits for room in a send channel
+// and how long an agent may stay silent. Zero keeps the current value.
+func (cm *ConnectionManager) SetTimeouts(send, stale time.Duration) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	if send > 0 {
+		cm.sendTimeout = send
+	}
+	if stale > 0 {
+		cm.staleTimeout = stale
+	}
+}
 
-		delete(cm.agents, agentID)
+// SetMaxStreams lets every agent hold up to n streams at once, such as one
+// per replica. A stream beyond that replaces the agent's oldest one. The
+// default of 1 makes every new stream replace the previous one.
+func (cm *ConnectionManager) SetMaxStreams(n int) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	if n > 0 {
+		cm.maxStreams = n
+	}
+}
+
+// Register adds stream to the connections of agentID, replacing its oldest
+// one if it already has as many as allowed. info is what was negotiated with
+// the agent. The agent's HTTP server is started with its first stream, and an
+// agent that reconnects within its grace period gets its previous port back.
+func (cm *ConnectionManager) Register(agentID string, stream proto.ProxyService_StreamServer, info AgentInfo) (*AgentConnection, error) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	// Requests held while the agent was away are resumed once its handshake
+	// is done, on this connection or the next.
+	wait, heldPort := cm.takeReconnectWait(agentID)
+
+	if conns := cm.agents[agentID]; len(conns) >= cm.maxStreams {
+		oldest := conns[0]
+		slog.Warn("Agent already connected, replacing connection",
+			"agent_id", agentID,
+			"streams", len(conns))
+		if len(conns) == 1 {
+			wait, oldest.reconnect = oldest.reconnect, nil
+		}
+		cm.remove(oldest, DisconnectReasonReplaced)
 	}
+	existing := cm.agents[agentID]
 
-	// Start per-agent HTTP server if manager available
+	// Start per-agent HTTP server if manager available, unless the agent's
+	// other streams or its grace period kept one running
 	var port int
-	if cm.agentServerManager != nil {
+	var tunnels map[string]int
+	switch {
+	case len(existing) > 0:
+		port = existing[0].Port
+		tunnels = existing[0].TCPTunnels
+	case heldPort != 0:
+		port = heldPort
+	case cm.agentServerManager != nil:
 		allocatedPort, err := cm.agentServerManager.StartAgentServer(agentID)
 		if err != nil {
 			slog.Error("Failed to start agent HTTP server",
 				"agent_id", agentID,
 				"error", err)
+			if wait != nil {
+				wait.finish(nil)
+			}
 			return nil, fmt.Errorf("failed to start agent HTTP server: %w", err)
 		}
 		port = allocatedPort
@@ -102,38 +237,116 @@ func (cm *ConnectionManager) Register(agentID string, stream proto.ProxyService_
 
 	ctx, cancel := context.WithCancel(context.Background())
 	conn := &AgentConnection{
-		ID:       agentID,
-		Port:     port,
-		Stream:   stream,
-		SendCh:   make(chan *proto.ProxyMessage, sendChannelBuffer),
-		LastSeen: time.Now(),
-		ctx:      ctx,
-		cancel:   cancel,
+		ID:         agentID,
+		Port:       port,
+		TCPTunnels: tunnels,
+		Info:       info,
+		Stream:     stream,
+		SendCh:     make(chan *proto.ProxyMessage, sendChannelBuffer),
+		LastSeen:   time.Now(),
+		ctx:        ctx,
+		cancel:     cancel,
 	}
+	conn.reconnect = wait
 
-	cm.agents[agentID] = conn
+	cm.agents[agentID] = append(existing, conn)
+	metrics.AgentRegistrations.Inc()
+	cm.updateConnectionMetrics()
 
-	if port != 0 {
+	switch {
+	case len(existing) > 0:
+		slog.Info("Agent opened another stream",
+			"agent_id", agentID,
+			"port", port,
+			"streams", len(existing)+1)
+		return conn, nil
+	case port != 0:
 		slog.Info("Agent registered with dedicated HTTP server",
 			"agent_id", agentID,
 			"port", port,
 			"total_connections", len(cm.agents))
-	} else {
+	default:
 		slog.Info("Agent registered",
 			"agent_id", agentID,
 			"total_connections", len(cm.agents))
 	}
 
+	if cm.sessionRecorder != nil {
+		cm.sessionRecorder.SessionStarted(newSessionInfo(conn))
+	}
+
 	return conn, nil
 }
 
+// Deregister closes every connection of agentID.
 func (cm *ConnectionManager) Deregister(agentID string) {
+	cm.deregister(agentID, DisconnectReasonClosed)
+}
+
+func (cm *ConnectionManager) deregister(agentID, reason string) {
 	cm.mu.Lock()
 	defer cm.mu.Unlock()
 
-	if conn, ok := cm.agents[agentID]; ok {
-		conn.cancel()
-		close(conn.SendCh)
+	for _, conn := range slices.Clone(cm.agents[agentID]) {
+		cm.remove(conn, reason)
+	}
+}
+
+// deregisterConn deregisters conn and reports whether it was still
+// registered. Connections that were already replaced are left alone.
+func (cm *ConnectionManager) deregisterConn(conn *AgentConnection, reason string) bool {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	if !slices.Contains(cm.agents[conn.ID], conn) {
+		return false
+	}
+	cm.remove(conn, reason)
+	return true
+}
+
+// remove ends conn. While the agent has other streams, they take over its
+// work. Otherwise the agent is gone, except that a connection closed by the
+// agent's side is held for the reconnect grace period: its HTTP server keeps
+// running and requests for it wait for the agent to return. Callers must hold
+// cm.mu.
+func (cm *ConnectionManager) remove(conn *AgentConnection, reason string) {
+	agentID := conn.ID
+	conn.cancel()
+
+	switch reason {
+	case DisconnectReasonStale:
+		metrics.StaleConnectionRemovals.Inc()
+	case DisconnectReasonReplaced:
+	default:
+		metrics.AgentDeregistrations.Inc()
+	}
+
+	remaining := slices.DeleteFunc(slices.Clone(cm.agents[agentID]), func(c *AgentConnection) bool {
+		return c == conn
+	})
+	if len(remaining) > 0 {
+		cm.agents[agentID] = remaining
+		cm.updateConnectionMetrics()
+		if conn.reconnect != nil {
+			conn.reconnect.finish(remaining[0])
+			conn.reconnect = nil
+		}
+		slog.Info("Agent stream closed, its other streams take over",
+			"agent_id", agentID,
+			"reason", reason,
+			"streams", len(remaining))
+		return
+	}
+
+	held := reason == DisconnectReasonClosed && cm.reconnectGrace > 0
+	if held {
+		cm.holdForReconnect(conn)
+	} else {
+		if conn.reconnect != nil {
+			conn.reconnect.finish(nil)
+			conn.reconnect = nil
+		}
 
 		// Stop agent HTTP server if manager available
 		if cm.agentServerManager != nil && conn.Port != 0 {
@@ -144,60 +357,188 @@ func (cm *ConnectionManager) Deregister(agentID string) {
 					"error", err)
 			}
 		}
+	}
+	cm.stopTCPTunnels(conn)
 
-		delete(cm.agents, agentID)
+	delete(cm.agents, agentID)
+	cm.updateConnectionMetrics()
+	cm.recordSessionEnded(agentID, reason)
 
-		if conn.Port != 0 {
-			slog.Info("Agent deregistered, HTTP server stopped",
-				"agent_id", agentID,
-				"port", conn.Port,
-				"total_connections", len(cm.agents))
-		} else {
-			slog.Info("Agent deregistered",
-				"agent_id", agentID,
-				"total_connections", len(cm.agents))
-		}
+	switch {
+	case held:
+		slog.Info("Agent deregistered, holding its requests until it reconnects",
+			"agent_id", agentID,
+			"port", conn.Port,
+			"grace_period", cm.reconnectGrace,
+			"total_connections", len(cm.agents))
+	case conn.Port != 0:
+		slog.Info("Agent deregistered, HTTP server stopped",
+			"agent_id", agentID,
+			"port", conn.Port,
+			"total_connections", len(cm.agents))
+	default:
+		slog.Info("Agent deregistered",
+			"agent_id", agentID,
+			"total_connections", len(cm.agents))
 	}
 }
 
+// StartTCPTunnels opens listeners for the TCP tunnels announced by a registered
+// agent. Failing to do so is not fatal for the agent connection, which keeps
+// serving HTTP. Tunnels already opened for another stream of the agent are
+// shared with it.
+func (cm *ConnectionManager) StartTCPTunnels(agentID string, tunnels []string) error {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	conns, ok := cm.agents[agentID]
+	if !ok {
+		return fmt.Errorf("agent not found: %s", agentID)
+	}
+	if len(conns[0].TCPTunnels) > 0 {
+		return nil
+	}
+
+	if cm.tcpTunnelManager == nil {
+		slog.Warn("Agent announced TCP tunnels but TCP forwarding is disabled",
+			"agent_id", agentID,
+			"tunnels", tunnels)
+		return nil
+	}
+
+	ports, err := cm.tcpTunnelManager.StartAgentTunnels(agentID, tunnels)
+	if err != nil {
+		return fmt.Errorf("failed to start TCP tunnels: %w", err)
+	}
+	for _, conn := range conns {
+		conn.TCPTunnels = ports
+	}
+
+	slog.Info("Agent TCP tunnels started", "agent_id", agentID, "tunnels", ports)
+
+	return nil
+}
+
+// stopTCPTunnels closes the tunnel listeners of conn. Callers must hold cm.mu.
+func (cm *ConnectionManager) stopTCPTunnels(conn *AgentConnection) {
+	if cm.tcpTunnelManager == nil || len(conn.TCPTunnels) == 0 {
+		return
+	}
+
+	if err := cm.tcpTunnelManager.StopAgentTunnels(conn.ID); err != nil {
+		slog.Error("Failed to stop agent TCP tunnels",
+			"agent_id", conn.ID,
+			"error", err)
+	}
+}
+
+// SendToAgent queues msg on the connection GetConnection returns. Frames that
+// belong to a request or session already under way must go to its stream
+// with sendTo instead.
 func (cm *ConnectionManager) SendToAgent(agentID string, msg *proto.ProxyMessage) error {
+	conn, ok := cm.GetConnection(agentID)
+	if !ok {
+		return fmt.Errorf("agent not found: %s", agentID)
+	}
+	return cm.sendTo(conn, msg)
+}
+
+// sendTo queues msg on conn, failing with errConnectionClosed once conn has
+// ended.
+func (cm *ConnectionManager) sendTo(conn *AgentConnection, msg *proto.ProxyMessage) error {
 	cm.mu.RLock()
-	conn, ok := cm.agents[agentID]
+	timeout := cm.sendTimeout
 	cm.mu.RUnlock()
 
-	if !ok {
-		return fmt.Errorf("agent not found: %s", agentID)
+	if conn.ctx.Err() != nil {
+		return fmt.Errorf("%w: %s", errConnectionClosed, conn.ID)
 	}
 
 	select {
 	case conn.SendCh <- msg:
-		slog.Debug("Message queued for agent", "agent_id", agentID, "message_id", msg.Id, "type", msg.Type)
+		slog.Debug("Message queued for agent", "agent_id", conn.ID, "message_id", msg.Id, "type", msg.Type)
 		return nil
-	case <-time.After(sendTimeout):
-		return fmt.Errorf("timeout sending message to agent: %s", agentID)
+	case <-time.After(timeout):
+		metrics.SendTimeouts.WithLabelValues(conn.ID).Inc()
+		return fmt.Errorf("timeout sending message to agent: %s", conn.ID)
 	case <-conn.ctx.Done():
-		return fmt.Errorf("agent connection closed: %s", agentID)
+		return fmt.Errorf("%w: %s", errConnectionClosed, conn.ID)
 	}
 }
 
+// UpdateLastSeen marks every connection of agentID as seen now.
 func (cm *ConnectionManager) UpdateLastSeen(agentID string) {
 	cm.mu.Lock()
 	defer cm.mu.Unlock()
 
-	if conn, ok := cm.agents[agentID]; ok {
+	for _, conn := range cm.agents[agentID] {
 		conn.LastSeen = time.Now()
-		slog.Debug("Agent last seen updated", "agent_id", agentID)
 	}
+	slog.Debug("Agent last seen updated", "agent_id", agentID)
+}
+
+// touch marks conn as seen now.
+func (cm *ConnectionManager) touch(conn *AgentConnection) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	conn.LastSeen = time.Now()
 }
 
+// GetConnection returns the connection of agentID that the next request
+// would go to: the least busy open one that is not draining, or the oldest if
+// none is.
 func (cm *ConnectionManager) GetConnection(agentID string) (*AgentConnection, bool) {
 	cm.mu.RLock()
 	defer cm.mu.RUnlock()
 
-	conn, ok := cm.agents[agentID]
+	return cm.connection(agentID)
+}
+
+// pick is GetConnection for callers about to send new work to the returned
+// connection. Equally busy streams are picked in turn.
+func (cm *ConnectionManager) pick(agentID string) (*AgentConnection, bool) {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+
+	conn, ok := cm.connection(agentID)
+	if ok {
+		conn.picked.Store(cm.picks.Add(1))
+	}
 	return conn, ok
 }
 
+// connection is GetConnection for callers holding cm.mu.
+func (cm *ConnectionManager) connection(agentID string) (*AgentConnection, bool) {
+	conns := cm.agents[agentID]
+	if len(conns) == 0 {
+		return nil, false
+	}
+
+	var best *AgentConnection
+	for _, conn := range conns {
+		if conn.Draining() || conn.ctx.Err() != nil {
+			continue
+		}
+		// Of equally busy streams, the one picked least recently wins.
+		if best == nil || conn.load() < best.load() ||
+			conn.load() == best.load() && conn.picked.Load() < best.picked.Load() {
+			best = conn
+		}
+	}
+	if best == nil {
+		return conns[0], true
+	}
+	return best, true
+}
+
+// Connections returns every connection of agentID, oldest first.
+func (cm *ConnectionManager) Connections(agentID string) []*AgentConnection {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+
+	return slices.Clone(cm.agents[agentID])
+}
+
 func (cm *ConnectionManager) ListConnections() []string {
 	cm.mu.RLock()
 	defer cm.mu.RUnlock()
@@ -209,15 +550,40 @@ func (cm *ConnectionManager) ListConnections() []string {
 	return agentIDs
 }
 
+// SendQueueDepths returns the number of messages waiting in the send channels
+// of every connected agent.
+func (cm *ConnectionManager) SendQueueDepths() map[string]int {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+
+	depths := make(map[string]int, len(cm.agents))
+	for id, conns := range cm.agents {
+		for _, conn := range conns {
+			depths[id] += len(conn.SendCh)
+		}
+	}
+	return depths
+}
+
 func (cm *ConnectionManager) Stop() {
 	close(cm.stopCh)
 
 	cm.mu.Lock()
 	defer cm.mu.Unlock()
 
-	for agentID, conn := range cm.agents {
-		conn.cancel()
-		close(conn.SendCh)
+	for agentID := range cm.reconnecting {
+		wait, _ := cm.takeReconnectWait(agentID)
+		wait.finish(nil)
+	}
+
+	for agentID, conns := range cm.agents {
+		for _, conn := range conns {
+			conn.cancel()
+			if conn.reconnect != nil {
+				conn.reconnect.finish(nil)
+			}
+		}
+		conn := conns[0]
 
 		// Stop agent HTTP server if manager available
 		if cm.agentServerManager != nil && conn.Port != 0 {
@@ -228,8 +594,11 @@ func (cm *ConnectionManager) Stop() {
 					"error", err)
 			}
 		}
+		cm.stopTCPTunnels(conn)
+		cm.recordSessionEnded(agentID, DisconnectReasonShutdown)
 	}
-	cm.agents = make(map[string]*AgentConnection)
+	cm.agents = make(map[string][]*AgentConnection)
+	cm.updateConnectionMetrics()
 
 	// Shutdown all agent servers if manager available
 	if cm.agentServerManager != nil {
@@ -237,6 +606,12 @@ func (cm *ConnectionManager) Stop() {
 			slog.Error("Failed to shutdown agent server manager", "error", err)
 		}
 	}
+
+	if cm.tcpTunnelManager != nil {
+		if err := cm.tcpTunnelManager.Shutdown(); err != nil {
+			slog.Error("Failed to shutdown TCP tunnel manager", "error", err)
+		}
+	}
 }
 
 func (cm *ConnectionManager) cleanupStaleConnections() {
@@ -258,27 +633,53 @@ func (cm *ConnectionManager) removeStaleConnections() {
 	defer cm.mu.Unlock()
 
 	now := time.Now()
-	for agentID, conn := range cm.agents {
-		if now.Sub(conn.LastSeen) > staleConnectionTimeout {
-			slog.Warn("Removing stale connection",
-				"agent_id", agentID,
-				"last_seen", conn.LastSeen,
-				"port", conn.Port)
+	for agentID, conns := range cm.agents {
+		for _, conn := range conns {
+			if now.Sub(conn.LastSeen) > cm.staleTimeout {
+				slog.Warn("Removing stale connection",
+					"agent_id", agentID,
+					"last_seen", conn.LastSeen,
+					"port", conn.Port)
 
-			conn.cancel()
-			close(conn.SendCh)
-
-			// Stop agent HTTP server if manager available
-			if cm.agentServerManager != nil && conn.Port != 0 {
-				if err := cm.agentServerManager.StopAgentServer(agentID); err != nil {
-					slog.Error("Failed to stop agent HTTP server during stale connection cleanup",
-						"agent_id", agentID,
-						"port", conn.Port,
-						"error", err)
-				}
+				cm.remove(conn, DisconnectReasonStale)
 			}
-
-			delete(cm.agents, agentID)
 		}
 	}
 }
+
+// updateConnectionMetrics sets the gauges of connected agents and their
+// streams. Callers must hold cm.mu.
+func (cm *ConnectionManager) updateConnectionMetrics() {
+	streams := 0
+	for _, conns := range cm.agents {
+		streams += len(conns)
+	}
+	metrics.ConnectedAgents.Set(float64(len(cm.agents)))
+	metrics.AgentStreams.Set(float64(streams))
+}
+
+// recordSessionEnded reports the end of agentID's connection. Callers must
+// hold cm.mu.
+func (cm *ConnectionManager) recordSessionEnded(agentID, reason string) {
+	if cm.sessionRecorder != nil {
+		cm.sessionRecorder.SessionEnded(agentID, reason, time.Now())
+	}
+}
+
+func newSessionInfo(conn *AgentConnection) SessionInfo {
+	info := SessionInfo{
+		AgentID:     conn.ID,
+		Port:        conn.Port,
+		ConnectedAt: conn.LastSeen,
+	}
+	if conn.Stream == nil {
+		return info
+	}
+	if p, ok := peer.FromContext(conn.Stream.Context()); ok && p.Addr != nil {
+		info.RemoteAddr = p.Addr.String()
+	}
+	if leaf, ok := grpctls.VerifiedPeerCertificate(conn.Stream.Context()); ok {
+		info.CertSerial = leaf.SerialNumber.Text(16)
+	}
+	return info
+}
@@ -5,7 +5,9 @@ import (
 	"testing"
 	"time"
 
+	"github.com/EternisAI/silo-proxy/internal/metrics"
 	"github.com/EternisAI/silo-proxy/proto"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
@@ -32,6 +34,27 @@ func (m *MockAgentServerManager) Shutdown() error {
 	return args.Error(0)
 }
 
+// MockTCPTunnelManager is a mock implementation of TCPTunnelManager
+type MockTCPTunnelManager struct {
+	mock.Mock
+}
+
+func (m *MockTCPTunnelManager) StartAgentTunnels(agentID string, tunnels []string) (map[string]int, error) {
+	args := m.Called(agentID, tunnels)
+	ports, _ := args.Get(0).(map[string]int)
+	return ports, args.Error(1)
+}
+
+func (m *MockTCPTunnelManager) StopAgentTunnels(agentID string) error {
+	args := m.Called(agentID)
+	return args.Error(0)
+}
+
+func (m *MockTCPTunnelManager) Shutdown() error {
+	args := m.Called()
+	return args.Error(0)
+}
+
 // MockStream is a mock implementation of proto.ProxyService_StreamServer
 type MockStream struct {
 	mock.Mock
@@ -51,7 +74,8 @@ func (m *MockStream) Send(*proto.ProxyMessage) error {
 
 func (m *MockStream) Recv() (*proto.ProxyMessage, error) {
 	args := m.Called()
-	return nil, args.Error(0)
+	msg, _ := args.Get(0).(*proto.ProxyMessage)
+	return msg, args.Error(1)
 }
 
 func (m *MockStream) SetHeader(md metadata.MD) error {
@@ -103,7 +127,7 @@ func TestConnectionManager_Register_WithoutServerManager(t *testing.T) {
 	defer cm.Stop()
 
 	mockStream := NewMockStream()
-	conn, err := cm.Register("agent-1", mockStream)
+	conn, err := cm.Register("agent-1", mockStream, AgentInfo{})
 
 	require.NoError(t, err)
 	assert.NotNil(t, conn)
@@ -121,7 +145,7 @@ func TestConnectionManager_Register_WithServerManager(t *testing.T) {
 	cm := NewConnectionManager(mockASM)
 
 	mockStream := NewMockStream()
-	conn, err := cm.Register("agent-1", mockStream)
+	conn, err := cm.Register("agent-1", mockStream, AgentInfo{})
 
 	require.NoError(t, err)
 	assert.NotNil(t, conn)
@@ -141,7 +165,7 @@ func TestConnectionManager_Register_ServerManagerError(t *testing.T) {
 	cm := NewConnectionManager(mockASM)
 
 	mockStream := NewMockStream()
-	conn, err := cm.Register("agent-1", mockStream)
+	conn, err := cm.Register("agent-1", mockStream, AgentInfo{})
 
 	assert.Error(t, err)
 	assert.Nil(t, conn)
@@ -164,13 +188,13 @@ func TestConnectionManager_Register_ReplaceExisting(t *testing.T) {
 
 	// Register first connection
 	mockStream1 := NewMockStream()
-	conn1, err := cm.Register("agent-1", mockStream1)
+	conn1, err := cm.Register("agent-1", mockStream1, AgentInfo{})
 	require.NoError(t, err)
 	assert.Equal(t, 8100, conn1.Port)
 
 	// Register second connection (should replace first)
 	mockStream2 := NewMockStream()
-	conn2, err := cm.Register("agent-1", mockStream2)
+	conn2, err := cm.Register("agent-1", mockStream2, AgentInfo{})
 	require.NoError(t, err)
 	assert.Equal(t, 8101, conn2.Port)
 
@@ -188,7 +212,7 @@ func TestConnectionManager_Deregister_WithoutServerManager(t *testing.T) {
 	defer cm.Stop()
 
 	mockStream := NewMockStream()
-	conn, err := cm.Register("agent-1", mockStream)
+	conn, err := cm.Register("agent-1", mockStream, AgentInfo{})
 	require.NoError(t, err)
 	require.NotNil(t, conn)
 
@@ -211,7 +235,7 @@ func TestConnectionManager_Deregister_WithServerManager(t *testing.T) {
 	}()
 
 	mockStream := NewMockStream()
-	conn, err := cm.Register("agent-1", mockStream)
+	conn, err := cm.Register("agent-1", mockStream, AgentInfo{})
 	require.NoError(t, err)
 	require.NotNil(t, conn)
 
@@ -243,11 +267,11 @@ func TestConnectionManager_Stop_WithServerManager(t *testing.T) {
 	cm := NewConnectionManager(mockASM)
 
 	mockStream1 := NewMockStream()
-	_, err := cm.Register("agent-1", mockStream1)
+	_, err := cm.Register("agent-1", mockStream1, AgentInfo{})
 	require.NoError(t, err)
 
 	mockStream2 := NewMockStream()
-	_, err = cm.Register("agent-2", mockStream2)
+	_, err = cm.Register("agent-2", mockStream2, AgentInfo{})
 	require.NoError(t, err)
 
 	cm.Stop()
@@ -264,7 +288,7 @@ func TestConnectionManager_GetConnection(t *testing.T) {
 	defer cm.Stop()
 
 	mockStream := NewMockStream()
-	registered, err := cm.Register("agent-1", mockStream)
+	registered, err := cm.Register("agent-1", mockStream, AgentInfo{})
 	require.NoError(t, err)
 
 	conn, ok := cm.GetConnection("agent-1")
@@ -287,7 +311,7 @@ func TestConnectionManager_ListConnections(t *testing.T) {
 	for i := 1; i <= 3; i++ {
 		mockStream := NewMockStream()
 		agentID := "agent-" + string(rune('0'+i))
-		_, err := cm.Register(agentID, mockStream)
+		_, err := cm.Register(agentID, mockStream, AgentInfo{})
 		require.NoError(t, err)
 	}
 
@@ -303,7 +327,7 @@ func TestConnectionManager_UpdateLastSeen(t *testing.T) {
 	defer cm.Stop()
 
 	mockStream := NewMockStream()
-	conn, err := cm.Register("agent-1", mockStream)
+	conn, err := cm.Register("agent-1", mockStream, AgentInfo{})
 	require.NoError(t, err)
 
 	initialTime := conn.LastSeen
@@ -333,7 +357,7 @@ func TestConnectionManager_RemoveStaleConnections_WithServerManager(t *testing.T
 	}()
 
 	mockStream := NewMockStream()
-	conn, err := cm.Register("agent-1", mockStream)
+	conn, err := cm.Register("agent-1", mockStream, AgentInfo{})
 	require.NoError(t, err)
 
 	// Manually set LastSeen to past
@@ -371,7 +395,7 @@ func TestConnectionManager_ConcurrentRegistration(t *testing.T) {
 		go func(id int) {
 			agentID := "agent-" + string(rune('0'+id))
 			mockStream := NewMockStream()
-			_, err := cm.Register(agentID, mockStream)
+			_, err := cm.Register(agentID, mockStream, AgentInfo{})
 			assert.NoError(t, err)
 			done <- true
 		}(i)
@@ -400,7 +424,7 @@ func TestConnectionManager_PortFieldPersistence(t *testing.T) {
 	cm := NewConnectionManager(mockASM)
 
 	mockStream := NewMockStream()
-	_, err := cm.Register("agent-1", mockStream)
+	_, err := cm.Register("agent-1", mockStream, AgentInfo{})
 	require.NoError(t, err)
 
 	// Retrieve connection and verify port
@@ -424,7 +448,7 @@ func TestAgentConnection_PortFieldZeroWithoutManager(t *testing.T) {
 	defer cm.Stop()
 
 	mockStream := NewMockStream()
-	conn, err := cm.Register("agent-1", mockStream)
+	conn, err := cm.Register("agent-1", mockStream, AgentInfo{})
 	require.NoError(t, err)
 
 	// Port should be 0 when no server manager
@@ -439,7 +463,7 @@ func BenchmarkConnectionManager_Register(b *testing.B) {
 	for i := 0; i < b.N; i++ {
 		mockStream := NewMockStream()
 		agentID := "agent-bench"
-		cm.Register(agentID, mockStream)
+		cm.Register(agentID, mockStream, AgentInfo{})
 		cm.Deregister(agentID)
 	}
 }
@@ -457,7 +481,248 @@ func BenchmarkConnectionManager_RegisterWithServerManager(b *testing.B) {
 	for i := 0; i < b.N; i++ {
 		mockStream := NewMockStream()
 		agentID := "agent-bench"
-		cm.Register(agentID, mockStream)
+		cm.Register(agentID, mockStream, AgentInfo{})
 		cm.Deregister(agentID)
 	}
 }
+
+func TestConnectionManager_StartTCPTunnels(t *testing.T) {
+	mockTTM := new(MockTCPTunnelManager)
+	mockTTM.On("StartAgentTunnels", "agent-1", []string{"postgres", "ssh"}).
+		Return(map[string]int{"postgres": 8200, "ssh": 8201}, nil)
+	mockTTM.On("StopAgentTunnels", "agent-1").Return(nil)
+
+	cm := NewConnectionManager(nil)
+	cm.SetTCPTunnelManager(mockTTM)
+	defer func() {
+		mockTTM.On("Shutdown").Return(nil)
+		cm.Stop()
+	}()
+
+	_, err := cm.Register("agent-1", NewMockStream(), AgentInfo{})
+	require.NoError(t, err)
+
+	err = cm.StartTCPTunnels("agent-1", []string{"postgres", "ssh"})
+	require.NoError(t, err)
+
+	conn, ok := cm.GetConnection("agent-1")
+	require.True(t, ok)
+	assert.Equal(t, map[string]int{"postgres": 8200, "ssh": 8201}, conn.TCPTunnels)
+
+	cm.Deregister("agent-1")
+
+	mockTTM.AssertExpectations(t)
+}
+
+func TestConnectionManager_StartTCPTunnels_Error(t *testing.T) {
+	mockTTM := new(MockTCPTunnelManager)
+	mockTTM.On("StartAgentTunnels", "agent-1", []string{"ssh"}).Return(nil, assert.AnError)
+
+	cm := NewConnectionManager(nil)
+	cm.SetTCPTunnelManager(mockTTM)
+	defer func() {
+		mockTTM.On("Shutdown").Return(nil)
+		cm.Stop()
+	}()
+
+	_, err := cm.Register("agent-1", NewMockStream(), AgentInfo{})
+	require.NoError(t, err)
+
+	err = cm.StartTCPTunnels("agent-1", []string{"ssh"})
+	assert.ErrorIs(t, err, assert.AnError)
+
+	// The agent stays connected and nothing needs stopping on deregistration
+	conn, ok := cm.GetConnection("agent-1")
+	require.True(t, ok)
+	assert.Empty(t, conn.TCPTunnels)
+
+	cm.Deregister("agent-1")
+	mockTTM.AssertNotCalled(t, "StopAgentTunnels", "agent-1")
+}
+
+func TestConnectionManager_StartTCPTunnels_Disabled(t *testing.T) {
+	cm := NewConnectionManager(nil)
+	defer cm.Stop()
+
+	_, err := cm.Register("agent-1", NewMockStream(), AgentInfo{})
+	require.NoError(t, err)
+
+	err = cm.StartTCPTunnels("agent-1", []string{"ssh"})
+	require.NoError(t, err)
+
+	conn, ok := cm.GetConnection("agent-1")
+	require.True(t, ok)
+	assert.Empty(t, conn.TCPTunnels)
+}
+
+func TestConnectionManager_Metrics(t *testing.T) {
+	cm := NewConnectionManager(nil)
+	defer cm.Stop()
+
+	registrations := testutil.ToFloat64(metrics.AgentRegistrations)
+	deregistrations := testutil.ToFloat64(metrics.AgentDeregistrations)
+
+	_, err := cm.Register("agent-1", NewMockStream(), AgentInfo{})
+	require.NoError(t, err)
+	_, err = cm.Register("agent-2", NewMockStream(), AgentInfo{})
+	require.NoError(t, err)
+
+	assert.Equal(t, registrations+2, testutil.ToFloat64(metrics.AgentRegistrations))
+	assert.Equal(t, float64(2), testutil.ToFloat64(metrics.ConnectedAgents))
+
+	require.NoError(t, cm.SendToAgent("agent-1", &proto.ProxyMessage{Id: "msg-1"}))
+	assert.Equal(t, map[string]int{"agent-1": 1, "agent-2": 0}, cm.SendQueueDepths())
+
+	cm.Deregister("agent-1")
+
+	assert.Equal(t, deregistrations+1, testutil.ToFloat64(metrics.AgentDeregistrations))
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.ConnectedAgents))
+	assert.Equal(t, map[string]int{"agent-2": 0}, cm.SendQueueDepths())
+}
+
+func TestConnectionManager_StaleRemovalMetrics(t *testing.T) {
+	cm := NewConnectionManager(nil)
+	defer cm.Stop()
+
+	removals := testutil.ToFloat64(metrics.StaleConnectionRemovals)
+
+	conn, err := cm.Register("agent-1", NewMockStream(), AgentInfo{})
+	require.NoError(t, err)
+
+	cm.mu.Lock()
+	conn.LastSeen = time.Now().Add(-2 * staleConnectionTimeout)
+	cm.mu.Unlock()
+
+	cm.removeStaleConnections()
+
+	assert.Equal(t, removals+1, testutil.ToFloat64(metrics.StaleConnectionRemovals))
+	assert.Equal(t, float64(0), testutil.ToFloat64(metrics.ConnectedAgents))
+}
+
+type recordedSession struct {
+	agentID string
+	reason  string // empty for a session start
+}
+
+type fakeSessionRecorder struct {
+	events []recordedSession
+}
+
+func (r *fakeSessionRecorder) SessionStarted(session SessionInfo) {
+	r.events = append(r.events, recordedSession{agentID: session.AgentID})
+}
+
+func (r *fakeSessionRecorder) SessionEnded(agentID, reason string, _ time.Time) {
+	r.events = append(r.events, recordedSession{agentID: agentID, reason: reason})
+}
+
+func TestConnectionManager_SessionRecorder(t *testing.T) {
+	cm := NewConnectionManager(nil)
+	recorder := &fakeSessionRecorder{}
+	cm.SetSessionRecorder(recorder)
+
+	_, err := cm.Register("agent-1", NewMockStream(), AgentInfo{})
+	require.NoError(t, err)
+	_, err = cm.Register("agent-1", NewMockStream(), AgentInfo{})
+	require.NoError(t, err)
+	cm.Deregister("agent-1")
+
+	conn, err := cm.Register("agent-2", NewMockStream(), AgentInfo{})
+	require.NoError(t, err)
+	cm.mu.Lock()
+	conn.LastSeen = time.Now().Add(-2 * staleConnectionTimeout)
+	cm.mu.Unlock()
+	cm.removeStaleConnections()
+
+	_, err = cm.Register("agent-3", NewMockStream(), AgentInfo{})
+	require.NoError(t, err)
+	cm.Stop()
+
+	assert.Equal(t, []recordedSession{
+		{agentID: "agent-1"},
+		{agentID: "agent-1", reason: DisconnectReasonReplaced},
+		{agentID: "agent-1"},
+		{agentID: "agent-1", reason: DisconnectReasonClosed},
+		{agentID: "agent-2"},
+		{agentID: "agent-2", reason: DisconnectReasonStale},
+		{agentID: "agent-3"},
+		{agentID: "agent-3", reason: DisconnectReasonShutdown},
+	}, recorder.events)
+}
+
+func TestConnectionManager_DeregisterConn_IgnoresReplacedConnection(t *testing.T) {
+	cm := NewConnectionManager(nil)
+	defer cm.Stop()
+
+	old, err := cm.Register("agent-1", NewMockStream(), AgentInfo{})
+	require.NoError(t, err)
+	current, err := cm.Register("agent-1", NewMockStream(), AgentInfo{})
+	require.NoError(t, err)
+
+	cm.deregisterConn(old, DisconnectReasonClosed)
+
+	conn, ok := cm.GetConnection("agent-1")
+	require.True(t, ok)
+	assert.Same(t, current, conn)
+}
+
+func TestConnectionManager_MultipleStreams(t *testing.T) {
+	mockASM := new(MockAgentServerManager)
+	mockASM.On("StartAgentServer", "agent-1").Return(8100, nil).Once()
+	cm := NewConnectionManager(mockASM)
+	cm.SetMaxStreams(2)
+
+	conn1, err := cm.Register("agent-1", NewMockStream(), AgentInfo{})
+	require.NoError(t, err)
+	conn2, err := cm.Register("agent-1", NewMockStream(), AgentInfo{})
+	require.NoError(t, err)
+	assert.Equal(t, 8100, conn2.Port, "streams share the agent's server")
+	assert.Equal(t, []*AgentConnection{conn1, conn2}, cm.Connections("agent-1"))
+	assert.Equal(t, []string{"agent-1"}, cm.ListConnections())
+
+	// A third stream replaces the oldest one, keeping the server.
+	conn3, err := cm.Register("agent-1", NewMockStream(), AgentInfo{})
+	require.NoError(t, err)
+	assert.Error(t, conn1.ctx.Err())
+	assert.Equal(t, []*AgentConnection{conn2, conn3}, cm.Connections("agent-1"))
+
+	// The server stays up while any stream is left.
+	assert.True(t, cm.deregisterConn(conn2, DisconnectReasonClosed))
+	_, ok := cm.GetConnection("agent-1")
+	assert.True(t, ok)
+	mockASM.AssertExpectations(t)
+
+	mockASM.On("StopAgentServer", "agent-1").Return(nil).Once()
+	assert.True(t, cm.deregisterConn(conn3, DisconnectReasonClosed))
+	_, ok = cm.GetConnection("agent-1")
+	assert.False(t, ok)
+	mockASM.AssertExpectations(t)
+}
+
+func TestConnectionManager_GetConnection_BalancesStreams(t *testing.T) {
+	cm := NewConnectionManager(nil)
+	cm.SetMaxStreams(2)
+	defer cm.Stop()
+
+	conn1, err := cm.Register("agent-1", NewMockStream(), AgentInfo{})
+	require.NoError(t, err)
+	conn2, err := cm.Register("agent-1", NewMockStream(), AgentInfo{})
+	require.NoError(t, err)
+
+	picked := map[*AgentConnection]int{}
+	for range 10 {
+		conn, ok := cm.pick("agent-1")
+		require.True(t, ok)
+		picked[conn]++
+	}
+	assert.Equal(t, 5, picked[conn1], "idle streams take turns")
+	assert.Equal(t, 5, picked[conn2], "idle streams take turns")
+
+	conn1.inFlight.Add(1)
+	conn, _ := cm.GetConnection("agent-1")
+	assert.Same(t, conn2, conn, "the least busy stream is picked")
+
+	conn2.draining.Store(true)
+	conn, _ = cm.GetConnection("agent-1")
+	assert.Same(t, conn1, conn, "draining streams are skipped")
+}
@@ -0,0 +1,162 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"time"
+)
+
+// drainPollInterval is how often DrainAgent checks whether the agent's
+// pending requests have completed.
+const drainPollInterval = 100 * time.Millisecond
+
+// ErrAgentDraining is returned for new requests to an agent that is being
+// drained.
+var ErrAgentDraining = errors.New("agent is draining")
+
+// DisconnectAgent closes the connection of agentID. For a positive blockFor,
+// the agent is refused when it reconnects until blockFor has passed.
+func (s *Server) DisconnectAgent(agentID string, blockFor time.Duration) error {
+	return s.disconnect(agentID, DisconnectReasonAdmin, blockFor)
+}
+
+func (s *Server) disconnect(agentID, reason string, blockFor time.Duration) error {
+	if _, ok := s.connManager.GetConnection(agentID); !ok {
+		return fmt.Errorf("%w: %s", ErrAgentNotFound, agentID)
+	}
+
+	if blockFor > 0 {
+		s.BlockAgent(agentID, blockFor)
+	}
+	slog.Info("Disconnecting agent", "agent_id", agentID, "reason", reason, "block_for", blockFor)
+	s.connManager.deregister(agentID, reason)
+	return nil
+}
+
+// DrainAgent stops sending new requests and tunnels to agentID, waits for its
+// pending requests to complete, then disconnects it as DisconnectAgent does.
+// The agent is disconnected anyway once timeout has passed. It returns
+// early, leaving the agent draining, if ctx ends first. All of the agent's
+// current streams are drained together.
+func (s *Server) DrainAgent(ctx context.Context, agentID string, timeout, blockFor time.Duration) error {
+	conns := s.connManager.Connections(agentID)
+	if len(conns) == 0 {
+		return fmt.Errorf("%w: %s", ErrAgentNotFound, agentID)
+	}
+
+	for _, conn := range conns {
+		conn.draining.Store(true)
+	}
+	slog.Info("Draining agent",
+		"agent_id", agentID,
+		"streams", len(conns),
+		"pending_requests", s.PendingRequests(agentID),
+		"timeout", timeout)
+
+	deadline := time.After(timeout)
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+
+	for s.PendingRequests(agentID) > 0 {
+		select {
+		case <-ticker.C:
+			if !anyAlive(conns) {
+				return fmt.Errorf("agent disconnected while draining: %s", agentID)
+			}
+		case <-deadline:
+			slog.Warn("Drain timed out, disconnecting agent with requests pending",
+				"agent_id", agentID,
+				"pending_requests", s.PendingRequests(agentID))
+			return s.disconnectConns(agentID, conns, blockFor)
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	slog.Info("Agent drained", "agent_id", agentID)
+	return s.disconnectConns(agentID, conns, blockFor)
+}
+
+// anyAlive reports whether any of conns is still open.
+func anyAlive(conns []*AgentConnection) bool {
+	for _, conn := range conns {
+		if conn.ctx.Err() == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// disconnectConns disconnects the drained streams conns of agentID. Streams
+// the agent opened since the drain started are left alone.
+func (s *Server) disconnectConns(agentID string, conns []*AgentConnection, blockFor time.Duration) error {
+	current := s.connManager.Connections(agentID)
+	conns = slices.DeleteFunc(conns, func(conn *AgentConnection) bool {
+		return !slices.Contains(current, conn)
+	})
+	if len(conns) == 0 {
+		return fmt.Errorf("agent disconnected while draining: %s", agentID)
+	}
+
+	if blockFor > 0 {
+		s.BlockAgent(agentID, blockFor)
+	}
+	slog.Info("Disconnecting agent", "agent_id", agentID, "reason", DisconnectReasonDrained, "block_for", blockFor)
+	for _, conn := range conns {
+		s.connManager.deregisterConn(conn, DisconnectReasonDrained)
+	}
+	return nil
+}
+
+// PendingRequests returns the number of requests sent to agentID whose
+// responses have not been fully read yet.
+func (s *Server) PendingRequests(agentID string) int {
+	s.pendingMu.RLock()
+	defer s.pendingMu.RUnlock()
+
+	n := 0
+	for _, pending := range s.pendingRequests {
+		if pending.agentID == agentID {
+			n++
+		}
+	}
+	return n
+}
+
+// BlockAgent refuses connections from agentID for the next d.
+func (s *Server) BlockAgent(agentID string, d time.Duration) {
+	s.blockedMu.Lock()
+	defer s.blockedMu.Unlock()
+	s.blocked[agentID] = time.Now().Add(d)
+}
+
+// UnblockAgent lets agentID connect again and reports whether it was
+// blocked.
+func (s *Server) UnblockAgent(agentID string) bool {
+	s.blockedMu.Lock()
+	defer s.blockedMu.Unlock()
+
+	until, ok := s.blocked[agentID]
+	delete(s.blocked, agentID)
+	return ok && time.Now().Before(until)
+}
+
+// BlockedUntil returns when agentID may connect again, or false if it is not
+// blocked.
+func (s *Server) BlockedUntil(agentID string) (time.Time, bool) {
+	s.blockedMu.Lock()
+	defer s.blockedMu.Unlock()
+
+	until, ok := s.blocked[agentID]
+	if !ok {
+		return time.Time{}, false
+	}
+	if !time.Now().Before(until) {
+		delete(s.blocked, agentID)
+		return time.Time{}, false
+	}
+	return until, true
+}
@@ -0,0 +1,102 @@
+package server
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/EternisAI/silo-proxy/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestDisconnectAgent_BlocksReconnect(t *testing.T) {
+	s := NewServer(0, nil)
+	s.SetAllowUnverifiedAgentID(true)
+	conn, err := s.connManager.Register("agent-1", NewMockStream(), currentAgent)
+	require.NoError(t, err)
+
+	require.NoError(t, s.DisconnectAgent("agent-1", time.Minute))
+	_, ok := s.connManager.GetConnection("agent-1")
+	assert.False(t, ok)
+	assert.Error(t, conn.ctx.Err())
+
+	until, blocked := s.BlockedUntil("agent-1")
+	require.True(t, blocked)
+	assert.WithinDuration(t, time.Now().Add(time.Minute), until, time.Second)
+
+	stream := NewMockStream()
+	stream.On("Recv").Return(firstMessage("agent-1"), nil).Once()
+	err = s.streamHandler.HandleStream(stream)
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	assert.True(t, s.UnblockAgent("agent-1"))
+	_, blocked = s.BlockedUntil("agent-1")
+	assert.False(t, blocked)
+
+	assert.ErrorIs(t, s.DisconnectAgent("agent-1", 0), ErrAgentNotFound)
+}
+
+func TestDrainAgent_WaitsForPendingRequests(t *testing.T) {
+	s := NewServer(0, nil)
+	conn, err := s.connManager.Register("agent-1", NewMockStream(), currentAgent)
+	require.NoError(t, err)
+
+	responseCh := make(chan *AgentResponse, 1)
+	go func() {
+		resp, err := s.SendRequestToAgent(context.Background(), "agent-1", requestStart("req-1"), nil, 0)
+		assert.NoError(t, err)
+		responseCh <- resp
+	}()
+	nextFrame(t, conn)
+	nextFrame(t, conn)
+	require.Equal(t, 1, s.PendingRequests("agent-1"))
+
+	drained := make(chan error, 1)
+	go func() {
+		drained <- s.DrainAgent(context.Background(), "agent-1", 5*time.Second, 0)
+	}()
+	require.Eventually(t, conn.Draining, time.Second, 10*time.Millisecond)
+
+	_, err = s.SendRequestToAgent(context.Background(), "agent-1", requestStart("req-2"), nil, 0)
+	assert.ErrorIs(t, err, ErrAgentDraining)
+
+	s.HandleResponse(&proto.ProxyMessage{Id: "req-1", Type: proto.MessageType_RESPONSE, Payload: []byte("done")})
+	resp := <-responseCh
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "done", string(body))
+
+	select {
+	case err := <-drained:
+		t.Fatalf("drain finished before the response body was closed: %v", err)
+	case <-time.After(3 * drainPollInterval):
+	}
+	_, ok := s.connManager.GetConnection("agent-1")
+	assert.True(t, ok)
+
+	resp.Body.Close()
+	require.NoError(t, <-drained)
+	_, ok = s.connManager.GetConnection("agent-1")
+	assert.False(t, ok)
+}
+
+func TestDrainAgent_Timeout(t *testing.T) {
+	s := NewServer(0, nil)
+	conn, err := s.connManager.Register("agent-1", NewMockStream(), currentAgent)
+	require.NoError(t, err)
+
+	go func() {
+		_, _ = s.SendRequestToAgent(context.Background(), "agent-1", requestStart("req-1"), nil, time.Minute)
+	}()
+	nextFrame(t, conn)
+
+	require.NoError(t, s.DrainAgent(context.Background(), "agent-1", 200*time.Millisecond, time.Minute))
+	_, ok := s.connManager.GetConnection("agent-1")
+	assert.False(t, ok)
+	_, blocked := s.BlockedUntil("agent-1")
+	assert.True(t, blocked)
+}
@@ -0,0 +1,91 @@
+package server
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/EternisAI/silo-proxy/internal/grpc/protocol"
+	"github.com/EternisAI/silo-proxy/proto"
+	"github.com/google/uuid"
+)
+
+// AgentInfo is what an agent reported about itself in its HELLO, and what was
+// negotiated with it. Agents that predate the handshake open with a PING and
+// only have ProtocolVersion 1.
+type AgentInfo struct {
+	ProtocolVersion int
+	Version         string
+	OS              string
+	Arch            string
+	Hostname        string
+	// Capabilities are the optional features enabled for the connection.
+	Capabilities []string
+}
+
+// Supports reports whether capability was negotiated with the agent.
+func (i AgentInfo) Supports(capability string) bool {
+	return slices.Contains(i.Capabilities, capability)
+}
+
+// Legacy reports whether the agent predates the handshake. Such agents only
+// understand whole requests in a single REQUEST frame, and answer with a
+// single RESPONSE.
+func (i AgentInfo) Legacy() bool {
+	return i.ProtocolVersion == 1
+}
+
+// SetVersion sets the build version reported to agents in HELLO_ACK.
+func (s *Server) SetVersion(version string) {
+	s.version = version
+}
+
+// SetMinProtocolVersion rejects agents that cannot speak at least version.
+// Version 1 admits agents that predate the handshake. It must be called
+// before Start.
+func (s *Server) SetMinProtocolVersion(version int) {
+	s.minProtocolVersion = max(version, protocol.MinVersion)
+}
+
+// handshake negotiates the protocol with the agent that sent firstMsg. For a
+// HELLO it returns the HELLO_ACK to send, which carries the error if the agent
+// is rejected.
+func (s *Server) handshake(firstMsg *proto.ProxyMessage) (AgentInfo, *proto.ProxyMessage, error) {
+	if firstMsg.Type != proto.MessageType_HELLO {
+		if s.minProtocolVersion > 1 {
+			return AgentInfo{}, nil, fmt.Errorf("agent does not support protocol version %d or later", s.minProtocolVersion)
+		}
+		return AgentInfo{ProtocolVersion: 1}, nil, nil
+	}
+
+	hello := firstMsg.GetHello()
+	ack := &proto.ProxyMessage{
+		Id:       uuid.New().String(),
+		Type:     proto.MessageType_HELLO_ACK,
+		Metadata: map[string]string{},
+		Hello: &proto.Hello{
+			MinProtocolVersion: uint32(s.minProtocolVersion),
+			Version:            s.version,
+		},
+	}
+
+	version, ok := protocol.NegotiateVersion(s.minProtocolVersion, protocol.Version,
+		int(hello.GetMinProtocolVersion()), int(hello.GetProtocolVersion()))
+	if !ok {
+		err := fmt.Errorf("agent speaks protocol versions %d to %d, server requires %d to %d",
+			hello.GetMinProtocolVersion(), hello.GetProtocolVersion(), s.minProtocolVersion, protocol.Version)
+		ack.Metadata["error"] = err.Error()
+		return AgentInfo{}, ack, err
+	}
+
+	info := AgentInfo{
+		ProtocolVersion: version,
+		Version:         hello.GetVersion(),
+		OS:              hello.GetOs(),
+		Arch:            hello.GetArch(),
+		Hostname:        hello.GetHostname(),
+		Capabilities:    protocol.NegotiateCapabilities(protocol.Capabilities, hello.GetCapabilities()),
+	}
+	ack.Hello.ProtocolVersion = uint32(version)
+	ack.Hello.Capabilities = info.Capabilities
+	return info, ack, nil
+}
@@ -0,0 +1,90 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/EternisAI/silo-proxy/internal/grpc/protocol"
+	"github.com/EternisAI/silo-proxy/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func helloMessage(lowest, highest int, capabilities ...string) *proto.ProxyMessage {
+	return &proto.ProxyMessage{
+		Type:     proto.MessageType_HELLO,
+		Metadata: map[string]string{"agent_id": "agent-1"},
+		Hello: &proto.Hello{
+			ProtocolVersion:    uint32(highest),
+			MinProtocolVersion: uint32(lowest),
+			Version:            "v1.2.3",
+			Os:                 "linux",
+			Arch:               "arm64",
+			Hostname:           "edge-1",
+			Capabilities:       capabilities,
+		},
+	}
+}
+
+func TestHandshake_Hello(t *testing.T) {
+	s := NewServer(0, nil)
+	s.SetVersion("v2.0.0")
+
+	info, ack, err := s.handshake(helloMessage(1, protocol.Version+1, protocol.Headers, "teleport"))
+	require.NoError(t, err)
+
+	assert.Equal(t, AgentInfo{
+		ProtocolVersion: protocol.Version,
+		Version:         "v1.2.3",
+		OS:              "linux",
+		Arch:            "arm64",
+		Hostname:        "edge-1",
+		Capabilities:    []string{protocol.Headers},
+	}, info)
+	assert.True(t, info.Supports(protocol.Headers))
+	assert.False(t, info.Supports(protocol.Cancel))
+
+	require.NotNil(t, ack)
+	assert.Equal(t, proto.MessageType_HELLO_ACK, ack.Type)
+	assert.Empty(t, ack.Metadata["error"])
+	assert.Equal(t, uint32(protocol.Version), ack.Hello.ProtocolVersion)
+	assert.Equal(t, "v2.0.0", ack.Hello.Version)
+	assert.Equal(t, []string{protocol.Headers}, ack.Hello.Capabilities)
+}
+
+func TestHandshake_LegacyPing(t *testing.T) {
+	s := NewServer(0, nil)
+
+	info, ack, err := s.handshake(firstMessage("agent-1"))
+	require.NoError(t, err)
+	assert.Nil(t, ack)
+	assert.Equal(t, AgentInfo{ProtocolVersion: 1}, info)
+
+	s.SetMinProtocolVersion(2)
+	_, _, err = s.handshake(firstMessage("agent-1"))
+	assert.Error(t, err)
+}
+
+func TestHandshake_IncompatibleVersion(t *testing.T) {
+	s := NewServer(0, nil)
+
+	_, ack, err := s.handshake(helloMessage(protocol.Version+1, protocol.Version+2))
+	require.Error(t, err)
+	require.NotNil(t, ack)
+	assert.Equal(t, proto.MessageType_HELLO_ACK, ack.Type)
+	assert.Contains(t, ack.Metadata["error"], "protocol versions")
+}
+
+func TestCancelRequest_SkippedForLegacyAgents(t *testing.T) {
+	s := NewServer(0, nil)
+	conn, err := s.connManager.Register("agent-1", NewMockStream(), AgentInfo{ProtocolVersion: 1})
+	require.NoError(t, err)
+
+	s.cancelRequest(conn, "req-1", "timeout")
+
+	select {
+	case msg := <-conn.SendCh:
+		t.Fatalf("unexpected %s frame", msg.Type)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -0,0 +1,180 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"github.com/EternisAI/silo-proxy/internal/metrics"
+)
+
+// defaultMaxQueued is how many requests are held per reconnecting agent
+// unless SetReconnectGrace says otherwise.
+const defaultMaxQueued = 100
+
+var (
+	// ErrAgentUnavailable is returned for requests held for an agent that
+	// did not reconnect within its grace period.
+	ErrAgentUnavailable = errors.New("agent did not reconnect in time")
+	// ErrReconnectQueueFull is returned for requests to a reconnecting agent
+	// that already has as many requests waiting as allowed.
+	ErrReconnectQueueFull = errors.New("too many requests waiting for agent to reconnect")
+)
+
+// reconnectWait holds the requests for an agent whose stream dropped until it
+// reconnects. Its fields other than queued are guarded by
+// ConnectionManager.mu; queued only grows under it.
+type reconnectWait struct {
+	port   int         // The agent's HTTP server port, kept while it is away
+	timer  *time.Timer // Ends the grace period
+	queued atomic.Int64
+	conn   *AgentConnection // The new connection, set before done is closed
+	done   chan struct{}
+}
+
+// finish releases the waiting requests to conn, or fails them if conn is nil.
+func (w *reconnectWait) finish(conn *AgentConnection) {
+	w.conn = conn
+	close(w.done)
+}
+
+// SetReconnectGrace makes agents whose stream drops keep their HTTP server
+// and port for grace. Up to maxQueued requests for such an agent wait for it
+// to reconnect; zero keeps the current limit. A zero grace stops agents'
+// servers as soon as their stream ends.
+func (cm *ConnectionManager) SetReconnectGrace(grace time.Duration, maxQueued int) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	cm.reconnectGrace = grace
+	if maxQueued > 0 {
+		cm.maxQueued = maxQueued
+	}
+}
+
+// AwaitConnection returns the connection of agentID as GetConnection does. If
+// the agent is within its reconnect grace period, it waits until the agent is
+// back and has completed its handshake, and fails with ErrAgentUnavailable if
+// the grace period ends first.
+func (cm *ConnectionManager) AwaitConnection(ctx context.Context, agentID string) (*AgentConnection, error) {
+	cm.mu.Lock()
+	wait, ok := cm.reconnecting[agentID]
+	for _, conn := range cm.agents[agentID] {
+		if conn.reconnect != nil {
+			wait, ok = conn.reconnect, true
+		}
+	}
+	if !ok {
+		conn, connected := cm.connection(agentID)
+		cm.mu.Unlock()
+		if connected {
+			return conn, nil
+		}
+		return nil, fmt.Errorf("%w: %s", ErrAgentNotFound, agentID)
+	}
+	if wait.queued.Load() >= int64(cm.maxQueued) {
+		cm.mu.Unlock()
+		return nil, ErrReconnectQueueFull
+	}
+	wait.queued.Add(1)
+	cm.mu.Unlock()
+
+	// Not under cm.mu, which is held while the agent's server is stopped
+	// and waits for this request.
+	metrics.ReconnectQueuedRequests.Inc()
+	defer func() {
+		wait.queued.Add(-1)
+		metrics.ReconnectQueuedRequests.Dec()
+	}()
+
+	select {
+	case <-wait.done:
+		if wait.conn == nil {
+			return nil, ErrAgentUnavailable
+		}
+		return wait.conn, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// holdForReconnect keeps conn's HTTP server running and starts holding
+// requests for its agent for the grace period. Callers must hold cm.mu.
+func (cm *ConnectionManager) holdForReconnect(conn *AgentConnection) {
+	wait := conn.reconnect
+	if wait == nil {
+		wait = &reconnectWait{done: make(chan struct{})}
+	}
+	conn.reconnect = nil
+
+	wait.port = conn.Port
+	wait.timer = time.AfterFunc(cm.reconnectGrace, func() {
+		cm.expireReconnect(conn.ID, wait)
+	})
+	cm.reconnecting[conn.ID] = wait
+}
+
+// takeReconnectWait ends the grace period of agentID, if it is in one, and
+// returns its waiting requests and the port its server kept. Callers must
+// hold cm.mu.
+func (cm *ConnectionManager) takeReconnectWait(agentID string) (*reconnectWait, int) {
+	wait, ok := cm.reconnecting[agentID]
+	if !ok {
+		return nil, 0
+	}
+	wait.timer.Stop()
+	delete(cm.reconnecting, agentID)
+	return wait, wait.port
+}
+
+// expireReconnect gives up on agentID once its grace period has passed: its
+// HTTP server is stopped and the requests held for it fail.
+func (cm *ConnectionManager) expireReconnect(agentID string, wait *reconnectWait) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	if cm.reconnecting[agentID] != wait {
+		return
+	}
+	delete(cm.reconnecting, agentID)
+
+	metrics.ReconnectGraceExpirations.Inc()
+	slog.Warn("Agent did not reconnect within grace period",
+		"agent_id", agentID,
+		"port", wait.port,
+		"queued_requests", wait.queued.Load())
+
+	// Fail the held requests first: stopping the server waits for them.
+	wait.finish(nil)
+
+	if cm.agentServerManager != nil && wait.port != 0 {
+		if err := cm.agentServerManager.StopAgentServer(agentID); err != nil {
+			slog.Error("Failed to stop agent HTTP server after reconnect grace period",
+				"agent_id", agentID,
+				"port", wait.port,
+				"error", err)
+		}
+	}
+}
+
+// resumeQueued sends the requests held while conn's agent was away on to
+// conn. The stream handler calls it once the handshake is done, so that they
+// follow the HELLO_ACK.
+func (cm *ConnectionManager) resumeQueued(conn *AgentConnection) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	wait := conn.reconnect
+	if wait == nil {
+		return
+	}
+	conn.reconnect = nil
+
+	slog.Info("Agent reconnected within grace period",
+		"agent_id", conn.ID,
+		"port", conn.Port,
+		"queued_requests", wait.queued.Load())
+	wait.finish(conn)
+}
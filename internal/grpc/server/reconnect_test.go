@@ -0,0 +1,135 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type awaitResult struct {
+	conn *AgentConnection
+	err  error
+}
+
+func awaitInBackground(cm *ConnectionManager, agentID string) chan awaitResult {
+	result := make(chan awaitResult, 1)
+	go func() {
+		conn, err := cm.AwaitConnection(context.Background(), agentID)
+		result <- awaitResult{conn, err}
+	}()
+	return result
+}
+
+func queuedRequests(cm *ConnectionManager, agentID string) int {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	if wait, ok := cm.reconnecting[agentID]; ok {
+		return int(wait.queued.Load())
+	}
+	return 0
+}
+
+func TestReconnectGrace_HoldsRequestsUntilAgentReturns(t *testing.T) {
+	mockASM := new(MockAgentServerManager)
+	mockASM.On("StartAgentServer", "agent-1").Return(8100, nil).Once()
+	cm := NewConnectionManager(mockASM)
+	cm.SetReconnectGrace(time.Minute, 0)
+	defer func() {
+		mockASM.On("StopAgentServer", "agent-1").Return(nil)
+		mockASM.On("Shutdown").Return(nil)
+		cm.Stop()
+	}()
+
+	_, err := cm.Register("agent-1", NewMockStream(), AgentInfo{})
+	require.NoError(t, err)
+	cm.Deregister("agent-1")
+
+	_, ok := cm.GetConnection("agent-1")
+	assert.False(t, ok)
+
+	result := awaitInBackground(cm, "agent-1")
+	require.Eventually(t, func() bool { return queuedRequests(cm, "agent-1") == 1 }, time.Second, 10*time.Millisecond)
+
+	conn, err := cm.Register("agent-1", NewMockStream(), AgentInfo{})
+	require.NoError(t, err)
+	assert.Equal(t, 8100, conn.Port, "the agent keeps its server and port")
+
+	select {
+	case <-result:
+		t.Fatal("request resumed before the handshake was done")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	cm.resumeQueued(conn)
+	got := <-result
+	require.NoError(t, got.err)
+	assert.Same(t, conn, got.conn)
+	mockASM.AssertExpectations(t)
+}
+
+func TestReconnectGrace_Expires(t *testing.T) {
+	mockASM := new(MockAgentServerManager)
+	mockASM.On("StartAgentServer", "agent-1").Return(8100, nil)
+	mockASM.On("StopAgentServer", "agent-1").Return(nil).Once()
+	cm := NewConnectionManager(mockASM)
+	cm.SetReconnectGrace(100*time.Millisecond, 0)
+	defer func() {
+		mockASM.On("Shutdown").Return(nil)
+		cm.Stop()
+	}()
+
+	_, err := cm.Register("agent-1", NewMockStream(), AgentInfo{})
+	require.NoError(t, err)
+	cm.Deregister("agent-1")
+
+	got := <-awaitInBackground(cm, "agent-1")
+	assert.ErrorIs(t, got.err, ErrAgentUnavailable)
+	mockASM.AssertExpectations(t)
+
+	_, err = cm.AwaitConnection(context.Background(), "agent-1")
+	assert.ErrorIs(t, err, ErrAgentNotFound)
+}
+
+func TestReconnectGrace_QueueFull(t *testing.T) {
+	cm := NewConnectionManager(nil)
+	cm.SetReconnectGrace(time.Minute, 1)
+	defer cm.Stop()
+
+	_, err := cm.Register("agent-1", NewMockStream(), AgentInfo{})
+	require.NoError(t, err)
+	cm.Deregister("agent-1")
+
+	result := awaitInBackground(cm, "agent-1")
+	require.Eventually(t, func() bool { return queuedRequests(cm, "agent-1") == 1 }, time.Second, 10*time.Millisecond)
+
+	_, err = cm.AwaitConnection(context.Background(), "agent-1")
+	assert.ErrorIs(t, err, ErrReconnectQueueFull)
+
+	conn, err := cm.Register("agent-1", NewMockStream(), AgentInfo{})
+	require.NoError(t, err)
+	cm.resumeQueued(conn)
+	assert.NoError(t, (<-result).err)
+}
+
+func TestReconnectGrace_NotForAdminDisconnect(t *testing.T) {
+	mockASM := new(MockAgentServerManager)
+	mockASM.On("StartAgentServer", "agent-1").Return(8100, nil)
+	mockASM.On("StopAgentServer", "agent-1").Return(nil).Once()
+	cm := NewConnectionManager(mockASM)
+	cm.SetReconnectGrace(time.Minute, 0)
+	defer func() {
+		mockASM.On("Shutdown").Return(nil)
+		cm.Stop()
+	}()
+
+	_, err := cm.Register("agent-1", NewMockStream(), AgentInfo{})
+	require.NoError(t, err)
+	cm.deregister("agent-1", DisconnectReasonAdmin)
+
+	_, err = cm.AwaitConnection(context.Background(), "agent-1")
+	assert.ErrorIs(t, err, ErrAgentNotFound)
+	mockASM.AssertExpectations(t)
+}
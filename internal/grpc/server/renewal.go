@@ -0,0 +1,90 @@
+package server
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/EternisAI/silo-proxy/proto"
+
+	grpctls "github.com/EternisAI/silo-proxy/internal/grpc/tls"
+)
+
+const certRenewalTimeout = 30 * time.Second
+
+// CertRenewer issues renewed client certificates to connected agents.
+// RenewAgentCert signs a PEM CSR from the agent authenticated by current and
+// returns the PEM certificate. ConfirmAgentCert is called whenever an agent
+// connects, so that certificates replaced by a renewal can be retired once
+// their successor is in use.
+type CertRenewer interface {
+	RenewAgentCert(ctx context.Context, agentID string, current *x509.Certificate, csrPEM []byte) ([]byte, error)
+	ConfirmAgentCert(ctx context.Context, agentID string, current *x509.Certificate) error
+}
+
+// SetCertRenewer enables certificate renewal over the agent stream.
+func (s *Server) SetCertRenewer(cr CertRenewer) {
+	s.certRenewer = cr
+}
+
+// handleCertRenewal answers a CERT_RENEW_REQUEST with the renewed certificate
+// or an "error" metadata entry.
+func (s *Server) handleCertRenewal(conn *AgentConnection, msg *proto.ProxyMessage) {
+	agentID := conn.ID
+	reply := &proto.ProxyMessage{
+		Id:       msg.Id,
+		Type:     proto.MessageType_CERT_RENEW_RESPONSE,
+		Metadata: map[string]string{},
+	}
+
+	certPEM, err := s.renewAgentCert(conn, msg.Payload)
+	if err != nil {
+		slog.Warn("Certificate renewal failed", "agent_id", agentID, "error", err)
+		reply.Metadata["error"] = err.Error()
+	} else {
+		reply.Payload = certPEM
+	}
+
+	if err := s.connManager.sendTo(conn, reply); err != nil {
+		slog.Error("Failed to send certificate renewal response", "agent_id", agentID, "error", err)
+	}
+}
+
+func (s *Server) renewAgentCert(conn *AgentConnection, csrPEM []byte) ([]byte, error) {
+	if s.certRenewer == nil {
+		return nil, errors.New("certificate renewal is not enabled on this server")
+	}
+	if conn.ctx.Err() != nil {
+		return nil, errors.New("agent is not connected")
+	}
+
+	current, ok := grpctls.VerifiedPeerCertificate(conn.Stream.Context())
+	if !ok {
+		return nil, errors.New("renewal requires a verified client certificate")
+	}
+
+	ctx, cancel := context.WithTimeout(conn.ctx, certRenewalTimeout)
+	defer cancel()
+	return s.certRenewer.RenewAgentCert(ctx, conn.ID, current, csrPEM)
+}
+
+// confirmAgentCert reports the certificate a newly connected agent presented
+// to the CertRenewer.
+func (s *Server) confirmAgentCert(ctx context.Context, agentID string) {
+	if s.certRenewer == nil {
+		return
+	}
+
+	current, ok := grpctls.VerifiedPeerCertificate(ctx)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, certRenewalTimeout)
+	defer cancel()
+	if err := s.certRenewer.ConfirmAgentCert(ctx, agentID, current); err != nil {
+		slog.Error("Failed to confirm agent certificate", "agent_id", agentID, "error", err)
+	}
+}
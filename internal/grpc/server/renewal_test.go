@@ -0,0 +1,56 @@
+package server
+
+import (
+	"context"
+	"crypto/x509"
+	"testing"
+
+	"github.com/EternisAI/silo-proxy/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeCertRenewer struct {
+	renewedFor string
+}
+
+func (f *fakeCertRenewer) RenewAgentCert(_ context.Context, agentID string, current *x509.Certificate, csrPEM []byte) ([]byte, error) {
+	f.renewedFor = current.Subject.CommonName
+	return append([]byte("cert for "), csrPEM...), nil
+}
+
+func (f *fakeCertRenewer) ConfirmAgentCert(context.Context, string, *x509.Certificate) error {
+	return nil
+}
+
+func TestHandleCertRenewal(t *testing.T) {
+	s := NewServer(0, nil)
+	renewer := &fakeCertRenewer{}
+	s.SetCertRenewer(renewer)
+
+	conn, err := s.connManager.Register("agent-1", newMTLSStream("agent-1"), AgentInfo{})
+	require.NoError(t, err)
+
+	s.handleCertRenewal(conn, &proto.ProxyMessage{Id: "renew-1", Type: proto.MessageType_CERT_RENEW_REQUEST, Payload: []byte("csr")})
+
+	reply := <-conn.SendCh
+	assert.Equal(t, proto.MessageType_CERT_RENEW_RESPONSE, reply.Type)
+	assert.Equal(t, "renew-1", reply.Id)
+	assert.Empty(t, reply.Metadata["error"])
+	assert.Equal(t, "cert for csr", string(reply.Payload))
+	assert.Equal(t, "agent-1", renewer.renewedFor)
+}
+
+func TestHandleCertRenewal_RequiresVerifiedCertificate(t *testing.T) {
+	s := NewServer(0, nil)
+	s.SetCertRenewer(&fakeCertRenewer{})
+
+	conn, err := s.connManager.Register("agent-1", NewMockStream(), AgentInfo{})
+	require.NoError(t, err)
+
+	s.handleCertRenewal(conn, &proto.ProxyMessage{Id: "renew-1", Type: proto.MessageType_CERT_RENEW_REQUEST, Payload: []byte("csr")})
+
+	reply := <-conn.SendCh
+	assert.Contains(t, reply.Metadata["error"], "verified client certificate")
+	assert.Empty(t, reply.Payload)
+}
@@ -2,21 +2,40 @@ package server
 
 import (
 	"context"
+	"crypto/x509"
+	"errors"
 	"fmt"
+	"io"
 	"log/slog"
+	"maps"
 	"net"
+	"net/http"
+	"strconv"
 	"sync"
 	"time"
 
+	"github.com/EternisAI/silo-proxy/internal/grpc/chunk"
+	"github.com/EternisAI/silo-proxy/internal/grpc/headers"
+	"github.com/EternisAI/silo-proxy/internal/grpc/protocol"
+	"github.com/EternisAI/silo-proxy/internal/grpc/tunnel"
+	"github.com/EternisAI/silo-proxy/internal/metrics"
 	"github.com/EternisAI/silo-proxy/proto"
 	"google.golang.org/grpc"
 
 	grpctls "github.com/EternisAI/silo-proxy/internal/grpc/tls"
 )
 
-const (
-	requestTimeout = 30 * time.Second
-)
+// requestTimeout is the default of Timeouts.Request.
+const requestTimeout = 30 * time.Second
+
+// maxLegacyBody is the largest request body sent to legacy agents, which take
+// it in a single REQUEST frame. It leaves room for the headers below gRPC's
+// default 4 MB message limit.
+const maxLegacyBody = 4<<20 - 64<<10
+
+// ErrBodyTooLarge is returned for requests whose body does not fit in the
+// single REQUEST frame a legacy agent takes.
+var ErrBodyTooLarge = errors.New("request body too large for agent")
 
 type Server struct {
 	proto.UnimplementedProxyServiceServer
@@ -26,8 +45,87 @@ type Server struct {
 	port            int
 	tlsConfig       *TLSConfig
 	listener        net.Listener
-	pendingRequests map[string]chan *proto.ProxyMessage
+	pendingRequests map[string]*pendingRequest
 	pendingMu       sync.RWMutex
+	sessions        map[string]*tunnel.Session
+	sessionsMu      sync.RWMutex
+	commands        map[string]chan *proto.ProxyMessage
+	commandsMu      sync.Mutex
+	blocked         map[string]time.Time
+	blockedMu       sync.Mutex
+	timeouts        Timeouts
+
+	version                string
+	minProtocolVersion     int
+	allowUnverifiedAgentID bool
+	revocationChecker      RevocationChecker
+	certRenewer            CertRenewer
+}
+
+// RevocationChecker reports whether an agent's client certificate has been
+// revoked.
+type RevocationChecker interface {
+	IsRevoked(certificate *x509.Certificate) bool
+}
+
+// pendingRequest tracks a request forwarded to an agent until its response
+// body has been fully consumed.
+type pendingRequest struct {
+	agentID string
+	conn    *AgentConnection // The stream carrying the request
+	startCh chan *proto.ProxyMessage
+	body    *chunk.Reader
+	window  *chunk.Window // Credit for the request body, granted by the agent
+}
+
+// AgentResponse is a response streamed back from an agent. Start is the
+// RESPONSE_START frame carrying the status code and headers. Body yields the
+// response body and must be closed by the caller.
+type AgentResponse struct {
+	Start *proto.ProxyMessage
+	Body  io.ReadCloser
+}
+
+// Trailer returns the trailers the agent sent after the response body. It is
+// only complete once Body has been read to the end.
+func (r *AgentResponse) Trailer() http.Header {
+	body, ok := r.Body.(*responseBody)
+	if !ok {
+		return nil
+	}
+	return headers.FromProto(body.Trailer())
+}
+
+// TrailerReader is a request body followed by trailers, like the body of an
+// *http.Request, whose Trailer is complete once the body has been read to EOF.
+type TrailerReader interface {
+	io.Reader
+	Trailer() http.Header
+}
+
+// responseBody releases its pending request when closed, and cancels the
+// request on the agent if the body was not read to the end.
+type responseBody struct {
+	*chunk.Reader
+	release  func()
+	cancel   func(reason string)
+	complete bool
+}
+
+func (b *responseBody) Read(p []byte) (int, error) {
+	n, err := b.Reader.Read(p)
+	if err == io.EOF {
+		b.complete = true
+	}
+	return n, err
+}
+
+func (b *responseBody) Close() error {
+	if !b.complete {
+		b.cancel("response body not consumed")
+	}
+	b.release()
+	return b.Reader.Close()
 }
 
 type TLSConfig struct {
@@ -45,7 +143,13 @@ func NewServer(port int, tlsConfig *TLSConfig) *Server {
 		connManager:     connManager,
 		port:            port,
 		tlsConfig:       tlsConfig,
-		pendingRequests: make(map[string]chan *proto.ProxyMessage),
+		pendingRequests: make(map[string]*pendingRequest),
+		sessions:        make(map[string]*tunnel.Session),
+		commands:        make(map[string]chan *proto.ProxyMessage),
+		blocked:         make(map[string]time.Time),
+		timeouts:        Timeouts{Request: requestTimeout},
+
+		minProtocolVersion: protocol.MinVersion,
 	}
 
 	streamHandler := NewStreamHandler(connManager, s)
@@ -69,11 +173,17 @@ func (s *Server) Start() error {
 			return fmt.Errorf("invalid client auth type: %w", err)
 		}
 
+		var isRevoked func(*x509.Certificate) bool
+		if s.revocationChecker != nil {
+			isRevoked = s.revocationChecker.IsRevoked
+		}
+
 		creds, err := grpctls.LoadServerCredentials(
 			s.tlsConfig.CertFile,
 			s.tlsConfig.KeyFile,
 			s.tlsConfig.CAFile,
 			clientAuth,
+			isRevoked,
 		)
 		if err != nil {
 			return fmt.Errorf("failed to load TLS credentials: %w", err)
@@ -127,54 +237,369 @@ func (s *Server) StopWithTimeout(timeout time.Duration) error {
 	return s.Stop(ctx)
 }
 
-func (s *Server) SendRequestToAgent(ctx context.Context, agentID string, msg *proto.ProxyMessage) (*proto.ProxyMessage, error) {
-	respCh := make(chan *proto.ProxyMessage, 1)
+// SendRequestToAgent sends a REQUEST_START frame to the agent and streams body
+// after it as BODY_CHUNK frames. It returns once the agent's RESPONSE_START frame
+// arrives; the response body is then read incrementally from the returned
+// AgentResponse, so neither side needs to buffer whole bodies. If the caller
+// gives up first, because ctx ends, the timeout passes or the body is closed
+// before it was read to the end, the agent is sent a CANCEL frame.
+//
+// timeout bounds the wait for the response headers and for each body chunk
+// after them; zero uses the configured default. It is passed on to the agent,
+// which applies it to its call to the local service. If body is a
+// TrailerReader, its trailers follow it to the agent. Requests to an agent
+// that is being drained fail with ErrAgentDraining.
+//
+// The request is carried by the least busy of the agent's streams, which all
+// of its frames then use. With agents that negotiated protocol.FlowControl,
+// both bodies are flow controlled: the upload waits for credit from the agent,
+// and the agent is granted credit as the response body is read. Legacy agents
+// are sent the request and its whole body in a single REQUEST frame instead;
+// a body larger than maxLegacyBody fails with ErrBodyTooLarge.
+func (s *Server) SendRequestToAgent(ctx context.Context, agentID string, msg *proto.ProxyMessage, body io.Reader, timeout time.Duration) (*AgentResponse, error) {
+	if timeout <= 0 {
+		timeout = s.timeouts.Request
+	}
+	if msg.Metadata == nil {
+		msg.Metadata = map[string]string{}
+	}
+	msg.Metadata["timeout_ms"] = strconv.FormatInt(timeout.Milliseconds(), 10)
 
-	s.pendingMu.Lock()
-	s.pendingRequests[msg.Id] = respCh
-	s.pendingMu.Unlock()
+	pending := &pendingRequest{
+		agentID: agentID,
+		startCh: make(chan *proto.ProxyMessage, 1),
+		body:    chunk.NewReader(timeout),
+		window:  chunk.NewWindow(),
+	}
 
-	defer func() {
-		s.pendingMu.Lock()
-		delete(s.pendingRequests, msg.Id)
-		s.pendingMu.Unlock()
-	}()
+	if body == nil {
+		body = http.NoBody
+	}
 
-	if err := s.connManager.SendToAgent(agentID, msg); err != nil {
-		return nil, fmt.Errorf("failed to send request to agent: %w", err)
+	conn, err := s.startRequest(agentID, msg, pending, &legacyRequest{start: msg, body: body})
+	if err != nil {
+		return nil, err
+	}
+	release := func() {
+		s.releaseRequest(conn, msg.Id)
+	}
+
+	send := func(frame *proto.ProxyMessage) error {
+		return s.connManager.sendTo(conn, frame)
+	}
+	if conn.Info.Supports(protocol.FlowControl) {
+		send = pending.window.Throttle(send)
+		pending.body.SetAcker(chunk.NewAcker(func(n int) {
+			if err := s.connManager.sendTo(conn, chunk.WindowUpdate(msg.Id, n)); err != nil {
+				slog.Debug("Failed to send WINDOW_UPDATE", "agent_id", agentID, "message_id", msg.Id, "error", err)
+			}
+		}))
+	}
+
+	// Legacy agents got the whole body with the REQUEST frame.
+	uploadErr := make(chan error, 1)
+	if !conn.Info.Legacy() {
+		go func() {
+			var trailer func() []*proto.Header
+			if tr, ok := body.(TrailerReader); ok {
+				trailer = func() []*proto.Header { return headers.ToProto(tr.Trailer()) }
+			}
+			_, err := chunk.SendWithTrailer(msg.Id, body, trailer, send)
+			if err != nil {
+				slog.Warn("Failed to stream request body to agent", "agent_id", agentID, "message_id", msg.Id, "error", err)
+				uploadErr <- err
+			}
+		}()
+	}
+
+	cancel := func(reason string) {
+		s.cancelRequest(conn, msg.Id, reason)
 	}
 
 	select {
-	case response := <-respCh:
-		return response, nil
-	case <-time.After(requestTimeout):
+	case start := <-pending.startCh:
+		return &AgentResponse{
+			Start: start,
+			Body:  &responseBody{Reader: pending.body, release: release, cancel: cancel},
+		}, nil
+	case err := <-uploadErr:
+		release()
+		cancel("request body failed")
+		return nil, fmt.Errorf("failed to send request body: %w", err)
+	case <-time.After(timeout):
+		release()
+		cancel("timeout")
 		return nil, fmt.Errorf("request timeout")
+	case <-conn.ctx.Done():
+		release()
+		return nil, fmt.Errorf("agent disconnected: %s", agentID)
 	case <-ctx.Done():
+		release()
+		cancel(ctx.Err().Error())
 		return nil, ctx.Err()
 	}
 }
 
+// startRequest registers pending as the request msg and sends msg on a
+// connection of agentID, or legacy's REQUEST frame if the agent is legacy.
+// Should that stream close before the frame was queued, the agent's next
+// stream is tried, so that a failed stream only fails the requests it was
+// already carrying.
+func (s *Server) startRequest(agentID string, msg *proto.ProxyMessage, pending *pendingRequest, legacy *legacyRequest) (*AgentConnection, error) {
+	for {
+		conn, ok := s.connManager.pick(agentID)
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrAgentNotFound, agentID)
+		}
+
+		// Checked under pendingMu so that a drain either sees this request as
+		// pending or this request sees the drain.
+		s.pendingMu.Lock()
+		if conn.Draining() {
+			s.pendingMu.Unlock()
+			return nil, ErrAgentDraining
+		}
+		pending.conn = conn
+		s.pendingRequests[msg.Id] = pending
+		metrics.PendingRequests.Set(float64(len(s.pendingRequests)))
+		s.pendingMu.Unlock()
+		conn.inFlight.Add(1)
+
+		setLegacyHeaders(conn, msg)
+		frame := msg
+		if conn.Info.Legacy() {
+			var err error
+			if frame, err = legacy.frame(); err != nil {
+				s.releaseRequest(conn, msg.Id)
+				return nil, err
+			}
+		}
+
+		err := s.connManager.sendTo(conn, frame)
+		if err == nil {
+			return conn, nil
+		}
+
+		s.releaseRequest(conn, msg.Id)
+		if !errors.Is(err, errConnectionClosed) {
+			return nil, fmt.Errorf("failed to send request to agent: %w", err)
+		}
+		slog.Warn("Agent stream closed, retrying request on another stream", "agent_id", agentID, "message_id", msg.Id)
+	}
+}
+
+// legacyRequest is the single REQUEST frame of a request, as legacy agents
+// take it: the metadata of the REQUEST_START frame, which carries the headers
+// as "header_" entries for them, and the whole body as payload. The body is
+// read the first time the frame is needed.
+type legacyRequest struct {
+	start *proto.ProxyMessage
+	body  io.Reader
+
+	request *proto.ProxyMessage
+	err     error
+}
+
+func (r *legacyRequest) frame() (*proto.ProxyMessage, error) {
+	if r.request != nil || r.err != nil {
+		return r.request, r.err
+	}
+
+	payload, err := io.ReadAll(io.LimitReader(r.body, maxLegacyBody+1))
+	switch {
+	case err != nil:
+		r.err = fmt.Errorf("failed to read request body: %w", err)
+	case len(payload) > maxLegacyBody:
+		r.err = fmt.Errorf("%w: legacy agents take at most %d bytes", ErrBodyTooLarge, maxLegacyBody)
+	default:
+		r.request = &proto.ProxyMessage{
+			Id:       r.start.Id,
+			Type:     proto.MessageType_REQUEST,
+			Payload:  payload,
+			Metadata: maps.Clone(r.start.Metadata),
+		}
+	}
+	return r.request, r.err
+}
+
+// releaseRequest forgets request id, carried by conn, and stops an upload
+// still waiting for credit. Releasing a request more than once has no effect.
+func (s *Server) releaseRequest(conn *AgentConnection, id string) {
+	s.pendingMu.Lock()
+	pending, ok := s.pendingRequests[id]
+	delete(s.pendingRequests, id)
+	metrics.PendingRequests.Set(float64(len(s.pendingRequests)))
+	s.pendingMu.Unlock()
+	if ok {
+		pending.window.Close()
+		conn.inFlight.Add(-1)
+	}
+}
+
+// setLegacyHeaders copies the headers of msg into its metadata for agents
+// that did not negotiate protocol.Headers on conn.
+func setLegacyHeaders(conn *AgentConnection, msg *proto.ProxyMessage) {
+	if !conn.Info.Supports(protocol.Headers) {
+		headers.SetLegacy(msg.Metadata, headers.FromProto(msg.Headers))
+	}
+}
+
+// cancelRequest tells the agent to abort request id on conn, the stream
+// carrying it. It is sent in the background so that a full send channel does
+// not hold up the caller. Agents that did not negotiate CANCEL are left to
+// finish the request.
+func (s *Server) cancelRequest(conn *AgentConnection, id, reason string) {
+	if !conn.Info.Supports(protocol.Cancel) {
+		return
+	}
+
+	slog.Info("Cancelling request on agent", "agent_id", conn.ID, "message_id", id, "reason", reason)
+	metrics.CancelledRequests.WithLabelValues(conn.ID).Inc()
+
+	go func() {
+		if err := s.connManager.sendTo(conn, &proto.ProxyMessage{
+			Id:       id,
+			Type:     proto.MessageType_CANCEL,
+			Metadata: map[string]string{"reason": reason},
+		}); err != nil {
+			slog.Debug("Failed to send CANCEL", "agent_id", conn.ID, "message_id", id, "error", err)
+		}
+	}()
+}
+
+// HandleResponse routes response frames from an agent to the pending request
+// they belong to. Legacy single-message RESPONSE frames are split into a start
+// frame and a one-chunk body.
 func (s *Server) HandleResponse(msg *proto.ProxyMessage) {
 	s.pendingMu.RLock()
-	respCh, ok := s.pendingRequests[msg.Id]
+	pending, ok := s.pendingRequests[msg.Id]
 	s.pendingMu.RUnlock()
 
 	if !ok {
-		slog.Warn("Received response for unknown request", "message_id", msg.Id)
+		slog.Warn("Received response for unknown request", "message_id", msg.Id, "type", msg.Type)
 		return
 	}
 
+	switch msg.Type {
+	case proto.MessageType_RESPONSE_START:
+		s.deliverStart(pending, msg)
+
+	case proto.MessageType_RESPONSE:
+		s.deliverStart(pending, msg)
+		s.deliverBody(pending, &proto.ProxyMessage{Id: msg.Id, Type: proto.MessageType_BODY_CHUNK, Payload: msg.Payload})
+		s.deliverBody(pending, &proto.ProxyMessage{Id: msg.Id, Type: proto.MessageType_BODY_END})
+
+	case proto.MessageType_BODY_CHUNK, proto.MessageType_BODY_END:
+		s.deliverBody(pending, msg)
+	}
+}
+
+// HandleWindowUpdate grants the credit of a WINDOW_UPDATE frame from an agent
+// to the request body or tunnel session it names.
+func (s *Server) HandleWindowUpdate(msg *proto.ProxyMessage) {
+	s.pendingMu.RLock()
+	pending, ok := s.pendingRequests[msg.Id]
+	s.pendingMu.RUnlock()
+
+	if !ok {
+		s.HandleTunnelMessage(msg)
+		return
+	}
+	pending.window.Grant(chunk.Granted(msg))
+}
+
+func (s *Server) deliverStart(pending *pendingRequest, msg *proto.ProxyMessage) {
 	select {
-	case respCh <- msg:
+	case pending.startCh <- msg:
 	default:
 		slog.Warn("Response channel full or closed", "message_id", msg.Id)
 	}
 }
 
+// deliverBody hands a response body frame to the reader of pending. A body
+// that lost a frame has failed, so the agent is told to stop sending it.
+func (s *Server) deliverBody(pending *pendingRequest, msg *proto.ProxyMessage) {
+	err := pending.body.Push(msg)
+	if err == nil || errors.Is(err, chunk.ErrClosed) {
+		return
+	}
+	slog.Warn("Failed to deliver response body chunk, cancelling request", "message_id", msg.Id, "error", err)
+	s.cancelRequest(pending.conn, msg.Id, "response body lost")
+}
+
 func (s *Server) GetConnectionManager() *ConnectionManager {
 	return s.connManager
 }
 
+// AgentAvailable reports whether agentID is connected and not draining, so
+// that new requests can be sent to it.
+func (s *Server) AgentAvailable(agentID string) bool {
+	conn, ok := s.connManager.GetConnection(agentID)
+	return ok && !conn.Draining() && conn.ctx.Err() == nil
+}
+
 func (s *Server) SetAgentServerManager(asm AgentServerManager) {
 	s.connManager.SetAgentServerManager(asm)
 }
+
+// SetAllowUnverifiedAgentID lets agents without a verified client certificate
+// identify themselves with the agent_id they send. It is meant for deployments
+// running without mutual TLS and must be set before Start.
+func (s *Server) SetAllowUnverifiedAgentID(allow bool) {
+	s.allowUnverifiedAgentID = allow
+}
+
+// SetRevocationChecker makes the server refuse agents whose client certificate
+// has been revoked, both at the TLS handshake and when a stream is opened. It
+// must be set before Start.
+func (s *Server) SetRevocationChecker(rc RevocationChecker) {
+	s.revocationChecker = rc
+}
+
+// DisconnectRevokedAgents tears down the live connections of agents whose
+// client certificate is now revoked and returns their IDs.
+func (s *Server) DisconnectRevokedAgents() []string {
+	if s.revocationChecker == nil {
+		return nil
+	}
+
+	var disconnected []string
+	for _, agentID := range s.connManager.ListConnections() {
+		revoked := false
+		for _, conn := range s.connManager.Connections(agentID) {
+			leaf, ok := grpctls.VerifiedPeerCertificate(conn.Stream.Context())
+			if !ok || !s.revocationChecker.IsRevoked(leaf) {
+				continue
+			}
+
+			slog.Info("Disconnecting agent with revoked certificate", "agent_id", agentID, "serial", leaf.SerialNumber.Text(16))
+			s.connManager.deregisterConn(conn, DisconnectReasonRevoked)
+			revoked = true
+		}
+		if revoked {
+			disconnected = append(disconnected, agentID)
+		}
+	}
+	return disconnected
+}
+
+// SetReconnectGrace holds the port of an agent whose stream drops, and up to
+// maxQueued requests for it, for grace while the agent reconnects. See
+// ConnectionManager.SetReconnectGrace.
+func (s *Server) SetReconnectGrace(grace time.Duration, maxQueued int) {
+	s.connManager.SetReconnectGrace(grace, maxQueued)
+}
+
+// SetMaxStreamsPerAgent lets every agent hold up to n streams at once. See
+// ConnectionManager.SetMaxStreams.
+func (s *Server) SetMaxStreamsPerAgent(n int) {
+	s.connManager.SetMaxStreams(n)
+}
+
+func (s *Server) SetTCPTunnelManager(ttm TCPTunnelManager) {
+	s.connManager.SetTCPTunnelManager(ttm)
+}
+
+func (s *Server) SetSessionRecorder(sr SessionRecorder) {
+	s.connManager.SetSessionRecorder(sr)
+}
@@ -0,0 +1,225 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/EternisAI/silo-proxy/internal/grpc/chunk"
+	"github.com/EternisAI/silo-proxy/internal/grpc/protocol"
+	"github.com/EternisAI/silo-proxy/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// nextFrame returns the next frame queued for the agent.
+func nextFrame(t *testing.T, conn *AgentConnection) *proto.ProxyMessage {
+	select {
+	case msg := <-conn.SendCh:
+		return msg
+	case <-time.After(time.Second):
+		require.FailNow(t, "no frame sent to agent")
+		return nil
+	}
+}
+
+// currentAgent is an agent that negotiated every capability of this build.
+var currentAgent = AgentInfo{ProtocolVersion: protocol.Version, Capabilities: protocol.Capabilities}
+
+func requestStart(id string) *proto.ProxyMessage {
+	return &proto.ProxyMessage{
+		Id:       id,
+		Type:     proto.MessageType_REQUEST_START,
+		Metadata: map[string]string{"method": "GET", "path": "/"},
+	}
+}
+
+func TestSendRequestToAgent_CallerGivesUp(t *testing.T) {
+	s := NewServer(0, nil)
+	conn, err := s.connManager.Register("agent-1", NewMockStream(), currentAgent)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := s.SendRequestToAgent(ctx, "agent-1", requestStart("req-1"), nil, 0)
+		errCh <- err
+	}()
+
+	start := nextFrame(t, conn)
+	assert.Equal(t, proto.MessageType_REQUEST_START, start.Type)
+	assert.Equal(t, "30000", start.Metadata["timeout_ms"])
+	assert.Equal(t, proto.MessageType_BODY_END, nextFrame(t, conn).Type)
+
+	cancel()
+	assert.ErrorIs(t, <-errCh, context.Canceled)
+
+	msg := nextFrame(t, conn)
+	assert.Equal(t, proto.MessageType_CANCEL, msg.Type)
+	assert.Equal(t, "req-1", msg.Id)
+	assert.Equal(t, "context canceled", msg.Metadata["reason"])
+}
+
+func TestSendRequestToAgent_ResponseBodyClosedEarly(t *testing.T) {
+	s := NewServer(0, nil)
+	conn, err := s.connManager.Register("agent-1", NewMockStream(), currentAgent)
+	require.NoError(t, err)
+
+	for _, readAll := range []bool{true, false} {
+		id := "req-partial"
+		if readAll {
+			id = "req-complete"
+		}
+
+		go func() {
+			nextFrame(t, conn)
+			nextFrame(t, conn)
+			s.HandleResponse(&proto.ProxyMessage{Id: id, Type: proto.MessageType_RESPONSE_START, Metadata: map[string]string{"status_code": "200"}})
+			s.HandleResponse(&proto.ProxyMessage{Id: id, Type: proto.MessageType_BODY_CHUNK, Payload: []byte("hello")})
+			if readAll {
+				s.HandleResponse(&proto.ProxyMessage{Id: id, Type: proto.MessageType_BODY_END})
+			}
+		}()
+
+		response, err := s.SendRequestToAgent(context.Background(), "agent-1", requestStart(id), nil, 0)
+		require.NoError(t, err)
+
+		if readAll {
+			data, err := io.ReadAll(response.Body)
+			require.NoError(t, err)
+			assert.Equal(t, "hello", string(data))
+		}
+		require.NoError(t, response.Body.Close())
+	}
+
+	// Only the request whose body was abandoned is cancelled
+	msg := nextFrame(t, conn)
+	assert.Equal(t, proto.MessageType_CANCEL, msg.Type)
+	assert.Equal(t, "req-partial", msg.Id)
+	assert.Empty(t, conn.SendCh)
+}
+
+func TestSendRequestToAgent_SkipsClosedStream(t *testing.T) {
+	s := NewServer(0, nil)
+	s.SetMaxStreamsPerAgent(2)
+	conn1, err := s.connManager.Register("agent-1", NewMockStream(), currentAgent)
+	require.NoError(t, err)
+	conn2, err := s.connManager.Register("agent-1", NewMockStream(), currentAgent)
+	require.NoError(t, err)
+
+	// The first stream has failed but its handler has not deregistered it yet.
+	conn1.cancel()
+
+	responseCh := make(chan *AgentResponse, 1)
+	go func() {
+		resp, err := s.SendRequestToAgent(context.Background(), "agent-1", requestStart("req-1"), nil, 0)
+		assert.NoError(t, err)
+		responseCh <- resp
+	}()
+
+	assert.Equal(t, "req-1", nextFrame(t, conn2).Id)
+	assert.Equal(t, int64(1), conn2.inFlight.Load())
+
+	s.HandleResponse(&proto.ProxyMessage{Id: "req-1", Type: proto.MessageType_RESPONSE})
+	resp := <-responseCh
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, int64(0), conn2.inFlight.Load())
+}
+
+func TestSendRequestToAgent_FlowControl(t *testing.T) {
+	s := NewServer(0, nil)
+	conn, err := s.connManager.Register("agent-1", NewMockStream(), currentAgent)
+	require.NoError(t, err)
+
+	body := bytes.Repeat([]byte("x"), chunk.WindowSize+chunk.Size)
+	responseCh := make(chan *AgentResponse, 1)
+	go func() {
+		resp, err := s.SendRequestToAgent(context.Background(), "agent-1", requestStart("req-1"), bytes.NewReader(body), 0)
+		assert.NoError(t, err)
+		responseCh <- resp
+	}()
+
+	// The upload stops once it has spent its window.
+	assert.Equal(t, proto.MessageType_REQUEST_START, nextFrame(t, conn).Type)
+	sent := 0
+	for sent < chunk.WindowSize {
+		msg := nextFrame(t, conn)
+		require.Equal(t, proto.MessageType_BODY_CHUNK, msg.Type)
+		sent += len(msg.Payload)
+	}
+	select {
+	case msg := <-conn.SendCh:
+		t.Fatalf("upload sent %s without credit", msg.Type)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	s.HandleWindowUpdate(chunk.WindowUpdate("req-1", chunk.Size))
+	assert.Equal(t, proto.MessageType_BODY_CHUNK, nextFrame(t, conn).Type)
+	assert.Equal(t, proto.MessageType_BODY_END, nextFrame(t, conn).Type)
+
+	// Reading the response body grants the agent credit.
+	s.HandleResponse(&proto.ProxyMessage{Id: "req-1", Type: proto.MessageType_RESPONSE_START, Metadata: map[string]string{"status_code": "200"}})
+	resp := <-responseCh
+	for range chunk.WindowSize / chunk.Size {
+		s.HandleResponse(&proto.ProxyMessage{Id: "req-1", Type: proto.MessageType_BODY_CHUNK, Payload: make([]byte, chunk.Size)})
+	}
+	s.HandleResponse(&proto.ProxyMessage{Id: "req-1", Type: proto.MessageType_BODY_END})
+
+	data, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Len(t, data, chunk.WindowSize)
+	require.NoError(t, resp.Body.Close())
+
+	granted := 0
+	for len(conn.SendCh) > 0 {
+		msg := <-conn.SendCh
+		require.Equal(t, proto.MessageType_WINDOW_UPDATE, msg.Type)
+		granted += chunk.Granted(msg)
+	}
+	assert.Equal(t, chunk.WindowSize, granted)
+}
+
+func TestSendRequestToAgent_LegacyAgent(t *testing.T) {
+	s := NewServer(0, nil)
+	conn, err := s.connManager.Register("agent-1", NewMockStream(), AgentInfo{ProtocolVersion: 1})
+	require.NoError(t, err)
+
+	msg := requestStart("req-1")
+	msg.Headers = []*proto.Header{{Name: "X-Test", Values: []string{"yes"}}}
+	responseCh := make(chan *AgentResponse, 1)
+	go func() {
+		resp, err := s.SendRequestToAgent(context.Background(), "agent-1", msg, bytes.NewReader([]byte("hello")), 0)
+		assert.NoError(t, err)
+		responseCh <- resp
+	}()
+
+	// The whole request goes out in one REQUEST frame.
+	request := nextFrame(t, conn)
+	assert.Equal(t, proto.MessageType_REQUEST, request.Type)
+	assert.Equal(t, "hello", string(request.Payload))
+	assert.Equal(t, "GET", request.Metadata["method"])
+	assert.Equal(t, "yes", request.Metadata["header_X-Test"])
+	assert.Empty(t, conn.SendCh)
+
+	s.HandleResponse(&proto.ProxyMessage{Id: "req-1", Type: proto.MessageType_RESPONSE, Payload: []byte("ok"), Metadata: map[string]string{"status_code": "200"}})
+	resp := <-responseCh
+	data, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "ok", string(data))
+	require.NoError(t, resp.Body.Close())
+}
+
+func TestSendRequestToAgent_LegacyAgentBodyTooLarge(t *testing.T) {
+	s := NewServer(0, nil)
+	conn, err := s.connManager.Register("agent-1", NewMockStream(), AgentInfo{ProtocolVersion: 1})
+	require.NoError(t, err)
+
+	body := bytes.NewReader(make([]byte, maxLegacyBody+1))
+	_, err = s.SendRequestToAgent(context.Background(), "agent-1", requestStart("req-1"), body, 0)
+
+	assert.ErrorIs(t, err, ErrBodyTooLarge)
+	assert.Empty(t, conn.SendCh)
+	assert.Equal(t, int64(0), conn.inFlight.Load())
+}
@@ -4,9 +4,15 @@ import (
 	"fmt"
 	"io"
 	"log/slog"
+	"strings"
+	"time"
 
 	"github.com/EternisAI/silo-proxy/proto"
 	"github.com/google/uuid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	grpctls "github.com/EternisAI/silo-proxy/internal/grpc/tls"
 )
 
 type StreamHandler struct {
@@ -27,33 +33,65 @@ func (sh *StreamHandler) HandleStream(stream proto.ProxyService_StreamServer) er
 		return fmt.Errorf("failed to receive first message: %w", err)
 	}
 
-	agentID := firstMsg.Metadata["agent_id"]
-	if agentID == "" {
-		return fmt.Errorf("agent_id not found in first message metadata")
+	agentID, err := sh.resolveAgentID(stream, firstMsg)
+	if err != nil {
+		slog.Warn("Rejected agent connection", "claimed_agent_id", firstMsg.Metadata["agent_id"], "error", err)
+		return err
 	}
 
-	slog.Info("Agent connection established", "agent_id", agentID)
+	if until, blocked := sh.server.BlockedUntil(agentID); blocked {
+		slog.Warn("Rejected blocked agent", "agent_id", agentID, "blocked_until", until)
+		return status.Errorf(codes.PermissionDenied,
+			"agent %s may not reconnect until %s", agentID, until.UTC().Format(time.RFC3339))
+	}
 
-	conn, err := sh.connManager.Register(agentID, stream)
+	info, ack, err := sh.server.handshake(firstMsg)
+	if err != nil {
+		slog.Warn("Rejected agent connection", "agent_id", agentID, "error", err)
+		if ack != nil {
+			_ = stream.Send(ack)
+		}
+		return status.Error(codes.FailedPrecondition, err.Error())
+	}
+
+	slog.Info("Agent connection established",
+		"agent_id", agentID,
+		"protocol_version", info.ProtocolVersion,
+		"version", info.Version,
+		"capabilities", info.Capabilities)
+
+	conn, err := sh.connManager.Register(agentID, stream, info)
 	if err != nil {
 		return fmt.Errorf("failed to register agent: %w", err)
 	}
 
 	defer func() {
-		sh.connManager.Deregister(agentID)
+		sh.connManager.deregisterConn(conn, DisconnectReasonClosed)
 		slog.Info("Agent disconnected", "agent_id", agentID)
 	}()
 
-	sh.connManager.UpdateLastSeen(agentID)
+	sh.connManager.touch(conn)
+	sh.server.confirmAgentCert(stream.Context(), agentID)
 
-	if err := sh.processMessage(agentID, firstMsg); err != nil {
+	if tunnels := parseTunnelNames(firstMsg.Metadata["tcp_tunnels"]); len(tunnels) > 0 {
+		if err := sh.connManager.StartTCPTunnels(agentID, tunnels); err != nil {
+			slog.Error("Failed to start TCP tunnels", "agent_id", agentID, "error", err)
+		}
+	}
+
+	if ack != nil {
+		if err := sh.connManager.sendTo(conn, ack); err != nil {
+			return fmt.Errorf("failed to send HELLO_ACK: %w", err)
+		}
+	} else if err := sh.processMessage(conn, firstMsg); err != nil {
 		slog.Error("Failed to process first message", "agent_id", agentID, "error", err)
 	}
+	sh.connManager.resumeQueued(conn)
 
 	done := make(chan struct{})
 	errChan := make(chan error, 2)
 
-	go sh.receiveLoop(agentID, stream, done, errChan)
+	go sh.receiveLoop(conn, stream, done, errChan)
 	go sh.sendLoop(agentID, stream, conn.SendCh, done, errChan)
 
 	select {
@@ -69,7 +107,43 @@ func (sh *StreamHandler) HandleStream(stream proto.ProxyService_StreamServer) er
 	}
 }
 
-func (sh *StreamHandler) receiveLoop(agentID string, stream proto.ProxyService_StreamServer, done chan struct{}, errChan chan error) {
+// resolveAgentID determines which agent is on the other end of stream. A
+// verified client certificate is authoritative: its CommonName is the agent ID
+// and any agent_id metadata must agree with it. Without one, the agent_id from
+// the first message is only trusted if unverified identities are allowed.
+// Revoked certificates are rejected here too, since a client can open new
+// streams on a connection whose handshake predates the revocation.
+func (sh *StreamHandler) resolveAgentID(stream proto.ProxyService_StreamServer, firstMsg *proto.ProxyMessage) (string, error) {
+	claimed := firstMsg.Metadata["agent_id"]
+
+	if leaf, ok := grpctls.VerifiedPeerCertificate(stream.Context()); ok {
+		if rc := sh.server.revocationChecker; rc != nil && rc.IsRevoked(leaf) {
+			return "", status.Errorf(codes.PermissionDenied,
+				"client certificate %s has been revoked", leaf.SerialNumber.Text(16))
+		}
+	}
+
+	if certID, ok := grpctls.VerifiedPeerIdentity(stream.Context()); ok {
+		if claimed != "" && claimed != certID {
+			return "", status.Errorf(codes.PermissionDenied,
+				"agent_id %q does not match client certificate identity %q", claimed, certID)
+		}
+		return certID, nil
+	}
+
+	if !sh.server.allowUnverifiedAgentID {
+		return "", status.Error(codes.Unauthenticated,
+			"a verified client certificate is required to identify the agent")
+	}
+
+	if claimed == "" {
+		return "", status.Error(codes.InvalidArgument, "agent_id not found in first message metadata")
+	}
+	return claimed, nil
+}
+
+func (sh *StreamHandler) receiveLoop(conn *AgentConnection, stream proto.ProxyService_StreamServer, done chan struct{}, errChan chan error) {
+	agentID := conn.ID
 	for {
 		select {
 		case <-done:
@@ -86,9 +160,9 @@ func (sh *StreamHandler) receiveLoop(agentID string, stream proto.ProxyService_S
 
 			slog.Debug("Message received", "agent_id", agentID, "message_id", msg.Id, "type", msg.Type)
 
-			sh.connManager.UpdateLastSeen(agentID)
+			sh.connManager.touch(conn)
 
-			if err := sh.processMessage(agentID, msg); err != nil {
+			if err := sh.processMessage(conn, msg); err != nil {
 				slog.Error("Failed to process message", "agent_id", agentID, "error", err)
 			}
 		}
@@ -116,7 +190,8 @@ func (sh *StreamHandler) sendLoop(agentID string, stream proto.ProxyService_Stre
 	}
 }
 
-func (sh *StreamHandler) processMessage(agentID string, msg *proto.ProxyMessage) error {
+func (sh *StreamHandler) processMessage(conn *AgentConnection, msg *proto.ProxyMessage) error {
+	agentID := conn.ID
 	switch msg.Type {
 	case proto.MessageType_PING:
 		slog.Debug("PING received", "agent_id", agentID, "message_id", msg.Id)
@@ -127,19 +202,49 @@ func (sh *StreamHandler) processMessage(agentID string, msg *proto.ProxyMessage)
 			Metadata: map[string]string{},
 		}
 
-		if err := sh.connManager.SendToAgent(agentID, pong); err != nil {
+		if err := sh.connManager.sendTo(conn, pong); err != nil {
 			return fmt.Errorf("failed to send PONG: %w", err)
 		}
 
 		slog.Debug("PONG sent", "agent_id", agentID, "message_id", pong.Id)
 
-	case proto.MessageType_RESPONSE:
-		slog.Debug("RESPONSE received", "agent_id", agentID, "message_id", msg.Id)
+	case proto.MessageType_RESPONSE, proto.MessageType_RESPONSE_START:
+		slog.Debug("RESPONSE received", "agent_id", agentID, "message_id", msg.Id, "type", msg.Type)
+		sh.server.HandleResponse(msg)
+
+	case proto.MessageType_BODY_CHUNK, proto.MessageType_BODY_END:
 		sh.server.HandleResponse(msg)
 
+	case proto.MessageType_WINDOW_UPDATE:
+		sh.server.HandleWindowUpdate(msg)
+
+	case proto.MessageType_WS_OPEN_ACK, proto.MessageType_WS_FRAME, proto.MessageType_WS_CLOSE,
+		proto.MessageType_TCP_OPEN_ACK, proto.MessageType_TCP_DATA, proto.MessageType_TCP_CLOSE_WRITE, proto.MessageType_TCP_CLOSE:
+		sh.server.HandleTunnelMessage(msg)
+
+	case proto.MessageType_CERT_RENEW_REQUEST:
+		slog.Info("Certificate renewal requested", "agent_id", agentID, "message_id", msg.Id)
+		go sh.server.handleCertRenewal(conn, msg)
+
+	case proto.MessageType_COMMAND_RESULT:
+		slog.Debug("COMMAND_RESULT received", "agent_id", agentID, "message_id", msg.Id)
+		sh.server.HandleCommandResult(msg)
+
 	default:
 		slog.Warn("Unknown message type", "agent_id", agentID, "type", msg.Type)
 	}
 
 	return nil
 }
+
+// parseTunnelNames splits the comma-separated tcp_tunnels metadata an agent
+// sends with its first message.
+func parseTunnelNames(value string) []string {
+	var names []string
+	for _, name := range strings.Split(value, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
@@ -0,0 +1,124 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"testing"
+
+	"github.com/EternisAI/silo-proxy/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// newMTLSStream returns a stream whose peer presented a verified client
+// certificate with the given CommonName.
+func newMTLSStream(commonName string) *MockStream {
+	leaf := &x509.Certificate{SerialNumber: big.NewInt(1), Subject: pkix.Name{CommonName: commonName}}
+	ctx := peer.NewContext(context.Background(), &peer.Peer{
+		AuthInfo: credentials.TLSInfo{
+			State: tls.ConnectionState{
+				PeerCertificates: []*x509.Certificate{leaf},
+				VerifiedChains:   [][]*x509.Certificate{{leaf}},
+			},
+		},
+	})
+
+	stream := NewMockStream()
+	stream.ctx = ctx
+	return stream
+}
+
+func firstMessage(agentID string) *proto.ProxyMessage {
+	msg := &proto.ProxyMessage{Type: proto.MessageType_PING, Metadata: map[string]string{}}
+	if agentID != "" {
+		msg.Metadata["agent_id"] = agentID
+	}
+	return msg
+}
+
+func TestResolveAgentID_CertificateIsAuthoritative(t *testing.T) {
+	s := NewServer(0, nil)
+
+	agentID, err := s.streamHandler.resolveAgentID(newMTLSStream("agent-1"), firstMessage("agent-1"))
+	require.NoError(t, err)
+	assert.Equal(t, "agent-1", agentID)
+
+	agentID, err = s.streamHandler.resolveAgentID(newMTLSStream("agent-1"), firstMessage(""))
+	require.NoError(t, err)
+	assert.Equal(t, "agent-1", agentID)
+}
+
+func TestResolveAgentID_MismatchRejected(t *testing.T) {
+	s := NewServer(0, nil)
+	s.SetAllowUnverifiedAgentID(true)
+
+	_, err := s.streamHandler.resolveAgentID(newMTLSStream("agent-1"), firstMessage("agent-2"))
+	require.Error(t, err)
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	assert.Contains(t, err.Error(), `"agent-2"`)
+}
+
+func TestResolveAgentID_UnverifiedRejectedByDefault(t *testing.T) {
+	s := NewServer(0, nil)
+
+	_, err := s.streamHandler.resolveAgentID(NewMockStream(), firstMessage("agent-1"))
+	require.Error(t, err)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+}
+
+func TestResolveAgentID_UnverifiedAllowed(t *testing.T) {
+	s := NewServer(0, nil)
+	s.SetAllowUnverifiedAgentID(true)
+
+	agentID, err := s.streamHandler.resolveAgentID(NewMockStream(), firstMessage("agent-1"))
+	require.NoError(t, err)
+	assert.Equal(t, "agent-1", agentID)
+
+	_, err = s.streamHandler.resolveAgentID(NewMockStream(), firstMessage(""))
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+type revokedSerials map[int64]bool
+
+func (r revokedSerials) IsRevoked(certificate *x509.Certificate) bool {
+	return r[certificate.SerialNumber.Int64()]
+}
+
+func TestResolveAgentID_RevokedCertificateRejected(t *testing.T) {
+	s := NewServer(0, nil)
+	s.SetRevocationChecker(revokedSerials{1: true})
+
+	_, err := s.streamHandler.resolveAgentID(newMTLSStream("agent-1"), firstMessage("agent-1"))
+	require.Error(t, err)
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	assert.Contains(t, err.Error(), "revoked")
+
+	s.SetRevocationChecker(revokedSerials{2: true})
+	agentID, err := s.streamHandler.resolveAgentID(newMTLSStream("agent-1"), firstMessage("agent-1"))
+	require.NoError(t, err)
+	assert.Equal(t, "agent-1", agentID)
+}
+
+func TestDisconnectRevokedAgents(t *testing.T) {
+	s := NewServer(0, nil)
+	s.SetRevocationChecker(revokedSerials{1: true})
+
+	_, err := s.connManager.Register("agent-1", newMTLSStream("agent-1"), AgentInfo{})
+	require.NoError(t, err)
+	_, err = s.connManager.Register("agent-2", NewMockStream(), AgentInfo{})
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"agent-1"}, s.DisconnectRevokedAgents())
+
+	_, ok := s.connManager.GetConnection("agent-1")
+	assert.False(t, ok)
+	_, ok = s.connManager.GetConnection("agent-2")
+	assert.True(t, ok)
+}
@@ -0,0 +1,36 @@
+package server
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/EternisAI/silo-proxy/internal/grpc/tunnel"
+	"github.com/EternisAI/silo-proxy/proto"
+	"github.com/google/uuid"
+)
+
+// OpenTCP asks the agent to dial the target of its TCP tunnel tunnelName on
+// behalf of a client connected from remoteAddr. It returns the session to pump
+// the client connection through once the agent has acknowledged the dial.
+func (s *Server) OpenTCP(ctx context.Context, agentID, tunnelName, remoteAddr string) (*tunnel.Session, error) {
+	msg := &proto.ProxyMessage{
+		Id:   uuid.New().String(),
+		Type: proto.MessageType_TCP_OPEN,
+		Metadata: map[string]string{
+			"tunnel":      tunnelName,
+			"remote_addr": remoteAddr,
+		},
+	}
+
+	session, ack, err := s.openSession(ctx, agentID, msg, proto.MessageType_TCP_OPEN_ACK)
+	if err != nil {
+		return nil, err
+	}
+
+	if reason := ack.Metadata["error"]; reason != "" {
+		session.Close()
+		return nil, fmt.Errorf("agent failed to open tunnel %s: %s", tunnelName, reason)
+	}
+
+	return session, nil
+}
@@ -0,0 +1,84 @@
+package server
+
+import (
+	"strings"
+	"time"
+)
+
+// Timeouts bounds how long the server waits on agents. Zero durations keep
+// the defaults.
+type Timeouts struct {
+	// Request is how long to wait for an agent's response headers, and then
+	// for each slice of its response body.
+	Request time.Duration
+	// MaxRequest caps the timeout a client may ask for. When it is zero,
+	// clients can only shorten the timeout that applies to them.
+	MaxRequest time.Duration
+	// RequestOverrides replace Request for some agents or paths.
+	RequestOverrides []RequestTimeoutOverride
+	// Send is how long to wait for room in an agent's send channel.
+	Send time.Duration
+	// StaleConnection is how long an agent may stay silent before its
+	// connection is dropped. It must exceed the agents' ping interval.
+	StaleConnection time.Duration
+}
+
+// RequestTimeoutOverride sets the request timeout for requests to AgentID
+// whose path starts with PathPrefix. Either may be empty to match everything.
+type RequestTimeoutOverride struct {
+	AgentID    string
+	PathPrefix string
+	Timeout    time.Duration
+}
+
+// matches reports whether o applies to path on agentID, and how specific it
+// is: rules for a given agent win over rules for all agents, then longer
+// prefixes win over shorter ones.
+func (o RequestTimeoutOverride) matches(agentID, path string) (int, bool) {
+	if o.AgentID != "" && o.AgentID != agentID {
+		return 0, false
+	}
+	if !strings.HasPrefix(path, o.PathPrefix) {
+		return 0, false
+	}
+
+	score := len(o.PathPrefix) + 1
+	if o.AgentID != "" {
+		score += 1 << 16
+	}
+	return score, true
+}
+
+// SetTimeouts replaces the default timeouts. It must be called before Start.
+func (s *Server) SetTimeouts(timeouts Timeouts) {
+	if timeouts.Request > 0 {
+		s.timeouts.Request = timeouts.Request
+	}
+	s.timeouts.MaxRequest = timeouts.MaxRequest
+	s.timeouts.RequestOverrides = timeouts.RequestOverrides
+	s.connManager.SetTimeouts(timeouts.Send, timeouts.StaleConnection)
+}
+
+// RequestTimeout returns how long a request for path on agentID may wait for
+// the agent. requested is the timeout the client asked for, or zero; it is
+// honoured up to MaxRequest, or up to the configured timeout if there is no
+// maximum.
+func (s *Server) RequestTimeout(agentID, path string, requested time.Duration) time.Duration {
+	timeout := s.timeouts.Request
+	best := 0
+	for _, o := range s.timeouts.RequestOverrides {
+		if score, ok := o.matches(agentID, path); ok && score > best && o.Timeout > 0 {
+			timeout = o.Timeout
+			best = score
+		}
+	}
+
+	if requested <= 0 {
+		return timeout
+	}
+	limit := s.timeouts.MaxRequest
+	if limit <= 0 {
+		limit = timeout
+	}
+	return min(requested, limit)
+}
@@ -0,0 +1,52 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequestTimeout(t *testing.T) {
+	s := NewServer(0, nil)
+	assert.Equal(t, requestTimeout, s.RequestTimeout("agent-1", "/", 0))
+
+	s.SetTimeouts(Timeouts{
+		Request: time.Minute,
+		RequestOverrides: []RequestTimeoutOverride{
+			{PathPrefix: "/reports", Timeout: 10 * time.Minute},
+			{PathPrefix: "/reports/daily", Timeout: 20 * time.Minute},
+			{AgentID: "agent-2", Timeout: 2 * time.Minute},
+			{AgentID: "agent-2", PathPrefix: "/reports", Timeout: 5 * time.Minute},
+		},
+	})
+
+	tests := []struct {
+		agentID string
+		path    string
+		want    time.Duration
+	}{
+		{"agent-1", "/", time.Minute},
+		{"agent-1", "/reports/weekly", 10 * time.Minute},
+		{"agent-1", "/reports/daily/today", 20 * time.Minute},
+		{"agent-2", "/", 2 * time.Minute},
+		// Rules for the agent win over rules for all agents
+		{"agent-2", "/reports/daily", 5 * time.Minute},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, s.RequestTimeout(tt.agentID, tt.path, 0), "%s %s", tt.agentID, tt.path)
+	}
+}
+
+func TestRequestTimeout_Requested(t *testing.T) {
+	s := NewServer(0, nil)
+	s.SetTimeouts(Timeouts{Request: time.Minute})
+
+	// Without a maximum clients can only shorten the timeout
+	assert.Equal(t, 10*time.Second, s.RequestTimeout("agent-1", "/", 10*time.Second))
+	assert.Equal(t, time.Minute, s.RequestTimeout("agent-1", "/", time.Hour))
+
+	s.SetTimeouts(Timeouts{MaxRequest: 5 * time.Minute})
+	assert.Equal(t, 3*time.Minute, s.RequestTimeout("agent-1", "/", 3*time.Minute))
+	assert.Equal(t, 5*time.Minute, s.RequestTimeout("agent-1", "/", time.Hour))
+}
@@ -0,0 +1,101 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/EternisAI/silo-proxy/internal/grpc/protocol"
+	"github.com/EternisAI/silo-proxy/internal/grpc/tunnel"
+	"github.com/EternisAI/silo-proxy/proto"
+	"github.com/gorilla/websocket"
+)
+
+// openSession registers a tunnel session for openMsg, sends it to the agent and
+// waits for the agent's acknowledgement of type ackType. The session is closed
+// on any error; otherwise it is returned together with the ack and the caller
+// owns it. The session stays on the stream of the agent it was opened on.
+func (s *Server) openSession(ctx context.Context, agentID string, openMsg *proto.ProxyMessage, ackType proto.MessageType) (*tunnel.Session, *proto.ProxyMessage, error) {
+	conn, ok := s.connManager.pick(agentID)
+	if !ok {
+		return nil, nil, fmt.Errorf("%w: %s", ErrAgentNotFound, agentID)
+	}
+	if conn.Draining() {
+		return nil, nil, ErrAgentDraining
+	}
+
+	conn.inFlight.Add(1)
+	session := tunnel.NewSession(openMsg.Id,
+		func(frame *proto.ProxyMessage) error {
+			return s.connManager.sendTo(conn, frame)
+		},
+		func() {
+			s.sessionsMu.Lock()
+			delete(s.sessions, openMsg.Id)
+			s.sessionsMu.Unlock()
+			conn.inFlight.Add(-1)
+		})
+	if conn.Info.Supports(protocol.FlowControl) {
+		session.EnableFlowControl()
+	}
+
+	s.sessionsMu.Lock()
+	s.sessions[openMsg.Id] = session
+	s.sessionsMu.Unlock()
+
+	// Tear the session down if the agent goes away underneath it.
+	go func() {
+		select {
+		case <-conn.ctx.Done():
+			session.Close()
+		case <-session.Done():
+		}
+	}()
+
+	setLegacyHeaders(conn, openMsg)
+	if err := s.connManager.sendTo(conn, openMsg); err != nil {
+		session.Close()
+		return nil, nil, fmt.Errorf("failed to send %s to agent: %w", openMsg.Type, err)
+	}
+
+	ackCtx, cancel := context.WithTimeout(ctx, s.timeouts.Request)
+	defer cancel()
+
+	ack, err := session.Recv(ackCtx)
+	if err != nil {
+		session.Close()
+		return nil, nil, fmt.Errorf("waiting for %s failed: %w", ackType, err)
+	}
+
+	if ack.Type != ackType {
+		session.Close()
+		return nil, nil, fmt.Errorf("unexpected %s frame while waiting for %s", ack.Type, ackType)
+	}
+
+	return session, ack, nil
+}
+
+// HandleTunnelMessage routes WebSocket and TCP tunnel frames, and the
+// WINDOW_UPDATE frames granting sessions credit, from an agent to the session
+// they belong to.
+func (s *Server) HandleTunnelMessage(msg *proto.ProxyMessage) {
+	s.sessionsMu.RLock()
+	session, ok := s.sessions[msg.Id]
+	s.sessionsMu.RUnlock()
+
+	if !ok {
+		slog.Debug("Received tunnel frame for unknown session", "session_id", msg.Id, "type", msg.Type)
+		return
+	}
+
+	if err := session.Deliver(msg); err != nil {
+		slog.Warn("Failed to deliver tunnel frame", "session_id", msg.Id, "type", msg.Type, "error", err)
+
+		closeMsg := tunnel.TCPCloseMessage(msg.Id, "session overloaded")
+		switch msg.Type {
+		case proto.MessageType_WS_OPEN_ACK, proto.MessageType_WS_FRAME, proto.MessageType_WS_CLOSE:
+			closeMsg = tunnel.WebSocketCloseMessage(msg.Id, websocket.CloseInternalServerErr, "session overloaded")
+		}
+		session.Abort(closeMsg)
+	}
+}
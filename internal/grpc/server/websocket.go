@@ -0,0 +1,26 @@
+package server
+
+import (
+	"context"
+
+	"github.com/EternisAI/silo-proxy/internal/grpc/tunnel"
+	"github.com/EternisAI/silo-proxy/proto"
+)
+
+// OpenWebSocket asks the agent to open a WebSocket to its local service using
+// the WS_OPEN frame msg. It waits for the agent's WS_OPEN_ACK and returns it.
+// The session is only returned when the local handshake succeeded (status 101);
+// otherwise the ack carries the status code and body to send to the client.
+func (s *Server) OpenWebSocket(ctx context.Context, agentID string, msg *proto.ProxyMessage) (*tunnel.Session, *proto.ProxyMessage, error) {
+	session, ack, err := s.openSession(ctx, agentID, msg, proto.MessageType_WS_OPEN_ACK)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if ack.Metadata["status_code"] != "101" {
+		session.Close()
+		return nil, ack, nil
+	}
+
+	return session, ack, nil
+}
@@ -9,7 +9,10 @@ import (
 	"google.golang.org/grpc/credentials"
 )
 
-func LoadServerCredentials(certFile, keyFile, caFile string, clientAuth tls.ClientAuthType) (credentials.TransportCredentials, error) {
+// LoadServerCredentials builds the gRPC server TLS credentials. When isRevoked
+// is non-nil, handshakes presenting a verified client certificate it reports
+// as revoked are refused.
+func LoadServerCredentials(certFile, keyFile, caFile string, clientAuth tls.ClientAuthType, isRevoked func(*x509.Certificate) bool) (credentials.TransportCredentials, error) {
 	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
 	if err != nil {
 		return nil, fmt.Errorf("failed to load server certificate: %w", err)
@@ -32,6 +35,18 @@ func LoadServerCredentials(certFile, keyFile, caFile string, clientAuth tls.Clie
 		config.ClientCAs = caPool
 	}
 
+	if isRevoked != nil {
+		config.VerifyConnection = func(cs tls.ConnectionState) error {
+			if len(cs.VerifiedChains) == 0 || len(cs.VerifiedChains[0]) == 0 {
+				return nil
+			}
+			if leaf := cs.VerifiedChains[0][0]; isRevoked(leaf) {
+				return fmt.Errorf("client certificate %s for %q has been revoked", leaf.SerialNumber.Text(16), leaf.Subject.CommonName)
+			}
+			return nil
+		}
+	}
+
 	return credentials.NewTLS(config), nil
 }
 
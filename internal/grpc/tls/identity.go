@@ -0,0 +1,40 @@
+package tls
+
+import (
+	"context"
+	"crypto/x509"
+
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+)
+
+// VerifiedPeerCertificate returns the client certificate presented on the
+// connection behind ctx, provided its chain was verified against the CA, i.e.
+// with client_auth "require"; merely presented certificates are ignored.
+func VerifiedPeerCertificate(ctx context.Context) (*x509.Certificate, bool) {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.AuthInfo == nil {
+		return nil, false
+	}
+
+	tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok {
+		return nil, false
+	}
+
+	chains := tlsInfo.State.VerifiedChains
+	if len(chains) == 0 || len(chains[0]) == 0 {
+		return nil, false
+	}
+	return chains[0][0], true
+}
+
+// VerifiedPeerIdentity returns the CommonName of the verified client
+// certificate behind ctx; see VerifiedPeerCertificate.
+func VerifiedPeerIdentity(ctx context.Context) (string, bool) {
+	leaf, ok := VerifiedPeerCertificate(ctx)
+	if !ok || leaf.Subject.CommonName == "" {
+		return "", false
+	}
+	return leaf.Subject.CommonName, true
+}
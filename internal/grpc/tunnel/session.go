@@ -0,0 +1,178 @@
+package tunnel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+
+	"github.com/EternisAI/silo-proxy/internal/grpc/chunk"
+	"github.com/EternisAI/silo-proxy/proto"
+)
+
+var (
+	ErrSessionClosed = errors.New("tunnel session closed")
+	// ErrSessionOverloaded is returned by Deliver for a frame that does not
+	// fit in the session's buffer; the caller should close the session.
+	ErrSessionOverloaded = errors.New("tunnel session overloaded")
+)
+
+// Session is one end of a connection tunnelled over the agent stream. Frames
+// received from the stream are handed over with Deliver and consumed with
+// Recv; frames for the other end are sent with Send.
+//
+// Deliver never blocks, so that a slow session cannot hold up the stream's
+// receive loop. Up to chunk.MaxBuffered payload bytes wait for Recv; with flow
+// control the peer never sends more than that, and without it a session that
+// falls further behind is overloaded.
+type Session struct {
+	ID string
+
+	mu       sync.Mutex
+	frames   []*proto.ProxyMessage
+	buffered int           // Payload bytes in frames
+	ready    chan struct{} // Signalled when frames change
+
+	send      chunk.SendFunc
+	window    *chunk.Window // Credit for sending data, nil without flow control
+	acker     *chunk.Acker  // Grants the peer credit for data taken by Recv
+	done      chan struct{}
+	closeOnce sync.Once
+	onClose   func()
+}
+
+// NewSession creates a session. onClose, if not nil, is called once when the
+// session is closed so the owner can drop it from its registry.
+func NewSession(id string, send chunk.SendFunc, onClose func()) *Session {
+	return &Session{
+		ID:      id,
+		ready:   make(chan struct{}, 1),
+		send:    send,
+		done:    make(chan struct{}),
+		onClose: onClose,
+	}
+}
+
+// EnableFlowControl makes the session exchange WINDOW_UPDATE credit with a
+// peer that negotiated it: Send waits for credit before sending TCP_DATA and
+// WS_FRAME payloads, and Recv grants the peer credit for those it takes. It
+// must be called before the session is used.
+func (s *Session) EnableFlowControl() {
+	s.window = chunk.NewWindow()
+	s.acker = chunk.NewAcker(func(n int) {
+		if err := s.Send(chunk.WindowUpdate(s.ID, n)); err != nil {
+			slog.Debug("Failed to send WINDOW_UPDATE", "session_id", s.ID, "error", err)
+		}
+	})
+}
+
+// Deliver hands a frame received from the stream to the session without
+// blocking. A WINDOW_UPDATE frame grants its credit to Send instead. A frame
+// that does not fit in the buffer is refused with ErrSessionOverloaded.
+func (s *Session) Deliver(msg *proto.ProxyMessage) error {
+	select {
+	case <-s.done:
+		return ErrSessionClosed
+	default:
+	}
+
+	if msg.Type == proto.MessageType_WINDOW_UPDATE {
+		s.window.Grant(chunk.Granted(msg))
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.buffered+len(msg.Payload) > chunk.MaxBuffered {
+		return fmt.Errorf("%w: %s is more than %d bytes behind", ErrSessionOverloaded, s.ID, chunk.MaxBuffered)
+	}
+	s.frames = append(s.frames, msg)
+	s.buffered += len(msg.Payload)
+
+	select {
+	case s.ready <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Send forwards a frame to the other end of the tunnel, first waiting for
+// credit for its payload if it carries data and the session is flow
+// controlled.
+func (s *Session) Send(msg *proto.ProxyMessage) error {
+	select {
+	case <-s.done:
+		return ErrSessionClosed
+	default:
+	}
+
+	switch msg.Type {
+	case proto.MessageType_TCP_DATA, proto.MessageType_WS_FRAME:
+		if err := s.window.Acquire(len(msg.Payload)); err != nil {
+			return ErrSessionClosed
+		}
+	}
+	return s.send(msg)
+}
+
+// Recv waits for the next frame from the other end of the tunnel. It must not
+// be called concurrently.
+func (s *Session) Recv(ctx context.Context) (*proto.ProxyMessage, error) {
+	for {
+		select {
+		case <-s.done:
+			return nil, ErrSessionClosed
+		default:
+		}
+
+		s.mu.Lock()
+		if len(s.frames) > 0 {
+			msg := s.frames[0]
+			s.frames[0] = nil
+			s.frames = s.frames[1:]
+			s.buffered -= len(msg.Payload)
+			s.mu.Unlock()
+
+			s.acker.Consumed(len(msg.Payload))
+			return msg, nil
+		}
+		s.mu.Unlock()
+
+		select {
+		case <-s.ready:
+		case <-s.done:
+			return nil, ErrSessionClosed
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// Abort closes the session and tells the peer with msg, a TCP_CLOSE or
+// WS_CLOSE frame. msg is sent in the background, so that Abort can be called
+// from the stream's receive loop.
+func (s *Session) Abort(msg *proto.ProxyMessage) {
+	s.Close()
+	go func() {
+		if err := s.send(msg); err != nil {
+			slog.Debug("Failed to send close frame", "session_id", s.ID, "type", msg.Type, "error", err)
+		}
+	}()
+}
+
+// Done is closed once the session has been closed.
+func (s *Session) Done() <-chan struct{} {
+	return s.done
+}
+
+func (s *Session) Close() {
+	s.closeOnce.Do(func() {
+		close(s.done)
+		s.window.Close()
+		if s.onClose != nil {
+			s.onClose()
+		}
+	})
+}
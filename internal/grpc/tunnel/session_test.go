@@ -0,0 +1,78 @@
+package tunnel
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/EternisAI/silo-proxy/internal/grpc/chunk"
+	"github.com/EternisAI/silo-proxy/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newPeerSessions returns two sessions wired back to back, as the server and
+// agent ends would be across the gRPC stream.
+func newPeerSessions(t *testing.T) (*Session, *Session) {
+	var a, b *Session
+	a = NewSession("session-1", func(msg *proto.ProxyMessage) error { return b.Deliver(msg) }, nil)
+	b = NewSession("session-1", func(msg *proto.ProxyMessage) error { return a.Deliver(msg) }, nil)
+	return a, b
+}
+
+func TestSession_CloseUnblocksRecv(t *testing.T) {
+	var closed atomic.Bool
+	s := NewSession("session-1", func(*proto.ProxyMessage) error { return nil }, func() { closed.Store(true) })
+
+	go s.Close()
+
+	_, err := s.Recv(context.Background())
+	assert.ErrorIs(t, err, ErrSessionClosed)
+	assert.Eventually(t, closed.Load, time.Second, 10*time.Millisecond)
+
+	assert.ErrorIs(t, s.Send(&proto.ProxyMessage{}), ErrSessionClosed)
+	assert.ErrorIs(t, s.Deliver(&proto.ProxyMessage{}), ErrSessionClosed)
+}
+
+func TestSession_DeliverNeverBlocks(t *testing.T) {
+	s := NewSession("session-1", func(*proto.ProxyMessage) error { return nil }, nil)
+	data := &proto.ProxyMessage{Type: proto.MessageType_TCP_DATA, Payload: make([]byte, chunk.Size)}
+
+	// Nothing reads: the buffer fills up and the next frame is refused.
+	for range chunk.MaxBuffered / chunk.Size {
+		require.NoError(t, s.Deliver(data))
+	}
+	assert.ErrorIs(t, s.Deliver(data), ErrSessionOverloaded)
+}
+
+func TestSession_FlowControlPausesSender(t *testing.T) {
+	a, b := newPeerSessions(t)
+	a.EnableFlowControl()
+	b.EnableFlowControl()
+
+	frames := 2 * chunk.WindowSize / chunk.Size
+	sent := make(chan error, 1)
+	go func() {
+		for range frames {
+			if err := a.Send(&proto.ProxyMessage{Id: a.ID, Type: proto.MessageType_TCP_DATA, Payload: make([]byte, chunk.Size)}); err != nil {
+				sent <- err
+				return
+			}
+		}
+		sent <- nil
+	}()
+
+	select {
+	case err := <-sent:
+		t.Fatalf("sender did not wait for credit: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	for range frames {
+		msg, err := b.Recv(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, proto.MessageType_TCP_DATA, msg.Type)
+	}
+	assert.NoError(t, <-sent)
+}
@@ -0,0 +1,146 @@
+package tunnel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+
+	"github.com/EternisAI/silo-proxy/internal/grpc/chunk"
+	"github.com/EternisAI/silo-proxy/proto"
+)
+
+var errClosedByPeer = errors.New("tcp connection closed by peer")
+
+// closeWriter is implemented by connections that support half-close, such as
+// *net.TCPConn.
+type closeWriter interface {
+	CloseWrite() error
+}
+
+// TCPCloseMessage builds the TCP_CLOSE frame for a session. reason is empty
+// for an orderly teardown.
+func TCPCloseMessage(id string, reason string) *proto.ProxyMessage {
+	msg := &proto.ProxyMessage{
+		Id:       id,
+		Type:     proto.MessageType_TCP_CLOSE,
+		Metadata: map[string]string{},
+	}
+	if reason != "" {
+		msg.Metadata["error"] = reason
+	}
+	return msg
+}
+
+// PumpTCP relays bytes between a TCP connection and a tunnel session. Each
+// direction is shut down independently: EOF on conn is forwarded as
+// TCP_CLOSE_WRITE and a TCP_CLOSE_WRITE from the peer half-closes conn. Once
+// both directions are finished, or either fails, conn and the session are
+// closed.
+func PumpTCP(conn net.Conn, session *Session) {
+	errCh := make(chan error, 2)
+
+	go func() { errCh <- tcpConnToSession(conn, session) }()
+	go func() { errCh <- tcpSessionToConn(conn, session) }()
+
+	var err error
+	pending := 2
+	for pending > 0 && err == nil {
+		err = <-errCh
+		pending--
+	}
+
+	if err != nil && !errors.Is(err, errClosedByPeer) && !errors.Is(err, ErrSessionClosed) {
+		if sendErr := session.Send(TCPCloseMessage(session.ID, err.Error())); sendErr != nil {
+			slog.Debug("Failed to send TCP_CLOSE", "session_id", session.ID, "error", sendErr)
+		}
+	}
+
+	session.Close()
+	conn.Close()
+	for ; pending > 0; pending-- {
+		<-errCh
+	}
+
+	slog.Debug("TCP session ended", "session_id", session.ID, "reason", err)
+}
+
+func tcpConnToSession(conn net.Conn, session *Session) error {
+	buf := make([]byte, chunk.Size)
+
+	for {
+		n, readErr := conn.Read(buf)
+		if n > 0 {
+			payload := make([]byte, n)
+			copy(payload, buf[:n])
+
+			if err := session.Send(&proto.ProxyMessage{
+				Id:      session.ID,
+				Type:    proto.MessageType_TCP_DATA,
+				Payload: payload,
+			}); err != nil {
+				return fmt.Errorf("failed to send tcp data: %w", err)
+			}
+		}
+
+		if readErr == nil {
+			continue
+		}
+
+		select {
+		case <-session.Done():
+			return ErrSessionClosed
+		default:
+		}
+
+		if readErr != io.EOF {
+			return fmt.Errorf("failed to read tcp connection: %w", readErr)
+		}
+
+		if err := session.Send(&proto.ProxyMessage{
+			Id:   session.ID,
+			Type: proto.MessageType_TCP_CLOSE_WRITE,
+		}); err != nil {
+			return fmt.Errorf("failed to send tcp half-close: %w", err)
+		}
+		return nil
+	}
+}
+
+func tcpSessionToConn(conn net.Conn, session *Session) error {
+	for {
+		msg, err := session.Recv(context.Background())
+		if err != nil {
+			return err
+		}
+
+		switch msg.Type {
+		case proto.MessageType_TCP_DATA:
+			if _, err := conn.Write(msg.Payload); err != nil {
+				return fmt.Errorf("failed to write tcp connection: %w", err)
+			}
+
+		case proto.MessageType_TCP_CLOSE_WRITE:
+			if cw, ok := conn.(closeWriter); ok {
+				if err := cw.CloseWrite(); err != nil {
+					return fmt.Errorf("failed to half-close tcp connection: %w", err)
+				}
+				return nil
+			}
+			// Without half-close support the best we can do is end the
+			// connection once the peer is done writing.
+			return errors.New("connection does not support half-close")
+
+		case proto.MessageType_TCP_CLOSE:
+			if reason := msg.Metadata["error"]; reason != "" {
+				return fmt.Errorf("%w: %s", errClosedByPeer, reason)
+			}
+			return errClosedByPeer
+
+		default:
+			slog.Warn("Unexpected frame on tcp session", "session_id", session.ID, "type", msg.Type)
+		}
+	}
+}
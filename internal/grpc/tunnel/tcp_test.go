@@ -0,0 +1,113 @@
+package tunnel
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/EternisAI/silo-proxy/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startTCPPump accepts a single connection on a local listener and pumps it
+// through session. It returns the listener's address.
+func startTCPPump(t *testing.T, session *Session) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		PumpTCP(conn, session)
+	}()
+
+	return ln.Addr().String()
+}
+
+func TestPumpTCP_RelaysDataWithHalfClose(t *testing.T) {
+	serverEnd, agentEnd := newPeerSessions(t)
+	clientAddr := startTCPPump(t, serverEnd)
+
+	// The target reads until EOF before answering, so the response only
+	// arrives if the client's half-close made it through the tunnel.
+	target, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer target.Close()
+
+	go func() {
+		conn, err := target.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		data, _ := io.ReadAll(conn)
+		fmt.Fprintf(conn, "received %d bytes", len(data))
+	}()
+
+	targetConn, err := net.Dial("tcp", target.Addr().String())
+	require.NoError(t, err)
+	go PumpTCP(targetConn, agentEnd)
+
+	conn, err := net.Dial("tcp", clientAddr)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, err = conn.Write(make([]byte, 100_000))
+	require.NoError(t, err)
+	require.NoError(t, conn.(*net.TCPConn).CloseWrite())
+
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(2*time.Second)))
+	resp, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.Equal(t, "received 100000 bytes", string(resp))
+
+	assert.Eventually(t, func() bool {
+		select {
+		case <-serverEnd.Done():
+			return true
+		default:
+			return false
+		}
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestPumpTCP_PeerCloseEndsConnection(t *testing.T) {
+	serverEnd, agentEnd := newPeerSessions(t)
+	clientAddr := startTCPPump(t, serverEnd)
+
+	conn, err := net.Dial("tcp", clientAddr)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	require.NoError(t, agentEnd.Send(TCPCloseMessage("session-1", "connection refused")))
+
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(2*time.Second)))
+	_, err = conn.Read(make([]byte, 1))
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestPumpTCP_ClientResetForwarded(t *testing.T) {
+	serverEnd, agentEnd := newPeerSessions(t)
+	clientAddr := startTCPPump(t, serverEnd)
+
+	conn, err := net.Dial("tcp", clientAddr)
+	require.NoError(t, err)
+
+	require.NoError(t, conn.(*net.TCPConn).SetLinger(0))
+	require.NoError(t, conn.Close())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	frame, err := agentEnd.Recv(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, proto.MessageType_TCP_CLOSE, frame.Type)
+	assert.NotEmpty(t, frame.Metadata["error"])
+}
@@ -0,0 +1,146 @@
+package tunnel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/EternisAI/silo-proxy/proto"
+	"github.com/gorilla/websocket"
+)
+
+const writeWait = 10 * time.Second
+
+// MaxMessageSize is the largest WebSocket message relayed through a tunnel,
+// which carries each message in a single WS_FRAME. It keeps every frame below
+// gRPC's default 4 MB message limit. A larger message closes the WebSocket
+// with 1009 (message too big) on both ends.
+const MaxMessageSize = 4<<20 - 64<<10
+
+const (
+	messageTypeText   = "text"
+	messageTypeBinary = "binary"
+)
+
+// WebSocketCloseMessage builds the WS_CLOSE frame for a session.
+func WebSocketCloseMessage(id string, code int, reason string) *proto.ProxyMessage {
+	return &proto.ProxyMessage{
+		Id:   id,
+		Type: proto.MessageType_WS_CLOSE,
+		Metadata: map[string]string{
+			"code":   strconv.Itoa(code),
+			"reason": reason,
+		},
+	}
+}
+
+// PumpWebSocket relays messages between a WebSocket connection and a tunnel
+// session until either side closes, then closes both. Messages larger than
+// MaxMessageSize end the session.
+func PumpWebSocket(conn *websocket.Conn, session *Session) {
+	conn.SetReadLimit(MaxMessageSize)
+	errCh := make(chan error, 2)
+
+	go func() { errCh <- wsConnToSession(conn, session) }()
+	go func() { errCh <- wsSessionToConn(conn, session) }()
+
+	err := <-errCh
+	session.Close()
+	conn.Close()
+	<-errCh
+
+	slog.Debug("WebSocket session ended", "session_id", session.ID, "reason", err)
+}
+
+func wsConnToSession(conn *websocket.Conn, session *Session) error {
+	for {
+		messageType, data, err := conn.ReadMessage()
+		if err != nil {
+			select {
+			case <-session.Done():
+				return err
+			default:
+			}
+
+			code, reason := websocket.CloseGoingAway, ""
+			var closeErr *websocket.CloseError
+			switch {
+			case errors.As(err, &closeErr):
+				code, reason = closeErr.Code, closeErr.Text
+			case errors.Is(err, websocket.ErrReadLimit):
+				// The connection has already been sent 1009 by the read.
+				code, reason = websocket.CloseMessageTooBig, "message too big"
+			}
+
+			if sendErr := session.Send(WebSocketCloseMessage(session.ID, code, reason)); sendErr != nil {
+				slog.Debug("Failed to send WS_CLOSE", "session_id", session.ID, "error", sendErr)
+			}
+			return err
+		}
+
+		name := messageTypeBinary
+		if messageType == websocket.TextMessage {
+			name = messageTypeText
+		}
+
+		if err := session.Send(&proto.ProxyMessage{
+			Id:       session.ID,
+			Type:     proto.MessageType_WS_FRAME,
+			Payload:  data,
+			Metadata: map[string]string{"message_type": name},
+		}); err != nil {
+			return fmt.Errorf("failed to send websocket frame: %w", err)
+		}
+	}
+}
+
+func wsSessionToConn(conn *websocket.Conn, session *Session) error {
+	for {
+		msg, err := session.Recv(context.Background())
+		if err != nil {
+			_ = conn.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.CloseGoingAway, ""),
+				time.Now().Add(writeWait))
+			return err
+		}
+
+		switch msg.Type {
+		case proto.MessageType_WS_FRAME:
+			messageType := websocket.BinaryMessage
+			if msg.Metadata["message_type"] == messageTypeText {
+				messageType = websocket.TextMessage
+			}
+
+			if err := conn.WriteMessage(messageType, msg.Payload); err != nil {
+				return fmt.Errorf("failed to write websocket message: %w", err)
+			}
+
+		case proto.MessageType_WS_CLOSE:
+			code, err := strconv.Atoi(msg.Metadata["code"])
+			if err != nil || !sendableCloseCode(code) {
+				code = websocket.CloseGoingAway
+			}
+
+			_ = conn.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(code, msg.Metadata["reason"]),
+				time.Now().Add(writeWait))
+			return nil
+
+		default:
+			slog.Warn("Unexpected frame on websocket session", "session_id", session.ID, "type", msg.Type)
+		}
+	}
+}
+
+// sendableCloseCode reports whether code may appear in a close frame. Codes
+// such as 1006 only describe local conditions and must not be sent.
+func sendableCloseCode(code int) bool {
+	switch code {
+	case websocket.CloseAbnormalClosure, websocket.CloseTLSHandshake:
+		return false
+	}
+	return code >= 1000 && code < 5000
+}
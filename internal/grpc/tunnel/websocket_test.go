@@ -0,0 +1,120 @@
+package tunnel
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/EternisAI/silo-proxy/proto"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func startPumpServer(t *testing.T, session *Session) string {
+	upgrader := websocket.Upgrader{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		require.NoError(t, err)
+		PumpWebSocket(conn, session)
+	}))
+	t.Cleanup(srv.Close)
+	return "ws" + strings.TrimPrefix(srv.URL, "http")
+}
+
+func TestPumpWebSocket_RelaysMessages(t *testing.T) {
+	serverEnd, agentEnd := newPeerSessions(t)
+	url := startPumpServer(t, serverEnd)
+
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	require.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte("hello")))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	frame, err := agentEnd.Recv(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, proto.MessageType_WS_FRAME, frame.Type)
+	assert.Equal(t, "text", frame.Metadata["message_type"])
+	assert.Equal(t, "hello", string(frame.Payload))
+
+	require.NoError(t, agentEnd.Send(&proto.ProxyMessage{
+		Id:       "session-1",
+		Type:     proto.MessageType_WS_FRAME,
+		Payload:  []byte{0x01, 0x02},
+		Metadata: map[string]string{"message_type": "binary"},
+	}))
+
+	messageType, data, err := conn.ReadMessage()
+	require.NoError(t, err)
+	assert.Equal(t, websocket.BinaryMessage, messageType)
+	assert.Equal(t, []byte{0x01, 0x02}, data)
+}
+
+func TestPumpWebSocket_ClientCloseForwarded(t *testing.T) {
+	serverEnd, agentEnd := newPeerSessions(t)
+	url := startPumpServer(t, serverEnd)
+
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	require.NoError(t, conn.WriteMessage(websocket.CloseMessage,
+		websocket.FormatCloseMessage(4000, "bye")))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	frame, err := agentEnd.Recv(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, proto.MessageType_WS_CLOSE, frame.Type)
+	assert.Equal(t, "4000", frame.Metadata["code"])
+	assert.Equal(t, "bye", frame.Metadata["reason"])
+}
+
+func TestPumpWebSocket_PeerCloseForwarded(t *testing.T) {
+	serverEnd, agentEnd := newPeerSessions(t)
+	url := startPumpServer(t, serverEnd)
+
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	require.NoError(t, agentEnd.Send(WebSocketCloseMessage("session-1", websocket.CloseNormalClosure, "done")))
+
+	_, _, err = conn.ReadMessage()
+	var closeErr *websocket.CloseError
+	require.ErrorAs(t, err, &closeErr)
+	assert.Equal(t, websocket.CloseNormalClosure, closeErr.Code)
+	assert.Equal(t, "done", closeErr.Text)
+}
+
+func TestPumpWebSocket_MessageTooBig(t *testing.T) {
+	serverEnd, agentEnd := newPeerSessions(t)
+	url := startPumpServer(t, serverEnd)
+
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	require.NoError(t, conn.WriteMessage(websocket.BinaryMessage, make([]byte, MaxMessageSize+1)))
+
+	_, _, err = conn.ReadMessage()
+	var closeErr *websocket.CloseError
+	require.ErrorAs(t, err, &closeErr)
+	assert.Equal(t, websocket.CloseMessageTooBig, closeErr.Code)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	frame, err := agentEnd.Recv(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, proto.MessageType_WS_CLOSE, frame.Type)
+	assert.Equal(t, "1009", frame.Metadata["code"])
+}
@@ -0,0 +1,248 @@
+// Package metrics holds the Prometheus collectors exported by the server and
+// the agent. Each binary registers only its own set, so /metrics on the agent
+// does not list server series and vice versa.
+package metrics
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "silo_proxy"
+
+// Server metrics.
+var (
+	ConnectedAgents = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "connected_agents",
+		Help:      "Number of agents currently connected.",
+	})
+
+	AgentStreams = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "agent_streams",
+		Help:      "Number of streams open to connected agents, which may hold several each.",
+	})
+
+	AgentRegistrations = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "agent_registrations_total",
+		Help:      "Agent connections registered, including replacements of an existing connection.",
+	})
+
+	AgentDeregistrations = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "agent_deregistrations_total",
+		Help:      "Agent connections deregistered when their stream ended.",
+	})
+
+	StaleConnectionRemovals = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "stale_connection_removals_total",
+		Help:      "Agent connections removed because the agent stopped sending messages.",
+	})
+
+	PendingRequests = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "pending_requests",
+		Help:      "Requests forwarded to agents whose response has not been fully consumed.",
+	})
+
+	ReconnectQueuedRequests = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "reconnect_queued_requests",
+		Help:      "Requests held until their agent reconnects.",
+	})
+
+	ReconnectGraceExpirations = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "reconnect_grace_expirations_total",
+		Help:      "Disconnected agents that did not reconnect within their grace period.",
+	})
+
+	ProxyRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "proxy_requests_total",
+		Help:      "HTTP requests proxied to agents by agent and response status code.",
+	}, []string{"agent_id", "code"})
+
+	ProxyRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "proxy_request_duration_seconds",
+		Help:      "Time from receiving a proxied HTTP request until its response body was written.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"agent_id"})
+
+	CancelledRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "cancelled_requests_total",
+		Help:      "Requests cancelled on the agent because the caller gave up or timed out.",
+	}, []string{"agent_id"})
+
+	AgentSessionEventsDropped = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "agent_session_events_dropped_total",
+		Help:      "Agent connect and disconnect events not recorded in the agent registry because its queue stayed full.",
+	})
+
+	AgentPoolRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "agent_pool_requests_total",
+		Help:      "Requests to agent pools by pool and the agent picked, empty if none was available.",
+	}, []string{"pool", "agent_id"})
+
+	AgentCommands = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "agent_commands_total",
+		Help:      "Commands run on agents, by command and result.",
+	}, []string{"command", "result"})
+
+	RateLimitedRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rate_limited_requests_total",
+		Help:      "Requests for agents refused with 429 by agent and the limit that was hit.",
+	}, []string{"agent_id", "limit"})
+
+	PortPoolSize = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "port_pool_size",
+		Help:      "Number of ports in a port pool.",
+	}, []string{"pool"})
+
+	PortPoolAllocated = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "port_pool_allocated",
+		Help:      "Number of ports currently allocated from a port pool.",
+	}, []string{"pool"})
+
+	SendTimeouts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "send_timeouts_total",
+		Help:      "Messages that could not be queued because an agent's send channel stayed full.",
+	}, []string{"agent_id"})
+
+	ProvisionRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "provision_requests_total",
+		Help:      "Agent provisioning requests by result.",
+	}, []string{"result"})
+
+	CertificatesIssued = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "certificates_issued_total",
+		Help:      "Agent certificates issued by how the key was obtained: server_key, csr or renewal.",
+	}, []string{"method"})
+)
+
+// Agent metrics.
+var (
+	AgentConnected = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "agent",
+		Name:      "connected",
+		Help:      "Whether the agent currently has a stream to the server (1) or not (0).",
+	})
+
+	AgentReconnectAttempts = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "agent",
+		Name:      "reconnect_attempts_total",
+		Help:      "Attempts to connect to the server after the first one.",
+	})
+
+	AgentReconnectBackoff = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "agent",
+		Name:      "reconnect_backoff_seconds",
+		Help:      "Delay before the next reconnect attempt.",
+	})
+
+	AgentSendTimeouts = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "agent",
+		Name:      "send_timeouts_total",
+		Help:      "Messages that could not be queued because the agent's send channel stayed full.",
+	})
+
+	AgentRejectedRequests = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "agent",
+		Name:      "rejected_requests_total",
+		Help:      "Requests answered with 503 because every worker was busy and the queue was full.",
+	})
+)
+
+var sendQueueDepthDesc = prometheus.NewDesc(
+	prometheus.BuildFQName(namespace, "", "send_queue_depth"),
+	"Messages queued in an agent connection's send channel.",
+	[]string{"agent_id"}, nil,
+)
+
+// sendQueueCollector reports send channel depths when scraped, so that the
+// series of an agent disappear as soon as it disconnects.
+type sendQueueCollector struct {
+	depths func() map[string]int
+}
+
+func (c sendQueueCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- sendQueueDepthDesc
+}
+
+func (c sendQueueCollector) Collect(ch chan<- prometheus.Metric) {
+	for agentID, depth := range c.depths() {
+		ch <- prometheus.MustNewConstMetric(sendQueueDepthDesc, prometheus.GaugeValue, float64(depth), agentID)
+	}
+}
+
+// RegisterServer registers the server metrics with the default registry.
+// sendQueueDepths returns the send channel depth of every connected agent.
+func RegisterServer(sendQueueDepths func() map[string]int) {
+	prometheus.MustRegister(
+		sendQueueCollector{depths: sendQueueDepths},
+		ConnectedAgents,
+		AgentStreams,
+		AgentRegistrations,
+		AgentDeregistrations,
+		StaleConnectionRemovals,
+		PendingRequests,
+		ReconnectQueuedRequests,
+		ReconnectGraceExpirations,
+		ProxyRequests,
+		ProxyRequestDuration,
+		CancelledRequests,
+		AgentSessionEventsDropped,
+		AgentPoolRequests,
+		AgentCommands,
+		RateLimitedRequests,
+		PortPoolSize,
+		PortPoolAllocated,
+		SendTimeouts,
+		ProvisionRequests,
+		CertificatesIssued,
+	)
+}
+
+// RegisterAgent registers the agent metrics with the default registry.
+// sendQueueDepth returns the depth of the agent's send channel.
+func RegisterAgent(sendQueueDepth func() int) {
+	prometheus.MustRegister(
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "agent",
+			Name:      "send_queue_depth",
+			Help:      "Messages queued in the agent's send channel.",
+		}, func() float64 { return float64(sendQueueDepth()) }),
+		AgentConnected,
+		AgentReconnectAttempts,
+		AgentReconnectBackoff,
+		AgentSendTimeouts,
+		AgentRejectedRequests,
+	)
+}
+
+// Handler serves the default registry, which also carries the Go runtime and
+// process collectors, in the Prometheus exposition format.
+func Handler() gin.HandlerFunc {
+	return gin.WrapH(promhttp.Handler())
+}
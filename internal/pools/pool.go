@@ -0,0 +1,191 @@
+// Package pools groups agents that serve the same service into named pools,
+// and picks the agent each request to a pool goes to.
+package pools
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"slices"
+	"sync/atomic"
+
+	"github.com/EternisAI/silo-proxy/internal/domains"
+)
+
+// Balancing strategies of Config.Balance.
+const (
+	// RoundRobin sends requests to the pool's agents in turn.
+	RoundRobin = "round_robin"
+	// LeastInFlight sends each request to the agent with the fewest
+	// requests pending.
+	LeastInFlight = "least_in_flight"
+	// ConsistentHash sends requests with the same value of Config.HashHeader
+	// to the same agent for as long as it is available. Requests without
+	// the header are sent round robin.
+	ConsistentHash = "consistent_hash"
+)
+
+var (
+	// ErrNoAgentAvailable is returned when none of a pool's agents can take
+	// requests.
+	ErrNoAgentAvailable = errors.New("no agent in pool is available")
+	// ErrNoEligibleAgent is returned when agents of a pool are available but
+	// none of them may take the request.
+	ErrNoEligibleAgent = errors.New("no available agent in pool may take the request")
+	// ErrInvalidPool is returned for pool definitions that cannot be used.
+	ErrInvalidPool = errors.New("invalid pool")
+)
+
+// Config defines a pool of agents serving one service. The pool is reached on
+// Port, if set, and on requests whose Host header is Host, if set. Balance is
+// one of RoundRobin, the default, LeastInFlight and ConsistentHash.
+type Config struct {
+	Name       string   `mapstructure:"name"`
+	Agents     []string `mapstructure:"agents"`
+	Balance    string   `mapstructure:"balance"`
+	HashHeader string   `mapstructure:"hash_header"`
+	Port       int      `mapstructure:"port"`
+	Host       string   `mapstructure:"host"`
+}
+
+// Agents tells which agents can take requests and how busy they are.
+type Agents interface {
+	AgentAvailable(agentID string) bool
+	PendingRequests(agentID string) int
+}
+
+// Pool is a pool of agents. Agents that are not available, because they are
+// disconnected or draining, are left out until they are available again.
+type Pool struct {
+	Name string
+	Port int
+	Host string // Normalized, or empty
+
+	members    []string
+	balance    string
+	hashHeader string
+	agents     Agents
+	next       atomic.Uint64
+}
+
+// NewPool creates the pool config defines, with agents telling which of its
+// members are available.
+func NewPool(config Config, agents Agents) (*Pool, error) {
+	if config.Name == "" {
+		return nil, fmt.Errorf("%w: missing name", ErrInvalidPool)
+	}
+	if len(config.Agents) == 0 {
+		return nil, fmt.Errorf("%w: pool %s has no agents", ErrInvalidPool, config.Name)
+	}
+
+	balance := config.Balance
+	switch balance {
+	case "":
+		balance = RoundRobin
+	case RoundRobin, LeastInFlight:
+	case ConsistentHash:
+		if config.HashHeader == "" {
+			return nil, fmt.Errorf("%w: pool %s balances by hash but has no hash_header", ErrInvalidPool, config.Name)
+		}
+	default:
+		return nil, fmt.Errorf("%w: pool %s has unknown balance %q", ErrInvalidPool, config.Name, config.Balance)
+	}
+
+	members := slices.Clone(config.Agents)
+	slices.Sort(members)
+	members = slices.Compact(members)
+
+	return &Pool{
+		Name:       config.Name,
+		Port:       config.Port,
+		Host:       domains.Normalize(config.Host),
+		members:    members,
+		balance:    balance,
+		hashHeader: config.HashHeader,
+		agents:     agents,
+	}, nil
+}
+
+// Balance returns the pool's balancing strategy.
+func (p *Pool) Balance() string {
+	return p.balance
+}
+
+// Members returns the agents of the pool, whether available or not.
+func (p *Pool) Members() []string {
+	return slices.Clone(p.members)
+}
+
+// Available returns the agents of the pool that can take requests now.
+func (p *Pool) Available() []string {
+	available := make([]string, 0, len(p.members))
+	for _, agentID := range p.members {
+		if p.agents.AgentAvailable(agentID) {
+			available = append(available, agentID)
+		}
+	}
+	return available
+}
+
+// Pick returns the agent that r should be sent to, among the available agents
+// eligible returns true for. A nil eligible accepts every agent. If agents are
+// available but none is eligible, ErrNoEligibleAgent is returned.
+func (p *Pool) Pick(r *http.Request, eligible func(agentID string) bool) (string, error) {
+	available := p.Available()
+	if len(available) == 0 {
+		return "", fmt.Errorf("%w: %s", ErrNoAgentAvailable, p.Name)
+	}
+	if eligible != nil {
+		available = slices.DeleteFunc(available, func(agentID string) bool { return !eligible(agentID) })
+		if len(available) == 0 {
+			return "", fmt.Errorf("%w: %s", ErrNoEligibleAgent, p.Name)
+		}
+	}
+
+	switch p.balance {
+	case LeastInFlight:
+		return p.leastInFlight(available), nil
+	case ConsistentHash:
+		if key := r.Header.Get(p.hashHeader); key != "" {
+			return highestRandomWeight(available, key), nil
+		}
+	}
+	return p.roundRobin(available), nil
+}
+
+func (p *Pool) roundRobin(available []string) string {
+	return available[(p.next.Add(1)-1)%uint64(len(available))]
+}
+
+// leastInFlight returns the available agent with the fewest pending
+// requests. Ties are broken round robin, so that idle agents share the load.
+func (p *Pool) leastInFlight(available []string) string {
+	offset := p.next.Add(1) - 1
+	best, bestPending := "", 0
+	for i := range available {
+		agentID := available[(offset+uint64(i))%uint64(len(available))]
+		pending := p.agents.PendingRequests(agentID)
+		if best == "" || pending < bestPending {
+			best, bestPending = agentID, pending
+		}
+	}
+	return best
+}
+
+// highestRandomWeight returns the agent key hashes to. Each key goes to the
+// agent scoring highest for it, so that only the keys of an agent that
+// leaves, or of the agents a new one outscores, move.
+func highestRandomWeight(available []string, key string) string {
+	best, bestScore := "", uint64(0)
+	for _, agentID := range available {
+		h := fnv.New64a()
+		h.Write([]byte(key))
+		h.Write([]byte{0})
+		h.Write([]byte(agentID))
+		if score := h.Sum64(); best == "" || score > bestScore {
+			best, bestScore = agentID, score
+		}
+	}
+	return best
+}
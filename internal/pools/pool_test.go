@@ -0,0 +1,153 @@
+package pools
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeAgents reports the agents it lists as available, with their pending
+// requests.
+type fakeAgents map[string]int
+
+func (f fakeAgents) AgentAvailable(agentID string) bool {
+	_, ok := f[agentID]
+	return ok
+}
+
+func (f fakeAgents) PendingRequests(agentID string) int {
+	return f[agentID]
+}
+
+func pick(t *testing.T, pool *Pool, hashKey string) string {
+	r := httptest.NewRequest("GET", "/", nil)
+	if hashKey != "" {
+		r.Header.Set("X-User", hashKey)
+	}
+	agentID, err := pool.Pick(r, nil)
+	require.NoError(t, err)
+	return agentID
+}
+
+func TestNewPool_Invalid(t *testing.T) {
+	tests := []Config{
+		{Agents: []string{"a"}},
+		{Name: "api"},
+		{Name: "api", Agents: []string{"a"}, Balance: "random"},
+		{Name: "api", Agents: []string{"a"}, Balance: ConsistentHash},
+	}
+	for _, config := range tests {
+		_, err := NewPool(config, fakeAgents{})
+		assert.ErrorIs(t, err, ErrInvalidPool, config)
+	}
+}
+
+func TestPool_RoundRobinSkipsUnavailable(t *testing.T) {
+	agents := fakeAgents{"a": 0, "b": 0, "c": 0}
+	pool, err := NewPool(Config{Name: "api", Agents: []string{"a", "b", "c", "d"}}, agents)
+	require.NoError(t, err)
+	assert.Equal(t, RoundRobin, pool.Balance())
+	assert.Equal(t, []string{"a", "b", "c"}, pool.Available())
+
+	picked := map[string]int{}
+	for range 6 {
+		picked[pick(t, pool, "")]++
+	}
+	assert.Equal(t, map[string]int{"a": 2, "b": 2, "c": 2}, picked)
+
+	delete(agents, "b")
+	for range 4 {
+		assert.NotEqual(t, "b", pick(t, pool, ""))
+	}
+
+	clear(agents)
+	_, err = pool.Pick(httptest.NewRequest("GET", "/", nil), nil)
+	assert.ErrorIs(t, err, ErrNoAgentAvailable)
+}
+
+func TestPool_PicksOnlyEligible(t *testing.T) {
+	pool, err := NewPool(Config{Name: "api", Agents: []string{"a", "b", "c"}}, fakeAgents{"a": 0, "b": 0, "c": 0})
+	require.NoError(t, err)
+	r := httptest.NewRequest("GET", "/", nil)
+
+	onlyB := func(agentID string) bool { return agentID == "b" }
+	for range 3 {
+		agentID, err := pool.Pick(r, onlyB)
+		require.NoError(t, err)
+		assert.Equal(t, "b", agentID)
+	}
+
+	_, err = pool.Pick(r, func(string) bool { return false })
+	assert.ErrorIs(t, err, ErrNoEligibleAgent)
+}
+
+func TestPool_LeastInFlight(t *testing.T) {
+	agents := fakeAgents{"a": 3, "b": 1, "c": 2}
+	pool, err := NewPool(Config{Name: "api", Agents: []string{"a", "b", "c"}, Balance: LeastInFlight}, agents)
+	require.NoError(t, err)
+
+	assert.Equal(t, "b", pick(t, pool, ""))
+	agents["c"] = 0
+	assert.Equal(t, "c", pick(t, pool, ""))
+}
+
+func TestPool_ConsistentHash(t *testing.T) {
+	agents := fakeAgents{"a": 0, "b": 0, "c": 0}
+	pool, err := NewPool(Config{Name: "api", Agents: []string{"a", "b", "c"}, Balance: ConsistentHash, HashHeader: "X-User"}, agents)
+	require.NoError(t, err)
+
+	before := map[string]string{}
+	for i := range 100 {
+		key := fmt.Sprint("user-", i)
+		before[key] = pick(t, pool, key)
+		assert.Equal(t, before[key], pick(t, pool, key), "the same key goes to the same agent")
+	}
+	assert.Len(t, values(before), 3, "keys are spread over all agents")
+
+	delete(agents, "b")
+	for key, agentID := range before {
+		if agentID != "b" {
+			assert.Equal(t, agentID, pick(t, pool, key), "only the keys of the agent that left move")
+		} else {
+			assert.NotEqual(t, "b", pick(t, pool, key))
+		}
+	}
+}
+
+func values(m map[string]string) map[string]bool {
+	set := map[string]bool{}
+	for _, v := range m {
+		set[v] = true
+	}
+	return set
+}
+
+func TestNewSet(t *testing.T) {
+	set, err := NewSet([]Config{
+		{Name: "api", Agents: []string{"a"}, Host: "API.Example.com", Port: 8090},
+		{Name: "web", Agents: []string{"b"}},
+	}, fakeAgents{})
+	require.NoError(t, err)
+
+	pool, ok := set.ByHost("api.example.com")
+	require.True(t, ok)
+	assert.Equal(t, "api", pool.Name)
+	_, ok = set.Get("web")
+	assert.True(t, ok)
+	assert.Len(t, set.All(), 2)
+
+	_, err = NewSet([]Config{
+		{Name: "api", Agents: []string{"a"}},
+		{Name: "api", Agents: []string{"b"}},
+	}, fakeAgents{})
+	assert.ErrorIs(t, err, ErrInvalidPool)
+
+	_, err = NewSet([]Config{
+		{Name: "api", Agents: []string{"a"}, Port: 8090},
+		{Name: "web", Agents: []string{"b"}, Port: 8090},
+	}, fakeAgents{})
+	assert.ErrorIs(t, err, ErrInvalidPool)
+}
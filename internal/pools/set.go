@@ -0,0 +1,66 @@
+package pools
+
+import (
+	"fmt"
+	"slices"
+)
+
+// Set holds the configured pools.
+type Set struct {
+	pools  []*Pool
+	byName map[string]*Pool
+	byHost map[string]*Pool
+}
+
+// NewSet creates the pools configs define. Pool names, hosts and ports must
+// be unique.
+func NewSet(configs []Config, agents Agents) (*Set, error) {
+	s := &Set{
+		byName: make(map[string]*Pool, len(configs)),
+		byHost: make(map[string]*Pool),
+	}
+
+	ports := make(map[int]string)
+	for _, config := range configs {
+		pool, err := NewPool(config, agents)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := s.byName[pool.Name]; ok {
+			return nil, fmt.Errorf("%w: duplicate pool name %s", ErrInvalidPool, pool.Name)
+		}
+		if pool.Host != "" {
+			if other, ok := s.byHost[pool.Host]; ok {
+				return nil, fmt.Errorf("%w: pools %s and %s share host %s", ErrInvalidPool, other.Name, pool.Name, pool.Host)
+			}
+			s.byHost[pool.Host] = pool
+		}
+		if pool.Port != 0 {
+			if other, ok := ports[pool.Port]; ok {
+				return nil, fmt.Errorf("%w: pools %s and %s share port %d", ErrInvalidPool, other, pool.Name, pool.Port)
+			}
+			ports[pool.Port] = pool.Name
+		}
+
+		s.byName[pool.Name] = pool
+		s.pools = append(s.pools, pool)
+	}
+	return s, nil
+}
+
+// All returns the pools in the order they were configured.
+func (s *Set) All() []*Pool {
+	return slices.Clone(s.pools)
+}
+
+// Get returns the pool called name.
+func (s *Set) Get(name string) (*Pool, bool) {
+	pool, ok := s.byName[name]
+	return pool, ok
+}
+
+// ByHost returns the pool reached on host, a normalized hostname.
+func (s *Set) ByHost(host string) (*Pool, bool) {
+	pool, ok := s.byHost[host]
+	return pool, ok
+}
@@ -0,0 +1,98 @@
+package ratelimit
+
+import (
+	"fmt"
+	"time"
+)
+
+// Limits configures the limits applied to requests for agents: PerAgent caps
+// each agent's total traffic, PerClientIP caps each client address's share of
+// a single agent.
+type Limits struct {
+	PerAgent    Config `mapstructure:"per_agent"`
+	PerClientIP Config `mapstructure:"per_client_ip"`
+}
+
+func (l Limits) Enabled() bool {
+	return l.PerAgent.Enabled() || l.PerClientIP.Enabled()
+}
+
+// Error is returned when a request is refused. Limit names the limit that was
+// hit, for example "client_rate" or "agent_in_flight".
+type Error struct {
+	Limit      string
+	RetryAfter time.Duration
+	Err        error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %v", e.Limit, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// AgentLimiter applies Limits to requests for agents.
+type AgentLimiter struct {
+	agents  *Limiter
+	clients *Limiter
+}
+
+func NewAgentLimiter(limits Limits) *AgentLimiter {
+	return &AgentLimiter{
+		agents:  NewLimiter(limits.PerAgent),
+		clients: NewLimiter(limits.PerClientIP),
+	}
+}
+
+// Acquire admits a request from clientIP to agentID against both the rate and
+// the in-flight limits. release must be called once the request has finished.
+// A refused request gets an *Error and is not counted against any limit.
+func (l *AgentLimiter) Acquire(agentID, clientIP string) (release func(), err error) {
+	key := clientKey(agentID, clientIP)
+	releaseClient, retryAfter, err := l.clients.Acquire(key)
+	if err != nil {
+		return nil, newError("client", retryAfter, err)
+	}
+
+	releaseAgent, retryAfter, err := l.agents.Acquire(agentID)
+	if err != nil {
+		releaseClient()
+		l.clients.Refund(key)
+		return nil, newError("agent", retryAfter, err)
+	}
+
+	return func() {
+		releaseAgent()
+		releaseClient()
+	}, nil
+}
+
+// Allow admits a request from clientIP to agentID against the rate limits
+// only. It is meant for long-lived sessions such as WebSockets, which would
+// otherwise hold an in-flight slot for as long as they stay open. Like
+// Acquire, it counts refused requests against no limit.
+func (l *AgentLimiter) Allow(agentID, clientIP string) error {
+	key := clientKey(agentID, clientIP)
+	if retryAfter, err := l.clients.Allow(key); err != nil {
+		return newError("client", retryAfter, err)
+	}
+	if retryAfter, err := l.agents.Allow(agentID); err != nil {
+		l.clients.Refund(key)
+		return newError("agent", retryAfter, err)
+	}
+	return nil
+}
+
+func clientKey(agentID, clientIP string) string {
+	return agentID + "/" + clientIP
+}
+
+func newError(scope string, retryAfter time.Duration, err error) *Error {
+	limit := scope + "_rate"
+	if err == ErrTooManyInFlight {
+		limit = scope + "_in_flight"
+	}
+	return &Error{Limit: limit, RetryAfter: retryAfter, Err: err}
+}
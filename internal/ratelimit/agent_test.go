@@ -0,0 +1,86 @@
+package ratelimit
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAgentLimiter(t *testing.T) {
+	l := NewAgentLimiter(Limits{
+		PerAgent:    Config{MaxInFlight: 3},
+		PerClientIP: Config{MaxInFlight: 2},
+	})
+
+	release, err := l.Acquire("agent-1", "10.0.0.1")
+	require.NoError(t, err)
+	_, err = l.Acquire("agent-1", "10.0.0.1")
+	require.NoError(t, err)
+
+	_, err = l.Acquire("agent-1", "10.0.0.1")
+	var limitErr *Error
+	require.True(t, errors.As(err, &limitErr))
+	assert.Equal(t, "client_in_flight", limitErr.Limit)
+	assert.ErrorIs(t, err, ErrTooManyInFlight)
+
+	// The same client has its own share of another agent
+	_, err = l.Acquire("agent-2", "10.0.0.1")
+	require.NoError(t, err)
+
+	_, err = l.Acquire("agent-1", "10.0.0.2")
+	require.NoError(t, err)
+	_, err = l.Acquire("agent-1", "10.0.0.3")
+	require.True(t, errors.As(err, &limitErr))
+	assert.Equal(t, "agent_in_flight", limitErr.Limit)
+
+	// A request refused by the agent limit gives its client slot back
+	release()
+	_, err = l.Acquire("agent-1", "10.0.0.3")
+	require.NoError(t, err)
+	_, err = l.Acquire("agent-1", "10.0.0.1")
+	require.True(t, errors.As(err, &limitErr))
+	assert.Equal(t, "agent_in_flight", limitErr.Limit)
+}
+
+func TestAgentLimiter_Allow(t *testing.T) {
+	l := NewAgentLimiter(Limits{
+		PerAgent:    Config{RequestsPerSecond: 1, Burst: 1, MaxInFlight: 1},
+		PerClientIP: Config{MaxInFlight: 1},
+	})
+
+	_, err := l.Acquire("agent-1", "10.0.0.1")
+	require.NoError(t, err)
+
+	// Only the rate applies to long-lived sessions
+	err = l.Allow("agent-1", "10.0.0.1")
+	var limitErr *Error
+	require.True(t, errors.As(err, &limitErr))
+	assert.Equal(t, "agent_rate", limitErr.Limit)
+	assert.Positive(t, limitErr.RetryAfter)
+}
+
+func TestAgentLimiter_RefusedByAgentRefundsClientRate(t *testing.T) {
+	l := NewAgentLimiter(Limits{
+		PerAgent:    Config{RequestsPerSecond: 0.001, Burst: 1},
+		PerClientIP: Config{RequestsPerSecond: 0.001, Burst: 2},
+	})
+
+	_, err := l.Acquire("agent-1", "10.0.0.1")
+	require.NoError(t, err)
+
+	// Refused by the agent's rate, neither request spends a client token
+	var limitErr *Error
+	_, err = l.Acquire("agent-1", "10.0.0.2")
+	require.True(t, errors.As(err, &limitErr))
+	assert.Equal(t, "agent_rate", limitErr.Limit)
+	err = l.Allow("agent-1", "10.0.0.2")
+	require.True(t, errors.As(err, &limitErr))
+	assert.Equal(t, "agent_rate", limitErr.Limit)
+
+	for range 2 {
+		_, err = l.clients.Allow(clientKey("agent-1", "10.0.0.2"))
+		assert.NoError(t, err)
+	}
+}
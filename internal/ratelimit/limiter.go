@@ -0,0 +1,165 @@
+// Package ratelimit bounds how fast requests may arrive and how many may be in
+// flight at once, per key: a token bucket refilled at a fixed rate and a
+// counter of requests that have not finished yet.
+package ratelimit
+
+import (
+	"errors"
+	"math"
+	"sync"
+	"time"
+)
+
+var (
+	ErrRateLimited     = errors.New("rate limit exceeded")
+	ErrTooManyInFlight = errors.New("too many requests in flight")
+)
+
+// sweepInterval is how often keys that are back to their initial state are
+// forgotten, so that one-off clients do not accumulate.
+const sweepInterval = time.Minute
+
+// inFlightRetryAfter is suggested to clients refused for concurrency, when
+// there is no way to tell when a slot frees up.
+const inFlightRetryAfter = time.Second
+
+// Config limits a single key. Zero values disable the respective limit.
+type Config struct {
+	RequestsPerSecond float64 `mapstructure:"requests_per_second"`
+	// Burst is how many requests may arrive at once after a quiet period.
+	// It defaults to one second's worth of requests.
+	Burst       int `mapstructure:"burst"`
+	MaxInFlight int `mapstructure:"max_in_flight"`
+}
+
+func (c Config) Enabled() bool {
+	return c.RequestsPerSecond > 0 || c.MaxInFlight > 0
+}
+
+func (c Config) burst() float64 {
+	if c.Burst > 0 {
+		return float64(c.Burst)
+	}
+	return math.Max(1, math.Ceil(c.RequestsPerSecond))
+}
+
+type bucket struct {
+	tokens   float64
+	updated  time.Time
+	inFlight int
+}
+
+// Limiter applies the same Config to every key independently.
+type Limiter struct {
+	config Config
+	now    func() time.Time
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+func NewLimiter(config Config) *Limiter {
+	return &Limiter{
+		config:    config,
+		now:       time.Now,
+		buckets:   make(map[string]*bucket),
+		lastSweep: time.Now(),
+	}
+}
+
+// Allow takes a token from key's bucket. When it is empty, it returns
+// ErrRateLimited and how long until a token is available.
+func (l *Limiter) Allow(key string) (time.Duration, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.take(l.bucket(key))
+}
+
+// Acquire takes a token and an in-flight slot from key. On success, release
+// must be called once the request has finished. Otherwise the error is
+// ErrRateLimited or ErrTooManyInFlight, and nothing was taken.
+func (l *Limiter) Acquire(key string) (release func(), retryAfter time.Duration, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b := l.bucket(key)
+	if l.config.MaxInFlight > 0 && b.inFlight >= l.config.MaxInFlight {
+		return nil, inFlightRetryAfter, ErrTooManyInFlight
+	}
+	if retryAfter, err := l.take(b); err != nil {
+		return nil, retryAfter, err
+	}
+
+	b.inFlight++
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.mu.Lock()
+			b.inFlight--
+			l.mu.Unlock()
+		})
+	}, 0, nil
+}
+
+// Refund gives back the token Allow or Acquire took from key, for a request
+// that another limit then refused.
+func (l *Limiter) Refund(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.config.RequestsPerSecond > 0 {
+		b := l.bucket(key)
+		b.tokens = math.Min(l.config.burst(), b.tokens+1)
+	}
+}
+
+// bucket returns key's bucket, refilled up to now. The caller holds l.mu.
+func (l *Limiter) bucket(key string) *bucket {
+	now := l.now()
+	if now.Sub(l.lastSweep) >= sweepInterval {
+		l.sweep(now)
+	}
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.config.burst(), updated: now}
+		l.buckets[key] = b
+		return b
+	}
+
+	if l.config.RequestsPerSecond > 0 {
+		elapsed := now.Sub(b.updated).Seconds()
+		b.tokens = math.Min(l.config.burst(), b.tokens+elapsed*l.config.RequestsPerSecond)
+	}
+	b.updated = now
+	return b
+}
+
+func (l *Limiter) take(b *bucket) (time.Duration, error) {
+	if l.config.RequestsPerSecond <= 0 {
+		return 0, nil
+	}
+	if b.tokens < 1 {
+		wait := (1 - b.tokens) / l.config.RequestsPerSecond
+		return time.Duration(wait * float64(time.Second)), ErrRateLimited
+	}
+	b.tokens--
+	return 0, nil
+}
+
+// sweep forgets buckets that are idle and full again, which is the state a
+// new bucket starts in.
+func (l *Limiter) sweep(now time.Time) {
+	l.lastSweep = now
+	for key, b := range l.buckets {
+		if b.inFlight > 0 {
+			continue
+		}
+		tokens := b.tokens + now.Sub(b.updated).Seconds()*l.config.RequestsPerSecond
+		if l.config.RequestsPerSecond <= 0 || tokens >= l.config.burst() {
+			delete(l.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+func (c *fakeClock) Advance(d time.Duration) { c.now = c.now.Add(d) }
+
+func newTestLimiter(config Config) (*Limiter, *fakeClock) {
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	l := NewLimiter(config)
+	l.now = clock.Now
+	l.lastSweep = clock.now
+	return l, clock
+}
+
+func TestLimiter_Rate(t *testing.T) {
+	l, clock := newTestLimiter(Config{RequestsPerSecond: 2, Burst: 3})
+
+	for i := 0; i < 3; i++ {
+		_, err := l.Allow("a")
+		require.NoError(t, err, "request %d", i)
+	}
+
+	retryAfter, err := l.Allow("a")
+	assert.ErrorIs(t, err, ErrRateLimited)
+	assert.Equal(t, 500*time.Millisecond, retryAfter)
+
+	// Keys are limited independently
+	_, err = l.Allow("b")
+	assert.NoError(t, err)
+
+	clock.Advance(500 * time.Millisecond)
+	_, err = l.Allow("a")
+	assert.NoError(t, err)
+	_, err = l.Allow("a")
+	assert.ErrorIs(t, err, ErrRateLimited)
+
+	// The bucket never holds more than the burst
+	clock.Advance(time.Hour)
+	for i := 0; i < 3; i++ {
+		_, err := l.Allow("a")
+		require.NoError(t, err)
+	}
+	_, err = l.Allow("a")
+	assert.ErrorIs(t, err, ErrRateLimited)
+}
+
+func TestLimiter_DefaultBurst(t *testing.T) {
+	l, _ := newTestLimiter(Config{RequestsPerSecond: 0.5})
+
+	_, err := l.Allow("a")
+	require.NoError(t, err)
+	retryAfter, err := l.Allow("a")
+	assert.ErrorIs(t, err, ErrRateLimited)
+	assert.Equal(t, 2*time.Second, retryAfter)
+}
+
+func TestLimiter_InFlight(t *testing.T) {
+	l, _ := newTestLimiter(Config{MaxInFlight: 2})
+
+	release1, _, err := l.Acquire("a")
+	require.NoError(t, err)
+	_, _, err = l.Acquire("a")
+	require.NoError(t, err)
+
+	_, retryAfter, err := l.Acquire("a")
+	assert.ErrorIs(t, err, ErrTooManyInFlight)
+	assert.Equal(t, time.Second, retryAfter)
+
+	// Releasing twice frees a single slot
+	release1()
+	release1()
+	_, _, err = l.Acquire("a")
+	require.NoError(t, err)
+	_, _, err = l.Acquire("a")
+	assert.ErrorIs(t, err, ErrTooManyInFlight)
+}
+
+func TestLimiter_RefusedRequestTakesNothing(t *testing.T) {
+	l, _ := newTestLimiter(Config{RequestsPerSecond: 1, Burst: 2, MaxInFlight: 1})
+
+	release, _, err := l.Acquire("a")
+	require.NoError(t, err)
+
+	_, _, err = l.Acquire("a")
+	assert.ErrorIs(t, err, ErrTooManyInFlight)
+
+	// The refused request did not use the remaining token
+	release()
+	_, _, err = l.Acquire("a")
+	assert.NoError(t, err)
+}
+
+func TestLimiter_Sweep(t *testing.T) {
+	l, clock := newTestLimiter(Config{RequestsPerSecond: 1, MaxInFlight: 1})
+
+	_, _, err := l.Acquire("busy")
+	require.NoError(t, err)
+	_, err = l.Allow("idle")
+	require.NoError(t, err)
+
+	clock.Advance(sweepInterval)
+	_, err = l.Allow("other")
+	require.NoError(t, err)
+
+	assert.Contains(t, l.buckets, "busy")
+	assert.NotContains(t, l.buckets, "idle")
+}
+
+func TestLimiter_Refund(t *testing.T) {
+	l, _ := newTestLimiter(Config{RequestsPerSecond: 1, Burst: 1})
+
+	_, err := l.Allow("a")
+	require.NoError(t, err)
+	l.Refund("a")
+	_, err = l.Allow("a")
+	require.NoError(t, err)
+
+	// A refund never fills the bucket beyond its burst
+	l.Refund("a")
+	l.Refund("a")
+	_, err = l.Allow("a")
+	require.NoError(t, err)
+	_, err = l.Allow("a")
+	assert.ErrorIs(t, err, ErrRateLimited)
+}
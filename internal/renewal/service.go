@@ -0,0 +1,127 @@
+package renewal
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/EternisAI/silo-proxy/internal/cert"
+	"github.com/EternisAI/silo-proxy/internal/db/sqlc"
+	"github.com/EternisAI/silo-proxy/internal/revocation"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// supersededReason is the revocation reason recorded for renewed certificates.
+const supersededReason = "superseded"
+
+// Service renews agent certificates over the agent's authenticated stream.
+// Each renewal records the new serial as replacing the old one; the old
+// certificate is only retired, i.e. revoked as superseded, once the agent
+// connects with the new one, so an agent that fails to store its renewed
+// certificate is not locked out.
+type Service struct {
+	queries     *sqlc.Queries
+	certService *cert.Service
+	revocations *revocation.Service
+}
+
+func NewService(queries *sqlc.Queries, certService *cert.Service, revocations *revocation.Service) *Service {
+	return &Service{
+		queries:     queries,
+		certService: certService,
+		revocations: revocations,
+	}
+}
+
+// RenewAgentCert signs csrPEM for the agent authenticated by current and
+// returns the new certificate PEM encoded.
+func (s *Service) RenewAgentCert(ctx context.Context, agentID string, current *x509.Certificate, csrPEM []byte) ([]byte, error) {
+	renewed, err := s.certService.RenewAgentCert(agentID, csrPEM)
+	if err != nil {
+		return nil, err
+	}
+
+	currentSerial := revocation.SerialKey(current.SerialNumber)
+	if err := s.queries.CreateAgentCertificate(ctx, sqlc.CreateAgentCertificateParams{
+		SerialNumber: currentSerial,
+		AgentID:      agentID,
+		ExpiresAt:    pgtype.Timestamp{Time: current.NotAfter, Valid: true},
+	}); err != nil {
+		return nil, fmt.Errorf("record current certificate: %w", err)
+	}
+
+	if err := s.queries.CreateAgentCertificate(ctx, sqlc.CreateAgentCertificateParams{
+		SerialNumber:   revocation.SerialKey(renewed.SerialNumber),
+		AgentID:        agentID,
+		ReplacesSerial: pgtype.Text{String: currentSerial, Valid: true},
+		ExpiresAt:      pgtype.Timestamp{Time: renewed.NotAfter, Valid: true},
+	}); err != nil {
+		return nil, fmt.Errorf("record renewed certificate: %w", err)
+	}
+
+	slog.Info("Agent certificate renewed",
+		"agent_id", agentID,
+		"old_serial", currentSerial,
+		"new_serial", revocation.SerialKey(renewed.SerialNumber),
+		"not_after", renewed.NotAfter)
+
+	return cert.CertToPEM(renewed)
+}
+
+// ConfirmAgentCert is called when an agent connects with current. If current
+// came from a renewal, the certificate it replaced is retired.
+func (s *Service) ConfirmAgentCert(ctx context.Context, agentID string, current *x509.Certificate) error {
+	row, err := s.queries.GetAgentCertificate(ctx, revocation.SerialKey(current.SerialNumber))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("get agent certificate: %w", err)
+	}
+	if !row.ReplacesSerial.Valid {
+		return nil
+	}
+
+	previous, err := s.queries.GetAgentCertificate(ctx, row.ReplacesSerial.String)
+	if err != nil {
+		return fmt.Errorf("get replaced certificate: %w", err)
+	}
+	if previous.RetiredAt.Valid {
+		return nil
+	}
+
+	if err := s.revocations.RevokeSerial(ctx, agentID, previous.SerialNumber, previous.ExpiresAt.Time, supersededReason); err != nil {
+		return err
+	}
+	if _, err := s.queries.RetireAgentCertificate(ctx, previous.SerialNumber); err != nil {
+		return fmt.Errorf("retire agent certificate: %w", err)
+	}
+
+	slog.Info("Retired superseded agent certificate", "agent_id", agentID, "serial", previous.SerialNumber)
+	return nil
+}
+
+// RevokeAgentCerts revokes, with reason, every certificate recorded for the
+// agent that is not retired yet, so that no renewed certificate outlives the
+// deletion of the agent's certificate. It returns the revoked serials.
+func (s *Service) RevokeAgentCerts(ctx context.Context, agentID, reason string) ([]string, error) {
+	rows, err := s.queries.ListActiveAgentCertificates(ctx, agentID)
+	if err != nil {
+		return nil, fmt.Errorf("list agent certificates: %w", err)
+	}
+
+	serials := make([]string, 0, len(rows))
+	for _, row := range rows {
+		if err := s.revocations.RevokeSerial(ctx, agentID, row.SerialNumber, row.ExpiresAt.Time, reason); err != nil {
+			return serials, err
+		}
+		if _, err := s.queries.RetireAgentCertificate(ctx, row.SerialNumber); err != nil {
+			return serials, fmt.Errorf("retire agent certificate: %w", err)
+		}
+		serials = append(serials, row.SerialNumber)
+	}
+	return serials, nil
+}
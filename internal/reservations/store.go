@@ -0,0 +1,72 @@
+package reservations
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/EternisAI/silo-proxy/internal/db/sqlc"
+)
+
+var (
+	// ErrPortOutOfRange is returned when pinning a port outside the pool.
+	ErrPortOutOfRange = errors.New("port is outside the pool range")
+	// ErrPortUnavailable is returned when pinning a port that is pinned to,
+	// or in use by, another owner.
+	ErrPortUnavailable = errors.New("port is not available")
+	// ErrNotReserved is returned when an owner has no reservation.
+	ErrNotReserved = errors.New("no port reserved")
+)
+
+// Reservation is the port an owner gets whenever it is free. Pinned
+// reservations are set by an admin and never handed to another owner;
+// unpinned ones are reclaimed when the pool has no other free port.
+type Reservation struct {
+	Port   int
+	Pinned bool
+}
+
+// Store persists port reservations in Postgres, keyed by pool and owner.
+type Store struct {
+	queries *sqlc.Queries
+}
+
+func NewStore(queries *sqlc.Queries) *Store {
+	return &Store{queries: queries}
+}
+
+// Load returns the reservations of pool by owner.
+func (s *Store) Load(ctx context.Context, pool string) (map[string]Reservation, error) {
+	rows, err := s.queries.ListPortReservations(ctx, pool)
+	if err != nil {
+		return nil, fmt.Errorf("list port reservations: %w", err)
+	}
+
+	result := make(map[string]Reservation, len(rows))
+	for _, row := range rows {
+		result[row.Owner] = Reservation{Port: int(row.Port), Pinned: row.Pinned}
+	}
+	return result, nil
+}
+
+func (s *Store) Save(ctx context.Context, pool, owner string, r Reservation) error {
+	if err := s.queries.UpsertPortReservation(ctx, sqlc.UpsertPortReservationParams{
+		Pool:   pool,
+		Owner:  owner,
+		Port:   int32(r.Port),
+		Pinned: r.Pinned,
+	}); err != nil {
+		return fmt.Errorf("save port reservation: %w", err)
+	}
+	return nil
+}
+
+func (s *Store) Delete(ctx context.Context, pool, owner string) error {
+	if err := s.queries.DeletePortReservation(ctx, sqlc.DeletePortReservationParams{
+		Pool:  pool,
+		Owner: owner,
+	}); err != nil {
+		return fmt.Errorf("delete port reservation: %w", err)
+	}
+	return nil
+}
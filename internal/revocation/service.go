@@ -0,0 +1,165 @@
+package revocation
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/EternisAI/silo-proxy/internal/cert"
+	"github.com/EternisAI/silo-proxy/internal/db/sqlc"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// ErrInvalidReason is returned for revocation reasons not in Reasons.
+var ErrInvalidReason = errors.New("invalid revocation reason")
+
+// Reasons maps the accepted revocation reasons to their RFC 5280 CRL reason
+// codes.
+var Reasons = map[string]int{
+	"unspecified":          0,
+	"keyCompromise":        1,
+	"affiliationChanged":   3,
+	"superseded":           4,
+	"cessationOfOperation": 5,
+}
+
+// ValidateReason checks that reason is one of Reasons.
+func ValidateReason(reason string) error {
+	if _, ok := Reasons[reason]; ok {
+		return nil
+	}
+
+	valid := make([]string, 0, len(Reasons))
+	for name := range Reasons {
+		valid = append(valid, name)
+	}
+	sort.Strings(valid)
+	return fmt.Errorf("%w: %q (valid: %s)", ErrInvalidReason, reason, strings.Join(valid, ", "))
+}
+
+// SerialKey is the form a certificate serial number is stored in.
+func SerialKey(serial *big.Int) string {
+	return serial.Text(16)
+}
+
+// Service is the registry of revoked agent certificates. Revocations are
+// persisted in Postgres and mirrored in memory so that TLS handshakes can be
+// checked without a database round trip.
+type Service struct {
+	queries     *sqlc.Queries
+	certService *cert.Service
+
+	mu      sync.RWMutex
+	revoked map[string]struct{}
+}
+
+func NewService(queries *sqlc.Queries, certService *cert.Service) *Service {
+	return &Service{
+		queries:     queries,
+		certService: certService,
+		revoked:     make(map[string]struct{}),
+	}
+}
+
+// Load replaces the in-memory revocation set with the one in the database.
+func (s *Service) Load(ctx context.Context) error {
+	rows, err := s.queries.ListRevokedCertificates(ctx)
+	if err != nil {
+		return fmt.Errorf("list revoked certificates: %w", err)
+	}
+
+	revoked := make(map[string]struct{}, len(rows))
+	for _, row := range rows {
+		revoked[row.SerialNumber] = struct{}{}
+	}
+
+	s.mu.Lock()
+	s.revoked = revoked
+	s.mu.Unlock()
+	return nil
+}
+
+// Revoke records certificate as revoked. Revoking an already revoked
+// certificate is a no-op.
+func (s *Service) Revoke(ctx context.Context, agentID string, certificate *x509.Certificate, reason string) error {
+	return s.RevokeSerial(ctx, agentID, SerialKey(certificate.SerialNumber), certificate.NotAfter, reason)
+}
+
+// RevokeSerial records the certificate with the given serial, as produced by
+// SerialKey, as revoked. expiresAt is the certificate's NotAfter.
+func (s *Service) RevokeSerial(ctx context.Context, agentID, serial string, expiresAt time.Time, reason string) error {
+	if err := ValidateReason(reason); err != nil {
+		return err
+	}
+
+	if err := s.queries.RevokeCertificate(ctx, sqlc.RevokeCertificateParams{
+		SerialNumber: serial,
+		AgentID:      agentID,
+		Reason:       reason,
+		ExpiresAt:    pgtype.Timestamp{Time: expiresAt, Valid: true},
+	}); err != nil {
+		return fmt.Errorf("revoke certificate: %w", err)
+	}
+
+	s.mu.Lock()
+	s.revoked[serial] = struct{}{}
+	s.mu.Unlock()
+
+	slog.Info("Certificate revoked", "agent_id", agentID, "serial", serial, "reason", reason)
+	return nil
+}
+
+// IsRevoked reports whether certificate has been revoked.
+func (s *Service) IsRevoked(certificate *x509.Certificate) bool {
+	serial := SerialKey(certificate.SerialNumber)
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, revoked := s.revoked[serial]
+	return revoked
+}
+
+// CRL returns a DER encoded CRL of all revoked certificates that have not yet
+// expired, signed by the CA.
+func (s *Service) CRL(ctx context.Context) ([]byte, error) {
+	rows, err := s.queries.ListRevokedCertificates(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("list revoked certificates: %w", err)
+	}
+
+	entries, err := crlEntries(rows, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	return s.certService.CreateCRL(entries)
+}
+
+// crlEntries converts revocation rows into CRL entries, leaving out
+// certificates that expired before now since they are rejected anyway.
+func crlEntries(rows []sqlc.RevokedCertificate, now time.Time) ([]x509.RevocationListEntry, error) {
+	entries := make([]x509.RevocationListEntry, 0, len(rows))
+	for _, row := range rows {
+		if row.ExpiresAt.Valid && row.ExpiresAt.Time.Before(now) {
+			continue
+		}
+
+		serial, ok := new(big.Int).SetString(row.SerialNumber, 16)
+		if !ok {
+			return nil, fmt.Errorf("invalid serial number %q", row.SerialNumber)
+		}
+
+		entries = append(entries, x509.RevocationListEntry{
+			SerialNumber:   serial,
+			RevocationTime: row.RevokedAt.Time,
+			ReasonCode:     Reasons[row.Reason],
+		})
+	}
+	return entries, nil
+}
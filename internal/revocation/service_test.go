@@ -0,0 +1,48 @@
+package revocation
+
+import (
+	"crypto/x509"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/EternisAI/silo-proxy/internal/db/sqlc"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateReason(t *testing.T) {
+	assert.NoError(t, ValidateReason("keyCompromise"))
+
+	err := ValidateReason("stolen")
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, ErrInvalidReason))
+	assert.Contains(t, err.Error(), "cessationOfOperation")
+}
+
+func TestIsRevoked(t *testing.T) {
+	s := NewService(nil, nil)
+	s.revoked[SerialKey(big.NewInt(0xabc))] = struct{}{}
+
+	assert.True(t, s.IsRevoked(&x509.Certificate{SerialNumber: big.NewInt(0xabc)}))
+	assert.False(t, s.IsRevoked(&x509.Certificate{SerialNumber: big.NewInt(0xabd)}))
+}
+
+func TestCRLEntries(t *testing.T) {
+	now := time.Now()
+	timestamp := func(t time.Time) pgtype.Timestamp { return pgtype.Timestamp{Time: t, Valid: true} }
+
+	entries, err := crlEntries([]sqlc.RevokedCertificate{
+		{SerialNumber: "abc", Reason: "keyCompromise", RevokedAt: timestamp(now), ExpiresAt: timestamp(now.Add(time.Hour))},
+		{SerialNumber: "def", Reason: "superseded", RevokedAt: timestamp(now), ExpiresAt: timestamp(now.Add(-time.Hour))},
+	}, now)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, big.NewInt(0xabc), entries[0].SerialNumber)
+	assert.Equal(t, 1, entries[0].ReasonCode)
+
+	_, err = crlEntries([]sqlc.RevokedCertificate{{SerialNumber: "xyz"}}, now)
+	assert.Error(t, err)
+}
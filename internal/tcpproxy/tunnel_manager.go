@@ -0,0 +1,241 @@
+package tcpproxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+
+	grpcserver "github.com/EternisAI/silo-proxy/internal/grpc/server"
+	"github.com/EternisAI/silo-proxy/internal/grpc/tunnel"
+)
+
+const maxPortBindRetries = 3
+
+// PortAllocator hands out listener ports for tunnels. The HTTP package's
+// PortManager satisfies it. Ports are allocated to the owner
+// "<agent_id>/<tunnel>", so each tunnel keeps its own port across reconnects.
+type PortAllocator interface {
+	Allocate(owner string) (int, error)
+	Release(port int)
+}
+
+// TunnelInfo holds information about a running TCP tunnel listener.
+type TunnelInfo struct {
+	AgentID   string
+	Name      string
+	Port      int
+	Listener  net.Listener
+	StartedAt time.Time
+}
+
+// TunnelManager manages the lifecycle of per-agent TCP tunnel listeners. Each
+// tunnel an agent announces gets its own port; every connection accepted on it
+// is forwarded over the agent stream to the tunnel's target on the agent side.
+type TunnelManager struct {
+	tunnels    map[string][]*TunnelInfo // agentID -> tunnels
+	mu         sync.RWMutex
+	ports      PortAllocator
+	grpcServer *grpcserver.Server
+}
+
+// NewTunnelManager creates a new TunnelManager that allocates listener ports
+// from ports and opens tunnel sessions through gs.
+func NewTunnelManager(ports PortAllocator, gs *grpcserver.Server) *TunnelManager {
+	return &TunnelManager{
+		tunnels:    make(map[string][]*TunnelInfo),
+		ports:      ports,
+		grpcServer: gs,
+	}
+}
+
+// StartAgentTunnels opens a listener for each named tunnel of an agent and
+// returns the port allocated to each. Either all listeners are started or none.
+func (tm *TunnelManager) StartAgentTunnels(agentID string, names []string) (map[string]int, error) {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+
+	if _, exists := tm.tunnels[agentID]; exists {
+		return nil, fmt.Errorf("tunnels already exist for agent: %s", agentID)
+	}
+
+	var started []*TunnelInfo
+	ports := make(map[string]int, len(names))
+
+	for _, name := range names {
+		if _, dup := ports[name]; dup {
+			continue
+		}
+
+		info, err := tm.listen(agentID, name)
+		if err != nil {
+			for _, t := range started {
+				tm.closeTunnel(t)
+			}
+			return nil, fmt.Errorf("failed to start tunnel %s: %w", name, err)
+		}
+
+		started = append(started, info)
+		ports[name] = info.Port
+	}
+
+	tm.tunnels[agentID] = started
+
+	for _, info := range started {
+		go tm.serve(info)
+	}
+
+	return ports, nil
+}
+
+// listen allocates a port and binds it, retrying with another port if the
+// allocated one is taken by some other process.
+func (tm *TunnelManager) listen(agentID, name string) (*TunnelInfo, error) {
+	var lastErr error
+
+	// Ports that failed to bind are only released once we are done, so a
+	// retry does not get the same reserved port back.
+	var unbindable []int
+	defer func() {
+		for _, p := range unbindable {
+			tm.ports.Release(p)
+		}
+	}()
+
+	for attempt := 1; attempt <= maxPortBindRetries; attempt++ {
+		port, err := tm.ports.Allocate(agentID + "/" + name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to allocate port: %w", err)
+		}
+
+		listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+		if err != nil {
+			unbindable = append(unbindable, port)
+			lastErr = fmt.Errorf("failed to bind port %d: %w", port, err)
+			slog.Warn("TCP tunnel port binding failed, retrying",
+				"agent_id", agentID,
+				"tunnel", name,
+				"port", port,
+				"attempt", attempt,
+				"error", err)
+			continue
+		}
+
+		return &TunnelInfo{
+			AgentID:   agentID,
+			Name:      name,
+			Port:      port,
+			Listener:  listener,
+			StartedAt: time.Now(),
+		}, nil
+	}
+
+	return nil, fmt.Errorf("failed to bind after %d attempts: %w", maxPortBindRetries, lastErr)
+}
+
+func (tm *TunnelManager) serve(info *TunnelInfo) {
+	slog.Info("Starting TCP tunnel listener",
+		"agent_id", info.AgentID,
+		"tunnel", info.Name,
+		"port", info.Port)
+
+	for {
+		conn, err := info.Listener.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				slog.Error("TCP tunnel listener failed",
+					"agent_id", info.AgentID,
+					"tunnel", info.Name,
+					"port", info.Port,
+					"error", err)
+			}
+			return
+		}
+
+		go tm.handleConn(info, conn)
+	}
+}
+
+func (tm *TunnelManager) handleConn(info *TunnelInfo, conn net.Conn) {
+	remoteAddr := conn.RemoteAddr().String()
+
+	session, err := tm.grpcServer.OpenTCP(context.Background(), info.AgentID, info.Name, remoteAddr)
+	if err != nil {
+		slog.Warn("Failed to open TCP tunnel",
+			"agent_id", info.AgentID,
+			"tunnel", info.Name,
+			"remote_addr", remoteAddr,
+			"error", err)
+		conn.Close()
+		return
+	}
+
+	slog.Info("TCP tunnel connection opened",
+		"agent_id", info.AgentID,
+		"tunnel", info.Name,
+		"session_id", session.ID,
+		"remote_addr", remoteAddr)
+
+	tunnel.PumpTCP(conn, session)
+
+	slog.Info("TCP tunnel connection closed",
+		"agent_id", info.AgentID,
+		"tunnel", info.Name,
+		"session_id", session.ID)
+}
+
+// StopAgentTunnels closes the listeners of an agent's tunnels and releases
+// their ports. Connections already accepted end when the agent's sessions are
+// torn down.
+func (tm *TunnelManager) StopAgentTunnels(agentID string) error {
+	tm.mu.Lock()
+	tunnels, exists := tm.tunnels[agentID]
+	if !exists {
+		tm.mu.Unlock()
+		return fmt.Errorf("no tunnels found for agent: %s", agentID)
+	}
+	delete(tm.tunnels, agentID)
+	tm.mu.Unlock()
+
+	for _, info := range tunnels {
+		tm.closeTunnel(info)
+	}
+
+	slog.Info("Agent TCP tunnels stopped", "agent_id", agentID, "count", len(tunnels))
+
+	return nil
+}
+
+func (tm *TunnelManager) closeTunnel(info *TunnelInfo) {
+	if err := info.Listener.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+		slog.Warn("Failed to close TCP tunnel listener",
+			"agent_id", info.AgentID,
+			"tunnel", info.Name,
+			"port", info.Port,
+			"error", err)
+	}
+	tm.ports.Release(info.Port)
+}
+
+// Shutdown stops the tunnel listeners of every agent.
+func (tm *TunnelManager) Shutdown() error {
+	tm.mu.Lock()
+	agentIDs := make([]string, 0, len(tm.tunnels))
+	for agentID := range tm.tunnels {
+		agentIDs = append(agentIDs, agentID)
+	}
+	tm.mu.Unlock()
+
+	for _, agentID := range agentIDs {
+		if err := tm.StopAgentTunnels(agentID); err != nil {
+			slog.Error("Failed to stop agent tunnels during shutdown",
+				"agent_id", agentID,
+				"error", err)
+		}
+	}
+
+	return nil
+}
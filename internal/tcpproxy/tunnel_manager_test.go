@@ -0,0 +1,133 @@
+package tcpproxy
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	grpcserver "github.com/EternisAI/silo-proxy/internal/grpc/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakePorts allocates from a fixed set of ports found free at creation time.
+type fakePorts struct {
+	mu        sync.Mutex
+	available []int
+	released  []int
+	owners    []string
+}
+
+func newFakePorts(t *testing.T, n int) *fakePorts {
+	fp := &fakePorts{}
+	for i := 0; i < n; i++ {
+		ln, err := net.Listen("tcp", ":0")
+		require.NoError(t, err)
+		fp.available = append(fp.available, ln.Addr().(*net.TCPAddr).Port)
+		ln.Close()
+	}
+	return fp
+}
+
+func (fp *fakePorts) Allocate(owner string) (int, error) {
+	fp.mu.Lock()
+	defer fp.mu.Unlock()
+
+	if len(fp.available) == 0 {
+		return 0, fmt.Errorf("no available ports")
+	}
+	port := fp.available[0]
+	fp.available = fp.available[1:]
+	fp.owners = append(fp.owners, owner)
+	return port, nil
+}
+
+func (fp *fakePorts) Release(port int) {
+	fp.mu.Lock()
+	defer fp.mu.Unlock()
+
+	fp.released = append(fp.released, port)
+	fp.available = append(fp.available, port)
+}
+
+func TestStartAgentTunnels_Success(t *testing.T) {
+	ports := newFakePorts(t, 2)
+	tm := NewTunnelManager(ports, grpcserver.NewServer(0, nil))
+	defer tm.Shutdown()
+
+	allocated, err := tm.StartAgentTunnels("agent-1", []string{"postgres", "ssh", "ssh"})
+	require.NoError(t, err)
+	require.Len(t, allocated, 2)
+	assert.NotEqual(t, allocated["postgres"], allocated["ssh"])
+	assert.Equal(t, []string{"agent-1/postgres", "agent-1/ssh"}, ports.owners)
+
+	for _, port := range allocated {
+		conn, err := net.Dial("tcp", fmt.Sprintf("localhost:%d", port))
+		require.NoError(t, err)
+		conn.Close()
+	}
+}
+
+func TestStartAgentTunnels_DuplicateAgent(t *testing.T) {
+	ports := newFakePorts(t, 2)
+	tm := NewTunnelManager(ports, grpcserver.NewServer(0, nil))
+	defer tm.Shutdown()
+
+	_, err := tm.StartAgentTunnels("agent-1", []string{"ssh"})
+	require.NoError(t, err)
+
+	_, err = tm.StartAgentTunnels("agent-1", []string{"postgres"})
+	assert.Error(t, err)
+}
+
+func TestStartAgentTunnels_AllOrNothing(t *testing.T) {
+	ports := newFakePorts(t, 1)
+	tm := NewTunnelManager(ports, grpcserver.NewServer(0, nil))
+	defer tm.Shutdown()
+
+	_, err := tm.StartAgentTunnels("agent-1", []string{"postgres", "ssh"})
+	require.Error(t, err)
+
+	// The first tunnel's port is handed back when the second one fails
+	assert.Len(t, ports.released, 1)
+	assert.Len(t, ports.available, 1)
+
+	err = tm.StopAgentTunnels("agent-1")
+	assert.Error(t, err)
+}
+
+func TestStopAgentTunnels(t *testing.T) {
+	ports := newFakePorts(t, 1)
+	tm := NewTunnelManager(ports, grpcserver.NewServer(0, nil))
+
+	allocated, err := tm.StartAgentTunnels("agent-1", []string{"ssh"})
+	require.NoError(t, err)
+
+	require.NoError(t, tm.StopAgentTunnels("agent-1"))
+	assert.Equal(t, []int{allocated["ssh"]}, ports.released)
+
+	_, err = net.Dial("tcp", fmt.Sprintf("localhost:%d", allocated["ssh"]))
+	assert.Error(t, err)
+
+	assert.Error(t, tm.StopAgentTunnels("agent-1"))
+}
+
+func TestTunnel_ClosesConnectionWhenAgentMissing(t *testing.T) {
+	ports := newFakePorts(t, 1)
+	tm := NewTunnelManager(ports, grpcserver.NewServer(0, nil))
+	defer tm.Shutdown()
+
+	allocated, err := tm.StartAgentTunnels("agent-1", []string{"ssh"})
+	require.NoError(t, err)
+
+	conn, err := net.Dial("tcp", fmt.Sprintf("localhost:%d", allocated["ssh"]))
+	require.NoError(t, err)
+	defer conn.Close()
+
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(2*time.Second)))
+	_, err = conn.Read(make([]byte, 1))
+	assert.Equal(t, io.EOF, err)
+}
@@ -30,27 +30,111 @@ const (
 	MessageType_PING MessageType = 1
 	// Server responds with PONG
 	MessageType_PONG MessageType = 2
-	// Server sends REQUEST to forward to local services
+	// Server sends REQUEST to forward to local services (legacy, whole body in payload)
 	MessageType_REQUEST MessageType = 3
-	// Agent sends RESPONSE back to server
+	// Agent sends RESPONSE back to server (legacy, whole body in payload)
 	MessageType_RESPONSE MessageType = 4
+	// Server sends REQUEST_START with method, path and headers; the body follows as BODY_CHUNK frames
+	MessageType_REQUEST_START MessageType = 5
+	// Agent sends RESPONSE_START with status code and headers; the body follows as BODY_CHUNK frames
+	MessageType_RESPONSE_START MessageType = 6
+	// BODY_CHUNK carries the next slice of a request or response body, keyed by the request id
+	MessageType_BODY_CHUNK MessageType = 7
+	// BODY_END terminates a body; an "error" metadata entry means the sender aborted it
+	MessageType_BODY_END MessageType = 8
+	// Server sends WS_OPEN when a client asks to upgrade to WebSocket; the agent dials its local service
+	MessageType_WS_OPEN MessageType = 9
+	// Agent answers WS_OPEN with the handshake status code and the negotiated subprotocol
+	MessageType_WS_OPEN_ACK MessageType = 10
+	// WS_FRAME carries one WebSocket message in either direction, keyed by the session id
+	MessageType_WS_FRAME MessageType = 11
+	// WS_CLOSE ends a WebSocket session in either direction, with close code and reason in metadata
+	MessageType_WS_CLOSE MessageType = 12
+	// Server sends TCP_OPEN when a client connects to one of the agent's TCP tunnel ports
+	MessageType_TCP_OPEN MessageType = 13
+	// Agent answers TCP_OPEN once it has dialed the tunnel target; an "error" metadata entry means the dial failed
+	MessageType_TCP_OPEN_ACK MessageType = 14
+	// TCP_DATA carries raw bytes of a tunnelled TCP connection in either direction
+	MessageType_TCP_DATA MessageType = 15
+	// TCP_CLOSE_WRITE tells the peer the sender will write no more data (half-close)
+	MessageType_TCP_CLOSE_WRITE MessageType = 16
+	// TCP_CLOSE tears down a tunnelled TCP connection in either direction
+	MessageType_TCP_CLOSE MessageType = 17
+	// Agent sends CERT_RENEW_REQUEST with a PEM CSR as payload when its client certificate nears expiry
+	MessageType_CERT_RENEW_REQUEST MessageType = 18
+	// Server answers CERT_RENEW_REQUEST with the PEM certificate as payload; an "error" metadata entry means renewal failed
+	MessageType_CERT_RENEW_RESPONSE MessageType = 19
+	// Server sends CANCEL when the caller of a request gave up; the agent aborts the call to its local service
+	MessageType_CANCEL MessageType = 20
+	// Agent sends HELLO as its first message, with agent_id and tcp_tunnels metadata for older servers
+	MessageType_HELLO MessageType = 21
+	// Server answers HELLO with the negotiated version and capabilities; an "error" metadata entry means the agent was rejected
+	MessageType_HELLO_ACK MessageType = 22
+	// Server sends COMMAND to run a named command on the agent, with "command" and "timeout_ms" metadata and JSON arguments as payload
+	MessageType_COMMAND MessageType = 23
+	// Agent answers COMMAND with its JSON result as payload; "error" and "error_code" metadata entries mean the command failed
+	MessageType_COMMAND_RESULT MessageType = 24
+	// Either side sends WINDOW_UPDATE to grant the sender of a body or tunnel session more credit; "bytes" metadata is the amount
+	MessageType_WINDOW_UPDATE MessageType = 25
 )
 
 // Enum value maps for MessageType.
 var (
 	MessageType_name = map[int32]string{
-		0: "UNKNOWN",
-		1: "PING",
-		2: "PONG",
-		3: "REQUEST",
-		4: "RESPONSE",
+		0:  "UNKNOWN",
+		1:  "PING",
+		2:  "PONG",
+		3:  "REQUEST",
+		4:  "RESPONSE",
+		5:  "REQUEST_START",
+		6:  "RESPONSE_START",
+		7:  "BODY_CHUNK",
+		8:  "BODY_END",
+		9:  "WS_OPEN",
+		10: "WS_OPEN_ACK",
+		11: "WS_FRAME",
+		12: "WS_CLOSE",
+		13: "TCP_OPEN",
+		14: "TCP_OPEN_ACK",
+		15: "TCP_DATA",
+		16: "TCP_CLOSE_WRITE",
+		17: "TCP_CLOSE",
+		18: "CERT_RENEW_REQUEST",
+		19: "CERT_RENEW_RESPONSE",
+		20: "CANCEL",
+		21: "HELLO",
+		22: "HELLO_ACK",
+		23: "COMMAND",
+		24: "COMMAND_RESULT",
+		25: "WINDOW_UPDATE",
 	}
 	MessageType_value = map[string]int32{
-		"UNKNOWN":  0,
-		"PING":     1,
-		"PONG":     2,
-		"REQUEST":  3,
-		"RESPONSE": 4,
+		"UNKNOWN":             0,
+		"PING":                1,
+		"PONG":                2,
+		"REQUEST":             3,
+		"RESPONSE":            4,
+		"REQUEST_START":       5,
+		"RESPONSE_START":      6,
+		"BODY_CHUNK":          7,
+		"BODY_END":            8,
+		"WS_OPEN":             9,
+		"WS_OPEN_ACK":         10,
+		"WS_FRAME":            11,
+		"WS_CLOSE":            12,
+		"TCP_OPEN":            13,
+		"TCP_OPEN_ACK":        14,
+		"TCP_DATA":            15,
+		"TCP_CLOSE_WRITE":     16,
+		"TCP_CLOSE":           17,
+		"CERT_RENEW_REQUEST":  18,
+		"CERT_RENEW_RESPONSE": 19,
+		"CANCEL":              20,
+		"HELLO":               21,
+		"HELLO_ACK":           22,
+		"COMMAND":             23,
+		"COMMAND_RESULT":      24,
+		"WINDOW_UPDATE":       25,
 	}
 )
 
@@ -88,6 +172,9 @@ type ProxyMessage struct {
 	Type          MessageType            `protobuf:"varint,2,opt,name=type,proto3,enum=proxy.MessageType" json:"type,omitempty"`                                                           // Message type
 	Payload       []byte                 `protobuf:"bytes,3,opt,name=payload,proto3" json:"payload,omitempty"`                                                                             // Message payload
 	Metadata      map[string]string      `protobuf:"bytes,4,rep,name=metadata,proto3" json:"metadata,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"` // Additional metadata
+	Headers       []*Header              `protobuf:"bytes,5,rep,name=headers,proto3" json:"headers,omitempty"`                                                                             // HTTP headers of REQUEST_START, RESPONSE_START and WS_OPEN frames
+	Trailers      []*Header              `protobuf:"bytes,6,rep,name=trailers,proto3" json:"trailers,omitempty"`                                                                           // HTTP trailers, on the BODY_END frame that ends a body
+	Hello         *Hello                 `protobuf:"bytes,7,opt,name=hello,proto3" json:"hello,omitempty"`                                                                                 // Handshake details of HELLO and HELLO_ACK frames
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -150,25 +237,233 @@ func (x *ProxyMessage) GetMetadata() map[string]string {
 	return nil
 }
 
+func (x *ProxyMessage) GetHeaders() []*Header {
+	if x != nil {
+		return x.Headers
+	}
+	return nil
+}
+
+func (x *ProxyMessage) GetTrailers() []*Header {
+	if x != nil {
+		return x.Trailers
+	}
+	return nil
+}
+
+func (x *ProxyMessage) GetHello() *Hello {
+	if x != nil {
+		return x.Hello
+	}
+	return nil
+}
+
+// Header is one HTTP header field with all of its values, in order.
+// Older peers send headers as "header_<name>" metadata entries instead,
+// with one value per name.
+type Header struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Name          string                 `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	Values        []string               `protobuf:"bytes,2,rep,name=values,proto3" json:"values,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Header) Reset() {
+	*x = Header{}
+	mi := &file_proxy_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Header) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Header) ProtoMessage() {}
+
+func (x *Header) ProtoReflect() protoreflect.Message {
+	mi := &file_proxy_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Header.ProtoReflect.Descriptor instead.
+func (*Header) Descriptor() ([]byte, []int) {
+	return file_proxy_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *Header) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *Header) GetValues() []string {
+	if x != nil {
+		return x.Values
+	}
+	return nil
+}
+
+// Hello describes one side of a connection. In HELLO, protocol_version and
+// capabilities are what the agent supports; in HELLO_ACK, what was agreed on.
+type Hello struct {
+	state              protoimpl.MessageState `protogen:"open.v1"`
+	ProtocolVersion    uint32                 `protobuf:"varint,1,opt,name=protocol_version,json=protocolVersion,proto3" json:"protocol_version,omitempty"`            // Highest protocol version spoken, or the negotiated one
+	MinProtocolVersion uint32                 `protobuf:"varint,2,opt,name=min_protocol_version,json=minProtocolVersion,proto3" json:"min_protocol_version,omitempty"` // Lowest protocol version spoken
+	Version            string                 `protobuf:"bytes,3,opt,name=version,proto3" json:"version,omitempty"`                                                    // Build version of the sender
+	Os                 string                 `protobuf:"bytes,4,opt,name=os,proto3" json:"os,omitempty"`
+	Arch               string                 `protobuf:"bytes,5,opt,name=arch,proto3" json:"arch,omitempty"`
+	Hostname           string                 `protobuf:"bytes,6,opt,name=hostname,proto3" json:"hostname,omitempty"`
+	Capabilities       []string               `protobuf:"bytes,7,rep,name=capabilities,proto3" json:"capabilities,omitempty"` // Optional features supported, or enabled for the connection
+	unknownFields      protoimpl.UnknownFields
+	sizeCache          protoimpl.SizeCache
+}
+
+func (x *Hello) Reset() {
+	*x = Hello{}
+	mi := &file_proxy_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Hello) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Hello) ProtoMessage() {}
+
+func (x *Hello) ProtoReflect() protoreflect.Message {
+	mi := &file_proxy_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Hello.ProtoReflect.Descriptor instead.
+func (*Hello) Descriptor() ([]byte, []int) {
+	return file_proxy_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *Hello) GetProtocolVersion() uint32 {
+	if x != nil {
+		return x.ProtocolVersion
+	}
+	return 0
+}
+
+func (x *Hello) GetMinProtocolVersion() uint32 {
+	if x != nil {
+		return x.MinProtocolVersion
+	}
+	return 0
+}
+
+func (x *Hello) GetVersion() string {
+	if x != nil {
+		return x.Version
+	}
+	return ""
+}
+
+func (x *Hello) GetOs() string {
+	if x != nil {
+		return x.Os
+	}
+	return ""
+}
+
+func (x *Hello) GetArch() string {
+	if x != nil {
+		return x.Arch
+	}
+	return ""
+}
+
+func (x *Hello) GetHostname() string {
+	if x != nil {
+		return x.Hostname
+	}
+	return ""
+}
+
+func (x *Hello) GetCapabilities() []string {
+	if x != nil {
+		return x.Capabilities
+	}
+	return nil
+}
+
 var File_proxy_proto protoreflect.FileDescriptor
 
 const file_proxy_proto_rawDesc = "" +
 	"\n" +
-	"\vproxy.proto\x12\x05proxy\"\xdc\x01\n" +
+	"\vproxy.proto\x12\x05proxy\"\xd4\x02\n" +
 	"\fProxyMessage\x12\x0e\n" +
 	"\x02id\x18\x01 \x01(\tR\x02id\x12&\n" +
 	"\x04type\x18\x02 \x01(\x0e2\x12.proxy.MessageTypeR\x04type\x12\x18\n" +
 	"\apayload\x18\x03 \x01(\fR\apayload\x12=\n" +
-	"\bmetadata\x18\x04 \x03(\v2!.proxy.ProxyMessage.MetadataEntryR\bmetadata\x1a;\n" +
+	"\bmetadata\x18\x04 \x03(\v2!.proxy.ProxyMessage.MetadataEntryR\bmetadata\x12'\n" +
+	"\aheaders\x18\x05 \x03(\v2\r.proxy.HeaderR\aheaders\x12)\n" +
+	"\btrailers\x18\x06 \x03(\v2\r.proxy.HeaderR\btrailers\x12\"\n" +
+	"\x05hello\x18\a \x01(\v2\f.proxy.HelloR\x05hello\x1a;\n" +
 	"\rMetadataEntry\x12\x10\n" +
 	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
-	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01*I\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\"4\n" +
+	"\x06Header\x12\x12\n" +
+	"\x04name\x18\x01 \x01(\tR\x04name\x12\x16\n" +
+	"\x06values\x18\x02 \x03(\tR\x06values\"\xe2\x01\n" +
+	"\x05Hello\x12)\n" +
+	"\x10protocol_version\x18\x01 \x01(\rR\x0fprotocolVersion\x120\n" +
+	"\x14min_protocol_version\x18\x02 \x01(\rR\x12minProtocolVersion\x12\x18\n" +
+	"\aversion\x18\x03 \x01(\tR\aversion\x12\x0e\n" +
+	"\x02os\x18\x04 \x01(\tR\x02os\x12\x12\n" +
+	"\x04arch\x18\x05 \x01(\tR\x04arch\x12\x1a\n" +
+	"\bhostname\x18\x06 \x01(\tR\bhostname\x12\"\n" +
+	"\fcapabilities\x18\a \x03(\tR\fcapabilities*\xa5\x03\n" +
 	"\vMessageType\x12\v\n" +
 	"\aUNKNOWN\x10\x00\x12\b\n" +
 	"\x04PING\x10\x01\x12\b\n" +
 	"\x04PONG\x10\x02\x12\v\n" +
 	"\aREQUEST\x10\x03\x12\f\n" +
-	"\bRESPONSE\x10\x042F\n" +
+	"\bRESPONSE\x10\x04\x12\x11\n" +
+	"\rREQUEST_START\x10\x05\x12\x12\n" +
+	"\x0eRESPONSE_START\x10\x06\x12\x0e\n" +
+	"\n" +
+	"BODY_CHUNK\x10\a\x12\f\n" +
+	"\bBODY_END\x10\b\x12\v\n" +
+	"\aWS_OPEN\x10\t\x12\x0f\n" +
+	"\vWS_OPEN_ACK\x10\n" +
+	"\x12\f\n" +
+	"\bWS_FRAME\x10\v\x12\f\n" +
+	"\bWS_CLOSE\x10\f\x12\f\n" +
+	"\bTCP_OPEN\x10\r\x12\x10\n" +
+	"\fTCP_OPEN_ACK\x10\x0e\x12\f\n" +
+	"\bTCP_DATA\x10\x0f\x12\x13\n" +
+	"\x0fTCP_CLOSE_WRITE\x10\x10\x12\r\n" +
+	"\tTCP_CLOSE\x10\x11\x12\x16\n" +
+	"\x12CERT_RENEW_REQUEST\x10\x12\x12\x17\n" +
+	"\x13CERT_RENEW_RESPONSE\x10\x13\x12\n" +
+	"\n" +
+	"\x06CANCEL\x10\x14\x12\t\n" +
+	"\x05HELLO\x10\x15\x12\r\n" +
+	"\tHELLO_ACK\x10\x16\x12\v\n" +
+	"\aCOMMAND\x10\x17\x12\x12\n" +
+	"\x0eCOMMAND_RESULT\x10\x18\x12\x11\n" +
+	"\rWINDOW_UPDATE\x10\x192F\n" +
 	"\fProxyService\x126\n" +
 	"\x06Stream\x12\x13.proxy.ProxyMessage\x1a\x13.proxy.ProxyMessage(\x010\x01B'Z%github.com/EternisAI/silo-proxy/protob\x06proto3"
 
@@ -185,22 +480,27 @@ func file_proxy_proto_rawDescGZIP() []byte {
 }
 
 var file_proxy_proto_enumTypes = make([]protoimpl.EnumInfo, 1)
-var file_proxy_proto_msgTypes = make([]protoimpl.MessageInfo, 2)
+var file_proxy_proto_msgTypes = make([]protoimpl.MessageInfo, 4)
 var file_proxy_proto_goTypes = []any{
 	(MessageType)(0),     // 0: proxy.MessageType
 	(*ProxyMessage)(nil), // 1: proxy.ProxyMessage
-	nil,                  // 2: proxy.ProxyMessage.MetadataEntry
+	(*Header)(nil),       // 2: proxy.Header
+	(*Hello)(nil),        // 3: proxy.Hello
+	nil,                  // 4: proxy.ProxyMessage.MetadataEntry
 }
 var file_proxy_proto_depIdxs = []int32{
 	0, // 0: proxy.ProxyMessage.type:type_name -> proxy.MessageType
-	2, // 1: proxy.ProxyMessage.metadata:type_name -> proxy.ProxyMessage.MetadataEntry
-	1, // 2: proxy.ProxyService.Stream:input_type -> proxy.ProxyMessage
-	1, // 3: proxy.ProxyService.Stream:output_type -> proxy.ProxyMessage
-	3, // [3:4] is the sub-list for method output_type
-	2, // [2:3] is the sub-list for method input_type
-	2, // [2:2] is the sub-list for extension type_name
-	2, // [2:2] is the sub-list for extension extendee
-	0, // [0:2] is the sub-list for field type_name
+	4, // 1: proxy.ProxyMessage.metadata:type_name -> proxy.ProxyMessage.MetadataEntry
+	2, // 2: proxy.ProxyMessage.headers:type_name -> proxy.Header
+	2, // 3: proxy.ProxyMessage.trailers:type_name -> proxy.Header
+	3, // 4: proxy.ProxyMessage.hello:type_name -> proxy.Hello
+	1, // 5: proxy.ProxyService.Stream:input_type -> proxy.ProxyMessage
+	1, // 6: proxy.ProxyService.Stream:output_type -> proxy.ProxyMessage
+	6, // [6:7] is the sub-list for method output_type
+	5, // [5:6] is the sub-list for method input_type
+	5, // [5:5] is the sub-list for extension type_name
+	5, // [5:5] is the sub-list for extension extendee
+	0, // [0:5] is the sub-list for field type_name
 }
 
 func init() { file_proxy_proto_init() }
@@ -214,7 +514,7 @@ func file_proxy_proto_init() {
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_proxy_proto_rawDesc), len(file_proxy_proto_rawDesc)),
 			NumEnums:      1,
-			NumMessages:   2,
+			NumMessages:   4,
 			NumExtensions: 0,
 			NumServices:   1,
 		},
@@ -5,10 +5,14 @@ import (
 	"fmt"
 	"testing"
 
+	"github.com/EternisAI/silo-proxy/internal/access"
+	"github.com/EternisAI/silo-proxy/internal/agents"
 	"github.com/EternisAI/silo-proxy/internal/api/http"
 	"github.com/EternisAI/silo-proxy/internal/auth"
 	"github.com/EternisAI/silo-proxy/internal/db"
 	"github.com/EternisAI/silo-proxy/internal/db/sqlc"
+	"github.com/EternisAI/silo-proxy/internal/domains"
+	"github.com/EternisAI/silo-proxy/internal/reservations"
 	"github.com/EternisAI/silo-proxy/internal/users"
 	"github.com/EternisAI/silo-proxy/systemtest/postgres"
 	"github.com/EternisAI/silo-proxy/systemtest/tests"
@@ -47,10 +51,26 @@ func TestSystemIntegration(t *testing.T) {
 
 	authService := auth.NewService(queries, auth.Config{Secret: jwtSecret, ExpirationMinutes: 60})
 	userService := users.NewService(queries)
+	registry := agents.NewService(queries)
+
+	reservationStore := reservations.NewStore(queries)
+	portManager, err := http.NewPortManager(8100, 8110)
+	require.NoError(t, err)
+	portManager.SetPoolName("agent_http")
+	require.NoError(t, portManager.SetReservationStore(reservationStore))
+
+	domainService := domains.NewService(queries)
+	domainService.SetBaseDomain("tunnel.example.com")
+
+	accessService := access.NewService(queries, jwtSecret)
 
 	services := &http.Services{
 		AuthService: authService,
 		UserService: userService,
+		Registry:    registry,
+		AgentPorts:  portManager,
+		Domains:     domainService,
+		Access:      accessService,
 	}
 
 	gin.SetMode(gin.TestMode)
@@ -61,4 +81,10 @@ func TestSystemIntegration(t *testing.T) {
 	t.Run("Register", func(t *testing.T) { tests.TestRegister(t, engine, jwtSecret) })
 	t.Run("Login", func(t *testing.T) { tests.TestLogin(t, engine, jwtSecret) })
 	t.Run("UserCRUD", func(t *testing.T) { tests.TestUserCRUD(t, engine, jwtSecret) })
+	t.Run("AgentRegistry", func(t *testing.T) { tests.TestAgentRegistry(t, engine, registry, "admin-api-key") })
+	t.Run("PortReservations", func(t *testing.T) {
+		tests.TestPortReservations(t, engine, portManager, reservationStore, "admin-api-key")
+	})
+	t.Run("AgentDomains", func(t *testing.T) { tests.TestAgentDomains(t, engine, domainService, "admin-api-key") })
+	t.Run("AccessPolicies", func(t *testing.T) { tests.TestAccessPolicies(t, engine, accessService, "admin-api-key") })
 }
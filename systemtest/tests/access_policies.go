@@ -0,0 +1,84 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/EternisAI/silo-proxy/internal/access"
+	"github.com/EternisAI/silo-proxy/internal/api/http/dto"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAccessPolicies(t *testing.T, router *gin.Engine, accessService *access.Service, apiKey string) {
+	const agentKey = "agent-1-secret-key"
+
+	t.Run("requires api key", func(t *testing.T) {
+		rr := doJSON(router, "GET", "/api/v1/agents/agent-1/access-policy", nil)
+		assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	})
+
+	t.Run("agent without policy is open", func(t *testing.T) {
+		rr := doJSONWithAPIKey(router, "GET", "/api/v1/agents/agent-1/access-policy", nil, apiKey)
+		require.Equal(t, http.StatusOK, rr.Code)
+
+		var resp dto.AgentAccessPolicy
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+		assert.Empty(t, resp.AllowedCIDRs)
+		assert.False(t, resp.RequireJWT)
+		assert.False(t, resp.APIKeySet)
+	})
+
+	t.Run("set invalid policy", func(t *testing.T) {
+		rr := doJSONWithAPIKey(router, "PUT", "/api/v1/agents/agent-1/access-policy", map[string]any{
+			"allowed_cidrs": []string{"10.0.0.0/33"},
+		}, apiKey)
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+	})
+
+	t.Run("set policy", func(t *testing.T) {
+		rr := doJSONWithAPIKey(router, "PUT", "/api/v1/agents/agent-1/access-policy", map[string]any{
+			"allowed_cidrs": []string{"10.0.0.0/8"},
+			"api_key":       agentKey,
+			"basic_auth":    map[string]string{"username": "admin", "password": "secret"},
+		}, apiKey)
+		require.Equal(t, http.StatusOK, rr.Code)
+
+		var resp dto.AgentAccessPolicy
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+		assert.Equal(t, "agent-1", resp.AgentID)
+		assert.Equal(t, []string{"10.0.0.0/8"}, resp.AllowedCIDRs)
+		assert.True(t, resp.APIKeySet)
+		assert.Equal(t, "admin", resp.BasicAuthUsername)
+		assert.NotContains(t, rr.Body.String(), agentKey)
+	})
+
+	t.Run("policy is loaded from the database", func(t *testing.T) {
+		require.NoError(t, accessService.Load(t.Context()))
+
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = "10.1.2.3:5000"
+		assert.ErrorIs(t, accessService.Authorize("agent-1", r), access.ErrUnauthorized)
+
+		r.Header.Set("X-API-Key", agentKey)
+		assert.NoError(t, accessService.Authorize("agent-1", r))
+
+		r = httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = "192.168.1.1:5000"
+		r.SetBasicAuth("admin", "secret")
+		assert.ErrorIs(t, accessService.Authorize("agent-1", r), access.ErrForbidden)
+	})
+
+	t.Run("delete policy", func(t *testing.T) {
+		rr := doJSONWithAPIKey(router, "DELETE", "/api/v1/agents/agent-1/access-policy", nil, apiKey)
+		require.Equal(t, http.StatusNoContent, rr.Code)
+
+		require.NoError(t, accessService.Load(t.Context()))
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = "192.168.1.1:5000"
+		assert.NoError(t, accessService.Authorize("agent-1", r))
+	})
+}
@@ -0,0 +1,131 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/EternisAI/silo-proxy/internal/agents"
+	"github.com/EternisAI/silo-proxy/internal/api/http/dto"
+	grpcserver "github.com/EternisAI/silo-proxy/internal/grpc/server"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAgentRegistry(t *testing.T, router *gin.Engine, registry *agents.Service, apiKey string) {
+	connectedAt := time.Now().UTC().Truncate(time.Second)
+
+	registry.SessionStarted(grpcserver.SessionInfo{
+		AgentID:     "agent-1",
+		RemoteAddr:  "10.0.0.1:50000",
+		Port:        8100,
+		CertSerial:  "1a2b",
+		ConnectedAt: connectedAt,
+	})
+	registry.SessionEnded("agent-1", grpcserver.DisconnectReasonStale, connectedAt.Add(time.Minute))
+	registry.SessionStarted(grpcserver.SessionInfo{
+		AgentID:     "agent-1",
+		RemoteAddr:  "10.0.0.2:50000",
+		Port:        8101,
+		ConnectedAt: connectedAt.Add(2 * time.Minute),
+	})
+	// Close flushes the queued session events to the database.
+	registry.Close()
+
+	t.Run("requires api key", func(t *testing.T) {
+		rr := doJSON(router, "GET", "/api/v1/agents", nil)
+		assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	})
+
+	t.Run("list agents", func(t *testing.T) {
+		rr := doJSONWithAPIKey(router, "GET", "/api/v1/agents", nil, apiKey)
+		require.Equal(t, http.StatusOK, rr.Code)
+
+		var resp dto.RegisteredAgentsResponse
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+		require.Equal(t, 1, resp.Count)
+
+		agent := resp.Agents[0]
+		assert.Equal(t, "agent-1", agent.AgentID)
+		assert.False(t, agent.Online)
+		assert.Equal(t, "10.0.0.2:50000", agent.LastRemoteAddr)
+		assert.Equal(t, 8101, agent.Port)
+		assert.Empty(t, agent.CertSerial)
+		require.NotNil(t, agent.LastConnectedAt)
+		assert.True(t, connectedAt.Add(2*time.Minute).Equal(*agent.LastConnectedAt))
+		require.NotNil(t, agent.LastDisconnectedAt)
+		assert.True(t, connectedAt.Add(time.Minute).Equal(*agent.LastDisconnectedAt))
+	})
+
+	t.Run("session history", func(t *testing.T) {
+		rr := doJSONWithAPIKey(router, "GET", "/api/v1/agents/agent-1/sessions", nil, apiKey)
+		require.Equal(t, http.StatusOK, rr.Code)
+
+		var resp dto.AgentSessionsResponse
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+		require.Equal(t, int64(2), resp.Total)
+		require.Len(t, resp.Sessions, 2)
+
+		current, previous := resp.Sessions[0], resp.Sessions[1]
+		assert.Equal(t, "10.0.0.2:50000", current.RemoteAddr)
+		assert.Nil(t, current.DisconnectedAt)
+		assert.Equal(t, "10.0.0.1:50000", previous.RemoteAddr)
+		assert.Equal(t, "1a2b", previous.CertSerial)
+		assert.Equal(t, grpcserver.DisconnectReasonStale, previous.DisconnectReason)
+		require.NotNil(t, previous.DisconnectedAt)
+	})
+
+	t.Run("update labels", func(t *testing.T) {
+		body := dto.UpdateAgentLabelsRequest{Labels: map[string]string{"site": "berlin"}}
+		rr := doJSONWithAPIKey(router, "PUT", "/api/v1/agents/agent-1/labels", body, apiKey)
+		require.Equal(t, http.StatusOK, rr.Code)
+
+		var resp dto.RegisteredAgent
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+		assert.Equal(t, map[string]string{"site": "berlin"}, resp.Labels)
+	})
+
+	t.Run("server restart closes open sessions", func(t *testing.T) {
+		closed, err := registry.CloseOpenSessions(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), closed)
+
+		rr := doJSONWithAPIKey(router, "GET", "/api/v1/agents/agent-1/sessions", nil, apiKey)
+		require.Equal(t, http.StatusOK, rr.Code)
+
+		var resp dto.AgentSessionsResponse
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+		require.NotEmpty(t, resp.Sessions)
+		current := resp.Sessions[0]
+		require.NotNil(t, current.DisconnectedAt)
+		assert.Equal(t, agents.DisconnectReasonServerRestart, current.DisconnectReason)
+
+		agent, err := registry.Get(context.Background(), "agent-1")
+		require.NoError(t, err)
+		require.NotNil(t, agent.LastDisconnectedAt)
+		assert.True(t, current.DisconnectedAt.Equal(*agent.LastDisconnectedAt))
+	})
+
+	t.Run("unknown agent", func(t *testing.T) {
+		rr := doJSONWithAPIKey(router, "GET", "/api/v1/agents/missing", nil, apiKey)
+		assert.Equal(t, http.StatusNotFound, rr.Code)
+
+		rr = doJSONWithAPIKey(router, "GET", "/api/v1/agents/missing/sessions", nil, apiKey)
+		assert.Equal(t, http.StatusNotFound, rr.Code)
+	})
+}
+
+func doJSONWithAPIKey(router *gin.Engine, method, path string, body any, apiKey string) *httptest.ResponseRecorder {
+	b, _ := json.Marshal(body)
+	req := httptest.NewRequest(method, path, bytes.NewReader(b))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-API-Key", apiKey)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	return rr
+}
@@ -0,0 +1,79 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/EternisAI/silo-proxy/internal/api/http/dto"
+	"github.com/EternisAI/silo-proxy/internal/domains"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAgentDomains(t *testing.T, router *gin.Engine, domainService *domains.Service, apiKey string) {
+	t.Run("requires api key", func(t *testing.T) {
+		rr := doJSON(router, "GET", "/api/v1/agents/agent-1/domains", nil)
+		assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	})
+
+	t.Run("add domain", func(t *testing.T) {
+		rr := doJSONWithAPIKey(router, "POST", "/api/v1/agents/agent-1/domains", map[string]string{"domain": "App.Customer.io"}, apiKey)
+		require.Equal(t, http.StatusCreated, rr.Code)
+
+		var resp dto.AgentDomainsResponse
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+		assert.Equal(t, []string{"app.customer.io"}, resp.Domains)
+
+		agentID, ok := domainService.Lookup("app.customer.io")
+		assert.True(t, ok)
+		assert.Equal(t, "agent-1", agentID)
+	})
+
+	t.Run("add same domain again", func(t *testing.T) {
+		rr := doJSONWithAPIKey(router, "POST", "/api/v1/agents/agent-1/domains", map[string]string{"domain": "app.customer.io"}, apiKey)
+		assert.Equal(t, http.StatusCreated, rr.Code)
+	})
+
+	t.Run("add domain of another agent", func(t *testing.T) {
+		rr := doJSONWithAPIKey(router, "POST", "/api/v1/agents/agent-2/domains", map[string]string{"domain": "app.customer.io"}, apiKey)
+		assert.Equal(t, http.StatusConflict, rr.Code)
+	})
+
+	t.Run("add invalid domain", func(t *testing.T) {
+		rr := doJSONWithAPIKey(router, "POST", "/api/v1/agents/agent-1/domains", map[string]string{"domain": "not a domain"}, apiKey)
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+	})
+
+	t.Run("list domains", func(t *testing.T) {
+		rr := doJSONWithAPIKey(router, "GET", "/api/v1/agents/agent-1/domains", nil, apiKey)
+		require.Equal(t, http.StatusOK, rr.Code)
+
+		var resp dto.AgentDomainsResponse
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+		assert.Equal(t, "agent-1", resp.AgentID)
+		assert.Equal(t, []string{"app.customer.io"}, resp.Domains)
+	})
+
+	t.Run("mappings are loaded from the database", func(t *testing.T) {
+		require.NoError(t, domainService.Load(t.Context()))
+
+		agentID, ok := domainService.Lookup("app.customer.io")
+		assert.True(t, ok)
+		assert.Equal(t, "agent-1", agentID)
+	})
+
+	t.Run("remove domain of another agent", func(t *testing.T) {
+		rr := doJSONWithAPIKey(router, "DELETE", "/api/v1/agents/agent-2/domains/app.customer.io", nil, apiKey)
+		assert.Equal(t, http.StatusNotFound, rr.Code)
+	})
+
+	t.Run("remove domain", func(t *testing.T) {
+		rr := doJSONWithAPIKey(router, "DELETE", "/api/v1/agents/agent-1/domains/app.customer.io", nil, apiKey)
+		require.Equal(t, http.StatusNoContent, rr.Code)
+
+		_, ok := domainService.Lookup("app.customer.io")
+		assert.False(t, ok)
+	})
+}
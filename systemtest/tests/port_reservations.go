@@ -0,0 +1,90 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	internalhttp "github.com/EternisAI/silo-proxy/internal/api/http"
+	"github.com/EternisAI/silo-proxy/internal/api/http/dto"
+	"github.com/EternisAI/silo-proxy/internal/reservations"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPortReservations(t *testing.T, router *gin.Engine, portManager *internalhttp.PortManager, store *reservations.Store, apiKey string) {
+	port, err := portManager.Allocate("agent-sticky")
+	require.NoError(t, err)
+	portManager.Release(port)
+
+	t.Run("requires api key", func(t *testing.T) {
+		rr := doJSON(router, "GET", "/api/v1/agents/agent-sticky/port", nil)
+		assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	})
+
+	t.Run("get reserved port", func(t *testing.T) {
+		rr := doJSONWithAPIKey(router, "GET", "/api/v1/agents/agent-sticky/port", nil, apiKey)
+		require.Equal(t, http.StatusOK, rr.Code)
+
+		var resp dto.AgentPortReservation
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+		assert.Equal(t, port, resp.Port)
+		assert.False(t, resp.Pinned)
+	})
+
+	t.Run("get unknown agent", func(t *testing.T) {
+		rr := doJSONWithAPIKey(router, "GET", "/api/v1/agents/agent-unknown/port", nil, apiKey)
+		assert.Equal(t, http.StatusNotFound, rr.Code)
+	})
+
+	t.Run("pin port", func(t *testing.T) {
+		rr := doJSONWithAPIKey(router, "PUT", "/api/v1/agents/agent-pinned/port", map[string]int{"port": 8110}, apiKey)
+		require.Equal(t, http.StatusOK, rr.Code)
+
+		var resp dto.AgentPortReservation
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+		assert.Equal(t, 8110, resp.Port)
+		assert.True(t, resp.Pinned)
+	})
+
+	t.Run("pin port outside range", func(t *testing.T) {
+		rr := doJSONWithAPIKey(router, "PUT", "/api/v1/agents/agent-pinned/port", map[string]int{"port": 9000}, apiKey)
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+	})
+
+	t.Run("pin port pinned to another agent", func(t *testing.T) {
+		rr := doJSONWithAPIKey(router, "PUT", "/api/v1/agents/agent-other/port", map[string]int{"port": 8110}, apiKey)
+		assert.Equal(t, http.StatusConflict, rr.Code)
+	})
+
+	t.Run("reservations survive restart", func(t *testing.T) {
+		restarted, err := internalhttp.NewPortManager(8100, 8110)
+		require.NoError(t, err)
+		restarted.SetPoolName("agent_http")
+		require.NoError(t, restarted.SetReservationStore(store))
+		defer restarted.Close()
+
+		got, err := restarted.Allocate("agent-pinned")
+		require.NoError(t, err)
+		assert.Equal(t, 8110, got)
+
+		got, err = restarted.Allocate("agent-sticky")
+		require.NoError(t, err)
+		assert.Equal(t, port, got)
+	})
+
+	t.Run("unpin port", func(t *testing.T) {
+		rr := doJSONWithAPIKey(router, "DELETE", "/api/v1/agents/agent-pinned/port", nil, apiKey)
+		require.Equal(t, http.StatusNoContent, rr.Code)
+
+		loaded, err := store.Load(t.Context(), "agent_http")
+		require.NoError(t, err)
+		assert.Equal(t, reservations.Reservation{Port: 8110}, loaded["agent-pinned"])
+	})
+
+	t.Run("unpin unknown agent", func(t *testing.T) {
+		rr := doJSONWithAPIKey(router, "DELETE", "/api/v1/agents/agent-unknown/port", nil, apiKey)
+		assert.Equal(t, http.StatusNotFound, rr.Code)
+	})
+}